@@ -3,17 +3,24 @@ package alert_manager
 import (
 	"context"
 	"flag"
+	"fmt"
 	"github.com/golang/glog"
 	"github.com/mayuresh82/alert_manager/api"
 	ah "github.com/mayuresh82/alert_manager/handler"
+	"github.com/mayuresh82/alert_manager/internal/election"
 	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/mayuresh82/alert_manager/internal/queue"
 	"github.com/mayuresh82/alert_manager/internal/stats"
 	"github.com/mayuresh82/alert_manager/plugins"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
+// default deadline for draining in-flight alerts on shutdown
+const SHUTDOWN_TIMEOUT = 30 * time.Second
+
 // global flags
 var (
 	alertConfig = flag.String("alert-config", "", "full path to alert defintion file")
@@ -40,13 +47,48 @@ func Run(config *Config) {
 		}
 	}()
 
+	// elect the replica handling the alerts among the ones sharing the db. The others hand
+	// the alerts they receive over to it through the db.
+	ha := config.Ha != nil && config.Ha.Enabled
+	if ha {
+		id := config.Ha.Id
+		if id == "" {
+			host, _ := os.Hostname()
+			id = fmt.Sprintf("%s:%d", host, os.Getpid())
+		}
+		ah.UseElection(db, election.New(db, id, config.Ha.LeaseTime))
+	}
+
+	// persist incoming alerts to disk before handling them
+	if config.Queue != nil && config.Queue.Dir != "" {
+		if ha {
+			glog.Errorf("Not using the ingestion queue in %s, replicas queue alerts in the db", config.Queue.Dir)
+		} else {
+			q, err := queue.Open(config.Queue.Dir)
+			if err != nil {
+				glog.Fatalf("Failed to open ingestion queue: %v", err)
+			}
+			ah.UseQueue(q)
+		}
+	}
+
 	// start the handler
 	handler := ah.NewHandler(db)
-	go handler.Start(ctx)
+	handlerCtx, stopHandler := context.WithCancel(ctx)
+	handlerDone := make(chan struct{})
+	go func() {
+		handler.Start(handlerCtx)
+		close(handlerDone)
+	}()
 
-	//Initialize all the plugins
-	// Listener, transforms
-	plugins.Init(ctx, db)
+	// import maintenance calendars
+	handler.StartCalendarSync(ctx, config.Calendars)
+
+	// start the plugins, each group with its own context so that they can be stopped in order
+	outputCtx, stopOutputs := context.WithCancel(ctx)
+	outputs := plugins.StartOutputs(outputCtx)
+	listenCtx, stopListeners := context.WithCancel(ctx)
+	listeners := plugins.StartListeners(listenCtx)
 
 	// start the API server
 	glog.Infof("Starting API server on %s", config.Api.ApiAddr)
@@ -66,12 +108,20 @@ func Run(config *Config) {
 		}
 	}
 	server := api.NewServer(config.Api.ApiAddr, config.Api.ApiKey, auth, handler)
-	go server.Start(ctx)
+	listeners.Add(1)
+	go func() {
+		defer listeners.Done()
+		server.Start(listenCtx)
+	}()
 
 	// start the reporting agent
 	glog.Infof("Will send stats to %s", config.Reporter.Url)
 	go stats.StartExport(ctx, config.Agent.StatsExportInterval)
-	go config.Reporter.Start(ctx)
+	reporterDone := make(chan struct{})
+	go func() {
+		config.Reporter.Start(ctx)
+		close(reporterDone)
+	}()
 
 	// wait for sig
 	signalChan := make(chan os.Signal, 1)
@@ -93,4 +143,30 @@ func Run(config *Config) {
 		}
 	}()
 	<-shutdown
+
+	timeout := config.Agent.ShutdownTimeout
+	if timeout == 0 {
+		timeout = SHUTDOWN_TIMEOUT
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		glog.Infof("Stopping listeners")
+		stopListeners()
+		listeners.Wait()
+		glog.Infof("Draining alert handler and processors")
+		stopHandler()
+		<-handlerDone
+		glog.Infof("Stopping outputs")
+		stopOutputs()
+		outputs.Wait()
+		cancel()
+		<-reporterDone
+	}()
+	select {
+	case <-stopped:
+		glog.Infof("Alert Manager stopped")
+	case <-time.After(timeout):
+		glog.Errorf("Alert Manager did not stop within %v, exiting", timeout)
+	}
 }
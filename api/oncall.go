@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	ah "github.com/mayuresh82/alert_manager/handler"
+	"github.com/mayuresh82/alert_manager/internal/models"
+)
+
+// scheduleRequest is an on-call schedule with the name of its team
+type scheduleRequest struct {
+	models.Schedule
+	Team string
+}
+
+type onCallResponse struct {
+	Team   string
+	At     time.Time
+	OnCall []*models.OnCall
+}
+
+// parseTime parses an RFC3339 time or unix seconds
+func parseTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// GetOnCall returns who is on call for a team, now or at the time given by at
+func (s *Server) GetOnCall(w http.ResponseWriter, req *http.Request) {
+	team := req.URL.Query().Get("team")
+	if team == "" {
+		http.Error(w, "A team is required", http.StatusBadRequest)
+		return
+	}
+	at := time.Now()
+	if v := req.URL.Query().Get("at"); v != "" {
+		var err error
+		if at, err = parseTime(v); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid time %s: %v", v, err), http.StatusBadRequest)
+			return
+		}
+	}
+	resp := &onCallResponse{Team: team, At: at, OnCall: []*models.OnCall{}}
+	tx := s.handler.Db.NewTx()
+	err := models.WithTx(req.Context(), tx, func(ctx context.Context, tx models.Txn) error {
+		onCall, err := ah.WhoIsOnCall(tx, team, at)
+		if err != nil {
+			return err
+		}
+		if onCall != nil {
+			resp.OnCall = onCall
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("Api: Unable to get on-call for %s: %v", team, err)
+		http.Error(w, fmt.Sprintf("Unable to get on-call: %v", err), http.StatusInternalServerError)
+		s.statError.Add(1)
+		return
+	}
+	s.statGets.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetSchedules returns all on-call schedules, or those of a team
+func (s *Server) GetSchedules(w http.ResponseWriter, req *http.Request) {
+	var schedules models.Schedules
+	tx := s.handler.Db.NewTx()
+	err := models.WithTx(req.Context(), tx, func(ctx context.Context, tx models.Txn) error {
+		var er error
+		if team := req.URL.Query().Get("team"); team != "" {
+			schedules, er = tx.SelectSchedules(models.QuerySelectScheduleByTeam, team)
+		} else {
+			schedules, er = tx.SelectSchedules(models.QuerySelectSchedules)
+		}
+		return er
+	})
+	if err != nil {
+		glog.Errorf("Api: Unable to fetch schedules: %v", err)
+		http.Error(w, fmt.Sprintf("Unable to fetch schedules: %v", err), http.StatusInternalServerError)
+		s.statError.Add(1)
+		return
+	}
+	if schedules == nil {
+		schedules = models.Schedules{}
+	}
+	s.statGets.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedules)
+}
+
+func (s *Server) saveSchedule(w http.ResponseWriter, req *http.Request, id int64) {
+	sr := &scheduleRequest{}
+	if err := json.NewDecoder(req.Body).Decode(sr); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid parameters for query: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := sr.Schedule.Compile(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sr.Schedule.Id = id
+	tx := s.handler.Db.NewTx()
+	err := models.WithTx(req.Context(), tx, func(ctx context.Context, tx models.Txn) error {
+		var er error
+		sr.Schedule.Id, er = s.handler.SaveSchedule(ctx, tx, sr.Team, &sr.Schedule)
+		return er
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save schedule: %v", err), http.StatusBadRequest)
+		return
+	}
+	s.statPosts.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sr)
+}
+
+func (s *Server) CreateSchedule(w http.ResponseWriter, req *http.Request) {
+	s.saveSchedule(w, req, 0)
+}
+
+func (s *Server) UpdateSchedule(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil || id == 0 {
+		http.Error(w, "Invalid schedule id", http.StatusBadRequest)
+		return
+	}
+	s.saveSchedule(w, req, id)
+}
+
+func (s *Server) DeleteSchedule(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	tx := s.handler.Db.NewTx()
+	err := models.WithTx(req.Context(), tx, func(ctx context.Context, tx models.Txn) error {
+		return s.handler.DeleteSchedule(ctx, tx, id)
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to delete schedule: %v", err), http.StatusBadRequest)
+	}
+}
+
+// GetOverrides returns the overrides of a schedule that have not ended
+func (s *Server) GetOverrides(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	var overrides models.Overrides
+	tx := s.handler.Db.NewTx()
+	err := models.WithTx(req.Context(), tx, func(ctx context.Context, tx models.Txn) error {
+		var er error
+		overrides, er = tx.SelectOverrides(models.QuerySelectOverrides, id, time.Now().Unix())
+		return er
+	})
+	if err != nil {
+		glog.Errorf("Api: Unable to fetch overrides: %v", err)
+		http.Error(w, fmt.Sprintf("Unable to fetch overrides: %v", err), http.StatusInternalServerError)
+		s.statError.Add(1)
+		return
+	}
+	if overrides == nil {
+		overrides = models.Overrides{}
+	}
+	s.statGets.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overrides)
+}
+
+func (s *Server) CreateOverride(w http.ResponseWriter, req *http.Request) {
+	o := &models.Override{}
+	if err := json.NewDecoder(req.Body).Decode(o); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid parameters for query: %v", err), http.StatusBadRequest)
+		return
+	}
+	o.ScheduleId, _ = strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	tx := s.handler.Db.NewTx()
+	err := models.WithTx(req.Context(), tx, func(ctx context.Context, tx models.Txn) error {
+		var er error
+		o.Id, er = s.handler.AddOverride(ctx, tx, o)
+		return er
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create override: %v", err), http.StatusBadRequest)
+		return
+	}
+	s.statPosts.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(o)
+}
+
+func (s *Server) DeleteOverride(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	tx := s.handler.Db.NewTx()
+	err := models.WithTx(req.Context(), tx, func(ctx context.Context, tx models.Txn) error {
+		return s.handler.DeleteOverride(ctx, tx, id)
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to delete override: %v", err), http.StatusBadRequest)
+	}
+}
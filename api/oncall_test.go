@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var mockSchedule = &models.Schedule{
+	Id:       1,
+	Name:     "primary",
+	TeamId:   1,
+	Timezone: "UTC",
+	Layers:   models.Layers{{Name: "weekly", Rotation: models.RotationWeekly, Start: "2024-03-04T09:00", Users: []int64{1, 2}}},
+}
+
+func (tx *MockTx) SelectSchedules(query string, args ...interface{}) (models.Schedules, error) {
+	if len(args) > 0 && args[0] != "neteng" && args[0] != int64(1) {
+		return nil, nil
+	}
+	return models.Schedules{mockSchedule}, nil
+}
+
+func (tx *MockTx) SelectOverrides(query string, args ...interface{}) (models.Overrides, error) {
+	return nil, nil
+}
+
+func (tx *MockTx) SelectTeams(query string, args ...interface{}) (models.Teams, error) {
+	return models.Teams{{Id: 1, Name: "neteng"}}, nil
+}
+
+func (tx *MockTx) SelectUsers(query string, args ...interface{}) (models.Users, error) {
+	users := models.Users{{Id: 1, Name: "alice", TeamId: 1}, {Id: 2, Name: "bob", TeamId: 1}}
+	if query == models.QuerySelectUserById {
+		for _, u := range users {
+			if u.Id == args[0].(int64) {
+				return models.Users{u}, nil
+			}
+		}
+		return nil, nil
+	}
+	return users, nil
+}
+
+func TestServerOnCall(t *testing.T) {
+	s := NewMockServer()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/oncall", s.GetOnCall).Methods("GET")
+
+	for _, url := range []string{"/api/oncall", "/api/oncall?team=neteng&at=yesterday"} {
+		req, _ := http.NewRequest("GET", url, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, rr.Code, http.StatusBadRequest, url)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/oncall?team=neteng&at=2024-03-12T00:00:00Z", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var resp map[string]interface{}
+	if err := json.NewDecoder(rr.Result().Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	onCall := resp["OnCall"].([]interface{})
+	assert.Equal(t, len(onCall), 1)
+	user := onCall[0].(map[string]interface{})["User"].(map[string]interface{})
+	assert.Equal(t, user["Name"], "bob")
+
+	// nobody on call for a team without schedules
+	req, _ = http.NewRequest("GET", "/api/oncall?team=sre&at=1709251200", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	resp = nil
+	json.NewDecoder(rr.Result().Body).Decode(&resp)
+	assert.Equal(t, len(resp["OnCall"].([]interface{})), 0)
+}
+
+func TestServerSchedule(t *testing.T) {
+	s := NewMockServer()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/oncall/schedules", s.CreateSchedule).Methods("POST")
+
+	body := `{"Team": "neteng", "Name": "secondary", "Timezone": "Europe/Berlin", "Layers": [
+		{"Name": "daily", "Rotation": "daily", "Start": "2024-03-04T09:00", "Users": [1, 2]}]}`
+	req, _ := http.NewRequest("POST", "/api/oncall/schedules", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	// invalid rotation
+	body = `{"Team": "neteng", "Name": "secondary", "Timezone": "UTC", "Layers": [
+		{"Name": "daily", "Rotation": "hourly", "Start": "2024-03-04T09:00", "Users": [1]}]}`
+	req, _ = http.NewRequest("POST", "/api/oncall/schedules", bytes.NewBufferString(body))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+
+	// user not in the team
+	body = `{"Team": "neteng", "Name": "secondary", "Timezone": "UTC", "Layers": [
+		{"Name": "daily", "Rotation": "daily", "Start": "2024-03-04T09:00", "Users": [7]}]}`
+	req, _ = http.NewRequest("POST", "/api/oncall/schedules", bytes.NewBufferString(body))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+}
@@ -117,6 +117,19 @@ func (s *Server) Start(ctx context.Context) {
 	router.HandleFunc("/api/auth", s.CreateToken).Methods("POST")
 	router.HandleFunc("/api/auth/refresh", s.Validate(s.RefreshToken)).Methods("GET")
 	router.HandleFunc("/api/plugins", s.GetPluginsList).Methods("GET")
+	router.HandleFunc("/api/pending_clears", s.GetPendingClears).Methods("GET")
+	router.HandleFunc("/api/leader", s.GetLeader).Methods("GET")
+	router.HandleFunc("/api/users", s.Validate(s.CreateUser)).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/users/{id}", s.Validate(s.UpdateUser)).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/users/{id}", s.Validate(s.DeleteUser)).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/oncall", s.GetOnCall).Methods("GET")
+	router.HandleFunc("/api/oncall/schedules", s.GetSchedules).Methods("GET")
+	router.HandleFunc("/api/oncall/schedules", s.Validate(s.CreateSchedule)).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/oncall/schedules/{id}", s.Validate(s.UpdateSchedule)).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/oncall/schedules/{id}", s.Validate(s.DeleteSchedule)).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/oncall/schedules/{id}/overrides", s.GetOverrides).Methods("GET")
+	router.HandleFunc("/api/oncall/schedules/{id}/overrides", s.Validate(s.CreateOverride)).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/oncall/overrides/{id}", s.Validate(s.DeleteOverride)).Methods("DELETE", "OPTIONS")
 	router.HandleFunc("/api/{category}", s.GetItems).Methods("GET")
 	router.HandleFunc("/api/{category}/{id}", s.Validate(s.Update)).Methods("PATCH", "OPTIONS")
 	router.HandleFunc("/api/alerts/{id}", s.GetAlert).Methods("GET")
@@ -127,7 +140,7 @@ func (s *Server) Start(ctx context.Context) {
 	// CORS specific headers
 	allowedHeaders := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
 	allowedOrigins := handlers.AllowedOrigins([]string{"*"})
-	allowedMethods := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PATCH", "DELETE", "OPTIONS"})
+	allowedMethods := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"})
 
 	// set up the router
 	srv := &http.Server{
@@ -137,7 +150,18 @@ func (s *Server) Start(ctx context.Context) {
 		WriteTimeout: 10 * time.Second,
 		ReadTimeout:  10 * time.Second,
 	}
-	srv.ListenAndServe()
+	idleConnsClosed := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		if err := srv.Shutdown(context.Background()); err != nil {
+			glog.Errorf("API server Shutdown Error: %v", err)
+		}
+		close(idleConnsClosed)
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		glog.Errorf("API server ListenAndServe Error: %v", err)
+	}
+	<-idleConnsClosed
 }
 
 func (s *Server) Validate(next http.HandlerFunc) http.HandlerFunc {
@@ -303,6 +327,52 @@ func (s *Server) GetAlert(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// GetPendingClears returns the alerts that are waiting out the clear holddown
+func (s *Server) GetPendingClears(w http.ResponseWriter, req *http.Request) {
+	tx := s.handler.Db.NewTx()
+	var clears models.PendingClears
+	err := models.WithTx(req.Context(), tx, func(ctx context.Context, tx models.Txn) error {
+		var er error
+		clears, er = tx.SelectPendingClears(models.QuerySelectPendingClears)
+		return er
+	})
+	if err != nil {
+		glog.Errorf("Api: Unable to fetch pending clears: %v", err)
+		http.Error(w, fmt.Sprintf("Unable to fetch pending clears: %s", err.Error()), http.StatusInternalServerError)
+		s.statError.Add(1)
+		return
+	}
+	if clears == nil {
+		clears = models.PendingClears{}
+	}
+	s.statGets.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clears)
+}
+
+// GetLeader returns whether the replica serving the request is the leader, and which replica
+// holds the lease if the replicas elect a leader
+func (s *Server) GetLeader(w http.ResponseWriter, req *http.Request) {
+	status := struct {
+		Replica string        `json:"replica,omitempty"`
+		Leader  bool          `json:"leader"`
+		Lease   *models.Lease `json:"lease,omitempty"`
+	}{Leader: ah.IsLeader()}
+	if e := ah.GetElector(); e != nil {
+		lease, err := e.Lease(req.Context())
+		if err != nil {
+			glog.Errorf("Api: Unable to fetch leader lease: %v", err)
+			http.Error(w, fmt.Sprintf("Unable to fetch leader lease: %s", err.Error()), http.StatusInternalServerError)
+			s.statError.Add(1)
+			return
+		}
+		status.Replica, status.Lease = e.Id(), lease
+	}
+	s.statGets.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
 func (s *Server) Update(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	q, err := buildUpdateQuery(req, map[string][]string{"id": []string{vars["id"]}})
@@ -393,9 +463,18 @@ func (s *Server) CreateSuppRule(w http.ResponseWriter, req *http.Request) {
 	if rule.Name == "" {
 		rule.Name = fmt.Sprintf("Rule - %s - %v", rule.Creator, rule.Duration)
 	}
+	if err := rule.Compile(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	rule.CreatedAt = models.MyTime{time.Now()}
+	var id int64
 	tx := s.handler.Db.NewTx()
-	id, err := s.handler.AddSuppRule(req.Context(), tx, rule)
+	err := models.WithTx(req.Context(), tx, func(ctx context.Context, tx models.Txn) error {
+		var er error
+		id, er = s.handler.AddSuppRule(ctx, tx, rule)
+		return er
+	})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create suppression rule: %v", err), http.StatusInternalServerError)
 		return
@@ -410,7 +489,10 @@ func (s *Server) ClearSuppRule(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	id, _ := strconv.ParseInt(vars["id"], 10, 64)
 	tx := s.handler.Db.NewTx()
-	if err := s.handler.DeleteSuppRule(req.Context(), tx, id); err != nil {
+	err := models.WithTx(req.Context(), tx, func(ctx context.Context, tx models.Txn) error {
+		return s.handler.DeleteSuppRule(ctx, tx, id)
+	})
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Unable to delete suppression rule: %v", err), http.StatusBadRequest)
 		return
 	}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	ah "github.com/mayuresh82/alert_manager/handler"
+	"github.com/mayuresh82/alert_manager/internal/election"
 	"github.com/mayuresh82/alert_manager/internal/models"
 	tu "github.com/mayuresh82/alert_manager/testutil"
 	"github.com/stretchr/testify/assert"
@@ -16,6 +17,7 @@ import (
 	"os"
 	"strconv"
 	"testing"
+	"time"
 )
 
 var mockRules = map[string]models.SuppressionRule{
@@ -106,6 +108,17 @@ func (tx *MockTx) Rollback() error {
 	return nil
 }
 
+func (tx *MockTx) SelectEscalations(query string, args ...interface{}) (models.Escalations, error) {
+	return nil, nil
+}
+
+func (tx *MockTx) SelectPendingClears(query string, args ...interface{}) (models.PendingClears, error) {
+	deadline := time.Now().Add(30 * time.Second)
+	return models.PendingClears{
+		&models.PendingClear{AlertId: 1, Name: "mock", Entity: "e1", Deadline: models.MyTime{deadline}, CreatedAt: models.MyTime{deadline.Add(-time.Minute)}},
+	}, nil
+}
+
 func (tx *MockTx) Exec(query string, args ...interface{}) error {
 	return nil
 }
@@ -247,6 +260,62 @@ func TestServerGet(t *testing.T) {
 	assert.Equal(t, len(b), 2)
 }
 
+func TestServerPendingClears(t *testing.T) {
+	s := NewMockServer()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/pending_clears", s.GetPendingClears).Methods("GET")
+
+	req, err := http.NewRequest("GET", "/api/pending_clears", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var clears []map[string]interface{}
+	if err := json.NewDecoder(rr.Result().Body).Decode(&clears); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(clears), 1)
+	assert.Equal(t, clears[0]["alert_id"], float64(1))
+	assert.Equal(t, clears[0]["name"], "mock")
+	assert.True(t, clears[0]["time_left"].(float64) > 0)
+}
+
+func (tx *MockTx) SelectLeases(query string, args ...interface{}) (models.Leases, error) {
+	return models.Leases{{Name: args[0].(string), Holder: "replica-a", ExpiresAt: models.MyTime{time.Now().Add(10 * time.Second)}}}, nil
+}
+
+func TestServerLeader(t *testing.T) {
+	s := NewMockServer()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/leader", s.GetLeader).Methods("GET")
+	get := func() map[string]interface{} {
+		req, err := http.NewRequest("GET", "/api/leader", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, rr.Code, http.StatusOK)
+		var status map[string]interface{}
+		if err := json.NewDecoder(rr.Result().Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	// a replica that does not take part in an election always leads
+	assert.Equal(t, get(), map[string]interface{}{"leader": true})
+
+	ah.UseElection(s.handler.Db, election.New(s.handler.Db, "replica-b", 0))
+	defer ah.UseElection(nil, nil)
+	status := get()
+	assert.Equal(t, status["replica"], "replica-b")
+	assert.Equal(t, status["leader"], false)
+	assert.Equal(t, status["lease"].(map[string]interface{})["holder"], "replica-a")
+}
+
 func TestServerUpdate(t *testing.T) {
 	s := NewMockServer()
 	router := mux.NewRouter()
@@ -338,6 +407,32 @@ func TestSuppRule(t *testing.T) {
 	}
 	assert.Equal(t, a["Id"].(float64), float64(1))
 
+	// maintenance window with an invalid schedule
+	body, _ = json.Marshal(&map[string]interface{}{
+		"Name":     "window",
+		"Entities": map[string]interface{}{"device": "d1"},
+		"Duration": 3600,
+		"Schedule": "0 25 * * *",
+		"Creator":  "test",
+	})
+	req, _ = http.NewRequest("POST", "/api/suppression_rules", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+
+	// invalid match expression
+	body, _ = json.Marshal(&map[string]interface{}{
+		"Name":     "expr",
+		"Expr":     "Priority < high",
+		"Duration": 300,
+		"Creator":  "test",
+	})
+	req, _ = http.NewRequest("POST", "/api/suppression_rules", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	assert.Contains(t, rr.Body.String(), "Priority < needs a number")
+
 	req, _ = http.NewRequest("DELETE", "/api/suppression_rules/1/clear", nil)
 	rr = httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mayuresh82/alert_manager/internal/models"
+)
+
+// userRequest is a user with the name of its team and the contact details used to page it
+type userRequest struct {
+	Name    string
+	Team    string
+	Email   string
+	SlackId string
+}
+
+func (s *Server) saveUser(w http.ResponseWriter, req *http.Request, id int64) {
+	ur := &userRequest{}
+	if err := json.NewDecoder(req.Body).Decode(ur); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid parameters for query: %v", err), http.StatusBadRequest)
+		return
+	}
+	user := &models.User{Id: id, Name: ur.Name, Email: ur.Email, SlackId: ur.SlackId}
+	tx := s.handler.Db.NewTx()
+	err := models.WithTx(req.Context(), tx, func(ctx context.Context, tx models.Txn) error {
+		var er error
+		user.Id, er = s.handler.SaveUser(ctx, tx, ur.Team, user)
+		return er
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save user: %v", err), http.StatusBadRequest)
+		return
+	}
+	user.Team = models.Team{Id: user.TeamId, Name: ur.Team}
+	s.statPosts.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (s *Server) CreateUser(w http.ResponseWriter, req *http.Request) {
+	s.saveUser(w, req, 0)
+}
+
+func (s *Server) UpdateUser(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil || id == 0 {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+	s.saveUser(w, req, id)
+}
+
+func (s *Server) DeleteUser(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	tx := s.handler.Db.NewTx()
+	err := models.WithTx(req.Context(), tx, func(ctx context.Context, tx models.Txn) error {
+		return s.handler.DeleteUser(ctx, tx, id)
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to delete user: %v", err), http.StatusBadRequest)
+	}
+}
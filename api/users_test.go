@@ -0,0 +1,64 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var updatedUsers []*models.User
+
+func (tx *MockTx) UpdateUser(u *models.User) error {
+	updatedUsers = append(updatedUsers, u)
+	return nil
+}
+
+func TestServerUser(t *testing.T) {
+	s := NewMockServer()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/users", s.CreateUser).Methods("POST")
+	router.HandleFunc("/api/users/{id}", s.UpdateUser).Methods("PUT")
+
+	body := `{"Name": "carol", "Team": "neteng", "Email": "carol@example.com", "SlackId": "U0456"}`
+	req, _ := http.NewRequest("POST", "/api/users", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var resp map[string]interface{}
+	if err := json.NewDecoder(rr.Result().Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, resp["Id"], float64(1))
+	assert.Equal(t, resp["TeamId"], float64(1))
+	assert.Equal(t, resp["Email"], "carol@example.com")
+	assert.Equal(t, resp["SlackId"], "U0456")
+	assert.Equal(t, resp["Team"].(map[string]interface{})["Name"], "neteng")
+
+	body = `{"Name": "carol", "Team": "neteng", "SlackId": "U0789"}`
+	req, _ = http.NewRequest("PUT", "/api/users/3", bytes.NewBufferString(body))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	if assert.Equal(t, len(updatedUsers), 1) {
+		assert.Equal(t, updatedUsers[0].Id, int64(3))
+		assert.Equal(t, updatedUsers[0].SlackId, "U0789")
+		assert.Equal(t, updatedUsers[0].Email, "")
+	}
+
+	// no name, invalid email
+	for _, body := range []string{
+		`{"Team": "neteng", "Email": "carol@example.com"}`,
+		`{"Name": "carol", "Team": "neteng", "Email": "carol"}`,
+	} {
+		req, _ = http.NewRequest("POST", "/api/users", bytes.NewBufferString(body))
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, rr.Code, http.StatusBadRequest, body)
+	}
+}
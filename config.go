@@ -12,6 +12,8 @@ import (
 
 type AgentConfig struct {
 	StatsExportInterval time.Duration `mapstructure:"stats_export_interval"`
+	// how long to wait for in-flight alerts to drain on shutdown
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
 }
 
 type ApiConfig struct {
@@ -31,11 +33,29 @@ type DbConfig struct {
 	Timeout                  int
 }
 
+type QueueConfig struct {
+	// directory of the on-disk ingestion queue, the queue is disabled if empty
+	Dir string
+}
+
+type HaConfig struct {
+	// replicas sharing the db elect a leader, which alone handles the alerts
+	Enabled bool
+	// unique id of the replica, <hostname>:<pid> by default
+	Id string
+	// time within which a lost leader is replaced
+	LeaseTime time.Duration `mapstructure:"lease_time"`
+}
+
 type Config struct {
 	Agent    *AgentConfig
 	Api      *ApiConfig
 	Db       *DbConfig
+	Queue    *QueueConfig
+	Ha       *HaConfig
 	Reporter *reporting.InfluxReporter
+	// maintenance calendars imported as suppression rules
+	Calendars []*handler.CalendarConfig
 }
 
 func (c *Config) UnmarshalTOML(data interface{}) error {
@@ -68,6 +88,28 @@ func (c *Config) UnmarshalTOML(data interface{}) error {
 				return err
 			}
 			c.Db = d
+		case "queue":
+			q := &QueueConfig{}
+			decoderConfig.Result = q
+			decoder, _ := mapstructure.NewDecoder(decoderConfig)
+			if err := decoder.Decode(v); err != nil {
+				return err
+			}
+			c.Queue = q
+		case "ha":
+			h := &HaConfig{}
+			decoderConfig.Result = h
+			decoder, _ := mapstructure.NewDecoder(decoderConfig)
+			if err := decoder.Decode(v); err != nil {
+				return err
+			}
+			c.Ha = h
+		case "calendars":
+			decoderConfig.Result = &c.Calendars
+			decoder, _ := mapstructure.NewDecoder(decoderConfig)
+			if err := decoder.Decode(value); err != nil {
+				return err
+			}
 		case "reporter":
 			r := &reporting.InfluxReporter{}
 			decoderConfig.Result = r
@@ -0,0 +1,352 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/internal/ical"
+	"github.com/mayuresh82/alert_manager/internal/models"
+)
+
+const CALENDAR_SYNC_INTERVAL = 5 * time.Minute
+
+// CalendarConfig defines a maintenance calendar whose events are imported as suppression rules
+type CalendarConfig struct {
+	Name string
+	// path of an .ics file or an http(s) URL
+	Source   string
+	Interval time.Duration
+	// suppression match label -> event property holding its value, e.g. device = "LOCATION".
+	// A comma separated property value matches any of its values.
+	Labels         map[string]string
+	MatchCondition string `mapstructure:"match_condition"`
+}
+
+// calendarSync keeps the suppression rules imported from a calendar in sync with its events
+type calendarSync struct {
+	conf   *CalendarConfig
+	db     models.Dbase
+	supp   *suppressor
+	client *http.Client
+}
+
+// StartCalendarSync periodically imports the events of the calendars as suppression rules
+func (h *AlertHandler) StartCalendarSync(ctx context.Context, calendars []*CalendarConfig) {
+	for _, conf := range calendars {
+		if conf.Name == "" || conf.Source == "" || len(conf.Labels) == 0 {
+			glog.Errorf("Calendar %q needs a name, a source and labels, not importing", conf.Name)
+			continue
+		}
+		c := &calendarSync{conf: conf, db: h.Db, supp: h.Suppressor, client: &http.Client{Timeout: 30 * time.Second}}
+		go c.run(ctx)
+	}
+}
+
+func (c *calendarSync) run(ctx context.Context) {
+	interval := c.conf.Interval
+	if interval == 0 {
+		interval = CALENDAR_SYNC_INTERVAL
+	}
+	c.sync(ctx, time.Now())
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			c.sync(ctx, time.Now())
+		}
+	}
+}
+
+// sync creates, updates and removes the rules of the calendar to match its upcoming events.
+// The rules are left alone if the calendar cannot be read. Replicas that elect a leader leave
+// the import to it.
+func (c *calendarSync) sync(ctx context.Context, now time.Time) {
+	if !IsLeader() {
+		return
+	}
+	cal, err := c.fetch(ctx)
+	if err != nil {
+		glog.Errorf("Failed to read calendar %s: %v", c.conf.Name, err)
+		return
+	}
+	desired := c.rules(cal, now)
+	var added, updated, removed int
+	tx := c.db.NewTx()
+	err = models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		existing, err := tx.SelectRules(models.QuerySelectExternal, likeEscaper.Replace(c.prefix())+"%")
+		if err != nil {
+			return err
+		}
+		for _, rule := range existing {
+			want, ok := desired[rule.ExternalId]
+			if !ok {
+				if err := c.supp.DeleteRule(ctx, tx, rule.Id); err != nil {
+					return err
+				}
+				removed++
+				continue
+			}
+			delete(desired, rule.ExternalId)
+			if sameRule(rule, want) {
+				continue
+			}
+			want.Id = rule.Id
+			if err := c.supp.UpdateRule(ctx, tx, want); err != nil {
+				return err
+			}
+			updated++
+		}
+		var ids []string
+		for id := range desired {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if _, err := c.supp.SaveRule(ctx, tx, desired[id]); err != nil {
+				return err
+			}
+			added++
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("Failed to sync calendar %s: %v", c.conf.Name, err)
+		return
+	}
+	glog.V(2).Infof("Synced calendar %s: %d rules added, %d updated, %d removed", c.conf.Name, added, updated, removed)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (c *calendarSync) prefix() string {
+	return "calendar/" + c.conf.Name + "/"
+}
+
+func (c *calendarSync) fetch(ctx context.Context) (*ical.Calendar, error) {
+	var r io.ReadCloser
+	src := c.conf.Source
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		req, err := http.NewRequest("GET", src, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Got status %s from %s", resp.Status, src)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(strings.TrimPrefix(src, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+	return ical.Parse(r)
+}
+
+// rules returns the rules for the events that have not ended yet, by external id
+func (c *calendarSync) rules(cal *ical.Calendar, now time.Time) map[string]*models.SuppressionRule {
+	mcond := models.MatchCond_ALL
+	if cond, ok := models.CondMap[c.conf.MatchCondition]; ok {
+		mcond = cond
+	}
+	// series with a modified occurrence cannot be a maintenance window
+	overridden := make(map[string]bool)
+	for _, event := range cal.Events {
+		if _, ok := event.Props["RECURRENCE-ID"]; ok {
+			overridden[event.UID()] = true
+		}
+	}
+	rules := make(map[string]*models.SuppressionRule)
+	for _, event := range cal.Events {
+		uid := event.UID()
+		if uid == "" || event.Cancelled() {
+			continue
+		}
+		if overridden[uid] {
+			if _, ok := event.Props["RECURRENCE-ID"]; !ok {
+				glog.Errorf("Calendar %s: skipping recurring event %s: modified occurrences are not supported", c.conf.Name, uid)
+			}
+			continue
+		}
+		start, err := event.Start(cal.Location)
+		if err != nil {
+			glog.Errorf("Calendar %s: skipping event %s: %v", c.conf.Name, uid, err)
+			continue
+		}
+		end, err := event.End(cal.Location)
+		if err != nil {
+			glog.Errorf("Calendar %s: skipping event %s: %v", c.conf.Name, uid, err)
+			continue
+		}
+		var (
+			schedule string
+			until    time.Time
+		)
+		if event.Recurring() {
+			if schedule, until, err = recurrence(event, start); err != nil {
+				glog.Errorf("Calendar %s: skipping recurring event %s: %v", c.conf.Name, uid, err)
+				continue
+			}
+		}
+		if !end.After(start) {
+			continue
+		}
+		// a recurring event lasts until its last occurrence, which starts at until at the latest
+		last := end
+		if schedule != "" {
+			last = time.Time{}
+			if !until.IsZero() {
+				last = until.Add(end.Sub(start))
+			}
+		}
+		if !last.IsZero() && !last.After(now) {
+			continue
+		}
+		entities, ok := c.entities(event)
+		if !ok {
+			glog.V(2).Infof("Calendar %s: skipping event %s without match labels", c.conf.Name, uid)
+			continue
+		}
+		name := event.Get("SUMMARY")
+		if name == "" {
+			name = uid
+		}
+		if len(name) > 128 {
+			name = name[:128]
+		}
+		reason := event.Get("DESCRIPTION")
+		if reason == "" {
+			reason = fmt.Sprintf("Maintenance from calendar %s", c.conf.Name)
+		}
+		rule := &models.SuppressionRule{
+			Name:       name,
+			Mcond:      mcond,
+			Entities:   entities,
+			CreatedAt:  models.MyTime{start},
+			Duration:   int64(end.Sub(start).Seconds()),
+			Reason:     reason,
+			Creator:    "calendar " + c.conf.Name,
+			ExternalId: c.prefix() + uid,
+		}
+		if schedule != "" {
+			// a maintenance window open at every occurrence, from the first one
+			rule.Schedule, rule.Timezone = schedule, start.Location().String()
+			if err := rule.Compile(); err != nil {
+				glog.Errorf("Calendar %s: skipping recurring event %s: %v", c.conf.Name, uid, err)
+				continue
+			}
+		}
+		rules[rule.ExternalId] = rule
+	}
+	return rules
+}
+
+var rruleDays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// recurrence returns the RRULE of a recurring event as the schedule of a maintenance window,
+// and the UNTIL of the RRULE, if any. The parts an RRULE takes from DTSTART are made explicit.
+// RDATE, EXDATE, COUNT and INTERVAL other than 1 cannot be expressed by a schedule.
+func recurrence(event *ical.Event, start time.Time) (string, time.Time, error) {
+	for _, name := range []string{"RDATE", "EXDATE"} {
+		if _, ok := event.Props[name]; ok {
+			return "", time.Time{}, fmt.Errorf("%s is not supported", name)
+		}
+	}
+	rrule := strings.ToUpper(event.Get("RRULE"))
+	if rrule == "" {
+		return "", time.Time{}, fmt.Errorf("Event has no RRULE")
+	}
+	var (
+		parts []string
+		freq  string
+		until time.Time
+	)
+	has := make(map[string]bool)
+	for _, part := range strings.Split(rrule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "INTERVAL":
+			if kv[1] != "1" {
+				return "", time.Time{}, fmt.Errorf("INTERVAL=%s is not supported", kv[1])
+			}
+			continue
+		case "UNTIL":
+			var err error
+			if until, err = ical.ParseTime(kv[1], start.Location()); err != nil {
+				return "", time.Time{}, fmt.Errorf("Invalid UNTIL %s: %v", kv[1], err)
+			}
+			continue
+		case "FREQ":
+			freq = kv[1]
+		}
+		has[kv[0]] = true
+		parts = append(parts, part)
+	}
+	if !has["BYMINUTE"] {
+		parts = append(parts, fmt.Sprintf("BYMINUTE=%d", start.Minute()))
+	}
+	if !has["BYHOUR"] && freq != "HOURLY" {
+		parts = append(parts, fmt.Sprintf("BYHOUR=%d", start.Hour()))
+	}
+	switch {
+	case freq == "WEEKLY" && !has["BYDAY"]:
+		parts = append(parts, "BYDAY="+rruleDays[start.Weekday()])
+	case freq == "MONTHLY" && !has["BYDAY"] && !has["BYMONTHDAY"]:
+		parts = append(parts, fmt.Sprintf("BYMONTHDAY=%d", start.Day()))
+	case freq == "YEARLY" && !has["BYDAY"]:
+		if !has["BYMONTH"] {
+			parts = append(parts, fmt.Sprintf("BYMONTH=%d", start.Month()))
+		}
+		if !has["BYMONTHDAY"] {
+			parts = append(parts, fmt.Sprintf("BYMONTHDAY=%d", start.Day()))
+		}
+	}
+	return strings.Join(parts, ";"), until, nil
+}
+
+// entities maps the event properties to match labels. All mapped properties have to be set
+// so that a rule does not match more than the event says.
+func (c *calendarSync) entities(event *ical.Event) (models.Labels, bool) {
+	entities := models.Labels{}
+	for label, prop := range c.conf.Labels {
+		var values []string
+		for _, v := range strings.Split(event.Get(prop), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, regexp.QuoteMeta(v))
+			}
+		}
+		if len(values) == 0 {
+			return nil, false
+		}
+		entities[label] = "^(" + strings.Join(values, "|") + ")$"
+	}
+	return entities, true
+}
+
+func sameRule(a, b *models.SuppressionRule) bool {
+	return a.Name == b.Name && a.Mcond == b.Mcond && a.Entities.Equal(b.Entities) &&
+		a.CreatedAt.Unix() == b.CreatedAt.Unix() && a.Duration == b.Duration &&
+		a.Reason == b.Reason && a.Creator == b.Creator &&
+		a.Schedule == b.Schedule && a.Timezone == b.Timezone
+}
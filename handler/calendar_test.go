@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// calendarDb keeps suppression rules by id
+type calendarDb struct {
+	rules  map[int64]*models.SuppressionRule
+	nextId int64
+}
+
+func (m *calendarDb) NewTx() models.Txn {
+	return &calendarTx{MockTx: &MockTx{}, db: m}
+}
+
+func (m *calendarDb) Close() error {
+	return nil
+}
+
+type calendarTx struct {
+	*MockTx
+	db *calendarDb
+}
+
+func (t *calendarTx) NewInsert(query string, item interface{}) (int64, error) {
+	rule := *item.(*models.SuppressionRule)
+	t.db.nextId++
+	rule.Id = t.db.nextId
+	t.db.rules[rule.Id] = &rule
+	return rule.Id, nil
+}
+
+func (t *calendarTx) UpdateRule(rule *models.SuppressionRule) error {
+	r := *rule
+	t.db.rules[rule.Id] = &r
+	return nil
+}
+
+func (t *calendarTx) SelectRules(query string, args ...interface{}) (models.SuppRules, error) {
+	prefix := strings.TrimSuffix(args[0].(string), "%")
+	var rules models.SuppRules
+	for _, rule := range t.db.rules {
+		if strings.HasPrefix(rule.ExternalId, prefix) {
+			r := *rule
+			rules = append(rules, &r)
+		}
+	}
+	return rules, nil
+}
+
+func (t *calendarTx) InQuery(query string, args ...interface{}) error {
+	for _, id := range args[0].([]int64) {
+		delete(t.db.rules, id)
+	}
+	return nil
+}
+
+func writeCalendar(t *testing.T, path string, events ...string) {
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0"}
+	for _, e := range events {
+		lines = append(lines, "BEGIN:VEVENT", e, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\r\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCalendarSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calendar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "changes.ics")
+
+	m := &calendarDb{rules: make(map[int64]*models.SuppressionRule)}
+	c := &calendarSync{
+		conf: &CalendarConfig{Name: "changes", Source: path, Labels: map[string]string{"device": "LOCATION"}},
+		db:   m,
+		supp: &suppressor{db: m},
+	}
+	ctx := context.Background()
+	now := time.Now().UTC()
+	at := func(d time.Duration) string { return now.Add(d).Format("20060102T150405Z") }
+
+	writeCalendar(t, path,
+		// in progress
+		"UID:chg-1\nSUMMARY:router upgrade\nLOCATION:rtr1.dc1, rtr2.dc1\nDTSTART:"+at(-time.Hour)+"\nDTEND:"+at(time.Hour),
+		// upcoming
+		"UID:chg-2\nSUMMARY:switch upgrade\nLOCATION:sw1.dc1\nDTSTART:"+at(2*time.Hour)+"\nDURATION:PT1H",
+		// ended, cancelled or without a device
+		"UID:chg-3\nLOCATION:sw2.dc1\nDTSTART:"+at(-2*time.Hour)+"\nDTEND:"+at(-time.Hour),
+		"UID:chg-4\nLOCATION:sw3.dc1\nSTATUS:CANCELLED\nDTSTART:"+at(-time.Hour)+"\nDTEND:"+at(time.Hour),
+		"UID:chg-5\nDTSTART:"+at(-time.Hour)+"\nDTEND:"+at(time.Hour),
+		// recurring, in progress or from tomorrow
+		"UID:chg-6\nSUMMARY:daily reboot\nLOCATION:fw1.dc1\nDTSTART:"+at(-25*time.Hour)+"\nDTEND:"+at(-23*time.Hour)+"\nRRULE:FREQ=DAILY",
+		"UID:chg-7\nLOCATION:fw2.dc1\nDTSTART:"+at(23*time.Hour)+"\nDTEND:"+at(25*time.Hour)+"\nRRULE:FREQ=DAILY;INTERVAL=1",
+		// recurring, ended, unsupported or with a modified occurrence
+		"UID:chg-8\nLOCATION:fw3.dc1\nDTSTART:"+at(-49*time.Hour)+"\nDTEND:"+at(-47*time.Hour)+"\nRRULE:FREQ=DAILY;UNTIL="+at(-48*time.Hour),
+		"UID:chg-9\nLOCATION:fw3.dc1\nDTSTART:"+at(-time.Hour)+"\nDTEND:"+at(time.Hour)+"\nRRULE:FREQ=DAILY;COUNT=3",
+		"UID:chg-10\nLOCATION:fw3.dc1\nDTSTART:"+at(-time.Hour)+"\nDTEND:"+at(time.Hour)+"\nRRULE:FREQ=WEEKLY;INTERVAL=2",
+		"UID:chg-11\nLOCATION:fw3.dc1\nDTSTART:"+at(-time.Hour)+"\nDTEND:"+at(time.Hour)+"\nRRULE:FREQ=DAILY",
+		"UID:chg-11\nLOCATION:fw3.dc1\nRECURRENCE-ID:"+at(23*time.Hour)+"\nDTSTART:"+at(24*time.Hour)+"\nDTEND:"+at(26*time.Hour),
+	)
+	c.sync(ctx, now)
+	assert.Equal(t, len(m.rules), 4)
+	rule := c.supp.Match(models.Labels{"device": "rtr2.dc1"})
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, rule.Name, "router upgrade")
+		assert.Equal(t, rule.ExternalId, "calendar/changes/chg-1")
+		assert.Equal(t, rule.Duration, int64(7200))
+	}
+	assert.Nil(t, c.supp.Match(models.Labels{"device": "rtr1.dc10"}))
+	// not started yet
+	assert.Nil(t, c.supp.Match(models.Labels{"device": "sw1.dc1"}))
+	assert.Nil(t, c.supp.Match(models.Labels{"device": "fw2.dc1"}))
+	assert.Nil(t, c.supp.Match(models.Labels{"device": "fw3.dc1"}))
+	rule = c.supp.Match(models.Labels{"device": "fw1.dc1"})
+	if assert.NotNil(t, rule) {
+		start := now.Add(-25 * time.Hour)
+		assert.Equal(t, rule.Schedule, fmt.Sprintf("FREQ=DAILY;BYMINUTE=%d;BYHOUR=%d", start.Minute(), start.Hour()))
+		assert.Equal(t, rule.Timezone, "UTC")
+		assert.Equal(t, rule.Duration, int64(7200))
+	}
+
+	// unchanged
+	c.sync(ctx, now)
+	assert.Equal(t, len(m.rules), 4)
+	assert.Equal(t, len(c.supp.suppRules), 4)
+
+	// chg-1 moved to another device, chg-2 removed
+	writeCalendar(t, path,
+		"UID:chg-1\nSUMMARY:router upgrade\nLOCATION:rtr3.dc1\nDTSTART:"+at(-time.Hour)+"\nDTEND:"+at(time.Hour),
+	)
+	c.sync(ctx, now)
+	assert.Equal(t, len(m.rules), 1)
+	assert.Equal(t, len(c.supp.suppRules), 1)
+	assert.Nil(t, c.supp.Match(models.Labels{"device": "rtr2.dc1"}))
+	assert.NotNil(t, c.supp.Match(models.Labels{"device": "rtr3.dc1"}))
+
+	// an unreadable calendar keeps the rules
+	os.Remove(path)
+	c.sync(ctx, now)
+	assert.Equal(t, len(m.rules), 1)
+}
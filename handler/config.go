@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"fmt"
 	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/internal/models"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 )
@@ -26,6 +29,14 @@ func (o Outs) Get(sev string) []string {
 type GeneralConfig struct {
 	DefaultOutputs        Outs          `yaml:"default_outputs"`
 	ClearHolddownInterval time.Duration `yaml:"clear_holddown_interval"`
+	// default alert identity
+	Identity *Identity
+	// default flap detection
+	FlapDetection *FlapDetection `yaml:"flap_detection"`
+	// default window after a clear or expiry within which a re-fired alert is reopened
+	ReopenWindow time.Duration `yaml:"reopen_window"`
+	// page the on-call of the alert team in notifications
+	NotifyOnCall bool `yaml:"notify_oncall"`
 }
 
 type AlertConfig struct {
@@ -36,13 +47,18 @@ type AlertConfig struct {
 		Tags             []string
 		Description      string
 		Source           string
-		AutoExpire       *bool         `yaml:"auto_expire"`
-		ExpireAfter      time.Duration `yaml:"expire_after"`
-		AutoClear        *bool         `yaml:"auto_clear"`
-		NotifyOnClear    bool          `yaml:"notify_on_clear"`
-		NotifyDelay      time.Duration `yaml:"notify_delay"`
-		NotifyRemind     time.Duration `yaml:"notify_remind"`
-		DisableNotify    bool          `yaml:"disable_notify"`
+		Identity         *Identity
+		FlapDetection    *FlapDetection `yaml:"flap_detection"`
+		ReopenWindow     *time.Duration `yaml:"reopen_window"`
+		AutoExpire       *bool          `yaml:"auto_expire"`
+		ExpireAfter      time.Duration  `yaml:"expire_after"`
+		AutoClear        *bool          `yaml:"auto_clear"`
+		NotifyOnClear    bool           `yaml:"notify_on_clear"`
+		NotifyDelay      time.Duration  `yaml:"notify_delay"`
+		NotifyRemind     time.Duration  `yaml:"notify_remind"`
+		DisableNotify    bool           `yaml:"disable_notify"`
+		OnCallTeam       string         `yaml:"oncall_team"` // page the on-call of this team instead of the alert team
+		EscalationPolicy string         `yaml:"escalation_policy"`
 		Outputs          Outs
 		StaticLabels     map[string]interface{} `yaml:"static_labels"`
 		AggregationRules []string               `yaml:"aggregation_rules"`
@@ -53,20 +69,116 @@ type AlertConfig struct {
 	}
 }
 
+// Matches selects alerts by their labels, either with label values ( regexes for strings )
+// that all have to match or with a match expression ( see models.MatchExpr )
+type Matches struct {
+	Labels map[string]interface{}
+	Expr   *models.MatchExpr
+}
+
+func (m *Matches) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var expr string
+	if err := unmarshal(&expr); err == nil {
+		e, err := models.ParseMatchExpr(expr)
+		if err != nil {
+			return fmt.Errorf("Invalid match expression %q: %v", expr, err)
+		}
+		m.Expr = e
+		return nil
+	}
+	return unmarshal(&m.Labels)
+}
+
+// Match reports whether the labels match. Empty matches do not match anything.
+func (m Matches) Match(labels models.Labels) bool {
+	if m.Expr == nil && len(m.Labels) == 0 {
+		return false
+	}
+	if m.Expr != nil && !m.Expr.Match(labels) {
+		return false
+	}
+	for k, v := range m.Labels {
+		lv, ok := labels[k]
+		if !ok || !models.MatchValue(v, lv) {
+			return false
+		}
+	}
+	return true
+}
+
 type AggregationRuleConfig struct {
 	Name    string
 	Window  time.Duration
 	GroupBy []string `yaml:"group_by"`
-	Matches map[string]interface{}
+	Matches Matches
 	Alert   AlertConfig
 }
 
 type SuppressionRuleConfig struct {
-	Name           string
-	Duration       time.Duration
-	Reason         string
+	Name     string
+	Duration time.Duration
+	Reason   string
+	// how the label matches are combined, all or any
 	MatchCondition string `yaml:"match_condition"`
-	Matches        map[string]interface{}
+	Matches        Matches
+}
+
+// RootCauseRuleConfig inhibits the alerts downstream of an active root cause alert in the
+// network topology ( see the topology processor )
+type RootCauseRuleConfig struct {
+	Name string
+	// name of the root cause alert
+	Alert string
+	// device: alerts on the root cause device, and alerts of its neighbors towards it.
+	// site: alerts on all devices of the root cause site.
+	Scope string
+	// scopes of the alerts to inhibit, default: all
+	TargetScopes []string `yaml:"target_scopes"`
+	// how long to hold alerts back waiting for a root cause alert
+	Delay time.Duration
+}
+
+// Targets reports whether alerts of a scope can be inhibited by the rule
+func (r RootCauseRuleConfig) Targets(scope string) bool {
+	if len(r.TargetScopes) == 0 {
+		return true
+	}
+	for _, s := range r.TargetScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// EscalationStep notifies its targets once an alert has been unacknowledged for After
+type EscalationStep struct {
+	After  time.Duration
+	SendTo []string `yaml:"send_to"`
+	// page the current on-call of these teams, or of a single schedule as team/schedule
+	OnCall []string `yaml:"oncall"`
+}
+
+// EscalationPolicy notifies more targets in steps while an alert stays unacknowledged
+type EscalationPolicy struct {
+	Name  string
+	Steps []EscalationStep
+}
+
+func (p *EscalationPolicy) validate() error {
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("no steps")
+	}
+	for i, step := range p.Steps {
+		if step.After < 0 {
+			return fmt.Errorf("step %d: negative delay", i+1)
+		}
+		if len(step.SendTo) == 0 && len(step.OnCall) == 0 {
+			return fmt.Errorf("step %d: no outputs or on-call targets", i+1)
+		}
+	}
+	sort.SliceStable(p.Steps, func(i, j int) bool { return p.Steps[i].After < p.Steps[j].After })
+	return nil
 }
 
 type InhibitRuleConfig struct {
@@ -88,6 +200,8 @@ type configs struct {
 	AggregationRuleConfigs []AggregationRuleConfig `yaml:"aggregation_rules"`
 	SuppressionRuleConfigs []SuppressionRuleConfig `yaml:"suppression_rules"`
 	InhibitRuleConfigs     []InhibitRuleConfig     `yaml:"inhibit_rules"`
+	EscalationPolicies     []EscalationPolicy      `yaml:"escalation_policies"`
+	RootCauseRuleConfigs   []RootCauseRuleConfig   `yaml:"root_cause_rules"`
 }
 
 func readConfig(file string) (configs, error) {
@@ -113,6 +227,8 @@ type ConfigHandler struct {
 	aggRules      map[string]AggregationRuleConfig
 	suppRules     map[string]SuppressionRuleConfig
 	inhibitRules  map[string]InhibitRuleConfig
+	policies      map[string]EscalationPolicy
+	rootCauses    map[string]RootCauseRuleConfig
 	sync.Mutex
 }
 
@@ -123,6 +239,8 @@ func NewConfigHandler(file string) *ConfigHandler {
 		aggRules:     make(map[string]AggregationRuleConfig),
 		suppRules:    make(map[string]SuppressionRuleConfig),
 		inhibitRules: make(map[string]InhibitRuleConfig),
+		policies:     make(map[string]EscalationPolicy),
+		rootCauses:   make(map[string]RootCauseRuleConfig),
 	}
 	c.LoadConfig()
 	return c
@@ -142,7 +260,31 @@ func (c *ConfigHandler) LoadConfig() {
 		glog.Fatalf("Unable to load config file : %v", err)
 	}
 	c.generalConfig = configs.GeneralConfig
+	if identity := c.generalConfig.Identity; identity != nil {
+		if err := identity.validate(); err != nil {
+			glog.Errorf("Invalid default identity, using Name:Entity:Device: %v", err)
+			c.generalConfig.Identity = nil
+		}
+	}
+	if flap := c.generalConfig.FlapDetection; flap != nil {
+		if err := flap.validate(); err != nil {
+			glog.Errorf("Invalid default flap detection, disabling: %v", err)
+			c.generalConfig.FlapDetection = nil
+		}
+	}
 	for _, config := range configs.AlertConfig {
+		if identity := config.Config.Identity; identity != nil {
+			if err := identity.validate(); err != nil {
+				glog.Errorf("Invalid identity for %s, using default: %v", config.Name, err)
+				config.Config.Identity = nil
+			}
+		}
+		if flap := config.Config.FlapDetection; flap != nil {
+			if err := flap.validate(); err != nil {
+				glog.Errorf("Invalid flap detection for %s, using default: %v", config.Name, err)
+				config.Config.FlapDetection = nil
+			}
+		}
 		c.alertConfigs[config.Name] = config
 	}
 	for _, rule := range configs.AggregationRuleConfigs {
@@ -155,6 +297,27 @@ func (c *ConfigHandler) LoadConfig() {
 	for _, rule := range configs.InhibitRuleConfigs {
 		c.inhibitRules[rule.Name] = rule
 	}
+	for _, rule := range configs.RootCauseRuleConfigs {
+		if rule.Alert == "" || (rule.Scope != "device" && rule.Scope != "site") {
+			glog.Errorf("Invalid root cause rule %s, needs an alert and a device or site scope", rule.Name)
+			continue
+		}
+		c.rootCauses[rule.Name] = rule
+	}
+	for _, policy := range configs.EscalationPolicies {
+		if err := policy.validate(); err != nil {
+			glog.Errorf("Invalid escalation policy %s, ignoring: %v", policy.Name, err)
+			continue
+		}
+		c.policies[policy.Name] = policy
+	}
+	for _, config := range c.alertConfigs {
+		if name := config.Config.EscalationPolicy; name != "" {
+			if _, ok := c.policies[name]; !ok {
+				glog.Errorf("Unknown escalation policy %s for %s", name, config.Name)
+			}
+		}
+	}
 }
 
 func (c *ConfigHandler) GetGeneralConfig() GeneralConfig {
@@ -203,6 +366,23 @@ func (c *ConfigHandler) GetInhibitRules() []InhibitRuleConfig {
 	return rules
 }
 
+func (c *ConfigHandler) GetRootCauseRules() []RootCauseRuleConfig {
+	c.Lock()
+	defer c.Unlock()
+	rules := []RootCauseRuleConfig{}
+	for _, rule := range c.rootCauses {
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (c *ConfigHandler) GetEscalationPolicy(name string) (EscalationPolicy, bool) {
+	c.Lock()
+	defer c.Unlock()
+	policy, ok := c.policies[name]
+	return policy, ok
+}
+
 func (c *ConfigHandler) GetAlertConfig(name string) (AlertConfig, bool) {
 	c.Lock()
 	defer c.Unlock()
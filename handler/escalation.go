@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/internal/models"
+)
+
+// escalator runs the escalation policies started by the notifier
+var escalator struct {
+	h *AlertHandler
+	sync.Mutex
+}
+
+func setEscalator(h *AlertHandler) {
+	escalator.Lock()
+	escalator.h = h
+	escalator.Unlock()
+}
+
+// getEscalationPolicy returns the escalation policy of an alert, if it has one
+func getEscalationPolicy(name string) (EscalationPolicy, bool) {
+	if Config == nil {
+		return EscalationPolicy{}, false
+	}
+	config, ok := Config.GetAlertConfig(name)
+	if !ok || config.Config.EscalationPolicy == "" {
+		return EscalationPolicy{}, false
+	}
+	return Config.GetEscalationPolicy(config.Config.EscalationPolicy)
+}
+
+// alertOutputs returns the outputs configured for an alert at its severity, or the default outputs
+func alertOutputs(alert *models.Alert) []string {
+	var outputs []string
+	if config, ok := Config.GetAlertConfig(alert.Name); ok {
+		outputs = config.Config.Outputs.Get(alert.Severity.String())
+	}
+	if len(outputs) == 0 {
+		outputs = Config.GetGeneralConfig().DefaultOutputs.Get(alert.Severity.String())
+	}
+	return outputs
+}
+
+// StartEscalationPolicy starts the escalation policy of an alert from the first step. It is
+// called by the notifier when it first notifies the alert, so that alerts held back by the
+// processors are not escalated, and aggregated alerts are escalated through their aggregate.
+// It returns the steps that are due right away, to be notified along with that notification.
+func StartEscalationPolicy(alert *models.Alert) []*models.AlertEvent {
+	escalator.Lock()
+	h := escalator.h
+	escalator.Unlock()
+	if h == nil || alert.AggregatorId != 0 {
+		return nil
+	}
+	var steps []*models.AlertEvent
+	tx := h.Db.NewTx()
+	err := models.WithTx(context.Background(), tx, func(ctx context.Context, tx models.Txn) error {
+		var er error
+		steps, er = h.startEscalationPolicy(ctx, tx, alert)
+		return er
+	})
+	if err != nil {
+		glog.Errorf("Failed to start escalation policy for alert %d: %v", alert.Id, err)
+		return nil
+	}
+	return steps
+}
+
+// startEscalationPolicy starts the escalation policy of an alert that became active and runs
+// the steps without a delay. It returns the events of the steps that ran.
+func (h *AlertHandler) startEscalationPolicy(ctx context.Context, tx models.Txn, alert *models.Alert) ([]*models.AlertEvent, error) {
+	policy, ok := getEscalationPolicy(alert.Name)
+	if !ok || alert.Owner.Valid {
+		return nil, nil
+	}
+	esc := &models.Escalation{
+		AlertId:   alert.Id,
+		Policy:    policy.Name,
+		State:     models.EscalationRunning,
+		StartedAt: models.MyTime{time.Now()},
+	}
+	if err := tx.Exec(models.QueryInsertEscalation, esc.AlertId, esc.Policy, esc.State, esc.StartedAt); err != nil {
+		h.statDbError.Add(1)
+		return nil, fmt.Errorf("Unable to start escalation policy for alert %d: %v", alert.Id, err)
+	}
+	tx.NewRecord(alert.Id, fmt.Sprintf("Escalation policy %s started", policy.Name))
+	return h.advanceEscalation(ctx, tx, alert, esc, policy)
+}
+
+// advanceEscalation runs the steps of the policy that are due and schedules the next one. It
+// returns the events of the steps that ran, which are only to be sent once tx is committed.
+func (h *AlertHandler) advanceEscalation(ctx context.Context, tx models.Txn, alert *models.Alert, esc *models.Escalation, policy EscalationPolicy) ([]*models.AlertEvent, error) {
+	now := time.Now()
+	var steps []*models.AlertEvent
+	for esc.Step < len(policy.Steps) && !esc.StartedAt.Add(policy.Steps[esc.Step].After).After(now) {
+		steps = append(steps, h.runEscalationStep(tx, alert, policy, esc.Step))
+		esc.Step++
+	}
+	if esc.Step < len(policy.Steps) {
+		h.scheduleEscalationStep(ctx, alert.Id, esc.StartedAt.Add(policy.Steps[esc.Step].After))
+	} else {
+		Timers.Cancel(policyKey(alert.Id))
+		esc.State = models.EscalationCompleted
+		tx.NewRecord(alert.Id, fmt.Sprintf("Escalation policy %s completed", policy.Name))
+	}
+	if err := tx.Exec(models.QueryUpdateEscalation, esc.AlertId, esc.Step, esc.State, models.MyTime{now}); err != nil {
+		h.statDbError.Add(1)
+		return nil, fmt.Errorf("Unable to update escalation policy for alert %d: %v", alert.Id, err)
+	}
+	return steps, nil
+}
+
+// runEscalationStep returns the event notifying the outputs of a step, along with the on-call
+// of its on-call targets. A step with only on-call targets pages them through the outputs of
+// the alert.
+func (h *AlertHandler) runEscalationStep(tx models.Txn, alert *models.Alert, policy EscalationPolicy, i int) *models.AlertEvent {
+	step := policy.Steps[i]
+	var onCall []*models.OnCall
+	for _, target := range step.OnCall {
+		team, schedule := target, ""
+		if idx := strings.Index(target, "/"); idx >= 0 {
+			team, schedule = target[:idx], target[idx+1:]
+		}
+		targetOnCall, err := WhoIsOnCall(tx, team, time.Now())
+		if err != nil {
+			glog.Errorf("Escalation policy %s: %v", policy.Name, err)
+			continue
+		}
+		for _, oc := range targetOnCall {
+			if schedule == "" || oc.Schedule == schedule {
+				onCall = append(onCall, oc)
+			}
+		}
+	}
+	outputs := step.SendTo
+	if len(outputs) == 0 {
+		outputs = alertOutputs(alert)
+	}
+	glog.V(2).Infof("Escalating alert %s:%d, policy %s step %d", alert.Name, alert.Id, policy.Name, i+1)
+	msg := fmt.Sprintf("Escalation policy %s step %d: notification sent to %v", policy.Name, i+1, outputs)
+	if len(onCall) > 0 {
+		var names []string
+		for _, oc := range onCall {
+			names = append(names, oc.User.Name)
+		}
+		msg += fmt.Sprintf(", on call: %s", strings.Join(names, ", "))
+	}
+	tx.NewRecord(alert.Id, msg)
+	return &models.AlertEvent{Alert: alert, Type: models.EventType_ESCALATED, OnCall: onCall, Outputs: outputs}
+}
+
+func (h *AlertHandler) scheduleEscalationStep(ctx context.Context, id int64, at time.Time) {
+	Timers.Schedule(policyKey(id), at, func() { h.handleEscalationStep(ctx, id) })
+}
+
+// handleEscalationStep runs the next steps of the escalation policy of an alert, or stops the
+// policy if the alert was acknowledged or is no longer active. The steps are sent down the
+// processors once the transaction is committed.
+func (h *AlertHandler) handleEscalationStep(ctx context.Context, id int64) {
+	var steps []*models.AlertEvent
+	tx := h.Db.NewTx()
+	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		escalations, err := tx.SelectEscalations(models.QuerySelectEscalation, id)
+		if err != nil {
+			return err
+		}
+		if len(escalations) == 0 || escalations[0].State != models.EscalationRunning {
+			return nil
+		}
+		esc := escalations[0]
+		alert, err := tx.GetAlert(models.QuerySelectById, id)
+		if err != nil {
+			glog.V(2).Infof("Alert %d with escalation policy no longer exists", id)
+			return nil
+		}
+		reason := ""
+		policy, ok := Config.GetEscalationPolicy(esc.Policy)
+		switch {
+		case alert.Owner.Valid:
+			reason = fmt.Sprintf("alert acknowledged by %s", alert.Owner.String)
+		case alert.Status != models.Status_ACTIVE:
+			reason = fmt.Sprintf("alert is %s", alert.Status.String())
+		case !ok:
+			reason = "policy no longer exists"
+		}
+		if reason != "" {
+			return h.stopEscalation(tx, id, esc.Policy, reason)
+		}
+		steps, err = h.advanceEscalation(ctx, tx, alert, esc, policy)
+		return err
+	})
+	if err != nil {
+		glog.Errorf("Failed to run escalation policy for alert %d: %v", id, err)
+		h.statDbError.Add(1)
+		return
+	}
+	for _, step := range steps {
+		h.process(step)
+	}
+}
+
+// stopEscalation stops the running escalation policy of an alert
+func (h *AlertHandler) stopEscalation(tx models.Txn, id int64, policy, reason string) error {
+	Timers.Cancel(policyKey(id))
+	if err := tx.Exec(models.QueryStopEscalation, id, models.EscalationStopped, models.MyTime{time.Now()}); err != nil {
+		h.statDbError.Add(1)
+		return fmt.Errorf("Unable to stop escalation policy for alert %d: %v", id, err)
+	}
+	tx.NewRecord(id, fmt.Sprintf("Escalation policy %s stopped, %s", policy, reason))
+	return nil
+}
+
+// cancelEscalation stops the escalation policy of an alert if it is running, also when its
+// next step is scheduled by another replica
+func (h *AlertHandler) cancelEscalation(tx models.Txn, id int64, reason string) {
+	escalations, err := tx.SelectEscalations(models.QuerySelectEscalation, id)
+	if err != nil {
+		Timers.Cancel(policyKey(id))
+		h.statDbError.Add(1)
+		glog.Errorf("Unable to get escalation policy for alert %d: %v", id, err)
+		return
+	}
+	if len(escalations) == 0 || escalations[0].State != models.EscalationRunning {
+		Timers.Cancel(policyKey(id))
+		return
+	}
+	if err := h.stopEscalation(tx, id, escalations[0].Policy, reason); err != nil {
+		glog.Errorf("%v", err)
+	}
+}
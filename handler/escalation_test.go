@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type escalationTx struct {
+	*oncallTx
+	alert   *models.Alert
+	esc     *models.Escalation
+	records []string
+}
+
+func (t *escalationTx) Exec(query string, args ...interface{}) error {
+	switch query {
+	case models.QueryInsertEscalation:
+		t.esc = &models.Escalation{
+			AlertId:   args[0].(int64),
+			Policy:    args[1].(string),
+			State:     args[2].(string),
+			StartedAt: args[3].(models.MyTime),
+		}
+	case models.QueryUpdateEscalation:
+		t.esc.Step, t.esc.State = args[1].(int), args[2].(string)
+	case models.QueryStopEscalation:
+		if t.esc.State == models.EscalationRunning {
+			t.esc.State = args[1].(string)
+		}
+	}
+	return nil
+}
+
+func (t *escalationTx) SelectEscalations(query string, args ...interface{}) (models.Escalations, error) {
+	if t.esc == nil {
+		return nil, nil
+	}
+	esc := *t.esc
+	return models.Escalations{&esc}, nil
+}
+
+func (t *escalationTx) GetAlert(query string, args ...interface{}) (*models.Alert, error) {
+	return t.alert, nil
+}
+
+func (t *escalationTx) UpdateAlert(alert *models.Alert) error {
+	return nil
+}
+
+func (t *escalationTx) NewRecord(alertId int64, event string) (int64, error) {
+	t.records = append(t.records, event)
+	return 1, nil
+}
+
+func (t *escalationTx) Commit() error {
+	return nil
+}
+
+func (t *escalationTx) Rollback() error {
+	return nil
+}
+
+type escalationDb struct {
+	tx *escalationTx
+}
+
+func (m *escalationDb) NewTx() models.Txn {
+	return m.tx
+}
+
+func (m *escalationDb) Close() error {
+	return nil
+}
+
+func TestConfigEscalationPolicy(t *testing.T) {
+	policy, ok := Config.GetEscalationPolicy("neteng-page")
+	assert.True(t, ok)
+	// steps are sorted by delay
+	assert.Equal(t, policy.Steps[0].After, time.Duration(0))
+	assert.Equal(t, policy.Steps[2].After, 25*time.Minute)
+	// a step needs targets
+	_, ok = Config.GetEscalationPolicy("no-targets")
+	assert.False(t, ok)
+}
+
+func TestEscalationPolicy(t *testing.T) {
+	defer Timers.Cancel(policyKey(700))
+
+	tx := &escalationTx{oncallTx: newOncallTx()}
+	start := "2024-01-01T09:00"
+	tx.schedules = models.Schedules{
+		{Id: 1, Name: "primary", Timezone: "UTC", Layers: models.Layers{{Rotation: models.RotationWeekly, Start: start, Users: []int64{1}}}},
+		{Id: 2, Name: "secondary", Timezone: "UTC", Layers: models.Layers{{Rotation: models.RotationWeekly, Start: start, Users: []int64{2}}}},
+	}
+	h := &AlertHandler{Db: &escalationDb{tx: tx}, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
+	h.procChan = make(chan *models.AlertEvent, 10)
+	setEscalator(h)
+	defer setEscalator(nil)
+	ctx := context.Background()
+	alert := tu.MockAlert(700, "Test Alert Policy", "", "d1", "e1", "grafana", "phy_interface", "t1", "1", "WARN", []string{}, nil)
+	alert.Status = models.Status_ACTIVE
+	tx.alert = alert
+	back := func(d time.Duration) {
+		tx.esc.StartedAt = models.MyTime{time.Now().Add(-d)}
+	}
+
+	// an aggregated alert is escalated through its aggregate
+	alert.AggregatorId = 800
+	assert.Equal(t, len(StartEscalationPolicy(alert)), 0)
+	assert.Nil(t, tx.esc)
+	alert.AggregatorId = 0
+
+	// the first step is due right away, and is notified along with the alert
+	steps := StartEscalationPolicy(alert)
+	assert.Equal(t, len(steps), 1)
+	assert.Equal(t, steps[0].Type, models.EventType_ESCALATED)
+	assert.Equal(t, steps[0].Outputs, []string{"slack"})
+	assert.Equal(t, len(steps[0].OnCall), 0)
+	assert.Equal(t, len(h.procChan), 0)
+	assert.Equal(t, tx.records, []string{
+		"Escalation policy neteng-page started",
+		"Escalation policy neteng-page step 1: notification sent to [slack]",
+	})
+	assert.Equal(t, tx.esc.Step, 1)
+	at, ok := Timers.Deadline(policyKey(700))
+	assert.True(t, ok)
+	assert.True(t, at.Equal(tx.esc.StartedAt.Add(10*time.Minute)))
+
+	// the next steps are sent down the processors. The primary on-call is paged through the
+	// alert outputs.
+	back(11 * time.Minute)
+	h.handleEscalationStep(ctx, 700)
+	event := <-h.procChan
+	assert.Equal(t, event.Type, models.EventType_ESCALATED)
+	assert.Equal(t, event.Outputs, []string{"slack"})
+	assert.Equal(t, event.OnCall[0].User.Name, "alice")
+	assert.Equal(t, tx.records[2], "Escalation policy neteng-page step 2: notification sent to [slack], on call: alice")
+
+	// the last step pages the secondary, there is no managers schedule
+	back(26 * time.Minute)
+	h.handleEscalationStep(ctx, 700)
+	event = <-h.procChan
+	assert.Equal(t, event.Outputs, []string{"victorops"})
+	assert.Equal(t, len(event.OnCall), 1)
+	assert.Equal(t, event.OnCall[0].User.Name, "bob")
+	assert.Equal(t, tx.records[4], "Escalation policy neteng-page completed")
+	assert.Equal(t, tx.esc.State, models.EscalationCompleted)
+	_, ok = Timers.Deadline(policyKey(700))
+	assert.False(t, ok)
+
+	// acknowledging the alert stops the policy, also when another replica runs its steps
+	tx.records = nil
+	_, err := h.startEscalationPolicy(ctx, tx, alert)
+	assert.Nil(t, err)
+	Timers.Cancel(policyKey(700))
+	assert.Nil(t, h.SetOwner(ctx, tx, alert, "bob", "t1"))
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_ACKD)
+	assert.Equal(t, tx.records[len(tx.records)-1], "Escalation policy neteng-page stopped, alert acknowledged by bob")
+	assert.Equal(t, tx.esc.State, models.EscalationStopped)
+	_, ok = Timers.Deadline(policyKey(700))
+	assert.False(t, ok)
+
+	// an acknowledged alert does not start its policy
+	tx.esc = nil
+	steps, err = h.startEscalationPolicy(ctx, tx, alert)
+	assert.Nil(t, err)
+	assert.Equal(t, len(steps), 0)
+	assert.Nil(t, tx.esc)
+
+	// a policy step of an alert that is no longer active stops the policy
+	alert = tu.MockAlert(700, "Test Alert Policy", "", "d1", "e1", "grafana", "phy_interface", "t1", "1", "WARN", []string{}, nil)
+	alert.Status = models.Status_ACTIVE
+	tx.alert = alert
+	_, err = h.startEscalationPolicy(ctx, tx, alert)
+	assert.Nil(t, err)
+	alert.Status = models.Status_CLEARED
+	back(11 * time.Minute)
+	h.handleEscalationStep(ctx, 700)
+	assert.Equal(t, len(h.procChan), 0)
+	assert.Equal(t, tx.records[len(tx.records)-1], "Escalation policy neteng-page stopped, alert is CLEARED")
+}
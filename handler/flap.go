@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+)
+
+const FLAP_CHECK_INTERVAL = 30 * time.Second
+
+// FlapDetection marks an alert as flapping when it changes state between active and cleared
+// at least Threshold times within Window. A flapping alert is not cleared and is notified
+// once, until it has been stable for StablePeriod.
+type FlapDetection struct {
+	Window time.Duration
+	// a threshold of 0 disables flap detection
+	Threshold int
+	// defaults to the window
+	StablePeriod time.Duration `yaml:"stable_period"`
+}
+
+func (f *FlapDetection) validate() error {
+	if f.Threshold < 0 {
+		return fmt.Errorf("Flap threshold cannot be negative")
+	}
+	if f.Threshold > 0 && f.Window <= 0 {
+		return fmt.Errorf("Flap detection requires a window")
+	}
+	return nil
+}
+
+func (f FlapDetection) stablePeriod() time.Duration {
+	if f.StablePeriod > 0 {
+		return f.StablePeriod
+	}
+	return f.Window
+}
+
+// getFlapDetection returns the flap detection config for the named alert
+func getFlapDetection(name string) FlapDetection {
+	if Config == nil {
+		return FlapDetection{}
+	}
+	if config, ok := Config.GetAlertConfig(name); ok && config.Config.FlapDetection != nil {
+		return *config.Config.FlapDetection
+	}
+	if conf := Config.GetGeneralConfig().FlapDetection; conf != nil {
+		return *conf
+	}
+	return FlapDetection{}
+}
+
+// flapState tracks the state changes of an alert identity
+type flapState struct {
+	conf        FlapDetection
+	last        models.EventType
+	transitions []time.Time
+	lastChange  time.Time
+	// the alert marked as flapping, nil if not flapping
+	alert *models.Alert
+}
+
+func (s *flapState) prune(now time.Time) {
+	i := 0
+	for i < len(s.transitions) && now.Sub(s.transitions[i]) > s.conf.Window {
+		i++
+	}
+	s.transitions = s.transitions[i:]
+}
+
+// flapDetector keeps a sliding window of state changes per alert fingerprint
+type flapDetector struct {
+	states map[string]*flapState
+	sync.Mutex
+}
+
+func newFlapDetector() *flapDetector {
+	return &flapDetector{states: make(map[string]*flapState)}
+}
+
+// observe records an active or clear event for an alert identity. It returns whether the
+// identity is already flapping, and whether it has crossed the threshold and should start.
+func (f *flapDetector) observe(fingerprint string, eventType models.EventType, conf FlapDetection, now time.Time) (flapping, start bool) {
+	if fingerprint == "" {
+		return false, false
+	}
+	f.Lock()
+	defer f.Unlock()
+	s, ok := f.states[fingerprint]
+	if !ok {
+		if conf.Threshold == 0 {
+			return false, false
+		}
+		f.states[fingerprint] = &flapState{conf: conf, last: eventType, lastChange: now}
+		return false, false
+	}
+	if s.alert == nil {
+		// pick up config changes while not flapping
+		s.conf = conf
+	}
+	if eventType != s.last {
+		s.last = eventType
+		s.lastChange = now
+		s.transitions = append(s.transitions, now)
+	}
+	s.prune(now)
+	if s.alert != nil {
+		return true, false
+	}
+	return false, s.conf.Threshold > 0 && len(s.transitions) >= s.conf.Threshold
+}
+
+// start marks the alert as flapping and returns the number of state changes in the window
+func (f *flapDetector) start(alert *models.Alert) int {
+	f.Lock()
+	defer f.Unlock()
+	s, ok := f.states[alert.Fingerprint]
+	if !ok {
+		return 0
+	}
+	s.alert = alert
+	return len(s.transitions)
+}
+
+// stable returns the flapping alerts that had no state changes for their stable period along
+// with their last observed state, and forgets identities that have gone quiet.
+func (f *flapDetector) stable(now time.Time) map[*models.Alert]models.EventType {
+	f.Lock()
+	defer f.Unlock()
+	ended := make(map[*models.Alert]models.EventType)
+	for fp, s := range f.states {
+		if s.alert != nil {
+			if now.Sub(s.lastChange) >= s.conf.stablePeriod() {
+				ended[s.alert] = s.last
+				delete(f.states, fp)
+			}
+			continue
+		}
+		if now.Sub(s.lastChange) > s.conf.Window {
+			delete(f.states, fp)
+		}
+	}
+	return ended
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// flapDb keeps the last inserted alert so that it can be found again until cleared
+type flapDb struct {
+	alert *models.Alert
+}
+
+func (m *flapDb) NewTx() models.Txn {
+	return &flapTx{MockTx: &MockTx{}, db: m}
+}
+
+func (m *flapDb) Close() error {
+	return nil
+}
+
+type flapTx struct {
+	*MockTx
+	db *flapDb
+}
+
+func (t *flapTx) NewInsert(query string, item interface{}) (int64, error) {
+	if alert, ok := item.(*models.Alert); ok {
+		t.db.alert = alert
+		return 500, nil
+	}
+	return t.MockTx.NewInsert(query, item)
+}
+
+func (t *flapTx) GetAlert(query string, args ...interface{}) (*models.Alert, error) {
+	if t.db.alert == nil || t.db.alert.Status != models.Status_ACTIVE {
+		return nil, fmt.Errorf("No alert found")
+	}
+	return t.db.alert, nil
+}
+
+func (t *flapTx) InQuery(query string, args ...interface{}) error {
+	return nil
+}
+
+func TestFlapDetector(t *testing.T) {
+	f := newFlapDetector()
+	conf := FlapDetection{Window: 10 * time.Minute, Threshold: 3}
+	now := time.Now()
+
+	// disabled
+	flapping, start := f.observe("fp0", models.EventType_ACTIVE, FlapDetection{}, now)
+	assert.False(t, flapping || start)
+	assert.Equal(t, len(f.states), 0)
+
+	// repeated events are not state changes
+	for _, e := range []models.EventType{models.EventType_ACTIVE, models.EventType_ACTIVE, models.EventType_CLEARED, models.EventType_CLEARED} {
+		_, start = f.observe("fp1", e, conf, now)
+		assert.False(t, start)
+	}
+	assert.Equal(t, len(f.states["fp1"].transitions), 1)
+
+	// changes outside the window are dropped
+	_, start = f.observe("fp1", models.EventType_ACTIVE, conf, now.Add(8*time.Minute))
+	assert.False(t, start)
+	_, start = f.observe("fp1", models.EventType_CLEARED, conf, now.Add(11*time.Minute))
+	assert.False(t, start)
+	assert.Equal(t, len(f.states["fp1"].transitions), 2)
+	_, start = f.observe("fp1", models.EventType_ACTIVE, conf, now.Add(12*time.Minute))
+	assert.True(t, start)
+
+	alert := &models.Alert{Id: 1, Fingerprint: "fp1"}
+	assert.Equal(t, f.start(alert), 3)
+	flapping, start = f.observe("fp1", models.EventType_CLEARED, conf, now.Add(13*time.Minute))
+	assert.True(t, flapping)
+	assert.False(t, start)
+
+	// stable period defaults to the window
+	assert.Equal(t, len(f.stable(now.Add(20*time.Minute))), 0)
+	ended := f.stable(now.Add(23 * time.Minute))
+	assert.Equal(t, ended, map[*models.Alert]models.EventType{alert: models.EventType_CLEARED})
+	_, ok := f.states["fp1"]
+	assert.False(t, ok)
+
+	// quiet identities are forgotten
+	f.observe("fp2", models.EventType_ACTIVE, conf, now)
+	assert.Equal(t, len(f.stable(now.Add(11*time.Minute))), 0)
+	assert.Equal(t, len(f.states), 0)
+}
+
+func TestHandlerFlapping(t *testing.T) {
+	m := &flapDb{}
+	defer Timers.Cancel(clearKey(500))
+	h := &AlertHandler{Db: m, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
+	h.procChan = make(chan *models.AlertEvent, 10)
+	h.flapper = newFlapDetector()
+	h.Suppressor = &suppressor{db: m}
+	ctx := context.Background()
+
+	newAlert := func() *models.Alert {
+		a := tu.MockAlert(0, "Test Alert Flap", "", "d1", "e1", "grafana", "phy_interface", "t1", "1", "WARN", []string{}, nil)
+		a.AutoClear = true
+		return a
+	}
+	// active, clear, active, clear: the third change starts flapping
+	h.handleActive(ctx, m.NewTx(), newAlert())
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_ACTIVE)
+	h.handleClear(ctx, m.NewTx(), newAlert(), 0)
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_CLEARED)
+	h.handleActive(ctx, m.NewTx(), newAlert())
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_ACTIVE)
+	models.WithTx(ctx, m.NewTx(), func(ctx context.Context, tx models.Txn) error {
+		return h.handleClear(ctx, tx, newAlert(), 0)
+	})
+	event := <-h.procChan
+	assert.Equal(t, event.Type, models.EventType_FLAPPING)
+	assert.Equal(t, event.Alert, m.alert)
+	assert.Equal(t, m.alert.Status, models.Status_ACTIVE)
+	// the clear is kept pending until after the stable period
+	at, ok := Timers.Deadline(clearKey(500))
+	assert.True(t, ok)
+	assert.True(t, at.After(time.Now().Add(5*time.Minute)))
+
+	// no more events while flapping
+	h.handleActive(ctx, m.NewTx(), newAlert())
+	h.handleClear(ctx, m.NewTx(), newAlert(), 0)
+	assert.Equal(t, len(h.procChan), 0)
+	assert.Equal(t, m.alert.Status, models.Status_ACTIVE)
+
+	// not stable yet
+	h.handleFlapEnd(ctx)
+	assert.Equal(t, len(h.procChan), 0)
+
+	// stable and last cleared
+	h.flapper.states[m.alert.Fingerprint].lastChange = time.Now().Add(-5 * time.Minute)
+	h.handleFlapEnd(ctx)
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_FLAP_ENDED)
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_CLEARED)
+	assert.Equal(t, m.alert.Status, models.Status_CLEARED)
+	assert.Equal(t, len(h.flapper.states), 0)
+	_, ok = Timers.Deadline(clearKey(500))
+	assert.False(t, ok)
+
+	// an alert last cleared while flapping is still cleared once the flap state is lost
+	for i := 0; i < 2; i++ {
+		h.handleActive(ctx, m.NewTx(), newAlert())
+		<-h.procChan
+		h.handleClear(ctx, m.NewTx(), newAlert(), 0)
+		<-h.procChan
+	}
+	assert.Equal(t, m.alert.Status, models.Status_ACTIVE)
+	h.flapper = newFlapDetector()
+	Timers.Cancel(clearKey(500))
+	h.handlePendingClear(ctx, 500)
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_CLEARED)
+	assert.Equal(t, m.alert.Status, models.Status_CLEARED)
+}
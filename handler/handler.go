@@ -2,6 +2,7 @@ package handler
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/mayuresh82/alert_manager/internal/models"
@@ -9,57 +10,32 @@ import (
 	"github.com/mayuresh82/alert_manager/plugins"
 	"regexp"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
-const (
-	EXPIRY_CHECK_INTERVAL     = 5 * time.Minute
-	ESCALATION_CHECK_INTERVAL = 3 * time.Minute
-	CLEAR_HOLDDOWN_INTERVAL   = 1 * time.Minute
-)
+const CLEAR_HOLDDOWN_INTERVAL = 1 * time.Minute
 
 // all listeners send alerts down this channel
 var ListenChan = make(chan *models.AlertEvent)
 
-// ClearHandler keeps a track of clearing active alerts
-type ClearHandler struct {
-	actives map[int64]chan struct{}
-	sync.RWMutex
-}
-
-func (c *ClearHandler) get(id int64) (chan struct{}, bool) {
-	c.RLock()
-	defer c.RUnlock()
-	resetClear, ok := c.actives[id]
-	return resetClear, ok
-}
-
-func (c *ClearHandler) add(id int64) chan struct{} {
-	c.Lock()
-	defer c.Unlock()
-	c.actives[id] = make(chan struct{})
-	return c.actives[id]
-}
-
-func (c *ClearHandler) delete(id int64) {
-	c.Lock()
-	defer c.Unlock()
-	delete(c.actives, id)
-}
-
 // AlertHandler handles common alert operations such as expiry, suppression etc.
 // It also sends alerts to interested receivers
 type AlertHandler struct {
 	// db handler
 	Db         models.Dbase
 	Suppressor *suppressor
+	// the processor pipeline input, only set while the replica leads
 	procChan   chan *models.AlertEvent
-	clearer    *ClearHandler
+	procMu     sync.RWMutex
+	flapper    *flapDetector
 	teams      models.Teams
+	unsuppress chan struct{}
 
 	statTransformError stats.Stat
 	statDbError        stats.Stat
+	statQueueDropped   stats.Stat
 }
 
 // NewHandler returns a new alert handler which uses the supplied db
@@ -78,72 +54,175 @@ func NewHandler(db models.Dbase) *AlertHandler {
 	h := &AlertHandler{
 		Db:                 db,
 		Suppressor:         GetSuppressor(db),
-		procChan:           make(chan *models.AlertEvent),
-		clearer:            &ClearHandler{actives: make(map[int64]chan struct{})},
+		flapper:            newFlapDetector(),
 		teams:              teams,
+		unsuppress:         make(chan struct{}, 1),
 		statTransformError: stats.NewCounter("handler.transform_errors"),
 		statDbError:        stats.NewCounter("handler.db_errors"),
+		statQueueDropped:   stats.NewCounter("handler.queue_dropped"),
+	}
+	if elector == nil {
+		// replicas that elect a leader start a pipeline each time they lead
+		h.procChan = make(chan *models.AlertEvent)
 	}
 	return h
 }
 
 // Start needs to be called in a go-routine
+// Start handles alerts until ctx is done. It then drains the processor pipeline and returns
+// once all processors have flushed their events. Events still in the queue stay on disk.
+// Replicas that elect a leader only handle alerts while they lead.
 func (h *AlertHandler) Start(ctx context.Context) {
-	// start the processor pipeline
+	if elector != nil {
+		elector.Run(ctx, h.lead)
+	} else {
+		h.lead(ctx)
+	}
+	glog.Infof("Alert handler stopped")
+}
+
+// lead handles alerts, runs the alert timers, the housekeeping and the processor pipeline
+// until ctx is done, and then drains the pipeline
+func (h *AlertHandler) lead(ctx context.Context) {
+	procChan := h.procChan
+	if elector != nil {
+		// each term starts over from the db: the timers of the previous term are dropped,
+		// and the handler and processors schedule theirs again
+		Timers.Clear()
+		procChan = make(chan *models.AlertEvent)
+		h.setProcChan(procChan)
+	}
+	setEscalator(h)
+	// start the processor pipeline. It outlives ctx so that it can finish the events
+	// handled before shutdown, and stops once procChan is closed.
+	pctx, pcancel := context.WithCancel(context.Background())
+	defer pcancel()
 	procPipeline := plugins.NewProcessorPipeline()
-	procPipeline.Run(ctx, h.Db, h.procChan)
+	pipelineDone := procPipeline.Run(pctx, h.Db, procChan)
+
+	var wg sync.WaitGroup
+	// alert deadlines
+	h.loadTimers(ctx)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		Timers.Run(ctx)
+	}()
 
 	// housekeeping
+	wg.Add(1)
 	go func() {
-		t1 := time.NewTicker(EXPIRY_CHECK_INTERVAL)
-		t2 := time.NewTicker(ESCALATION_CHECK_INTERVAL)
+		defer wg.Done()
+		t := time.NewTicker(FLAP_CHECK_INTERVAL)
+		defer t.Stop()
+		u := time.NewTicker(UNSUPPRESS_CHECK_INTERVAL)
+		defer u.Stop()
 		for {
 			select {
-			case <-t1.C:
-				h.handleExpiry(ctx)
-			case <-t2.C:
-				h.handleEscalation(ctx)
+			case <-t.C:
+				h.handleFlapEnd(ctx)
+			case <-u.C:
+				h.checkUnsuppress(ctx)
+			case <-h.unsuppress:
+				h.checkUnsuppress(ctx)
 			case <-ctx.Done():
 				return
 			}
 		}
 	}()
 	// start listening for alerts
-	for {
-		select {
-		case alertEvent := <-ListenChan:
-			tx := h.Db.NewTx()
-			err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
-				alert := alertEvent.Alert
-
-				switch alertEvent.Type {
-				case models.EventType_ACTIVE:
-					return h.handleActive(ctx, tx, alert)
-				case models.EventType_CLEARED:
-					holddown := Config.GetGeneralConfig().ClearHolddownInterval
-					if holddown == 0 {
-						holddown = CLEAR_HOLDDOWN_INTERVAL
-					}
-					return h.handleClear(ctx, tx, alert, holddown)
+	if elector != nil {
+		h.consumeShared(ctx)
+		glog.V(4).Infof("Closing handler shared queue consumer")
+	} else if eventQueue != nil {
+		h.consumeQueue(ctx)
+		glog.V(4).Infof("Closing handler queue consumer")
+	} else {
+	loop:
+		for {
+			select {
+			case alertEvent := <-ListenChan:
+				if err := h.handleEvent(ctx, alertEvent); err != nil {
+					glog.Errorf("Unable to Handle Alert: %v", err)
 				}
-				return nil
-			})
-			if err != nil {
-				glog.Errorf("Unable to Handle Alert: %v", err)
+			case <-ctx.Done():
+				glog.V(4).Infof("Closing handler listen loop")
+				break loop
 			}
+		}
+	}
+	// nothing sends to the processors once the timers and housekeeping have stopped. Events
+	// of api actions are handed over to the next leader from now on. The notifier drops what is
+	// left in the processors once the lease has lapsed, see HoldsLease.
+	wg.Wait()
+	h.setProcChan(nil)
+	close(procChan)
+	<-pipelineDone
+}
 
-		case <-ctx.Done():
-			glog.V(4).Infof("Closing handler listen loop")
-			close(h.procChan)
-			return
+func (h *AlertHandler) setProcChan(procChan chan *models.AlertEvent) {
+	h.procMu.Lock()
+	h.procChan = procChan
+	h.procMu.Unlock()
+}
+
+// process sends an event down the processor pipeline. The pipeline only runs on the leader,
+// the other replicas hand the event over to it.
+func (h *AlertHandler) process(event *models.AlertEvent) {
+	h.procMu.RLock()
+	defer h.procMu.RUnlock()
+	if h.procChan != nil {
+		h.procChan <- event
+		return
+	}
+	if elector == nil {
+		return
+	}
+	if err := forward(h.Db, models.QueueProcess, event); err != nil {
+		glog.Errorf("Unable to hand alert %d over to the leader: %v", event.Alert.Id, err)
+		h.statDbError.Add(1)
+	}
+}
+
+// handleEvent handles an alert event in its own transaction and returns the error of the
+// transaction, if any
+func (h *AlertHandler) handleEvent(ctx context.Context, alertEvent *models.AlertEvent) error {
+	tx := h.Db.NewTx()
+	return models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		return h.applyEvent(ctx, tx, alertEvent)
+	})
+}
+
+func (h *AlertHandler) applyEvent(ctx context.Context, tx models.Txn, alertEvent *models.AlertEvent) error {
+	alert := alertEvent.Alert
+
+	switch alertEvent.Type {
+	case models.EventType_ACTIVE:
+		return h.handleActive(ctx, tx, alert)
+	case models.EventType_CLEARED:
+		holddown := Config.GetGeneralConfig().ClearHolddownInterval
+		if holddown == 0 {
+			holddown = CLEAR_HOLDDOWN_INTERVAL
 		}
+		return h.handleClear(ctx, tx, alert, holddown)
 	}
+	return nil
 }
 
 func (h *AlertHandler) handleActive(ctx context.Context, tx models.Txn, alert *models.Alert) error {
-	if h.checkExisting(tx, alert) {
+	// the fingerprint is taken before transforms so that it matches the one of a clear
+	alert.Fingerprint = Fingerprint(alert)
+	flapping, startFlap := h.flapper.observe(alert.Fingerprint, models.EventType_ACTIVE, getFlapDetection(alert.Name), time.Now())
+	if existingAlert := h.checkExisting(ctx, tx, alert); existingAlert != nil {
+		if startFlap {
+			h.startFlapping(tx, existingAlert)
+		}
 		return nil
 	}
+	// the alert is already known but held back
+	if touched, err := h.touchSuppressed(tx, alert); touched || err != nil {
+		return err
+	}
 	// add transforms
 	h.applyTransforms(alert)
 
@@ -151,22 +230,22 @@ func (h *AlertHandler) handleActive(ctx context.Context, tx models.Txn, alert *m
 	alert.ExtendLabels()
 	if rule := h.Suppressor.Match(alert.Labels); rule != nil && rule.TimeLeft() > 0 {
 		glog.V(2).Infof("Found matching suppression rule for %s:%s:%s: %d:%s", alert.Name, alert.Entity, alert.Device.String, rule.Id, rule.Name)
+		if rule.Scheduled() {
+			return h.suppressByWindow(ctx, tx, alert, rule)
+		}
 		return nil
 	}
-	// new alert
-	if !h.teams.Contains(alert.Team) {
-		// create new team
-		if err := tx.Exec(models.NewPartition(alert.Team)); err != nil {
-			glog.Errorf("Failed to create new team partition: %v", err)
-		}
-		team := &models.Team{Name: alert.Team}
-		id, err := tx.NewInsert(models.QueryInsertTeam, team)
-		if err != nil {
-			glog.Errorf("Failed to create new team: %v", err)
-		}
-		team.Id = id
-		h.teams = append(h.teams, team)
+	// a recently cleared alert is reopened instead of creating a new one
+	reopened, err := h.reopen(ctx, tx, alert)
+	if err != nil {
+		return err
 	}
+	if reopened != nil {
+		h.checkFlapping(tx, reopened, flapping, startFlap)
+		return nil
+	}
+	// new alert
+	h.ensureTeam(tx, alert.Team)
 	newId, err := tx.NewInsert(models.QueryInsertAlert, alert)
 	if err != nil {
 		h.statDbError.Add(1)
@@ -174,52 +253,164 @@ func (h *AlertHandler) handleActive(ctx context.Context, tx models.Txn, alert *m
 	}
 	alert.Id = newId
 	glog.V(2).Infof("Received alert with ID: %v", alert.Id)
+	h.scheduleExpiry(ctx, alert)
+	h.scheduleEscalation(ctx, alert)
 	tx.NewRecord(newId, fmt.Sprintf("Alert created from source %s with severity %s",
 		alert.Source, alert.Severity.String()))
 	// Send to interested parties
 	h.notifyReceivers(alert, models.EventType_ACTIVE)
+	h.checkFlapping(tx, alert, flapping, startFlap)
 	return nil
 }
 
+// ensureTeam creates the team and its alert partition if it does not exist yet
+func (h *AlertHandler) ensureTeam(tx models.Txn, name string) {
+	if h.teams.Contains(name) {
+		return
+	}
+	if err := tx.Exec(models.NewPartition(name)); err != nil {
+		glog.Errorf("Failed to create new team partition: %v", err)
+	}
+	team := &models.Team{Name: name}
+	id, err := tx.NewInsert(models.QueryInsertTeam, team)
+	if err != nil {
+		glog.Errorf("Failed to create new team: %v", err)
+	}
+	team.Id = id
+	h.teams = append(h.teams, team)
+}
+
+// checkFlapping starts or carries on flapping for an alert that became active
+func (h *AlertHandler) checkFlapping(tx models.Txn, alert *models.Alert, flapping, startFlap bool) {
+	if startFlap {
+		h.startFlapping(tx, alert)
+	} else if flapping {
+		// the flapping alert was cleared manually, carry on with the new one
+		h.flapper.start(alert)
+	}
+}
+
+// getReopenWindow returns the reopen window for an alert. The per-alert config overrides the general one.
+func getReopenWindow(name string) time.Duration {
+	if Config == nil {
+		return 0
+	}
+	if config, ok := Config.GetAlertConfig(name); ok && config.Config.ReopenWindow != nil {
+		return *config.Config.ReopenWindow
+	}
+	return Config.GetGeneralConfig().ReopenWindow
+}
+
+// reopen reactivates the alert with the same fingerprint that cleared or expired within the
+// reopen window, keeping its id, owner and history. It returns nil if there is no such alert.
+func (h *AlertHandler) reopen(ctx context.Context, tx models.Txn, alert *models.Alert) (*models.Alert, error) {
+	window := getReopenWindow(alert.Name)
+	if window <= 0 {
+		return nil, nil
+	}
+	existingAlert, err := tx.GetAlert(models.QuerySelectReopenable, alert.Fingerprint, time.Now().Add(-window).Unix())
+	if err != nil {
+		glog.V(2).Infof("No recently cleared alert found for %s:%s", alert.Name, alert.Entity)
+		return nil, nil
+	}
+	previous := existingAlert.Status
+	changes, _ := updateFields(existingAlert, alert)
+	existingAlert.Status = models.Status_ACTIVE
+	existingAlert.LastActive = models.MyTime{time.Now()}
+	existingAlert.Occurrences++
+	existingAlert.EndedAt = sql.NullInt64{}
+	// the old aggregate has moved on, the alert is aggregated again
+	existingAlert.AggregatorId = 0
+	if err := tx.UpdateAlert(existingAlert); err != nil {
+		h.statDbError.Add(1)
+		return nil, fmt.Errorf("Unable to reopen alert %d: %v", existingAlert.Id, err)
+	}
+	glog.V(2).Infof("Reopened alert %s:%d", existingAlert.Name, existingAlert.Id)
+	tx.NewRecord(existingAlert.Id, fmt.Sprintf("Alert reopened after being %s, occurrence %d",
+		previous.String(), existingAlert.Occurrences))
+	if len(changes) > 0 {
+		tx.NewRecord(existingAlert.Id, "Alert updated: "+strings.Join(changes, ", "))
+	}
+	h.scheduleExpiry(ctx, existingAlert)
+	h.scheduleEscalation(ctx, existingAlert)
+	h.notifyReceivers(existingAlert, models.EventType_ACTIVE)
+	return existingAlert, nil
+}
+
 func (h *AlertHandler) handleClear(ctx context.Context, tx models.Txn, alert *models.Alert, holddown time.Duration) error {
+	if alert.Id == 0 && alert.Fingerprint == "" {
+		alert.Fingerprint = Fingerprint(alert)
+	}
+	flapping, startFlap := h.flapper.observe(alert.Fingerprint, models.EventType_CLEARED, getFlapDetection(alert.Name), time.Now())
 	// clear existing alert if auto clear is true
 	existingAlert, err := h.GetExisting(tx, alert)
 	if err != nil {
+		if alert.Id == 0 {
+			return h.clearSuppressed(ctx, tx, alert)
+		}
 		glog.V(2).Infof("No existing alert found for %s:%s to clear", alert.Name, alert.Entity)
 		return nil
 	}
+	if startFlap {
+		h.startFlapping(tx, existingAlert)
+		flapping = true
+	}
 	if !existingAlert.AutoClear {
 		glog.V(2).Infof("Not auto-clearing alert %d ", existingAlert.Id)
 		return nil
 	}
+	if flapping {
+		// the alert is cleared once it stops flapping. The flap state is only kept in memory,
+		// so the clear is also kept pending until after the stable period, in case the state
+		// is lost before, e.g. on a restart or a change of leader.
+		glog.V(2).Infof("Not clearing flapping alert %d", existingAlert.Id)
+		stable := getFlapDetection(existingAlert.Name).stablePeriod()
+		return h.savePendingClear(ctx, tx, existingAlert.Id, time.Now().Add(stable+2*FLAP_CHECK_INTERVAL))
+	}
 	// wait for a holddown period before clearing the alert to avoid flaps
 	if holddown == 0 {
 		return h.clearAlert(ctx, tx, existingAlert)
 	}
-	go func() {
-		if _, ok := h.clearer.get(existingAlert.Id); ok {
-			return
+	return h.savePendingClear(ctx, tx, existingAlert.Id, time.Now().Add(holddown))
+}
+
+// savePendingClear clears an alert at deadline unless it fires again before
+func (h *AlertHandler) savePendingClear(ctx context.Context, tx models.Txn, id int64, deadline time.Time) error {
+	// repeated clears keep the first deadline
+	if _, ok := Timers.Deadline(clearKey(id)); ok {
+		return nil
+	}
+	// the pending clear is persisted so that it survives a restart, and only scheduled
+	// once it is committed
+	if err := tx.Exec(models.QueryInsertPendingClear, id, models.MyTime{deadline}, models.MyTime{time.Now()}); err != nil {
+		h.statDbError.Add(1)
+		return fmt.Errorf("Unable to save pending clear for alert %d: %v", id, err)
+	}
+	models.AfterCommit(tx, func() { h.schedulePendingClear(ctx, id, deadline) })
+	return nil
+}
+
+// handlePendingClear clears an alert once its clear holddown has passed
+func (h *AlertHandler) handlePendingClear(ctx context.Context, id int64) {
+	tx := h.Db.NewTx()
+	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		if err := tx.Exec(models.QueryDeletePendingClear, id); err != nil {
+			h.statDbError.Add(1)
+			return err
 		}
-		t := time.NewTimer(holddown)
-		resetClear := h.clearer.add(existingAlert.Id)
-		defer h.clearer.delete(existingAlert.Id)
-		for {
-			select {
-			case <-t.C:
-				newTx := h.Db.NewTx()
-				err := models.WithTx(ctx, newTx, func(ctx context.Context, tx models.Txn) error {
-					return h.clearAlert(ctx, tx, existingAlert)
-				})
-				if err != nil {
-					glog.Error(err)
-				}
-				return
-			case <-resetClear:
-				return
-			}
+		alert, err := tx.GetAlert(models.QuerySelectById, id)
+		if err != nil {
+			glog.V(2).Infof("Alert %d with pending clear no longer exists", id)
+			return nil
 		}
-	}()
-	return nil
+		if alert.Status != models.Status_ACTIVE || !alert.AutoClear {
+			return nil
+		}
+		return h.clearAlert(ctx, tx, alert)
+	})
+	if err != nil {
+		glog.Errorf("Failed to clear alert %d after holddown: %v", id, err)
+	}
 }
 
 func (h *AlertHandler) clearAlert(ctx context.Context, tx models.Txn, alert *models.Alert) error {
@@ -231,11 +422,12 @@ func (h *AlertHandler) clearAlert(ctx context.Context, tx models.Txn, alert *mod
 	return nil
 }
 
-func (h *AlertHandler) checkExisting(tx models.Txn, alert *models.Alert) bool {
+// checkExisting updates and returns the active alert matching the alert, if any
+func (h *AlertHandler) checkExisting(ctx context.Context, tx models.Txn, alert *models.Alert) *models.Alert {
 	existingAlert, err := h.GetExisting(tx, alert)
 	if err != nil {
 		glog.V(2).Infof("No existing alert found for %s:%s", alert.Name, alert.Entity)
-		return false
+		return nil
 	}
 	// extend the expiry time if alert already exists
 	toUpdate := []int64{existingAlert.Id}
@@ -248,14 +440,63 @@ func (h *AlertHandler) checkExisting(tx models.Txn, alert *models.Alert) bool {
 	if err != nil {
 		h.statDbError.Add(1)
 		glog.Errorf("Failed update last active: %v", err)
+		return nil
 	}
-	if resetClear, ok := h.clearer.get(existingAlert.Id); ok {
-		resetClear <- struct{}{}
+	h.cancelPendingClear(tx, existingAlert.Id)
+	h.scheduleExpiry(ctx, existingAlert)
+	if err := h.updateExisting(ctx, tx, existingAlert, alert); err != nil {
+		glog.Errorf("Failed to apply alert update: %v", err)
+	}
+	return existingAlert
+}
+
+// startFlapping marks an alert as flapping and notifies about it
+func (h *AlertHandler) startFlapping(tx models.Txn, alert *models.Alert) {
+	if alert.Fingerprint == "" {
+		alert.Fingerprint = Fingerprint(alert)
+	}
+	changes := h.flapper.start(alert)
+	glog.V(2).Infof("Alert %s:%d is flapping", alert.Name, alert.Id)
+	conf := getFlapDetection(alert.Name)
+	tx.NewRecord(alert.Id, fmt.Sprintf("Alert is flapping: %d state changes in %v", changes, conf.Window))
+	h.notifyReceivers(alert, models.EventType_FLAPPING)
+}
+
+// handleFlapEnd ends flapping for alerts that have been stable for long enough. Alerts whose
+// last state was cleared are cleared.
+func (h *AlertHandler) handleFlapEnd(ctx context.Context) {
+	for alert, last := range h.flapper.stable(time.Now()) {
+		tx := h.Db.NewTx()
+		err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+			existingAlert, err := tx.GetAlert(models.QuerySelectById, alert.Id)
+			if err != nil {
+				return err
+			}
+			if existingAlert.Status != models.Status_ACTIVE {
+				return nil
+			}
+			glog.V(2).Infof("Alert %s:%d stopped flapping", existingAlert.Name, existingAlert.Id)
+			tx.NewRecord(existingAlert.Id, fmt.Sprintf("Alert stopped flapping, last state %s", last.String()))
+			h.notifyReceivers(existingAlert, models.EventType_FLAP_ENDED)
+			if last == models.EventType_CLEARED && existingAlert.AutoClear {
+				return h.clearAlert(ctx, tx, existingAlert)
+			}
+			return nil
+		})
+		if err != nil {
+			glog.Errorf("Failed to end flapping for alert %d: %v", alert.Id, err)
+		}
 	}
-	return err == nil
 }
 
 func (h *AlertHandler) applyTransforms(alert *models.Alert) {
+	if errs := applyTransforms(alert); len(errs) > 0 {
+		h.statTransformError.Add(int64(len(errs)))
+	}
+}
+
+// applyTransforms applies all transforms registered for the alert and returns the transform errors
+func applyTransforms(alert *models.Alert) []error {
 	// apply transforms in order of priority. Lower == first
 	var toApply []Transform
 	for _, transform := range Transforms {
@@ -269,96 +510,119 @@ func (h *AlertHandler) applyTransforms(alert *models.Alert) {
 	sort.Slice(toApply, func(i, j int) bool {
 		return toApply[i].GetPriority() < toApply[j].GetPriority()
 	})
+	var errs []error
 	for _, xform := range toApply {
 		glog.V(2).Infof("Applying Transform: %s to alert %s", xform.Name(), alert.Name)
 		if err := xform.Apply(alert); err != nil {
 			glog.Errorf("Failed to apply transform %s to alert %s: %v", xform.Name(), alert.Name, err)
-			h.statTransformError.Add(1)
+			errs = append(errs, fmt.Errorf("Transform %s: %v", xform.Name(), err))
 		}
 	}
+	return errs
+}
+
+// DryRun applies the transforms and suppression rule matching to an alert the same way as for a
+// new alert, without touching the db or sending it to the processors. It returns the suppression
+// rule that would suppress the alert, if any, along with any transform errors.
+func DryRun(alert *models.Alert) (*models.SuppressionRule, []error) {
+	errs := applyTransforms(alert)
+	alert.ExtendLabels()
+	if suppr == nil {
+		return nil, errs
+	}
+	if rule := suppr.Match(alert.Labels); rule != nil && rule.TimeLeft() > 0 {
+		return rule, errs
+	}
+	return nil, errs
 }
 
 func (h *AlertHandler) notifyReceivers(alert *models.Alert, eventType models.EventType) {
 	event := &models.AlertEvent{Alert: alert, Type: eventType}
 	// send the alert down the processor pipeline
 	if len(plugins.Processors) > 0 {
-		h.procChan <- event
+		h.process(event)
 	}
 	if influxOut, ok := GetOutput("influx"); ok {
 		influxOut <- event
 	}
 }
 
-func (h *AlertHandler) handleExpiry(ctx context.Context) {
+// handleExpiry expires an alert that received no update for its expire_after duration
+func (h *AlertHandler) handleExpiry(ctx context.Context, id int64) {
 	tx := h.Db.NewTx()
 	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
-		expired, err := tx.SelectAlerts(models.QuerySelectExpired)
+		ex, err := tx.GetAlert(models.QuerySelectById, id)
 		if err != nil {
 			return err
 		}
-		for _, ex := range expired {
-			if ex.IsAggregate {
-				// aggregate expiry handled by aggregators
-				continue
-			}
-			glog.V(2).Infof("Alert ID %d has now expired", ex.Id)
-			ex.Status = models.Status_EXPIRED
-			if err := tx.UpdateAlert(ex); err != nil {
-				return err
-			}
-			tx.NewRecord(ex.Id, "Alert expired")
-			toSend := ex // this copy needed to avoid overwriting
-			h.notifyReceivers(toSend, models.EventType_EXPIRED)
+		if ex.Status != models.Status_ACTIVE {
+			return nil
 		}
+		if at, ok := expiresAt(ex); !ok || time.Now().Before(at) {
+			// updated since the timer was scheduled
+			h.scheduleExpiry(ctx, ex)
+			return nil
+		}
+		glog.V(2).Infof("Alert ID %d has now expired", ex.Id)
+		ex.Expire()
+		if err := tx.UpdateAlert(ex); err != nil {
+			return err
+		}
+		h.cancelTimers(tx, ex.Id)
+		tx.NewRecord(ex.Id, "Alert expired")
+		h.notifyReceivers(ex, models.EventType_EXPIRED)
 		return nil
 	})
 	if err != nil {
-		glog.Errorf("Failed to update expired alerts: %v", err)
+		glog.Errorf("Failed to update expired alert %d: %v", id, err)
 		h.statDbError.Add(1)
 	}
 }
 
-func (h *AlertHandler) handleEscalation(ctx context.Context) {
+// handleEscalation bumps up the severity of an unacknowledged alert according to the
+// escalation rules of the alert and schedules the next step
+func (h *AlertHandler) handleEscalation(ctx context.Context, id int64) {
 	tx := h.Db.NewTx()
 	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
-		unAckd, err := tx.SelectAlerts(models.QuerySelectNoOwner)
+		alert, err := tx.GetAlert(models.QuerySelectById, id)
 		if err != nil {
 			return err
 		}
-		for _, alert := range unAckd {
-			config, ok := Config.GetAlertConfig(alert.Name)
-			if !ok {
-				glog.V(4).Infof("Failed to check escalation for %s : No config found", alert.Name)
+		if alert.Status != models.Status_ACTIVE || alert.Owner.Valid {
+			return nil
+		}
+		config, ok := Config.GetAlertConfig(alert.Name)
+		if !ok {
+			glog.V(4).Infof("Failed to check escalation for %s : No config found", alert.Name)
+			return nil
+		}
+		var changed bool
+		for _, rule := range config.Config.EscalationRules {
+			newSev := models.SevMap[rule.EscalateTo]
+			if newSev >= alert.Severity {
 				continue
 			}
-			var changed bool
-			for _, rule := range config.Config.EscalationRules {
-				newSev := models.SevMap[rule.EscalateTo]
-				if newSev >= alert.Severity {
-					continue
+			timePassed := time.Now().Sub(alert.StartTime.Time)
+			if timePassed >= rule.After {
+				changed = true
+				glog.V(2).Infof("Escalating alert %s:%d to %s", alert.Name, alert.Id, rule.EscalateTo)
+				alert.SetSeverity(newSev)
+				if err := tx.UpdateAlert(alert); err != nil {
+					return err
 				}
-				timePassed := time.Now().Sub(alert.StartTime.Time)
-				if timePassed >= rule.After {
-					changed = true
-					glog.V(2).Infof("Escalating alert %s:%d to %s", alert.Name, alert.Id, rule.EscalateTo)
-					alert.SetSeverity(newSev)
-					if err := tx.UpdateAlert(alert); err != nil {
-						return err
-					}
-					tx.NewRecord(alert.Id, fmt.Sprintf(
-						"Alert severity escalated to %s", newSev.String()))
-					break
-				}
-			}
-			if changed {
-				toSend := alert // this copy needed to avoid overwriting
-				h.notifyReceivers(toSend, models.EventType_ESCALATED)
+				tx.NewRecord(alert.Id, fmt.Sprintf(
+					"Alert severity escalated to %s", newSev.String()))
+				break
 			}
 		}
+		if changed {
+			h.notifyReceivers(alert, models.EventType_ESCALATED)
+		}
+		h.scheduleEscalation(ctx, alert)
 		return nil
 	})
 	if err != nil {
-		glog.Errorf("Failed to escalate alerts : %v", err)
+		glog.Errorf("Failed to escalate alert %d: %v", id, err)
 		h.statDbError.Add(1)
 	}
 }
@@ -366,14 +630,16 @@ func (h *AlertHandler) handleEscalation(ctx context.Context) {
 func (h *AlertHandler) GetExisting(tx models.Txn, alert *models.Alert) (*models.Alert, error) {
 	var existing *models.Alert
 	var err error
-	// an alert is assumed to be uniquely identified by its Id or by its Name:Device:Entity
+	// an alert is uniquely identified by its Id or by its fingerprint
 	if alert.Id > 0 {
 		existing, err = tx.GetAlert(models.QuerySelectById, alert.Id)
 	} else {
-		if alert.Device.Valid {
-			existing, err = tx.GetAlert(models.QuerySelectByDevice, alert.Name, alert.Entity, alert.Device.String)
-		} else {
-			existing, err = tx.GetAlert(models.QuerySelectByNameEntity, alert.Name, alert.Entity)
+		if alert.Fingerprint == "" {
+			alert.Fingerprint = Fingerprint(alert)
+		}
+		existing, err = tx.GetAlert(models.QuerySelectByFingerprint, alert.Fingerprint)
+		if err != nil {
+			existing, err = h.adoptUnfingerprinted(tx, alert)
 		}
 	}
 	if err != nil {
@@ -382,6 +648,27 @@ func (h *AlertHandler) GetExisting(tx models.Txn, alert *models.Alert) (*models.
 	return existing, nil
 }
 
+// adoptUnfingerprinted looks up an active alert created before alerts had a fingerprint the
+// way they were identified then, by Name:Entity:Device, and sets the fingerprint of alert on it
+func (h *AlertHandler) adoptUnfingerprinted(tx models.Txn, alert *models.Alert) (*models.Alert, error) {
+	var existing *models.Alert
+	var err error
+	if alert.Device.Valid {
+		existing, err = tx.GetAlert(models.QuerySelectUnfingerprintedByDevice, alert.Name, alert.Entity, alert.Device.String)
+	} else {
+		existing, err = tx.GetAlert(models.QuerySelectUnfingerprinted, alert.Name, alert.Entity)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Exec(models.QueryUpdateFingerprint, alert.Fingerprint, existing.Id); err != nil {
+		h.statDbError.Add(1)
+		return nil, fmt.Errorf("Unable to set fingerprint of alert %d: %v", existing.Id, err)
+	}
+	existing.Fingerprint = alert.Fingerprint
+	return existing, nil
+}
+
 func (h *AlertHandler) Suppress(
 	ctx context.Context,
 	tx models.Txn,
@@ -389,18 +676,23 @@ func (h *AlertHandler) Suppress(
 	creator, reason string,
 	duration time.Duration,
 ) error {
-	if err := h.Suppressor.SuppressAlert(ctx, tx, alert, duration); err != nil {
-		return fmt.Errorf("Unable to suppress alert %d: %v", alert.Id, err)
-	}
-	// create a new supp rule to suppress any future similar alerts
-	ents := models.Labels{"alert_name": alert.Name, "entity": alert.Entity}
+	// create a new supp rule to suppress any future similar alerts. The values are matched
+	// exactly, which also lets the suppressor index the rule. The alert is unsuppressed when
+	// the rule expires or is deleted.
+	exact := func(v string) string { return "^" + regexp.QuoteMeta(v) + "$" }
+	ents := models.Labels{"alert_name": exact(alert.Name), "entity": exact(alert.Entity)}
 	if alert.Device.Valid {
-		ents["device"] = alert.Device.String
+		ents["device"] = exact(alert.Device.String)
 	}
 	r := models.NewSuppRule(ents, models.MatchCond_ALL, reason, "alert_manager", duration)
-	if _, err := h.AddSuppRule(ctx, tx, r); err != nil {
+	ruleId, err := h.AddSuppRule(ctx, tx, r)
+	if err != nil {
 		return fmt.Errorf("Failed to suppress alert: %v", err)
 	}
+	alert.SuppressedBy = ruleId
+	if err := h.Suppressor.SuppressAlert(ctx, tx, alert, duration); err != nil {
+		return fmt.Errorf("Unable to suppress alert %d: %v", alert.Id, err)
+	}
 	tx.NewRecord(alert.Id, fmt.Sprintf("Alert Suppressed by %s for %v : %s", creator, duration, reason))
 	h.notifyReceivers(alert, models.EventType_SUPPRESSED)
 	return nil
@@ -408,10 +700,11 @@ func (h *AlertHandler) Suppress(
 
 func (h *AlertHandler) Clear(ctx context.Context, tx models.Txn, alert *models.Alert) error {
 	alert.Clear()
-	if err := tx.Exec(models.QueryUpdateStatus, models.Status_CLEARED, alert.Id); err != nil {
+	if err := tx.Exec(models.QueryUpdateEnded, models.Status_CLEARED, alert.EndedAt, alert.Id); err != nil {
 		h.statDbError.Add(1)
 		return err
 	}
+	h.cancelTimers(tx, alert.Id)
 	tx.NewRecord(alert.Id, "Alert cleared")
 	h.notifyReceivers(alert, models.EventType_CLEARED)
 	return nil
@@ -424,7 +717,9 @@ func (h *AlertHandler) SetOwner(ctx context.Context, tx models.Txn, alert *model
 		h.statDbError.Add(1)
 		return err
 	}
+	Timers.Cancel(escalationKey(alert.Id))
 	tx.NewRecord(alert.Id, fmt.Sprintf("Alert owner set to %s, team set to %s", name, teamName))
+	h.cancelEscalation(tx, alert.Id, fmt.Sprintf("alert acknowledged by %s", name))
 	// Notify all the receivers
 	h.notifyReceivers(alert, models.EventType_ACKD)
 	return nil
@@ -435,7 +730,12 @@ func (h *AlertHandler) AddSuppRule(ctx context.Context, tx models.Txn, rule *mod
 	return h.Suppressor.SaveRule(ctx, tx, rule)
 }
 
-// DeleteSuppRule deletes an existing suppression rule from the suppressor
+// DeleteSuppRule deletes an existing suppression rule from the suppressor. The alerts it
+// suppressed are released.
 func (h *AlertHandler) DeleteSuppRule(ctx context.Context, tx models.Txn, id int64) error {
-	return h.Suppressor.DeleteRule(ctx, tx, id)
+	if err := h.Suppressor.DeleteRule(ctx, tx, id); err != nil {
+		return err
+	}
+	h.checkUnsuppressSoon()
+	return nil
 }
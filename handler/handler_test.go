@@ -2,6 +2,7 @@ package handler
 
 import (
 	"context"
+	"database/sql"
 	"flag"
 	"fmt"
 	"github.com/mayuresh82/alert_manager/internal/models"
@@ -63,11 +64,17 @@ func (t *MockTx) Commit() error {
 }
 
 func (t *MockTx) GetAlert(query string, args ...interface{}) (*models.Alert, error) {
-	if args[0].(string) == "Test Alert 1" {
+	if fp, ok := args[0].(string); ok && fp == Fingerprint(mockAlerts["existing_a1"]) {
 		return mockAlerts["existing_a1"], nil
-	} else {
-		return nil, fmt.Errorf("No alert found")
 	}
+	if id, ok := args[0].(int64); ok {
+		for _, alert := range mockAlerts {
+			if alert.Id == id {
+				return alert, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("No alert found")
 }
 
 func (t *MockTx) InQuery(query string, args ...interface{}) error {
@@ -91,13 +98,11 @@ func (tx *MockTx) Exec(query string, args ...interface{}) error {
 	return nil
 }
 
+func (t *MockTx) SelectEscalations(query string, args ...interface{}) (models.Escalations, error) {
+	return nil, nil
+}
+
 func (t *MockTx) SelectAlerts(query string, args ...interface{}) (models.Alerts, error) {
-	switch query {
-	case models.QuerySelectExpired:
-		return models.Alerts{mockAlerts["existing_a3"]}, nil
-	case models.QuerySelectNoOwner:
-		return models.Alerts{mockAlerts["existing_a4"]}, nil
-	}
 	return models.Alerts{}, nil
 }
 
@@ -141,7 +146,7 @@ func TestHandlerAlertActive(t *testing.T) {
 	tx := m.NewTx()
 	h := &AlertHandler{Db: m, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
 	h.procChan = make(chan *models.AlertEvent, 1)
-	h.clearer = &ClearHandler{actives: make(map[int64]chan struct{})}
+	h.flapper = newFlapDetector()
 	h.Suppressor = &suppressor{db: m}
 	ctx := context.Background()
 
@@ -170,7 +175,10 @@ func TestHandlerAlertActive(t *testing.T) {
 
 	// test new active alert - suppressed
 	rule := models.NewSuppRule(models.Labels{"device": "d2"}, models.MatchCond_ALL, "", "", time.Duration(1*time.Minute))
-	h.Suppressor.SaveRule(ctx, tx, rule)
+	models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		_, err := h.Suppressor.SaveRule(ctx, tx, rule)
+		return err
+	})
 	a2 = tu.MockAlert(0, "Test Alert 2", "", "d2", "e2", "src2", "scp2", "t1", "2", "WARN", []string{"c", "d"}, nil)
 	h.handleActive(ctx, tx, a2)
 }
@@ -180,7 +188,7 @@ func TestHandlerAlertClear(t *testing.T) {
 	tx := m.NewTx()
 	h := &AlertHandler{Db: m, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
 	h.procChan = make(chan *models.AlertEvent, 1)
-	h.clearer = &ClearHandler{actives: make(map[int64]chan struct{})}
+	h.flapper = newFlapDetector()
 	h.Suppressor = &suppressor{db: m}
 	ctx := context.Background()
 
@@ -207,43 +215,277 @@ func TestHandlerAlertExpiry(t *testing.T) {
 	m := &MockDb{}
 	h := &AlertHandler{Db: m, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
 	h.procChan = make(chan *models.AlertEvent, 1)
-	h.clearer = &ClearHandler{actives: make(map[int64]chan struct{})}
+	h.flapper = newFlapDetector()
 	h.Suppressor = &suppressor{db: m}
 	ctx := context.Background()
 
-	h.handleExpiry(ctx)
+	a3 := mockAlerts["existing_a3"]
+	a3.SetAutoExpire(15 * time.Minute)
+	a3.LastActive = models.MyTime{time.Now().Add(-10 * time.Minute)}
+	h.scheduleExpiry(ctx, a3)
+	at, ok := Timers.Deadline(expiryKey(300))
+	assert.True(t, ok)
+	assert.True(t, at.Equal(a3.LastActive.Add(15*time.Minute)))
+
+	// not yet expired
+	h.handleExpiry(ctx, 300)
+	assert.Equal(t, len(h.procChan), 0)
 
+	a3.LastActive = models.MyTime{time.Now().Add(-20 * time.Minute)}
+	h.handleExpiry(ctx, 300)
 	event := <-h.procChan
 	assert.Equal(t, event.Alert.Status.String(), "EXPIRED")
 	assert.Equal(t, event.Type, models.EventType_EXPIRED)
 	assert.Equal(t, int(event.Alert.Id), 300)
+	_, ok = Timers.Deadline(expiryKey(300))
+	assert.False(t, ok)
 }
 
 func TestHandlerAlertEscalate(t *testing.T) {
 	m := &MockDb{}
 	h := &AlertHandler{Db: m, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
 	h.procChan = make(chan *models.AlertEvent, 2)
-	h.clearer = &ClearHandler{actives: make(map[int64]chan struct{})}
+	h.flapper = newFlapDetector()
 	h.Suppressor = &suppressor{db: m}
 	ctx := context.Background()
 
 	// test no escalation needed
-	mockAlerts["existing_a4"].StartTime = models.MyTime{time.Now()}
-	h.handleEscalation(ctx)
+	start := time.Now()
+	mockAlerts["existing_a4"].StartTime = models.MyTime{start}
+	h.handleEscalation(ctx, 400)
+	assert.Equal(t, len(h.procChan), 0)
+	at, ok := Timers.Deadline(escalationKey(400))
+	assert.True(t, ok)
+	assert.True(t, at.Equal(start.Add(5*time.Minute)))
 
 	// test first level escalation
 	mockAlerts["existing_a4"].StartTime = models.MyTime{}
-	h.handleEscalation(ctx)
+	h.handleEscalation(ctx, 400)
 	event := <-h.procChan
 	assert.Equal(t, event.Alert.Severity.String(), "WARN")
 	assert.Equal(t, event.Type, models.EventType_ESCALATED)
 	mockAlerts["existing_a4"] = event.Alert
+	// next step is already due
+	at, _ = Timers.Deadline(escalationKey(400))
+	assert.True(t, at.Before(time.Now()))
 
 	// test second level escalation
-	h.handleEscalation(ctx)
+	h.handleEscalation(ctx, 400)
 	event = <-h.procChan
 	assert.Equal(t, event.Alert.Severity.String(), "CRITICAL")
 	assert.Equal(t, event.Type, models.EventType_ESCALATED)
+	_, ok = Timers.Deadline(escalationKey(400))
+	assert.False(t, ok)
+}
+
+// execTx records the queries it executes
+type execTx struct {
+	*MockTx
+	queries []string
+}
+
+func (t *execTx) Exec(query string, args ...interface{}) error {
+	t.queries = append(t.queries, query)
+	return nil
+}
+
+func TestHandlerClearHolddown(t *testing.T) {
+	m := &MockDb{}
+	tx := m.NewTx()
+	h := &AlertHandler{Db: m, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
+	h.procChan = make(chan *models.AlertEvent, 1)
+	h.flapper = newFlapDetector()
+	h.Suppressor = &suppressor{db: m}
+	ctx := context.Background()
+
+	existing := mockAlerts["existing_a1"]
+	existing.Status = models.Status_ACTIVE
+	existing.AutoClear = true
+	a1 := tu.MockAlert(0, "Test Alert 1", "", "d1", "e1", "src1", "scp1", "t1", "1", "WARN", []string{"a", "b"}, nil)
+	clearA1 := func() error {
+		return models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+			return h.handleClear(ctx, tx, a1, time.Minute)
+		})
+	}
+	assert.Nil(t, clearA1())
+	at, ok := Timers.Deadline(clearKey(100))
+	assert.True(t, ok)
+	assert.True(t, at.After(time.Now().Add(50*time.Second)))
+	assert.Equal(t, existing.Status, models.Status_ACTIVE)
+
+	// a new update cancels the clear
+	h.handleActive(ctx, tx, a1)
+	_, ok = Timers.Deadline(clearKey(100))
+	assert.False(t, ok)
+
+	// also when the clear was saved by another replica
+	etx := &execTx{MockTx: &MockTx{}}
+	h.handleActive(ctx, etx, a1)
+	assert.Contains(t, etx.queries, models.QueryDeletePendingClear)
+
+	// clear after the holddown
+	assert.Nil(t, clearA1())
+	Timers.Cancel(clearKey(100))
+	h.handlePendingClear(ctx, 100)
+	event := <-h.procChan
+	assert.Equal(t, event.Type, models.EventType_CLEARED)
+	assert.Equal(t, existing.Status, models.Status_CLEARED)
+
+	// alert no longer active
+	h.handlePendingClear(ctx, 100)
+	assert.Equal(t, len(h.procChan), 0)
+}
+
+func TestHandlerDryRun(t *testing.T) {
+	defer func(s *suppressor) { suppr = s }(suppr)
+	suppr = &suppressor{db: &MockDb{}}
+
+	a := tu.MockAlert(0, "Test Alert 2", "", "d2", "e2", "src2", "scp2", "t1", "2", "WARN", []string{"c", "d"}, nil)
+	rule, errs := DryRun(a)
+	assert.Nil(t, rule)
+	assert.Equal(t, len(errs), 0)
+	assert.Equal(t, a.Labels["suppress"], "me")
+	assert.Equal(t, a.Labels["alert_name"], "Test Alert 2")
+
+	r := models.NewSuppRule(models.Labels{"suppress": "me"}, models.MatchCond_ALL, "test", "test", time.Minute)
+	models.WithTx(context.Background(), &MockTx{}, func(ctx context.Context, tx models.Txn) error {
+		_, err := suppr.SaveRule(ctx, tx, r)
+		return err
+	})
+	a = tu.MockAlert(0, "Test Alert 2", "", "d2", "e2", "src2", "scp2", "t1", "2", "WARN", []string{"c", "d"}, nil)
+	rule, _ = DryRun(a)
+	assert.Equal(t, rule, r)
+	assert.Equal(t, a.Id, int64(0))
+}
+
+// reopenDb keeps the last inserted alert and the history records written for it
+type reopenDb struct {
+	alert      *models.Alert
+	inserts    int
+	records    []string
+	activeRoot int64
+}
+
+func (m *reopenDb) NewTx() models.Txn {
+	return &reopenTx{MockTx: &MockTx{}, db: m}
+}
+
+func (m *reopenDb) Close() error {
+	return nil
+}
+
+type reopenTx struct {
+	*MockTx
+	db *reopenDb
+}
+
+func (t *reopenTx) NewInsert(query string, item interface{}) (int64, error) {
+	if alert, ok := item.(*models.Alert); ok {
+		t.db.alert = alert
+		t.db.inserts++
+		return 600, nil
+	}
+	return t.MockTx.NewInsert(query, item)
+}
+
+func (t *reopenTx) GetAlert(query string, args ...interface{}) (*models.Alert, error) {
+	if t.db.alert != nil {
+		switch query {
+		case models.QuerySelectByFingerprint:
+			if t.db.alert.Status == models.Status_ACTIVE {
+				return t.db.alert, nil
+			}
+		case models.QuerySelectReopenable:
+			ended := t.db.alert.Status == models.Status_CLEARED || t.db.alert.Status == models.Status_EXPIRED
+			if ended && t.db.alert.EndedAt.Valid && t.db.alert.EndedAt.Int64 >= args[1].(int64) {
+				return t.db.alert, nil
+			}
+		case models.QuerySelectSuppressedByFingerprint:
+			held := t.db.alert.SuppressedBy != 0 || t.db.alert.InhibitedBy != 0
+			if t.db.alert.Status == models.Status_SUPPRESSED && held {
+				return t.db.alert, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("No alert found")
+}
+
+func (t *reopenTx) SelectAlerts(query string, args ...interface{}) (models.Alerts, error) {
+	if t.db.alert == nil || t.db.alert.Status != models.Status_SUPPRESSED {
+		return models.Alerts{}, nil
+	}
+	switch query {
+	case models.QuerySelectSuppressedBy:
+		if t.db.alert.SuppressedBy == args[0].(int64) {
+			return models.Alerts{t.db.alert}, nil
+		}
+	case models.QuerySelectAllSuppressed:
+		return models.Alerts{t.db.alert}, nil
+	case models.QuerySelectRootEnded:
+		if t.db.alert.InhibitedBy != 0 && t.db.alert.InhibitedBy != t.db.activeRoot {
+			return models.Alerts{t.db.alert}, nil
+		}
+	}
+	return models.Alerts{}, nil
+}
+
+func (t *reopenTx) InQuery(query string, args ...interface{}) error {
+	return nil
+}
+
+func (t *reopenTx) NewRecord(alertId int64, event string) (int64, error) {
+	t.db.records = append(t.db.records, event)
+	return 1, nil
+}
+
+func TestHandlerReopen(t *testing.T) {
+	m := &reopenDb{}
+	h := &AlertHandler{Db: m, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
+	h.procChan = make(chan *models.AlertEvent, 10)
+	h.flapper = newFlapDetector()
+	h.Suppressor = &suppressor{db: m}
+	ctx := context.Background()
+
+	newAlert := func(name string) *models.Alert {
+		return tu.MockAlert(0, name, "", "d1", "e1", "grafana", "phy_interface", "t1", "1", "WARN", []string{}, nil)
+	}
+	h.handleActive(ctx, m.NewTx(), newAlert("Test Alert Reopen"))
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_ACTIVE)
+	m.alert.Owner = sql.NullString{"foo", true}
+	if err := h.Clear(ctx, m.NewTx(), m.alert); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_CLEARED)
+	assert.True(t, m.alert.EndedAt.Valid)
+
+	// re-fired within the window: the same alert is active again
+	h.handleActive(ctx, m.NewTx(), newAlert("Test Alert Reopen"))
+	event := <-h.procChan
+	assert.Equal(t, event.Type, models.EventType_ACTIVE)
+	assert.Equal(t, event.Alert, m.alert)
+	assert.Equal(t, m.inserts, 1)
+	assert.Equal(t, m.alert.Id, int64(600))
+	assert.Equal(t, m.alert.Status, models.Status_ACTIVE)
+	assert.Equal(t, m.alert.Owner.String, "foo")
+	assert.Equal(t, m.alert.Occurrences, 2)
+	assert.Equal(t, m.records[len(m.records)-1], "Alert reopened after being CLEARED, occurrence 2")
+	assert.False(t, m.alert.EndedAt.Valid)
+
+	// expired outside the window
+	m.alert.Expire()
+	m.alert.EndedAt.Int64 -= int64((10 * time.Minute).Seconds())
+	h.handleActive(ctx, m.NewTx(), newAlert("Test Alert Reopen"))
+	<-h.procChan
+	assert.Equal(t, m.inserts, 2)
+	assert.Equal(t, m.alert.Occurrences, 1)
+
+	// no reopen window configured
+	m.alert.Name = "Test Alert 2"
+	m.alert.Status = models.Status_CLEARED
+	h.handleActive(ctx, m.NewTx(), newAlert("Test Alert 2"))
+	<-h.procChan
+	assert.Equal(t, m.inserts, 3)
+	assert.Equal(t, m.alert.Occurrences, 1)
 }
 
 func TestMain(m *testing.M) {
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+)
+
+// Identity lists the alert fields and label keys that make up the fingerprint of an alert.
+// The alert name is always part of the fingerprint. Alerts are identified before the transforms
+// run, so the fingerprint only sees the fields and labels set by the source or static labels.
+type Identity struct {
+	Fields []string
+	Labels []string
+}
+
+// alerts are identified by Name:Entity:Device unless configured otherwise
+var defaultIdentity = &Identity{Fields: []string{"entity", "device"}}
+
+var identityFields = map[string]func(a *models.Alert) string{
+	"entity":      func(a *models.Alert) string { return a.Entity },
+	"device":      func(a *models.Alert) string { return a.Device.String },
+	"source":      func(a *models.Alert) string { return a.Source },
+	"scope":       func(a *models.Alert) string { return a.Scope },
+	"team":        func(a *models.Alert) string { return a.Team },
+	"external_id": func(a *models.Alert) string { return a.ExternalId },
+}
+
+// fields that are only set by transforms, after the alert is identified
+var transformFields = map[string]bool{"site": true}
+
+func (i *Identity) validate() error {
+	for _, f := range i.Fields {
+		if transformFields[f] {
+			return fmt.Errorf("Identity field %s is set by transforms, which run after the alert is identified", f)
+		}
+		if _, ok := identityFields[f]; !ok && f != "name" {
+			return fmt.Errorf("Unknown identity field: %s", f)
+		}
+	}
+	return nil
+}
+
+func getIdentity(name string) *Identity {
+	if Config != nil {
+		if ac, ok := Config.GetAlertConfig(name); ok && ac.Config.Identity != nil {
+			return ac.Config.Identity
+		}
+		if identity := Config.GetGeneralConfig().Identity; identity != nil {
+			return identity
+		}
+	}
+	return defaultIdentity
+}
+
+// Fingerprint returns a hash of the alert name and the fields and labels identifying the
+// alert, as configured in its alert config or the general config
+func Fingerprint(alert *models.Alert) string {
+	identity := getIdentity(alert.Name)
+	h := sha1.New()
+	io.WriteString(h, alert.Name)
+	for _, f := range identity.Fields {
+		if get, ok := identityFields[f]; ok {
+			fmt.Fprintf(h, "\x00%s=%s", f, get(alert))
+		}
+	}
+	labels := append([]string{}, identity.Labels...)
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(h, "\x00labels.%s=%v", l, alert.Labels[l])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	// default identity is Name:Entity:Device
+	a1 := tu.MockAlert(0, "Test Alert 1", "", "d1", "e1", "src1", "scp1", "t1", "1", "WARN", nil, models.Labels{"foo": "bar"})
+	a2 := tu.MockAlert(0, "Test Alert 1", "desc", "d1", "e1", "src2", "scp2", "t2", "2", "CRITICAL", nil, nil)
+	assert.Equal(t, Fingerprint(a1), Fingerprint(a2))
+	a2.Entity = "e2"
+	assert.NotEqual(t, Fingerprint(a1), Fingerprint(a2))
+	a2 = tu.MockAlert(0, "Test Alert 2", "", "d1", "e1", "src1", "scp1", "t1", "1", "WARN", nil, nil)
+	assert.NotEqual(t, Fingerprint(a1), Fingerprint(a2))
+
+	// configured identity ignores the entity and uses labels
+	labels := models.Labels{"region": "us-west", "vip": "10.1.1.1", "pool": "p1"}
+	a1 = tu.MockAlert(0, "Test Alert Identity", "", "lb1", "e1", "src1", "scp1", "t1", "1", "WARN", nil, labels)
+	labels = models.Labels{"vip": "10.1.1.1", "region": "us-west", "pool": "p2"}
+	a2 = tu.MockAlert(0, "Test Alert Identity", "", "lb1", "e2", "src1", "scp1", "t1", "1", "WARN", nil, labels)
+	assert.Equal(t, Fingerprint(a1), Fingerprint(a2))
+	a2.Labels["region"] = "us-east"
+	assert.NotEqual(t, Fingerprint(a1), Fingerprint(a2))
+	delete(a2.Labels, "region")
+	assert.NotEqual(t, Fingerprint(a1), Fingerprint(a2))
+
+	assert.Error(t, (&Identity{Fields: []string{"entity", "color"}}).validate())
+	assert.Error(t, (&Identity{Fields: []string{"site"}}).validate())
+	assert.Nil(t, (&Identity{Fields: []string{"name", "entity"}, Labels: []string{"color"}}).validate())
+}
+
+func TestGetExistingByFingerprint(t *testing.T) {
+	h := &AlertHandler{}
+	a1 := tu.MockAlert(0, "Test Alert 1", "", "d1", "e1", "src1", "scp1", "t1", "1", "WARN", nil, nil)
+	existing, err := h.GetExisting(&MockTx{}, a1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, existing, mockAlerts["existing_a1"])
+	assert.Equal(t, a1.Fingerprint, Fingerprint(a1))
+
+	a1.Device.String = "d2"
+	a1.Fingerprint = ""
+	_, err = h.GetExisting(&MockTx{}, a1)
+	assert.Error(t, err)
+}
+
+// unfingerprintedTx has an active alert created before fingerprints
+type unfingerprintedTx struct {
+	*MockTx
+	alert   *models.Alert
+	adopted string
+}
+
+func (t *unfingerprintedTx) GetAlert(query string, args ...interface{}) (*models.Alert, error) {
+	if query == models.QuerySelectUnfingerprintedByDevice && args[0] == t.alert.Name && args[2] == t.alert.Device.String && t.adopted == "" {
+		return t.alert, nil
+	}
+	return nil, fmt.Errorf("No alert found")
+}
+
+func (t *unfingerprintedTx) Exec(query string, args ...interface{}) error {
+	if query == models.QueryUpdateFingerprint && args[1] == t.alert.Id {
+		t.adopted = args[0].(string)
+	}
+	return nil
+}
+
+func TestGetExistingUnfingerprinted(t *testing.T) {
+	h := &AlertHandler{statDbError: &tu.MockStat{}}
+	tx := &unfingerprintedTx{alert: tu.MockAlert(500, "Test Alert 5", "", "d5", "e5", "src5", "scp5", "t1", "5", "WARN", nil, nil)}
+	a5 := tu.MockAlert(0, "Test Alert 5", "", "d5", "e5", "src5", "scp5", "t1", "5", "WARN", nil, nil)
+	existing, err := h.GetExisting(tx, a5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, existing.Id, int64(500))
+	// the alert is found by its fingerprint from now on
+	assert.Equal(t, tx.adopted, Fingerprint(a5))
+	assert.Equal(t, existing.Fingerprint, Fingerprint(a5))
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/internal/election"
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/mayuresh82/alert_manager/plugins"
+)
+
+const (
+	// the leader also checks the shared queue periodically, in case it missed a notification
+	SHARED_QUEUE_POLL_INTERVAL = 5 * time.Second
+	SHARED_QUEUE_BATCH         = 100
+)
+
+var (
+	// elector, if set, elects the replica that handles alerts among the replicas sharing the
+	// db. The other replicas hand the alert events they receive over to it through the db.
+	elector  *election.Elector
+	sharedDb models.Dbase
+	// notified when events are added to the shared queue
+	sharedQueued <-chan struct{}
+)
+
+// UseElection makes the replica take part in the election of a leader among the replicas
+// sharing db. Only the leader handles alerts, runs the timers and housekeeping and feeds the
+// processors. It needs to be called before the handler and listeners are started.
+func UseElection(db models.Dbase, e *election.Elector) {
+	elector, sharedDb = e, db
+	if l, ok := db.(models.Listener); ok {
+		var err error
+		if sharedQueued, err = l.Listen(models.ChannelAlertQueue); err != nil {
+			glog.Errorf("Failed to listen for queued alert events: %v", err)
+		}
+	}
+}
+
+// GetElector returns the elector of the replica, or nil if it does not take part in an election
+func GetElector() *election.Elector {
+	return elector
+}
+
+// IsLeader reports whether the replica handles alerts. A replica that does not take part in
+// an election always does.
+func IsLeader() bool {
+	return elector == nil || elector.IsLeader()
+}
+
+// HoldsLease reports whether the replica may still send notifications. A leader keeps the lease
+// for a while after it stepped down, to finish sending what is left in the processors.
+func HoldsLease() bool {
+	return elector == nil || elector.Holds()
+}
+
+// forward hands an alert event over to the leader through the shared queue
+func forward(db models.Dbase, kind string, event *models.AlertEvent) error {
+	data, err := encodeEvent(event)
+	if err != nil {
+		return err
+	}
+	tx := db.NewTx()
+	return models.WithTx(context.Background(), tx, func(ctx context.Context, tx models.Txn) error {
+		return tx.Exec(models.QueryInsertQueued, kind, string(data), models.MyTime{time.Now()})
+	})
+}
+
+// consumeShared handles the events of the shared queue until ctx is done
+func (h *AlertHandler) consumeShared(ctx context.Context) {
+	t := time.NewTicker(SHARED_QUEUE_POLL_INTERVAL)
+	defer t.Stop()
+	for {
+		for h.handleShared(ctx) == SHARED_QUEUE_BATCH {
+		}
+		select {
+		case <-t.C:
+		case <-sharedQueued:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleShared handles the oldest events of the shared queue and returns how many it handled.
+// Like with the on-disk queue, events are only removed once handled: an alert event is removed
+// in the transaction that handles it. An event that fails to be handled stops the batch and is
+// retried at the next poll. An event that keeps failing with non-transient errors is dropped
+// after QUEUE_MAX_ATTEMPTS attempts, so that it does not block the events queued after it.
+func (h *AlertHandler) handleShared(ctx context.Context) int {
+	var queued models.QueuedEvents
+	tx := h.Db.NewTx()
+	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		var er error
+		queued, er = tx.SelectQueued(models.QuerySelectQueued, SHARED_QUEUE_BATCH)
+		return er
+	})
+	if err != nil {
+		glog.Errorf("Unable to read the shared alert queue: %v", err)
+		h.statDbError.Add(1)
+		return 0
+	}
+	for i, q := range queued {
+		if ctx.Err() != nil {
+			return i
+		}
+		alertEvent, err := decodeEvent([]byte(q.Data))
+		switch {
+		case err != nil:
+			glog.Errorf("Dropping invalid queued event %d: %v", q.Id, err)
+		case q.Kind == models.QueueAlert:
+			tx := h.Db.NewTx()
+			err = models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+				if err := h.applyEvent(ctx, tx, alertEvent); err != nil {
+					return err
+				}
+				return tx.Exec(models.QueryDeleteQueued, q.Id)
+			})
+			if err == nil {
+				continue
+			}
+			glog.Errorf("Unable to Handle Alert of queued event %d: %v", q.Id, err)
+			if models.Transient(err) || !h.failShared(ctx, q) {
+				return i
+			}
+			glog.Errorf("Dropping queued event %d after %d attempts: %s", q.Id, q.Attempts+1, q.Data)
+			h.statQueueDropped.Add(1)
+		case q.Kind == models.QueueProcess:
+			// sent to the processors by a replica that is not the leader
+			if len(plugins.Processors) > 0 {
+				h.process(alertEvent)
+			}
+		default:
+			glog.Errorf("Dropping queued event %d of unknown kind %s", q.Id, q.Kind)
+		}
+		tx := h.Db.NewTx()
+		err = models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+			return tx.Exec(models.QueryDeleteQueued, q.Id)
+		})
+		if err != nil {
+			glog.Errorf("Failed to remove queued event %d: %v", q.Id, err)
+			h.statDbError.Add(1)
+			return i
+		}
+	}
+	return len(queued)
+}
+
+// failShared counts a failed attempt to handle the queued event q. It returns true if the event
+// has reached QUEUE_MAX_ATTEMPTS and should be dropped.
+func (h *AlertHandler) failShared(ctx context.Context, q *models.QueuedEvent) bool {
+	if q.Attempts+1 >= QUEUE_MAX_ATTEMPTS {
+		return true
+	}
+	tx := h.Db.NewTx()
+	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		return tx.Exec(models.QueryFailQueued, q.Id)
+	})
+	if err != nil {
+		glog.Errorf("Failed to update queued event %d: %v", q.Id, err)
+		h.statDbError.Add(1)
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/election"
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/mayuresh82/alert_manager/plugins"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// replicaDb holds the lease and the shared queue of the replicas
+type replicaDb struct {
+	holder string
+	queue  models.QueuedEvents
+	nextId int64
+	sync.Mutex
+}
+
+func (m *replicaDb) NewTx() models.Txn {
+	return &replicaTx{MockTx: &MockTx{}, db: m}
+}
+
+func (m *replicaDb) Close() error {
+	return nil
+}
+
+func (m *replicaDb) queued() []string {
+	m.Lock()
+	defer m.Unlock()
+	var kinds []string
+	for _, q := range m.queue {
+		kinds = append(kinds, q.Kind)
+	}
+	return kinds
+}
+
+type replicaTx struct {
+	*MockTx
+	db *replicaDb
+}
+
+func (t *replicaTx) Exec(query string, args ...interface{}) error {
+	m := t.db
+	m.Lock()
+	defer m.Unlock()
+	switch query {
+	case models.QueryAcquireLease:
+		if m.holder == "" {
+			m.holder = args[1].(string)
+		}
+	case models.QueryReleaseLease:
+		if m.holder == args[1].(string) {
+			m.holder = ""
+		}
+	case models.QueryInsertQueued:
+		m.nextId++
+		m.queue = append(m.queue, &models.QueuedEvent{Id: m.nextId, Kind: args[0].(string), Data: args[1].(string)})
+	case models.QueryFailQueued:
+		for _, q := range m.queue {
+			if q.Id == args[0].(int64) {
+				q.Attempts++
+			}
+		}
+	case models.QueryDeleteQueued:
+		for i, q := range m.queue {
+			if q.Id == args[0].(int64) {
+				m.queue = append(m.queue[:i], m.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (t *replicaTx) SelectLeases(query string, args ...interface{}) (models.Leases, error) {
+	t.db.Lock()
+	defer t.db.Unlock()
+	if t.db.holder == "" {
+		return nil, nil
+	}
+	return models.Leases{{Name: args[0].(string), Holder: t.db.holder}}, nil
+}
+
+func (t *replicaTx) SelectQueued(query string, args ...interface{}) (models.QueuedEvents, error) {
+	t.db.Lock()
+	defer t.db.Unlock()
+	queued := make(models.QueuedEvents, len(t.db.queue))
+	copy(queued, t.db.queue)
+	return queued, nil
+}
+
+func (t *replicaTx) SelectPendingClears(query string, args ...interface{}) (models.PendingClears, error) {
+	return nil, nil
+}
+
+// captureProcessor is a pipeline that hands the events over to the test
+type captureProcessor struct {
+	events chan *models.AlertEvent
+}
+
+func (c *captureProcessor) Name() string { return "capture" }
+
+func (c *captureProcessor) Stage() int { return 0 }
+
+func (c *captureProcessor) Process(ctx context.Context, db models.Dbase, in chan *models.AlertEvent) chan *models.AlertEvent {
+	out := make(chan *models.AlertEvent)
+	go func() {
+		for event := range in {
+			c.events <- event
+		}
+		close(out)
+	}()
+	return out
+}
+
+func TestLeaderElection(t *testing.T) {
+	db := &replicaDb{holder: "replica-a"}
+	UseElection(db, election.New(db, "replica-b", 15*time.Second))
+	defer UseElection(nil, nil)
+	processors := plugins.Processors
+	defer func() { plugins.Processors = processors }()
+	capture := &captureProcessor{events: make(chan *models.AlertEvent, 2)}
+	plugins.Processors = []plugins.Processor{capture}
+	h := &AlertHandler{Db: db, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
+	h.flapper = newFlapDetector()
+	h.Suppressor = &suppressor{db: db}
+	defer Timers.Cancel(escalationKey(200))
+
+	// a replica that is not the leader hands the alerts it receives and the events of api
+	// actions over to the leader
+	assert.False(t, IsLeader())
+	alert := tu.MockAlert(0, "Test Alert 2", "", "d2", "e2", "src2", "scp2", "t1", "2", "WARN", nil, nil)
+	assert.Nil(t, Send(&models.AlertEvent{Alert: alert, Type: models.EventType_ACTIVE}))
+	h.notifyReceivers(mockAlerts["existing_a3"], models.EventType_ACKD)
+	assert.Equal(t, len(capture.events), 0)
+	assert.Equal(t, db.queued(), []string{models.QueueAlert, models.QueueProcess})
+
+	// once the leader is gone, the replica takes over and handles the queued events
+	db.Lock()
+	db.holder = ""
+	db.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.Start(ctx)
+		close(done)
+	}()
+	event := <-capture.events
+	assert.Equal(t, event.Type, models.EventType_ACTIVE)
+	assert.Equal(t, event.Alert.Id, int64(200))
+	event = <-capture.events
+	assert.Equal(t, event.Type, models.EventType_ACKD)
+	assert.Equal(t, event.Alert.Id, int64(300))
+	assert.True(t, IsLeader())
+
+	// the leader gives up the lease on shutdown
+	cancel()
+	<-done
+	assert.Equal(t, len(db.queued()), 0)
+	assert.False(t, IsLeader())
+	assert.Equal(t, db.holder, "")
+}
+
+func TestHandleShared(t *testing.T) {
+	db := &flakyDb{fails: 1}
+	h := &AlertHandler{Db: db, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}, statQueueDropped: &tu.MockStat{}}
+	h.flapper = newFlapDetector()
+	h.Suppressor = &suppressor{db: db}
+	defer Timers.Cancel(escalationKey(200))
+
+	alert := tu.MockAlert(0, "Test Alert 2", "", "d2", "e2", "src2", "scp2", "t1", "2", "WARN", nil, nil)
+	assert.Nil(t, forward(db, models.QueueAlert, &models.AlertEvent{Alert: alert, Type: models.EventType_ACTIVE}))
+
+	// an event that fails to be handled stays queued until it is handled
+	ctx := context.Background()
+	assert.Equal(t, h.handleShared(ctx), 0)
+	assert.Equal(t, db.queued(), []string{models.QueueAlert})
+	assert.Equal(t, h.handleShared(ctx), 1)
+	assert.Equal(t, len(db.queued()), 0)
+	assert.Equal(t, db.inserts, 1)
+
+	// an event that keeps failing is dropped after the last attempt
+	alert = tu.MockAlert(0, "Test Alert 3", "", "d3", "e3", "src3", "scp3", "t1", "3", "WARN", nil, nil)
+	assert.Nil(t, forward(db, models.QueueAlert, &models.AlertEvent{Alert: alert, Type: models.EventType_ACTIVE}))
+	db.fails = QUEUE_MAX_ATTEMPTS
+	for i := 1; i < QUEUE_MAX_ATTEMPTS; i++ {
+		assert.Equal(t, h.handleShared(ctx), 0)
+		assert.Equal(t, db.queue[0].Attempts, i)
+	}
+	assert.Equal(t, h.handleShared(ctx), 1)
+	assert.Equal(t, len(db.queued()), 0)
+	assert.Equal(t, db.inserts, 1)
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/internal/models"
+)
+
+// WhoIsOnCall returns who is on call for a team at a time, one entry for each schedule of
+// the team that has someone on call
+func WhoIsOnCall(tx models.Txn, team string, at time.Time) ([]*models.OnCall, error) {
+	schedules, err := tx.SelectSchedules(models.QuerySelectScheduleByTeam, team)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get schedules for team %s: %v", team, err)
+	}
+	var onCall []*models.OnCall
+	for _, s := range schedules {
+		if err := s.Compile(); err != nil {
+			glog.Errorf("Skipping on-call schedule %d: %v", s.Id, err)
+			continue
+		}
+		overrides, err := tx.SelectOverrides(models.QuerySelectOverrides, s.Id, at.Unix())
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get overrides for schedule %s: %v", s.Name, err)
+		}
+		userId, layer, until, ok := s.OnCall(at, overrides)
+		if !ok {
+			continue
+		}
+		users, err := tx.SelectUsers(models.QuerySelectUserById, userId)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get user %d: %v", userId, err)
+		}
+		if len(users) == 0 {
+			glog.Errorf("On-call schedule %s: user %d does not exist", s.Name, userId)
+			continue
+		}
+		onCall = append(onCall, &models.OnCall{Schedule: s.Name, User: users[0], Layer: layer, Until: models.MyTime{until}})
+	}
+	return onCall, nil
+}
+
+// teamId returns the id of a team by name
+func teamId(tx models.Txn, name string) (int64, error) {
+	teams, err := tx.SelectTeams(models.QuerySelectTeamByName, name)
+	if err != nil {
+		return 0, err
+	}
+	if len(teams) == 0 {
+		return 0, fmt.Errorf("Team %s does not exist", name)
+	}
+	return teams[0].Id, nil
+}
+
+// checkTeamUsers returns an error unless all users belong to the team
+func checkTeamUsers(tx models.Txn, teamId int64, userIds ...int64) error {
+	users, err := tx.SelectUsers(models.QuerySelectUsersByTeam, teamId)
+	if err != nil {
+		return err
+	}
+	members := make(map[int64]bool)
+	for _, u := range users {
+		members[u.Id] = true
+	}
+	for _, id := range userIds {
+		if !members[id] {
+			return fmt.Errorf("User %d is not a member of team %d", id, teamId)
+		}
+	}
+	return nil
+}
+
+// SaveUser creates a user of a team, or replaces it if it has an id
+func (h *AlertHandler) SaveUser(ctx context.Context, tx models.Txn, team string, u *models.User) (int64, error) {
+	if u.Name == "" {
+		return 0, fmt.Errorf("User needs a name")
+	}
+	if u.Email != "" {
+		if _, err := mail.ParseAddress(u.Email); err != nil {
+			return 0, fmt.Errorf("Invalid email %s: %v", u.Email, err)
+		}
+	}
+	id, err := teamId(tx, team)
+	if err != nil {
+		return 0, err
+	}
+	u.TeamId = id
+	if u.Id != 0 {
+		if err := tx.UpdateUser(u); err != nil {
+			return 0, fmt.Errorf("Unable to update user: %v", err)
+		}
+		return u.Id, nil
+	}
+	return tx.NewInsert(models.QueryInsertUser, u)
+}
+
+// DeleteUser deletes a user
+func (h *AlertHandler) DeleteUser(ctx context.Context, tx models.Txn, id int64) error {
+	return tx.Exec(models.QueryDeleteUser, id)
+}
+
+// SaveSchedule creates an on-call schedule for a team, or replaces it if it has an id.
+// The users of its layers have to be members of the team.
+func (h *AlertHandler) SaveSchedule(ctx context.Context, tx models.Txn, team string, s *models.Schedule) (int64, error) {
+	if err := s.Compile(); err != nil {
+		return 0, err
+	}
+	id, err := teamId(tx, team)
+	if err != nil {
+		return 0, err
+	}
+	s.TeamId = id
+	if err := checkTeamUsers(tx, s.TeamId, s.UserIds()...); err != nil {
+		return 0, err
+	}
+	if s.Id != 0 {
+		if err := tx.UpdateSchedule(s); err != nil {
+			return 0, fmt.Errorf("Unable to update schedule: %v", err)
+		}
+		return s.Id, nil
+	}
+	return tx.NewInsert(models.QueryInsertSchedule, s)
+}
+
+// DeleteSchedule deletes an on-call schedule and its overrides
+func (h *AlertHandler) DeleteSchedule(ctx context.Context, tx models.Txn, id int64) error {
+	return tx.Exec(models.QueryDeleteSchedule, id)
+}
+
+// AddOverride puts a member of the schedule team on call for the override period
+func (h *AlertHandler) AddOverride(ctx context.Context, tx models.Txn, o *models.Override) (int64, error) {
+	if !o.End.After(o.Start.Time) {
+		return 0, fmt.Errorf("Override has to end after it starts")
+	}
+	schedules, err := tx.SelectSchedules(models.QuerySelectScheduleById, o.ScheduleId)
+	if err != nil {
+		return 0, err
+	}
+	if len(schedules) == 0 {
+		return 0, fmt.Errorf("Schedule %d does not exist", o.ScheduleId)
+	}
+	if err := checkTeamUsers(tx, schedules[0].TeamId, o.UserId); err != nil {
+		return 0, err
+	}
+	return tx.NewInsert(models.QueryInsertOverride, o)
+}
+
+// DeleteOverride deletes an on-call override
+func (h *AlertHandler) DeleteOverride(ctx context.Context, tx models.Txn, id int64) error {
+	return tx.Exec(models.QueryDeleteOverride, id)
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type oncallTx struct {
+	*models.Tx
+	schedules models.Schedules
+	overrides models.Overrides
+	users     models.Users
+	teams     models.Teams
+	inserted  []interface{}
+	updated   []*models.Schedule
+}
+
+func (t *oncallTx) SelectSchedules(query string, args ...interface{}) (models.Schedules, error) {
+	var schedules models.Schedules
+	for _, s := range t.schedules {
+		switch query {
+		case models.QuerySelectScheduleById:
+			if s.Id == args[0].(int64) {
+				schedules = append(schedules, s)
+			}
+		default:
+			schedules = append(schedules, s)
+		}
+	}
+	return schedules, nil
+}
+
+func (t *oncallTx) SelectOverrides(query string, args ...interface{}) (models.Overrides, error) {
+	return t.overrides, nil
+}
+
+func (t *oncallTx) SelectUsers(query string, args ...interface{}) (models.Users, error) {
+	var users models.Users
+	for _, u := range t.users {
+		switch query {
+		case models.QuerySelectUserById:
+			if u.Id == args[0].(int64) {
+				users = append(users, u)
+			}
+		case models.QuerySelectUsersByTeam:
+			if u.TeamId == args[0].(int64) {
+				users = append(users, u)
+			}
+		}
+	}
+	return users, nil
+}
+
+func (t *oncallTx) SelectTeams(query string, args ...interface{}) (models.Teams, error) {
+	var teams models.Teams
+	for _, team := range t.teams {
+		if team.Name == args[0].(string) {
+			teams = append(teams, team)
+		}
+	}
+	return teams, nil
+}
+
+func (t *oncallTx) NewInsert(query string, item interface{}) (int64, error) {
+	t.inserted = append(t.inserted, item)
+	return 10, nil
+}
+
+func (t *oncallTx) UpdateSchedule(s *models.Schedule) error {
+	t.updated = append(t.updated, s)
+	return nil
+}
+
+func newOncallTx() *oncallTx {
+	return &oncallTx{
+		teams: models.Teams{{Id: 1, Name: "neteng"}},
+		users: models.Users{
+			{Id: 1, Name: "alice", TeamId: 1},
+			{Id: 2, Name: "bob", TeamId: 1},
+			{Id: 3, Name: "carol", TeamId: 2},
+		},
+	}
+}
+
+func newSchedule(users ...int64) *models.Schedule {
+	return &models.Schedule{
+		Name:     "primary",
+		Timezone: "UTC",
+		Layers:   models.Layers{{Name: "weekly", Rotation: models.RotationWeekly, Start: "2024-03-04T09:00", Users: users}},
+	}
+}
+
+func TestWhoIsOnCall(t *testing.T) {
+	tx := newOncallTx()
+	s := newSchedule(1, 2)
+	s.Id = 1
+	tx.schedules = models.Schedules{s}
+	at := time.Date(2024, 3, 12, 0, 0, 0, 0, time.UTC)
+
+	onCall, err := WhoIsOnCall(tx, "neteng", at)
+	assert.Nil(t, err)
+	assert.Equal(t, len(onCall), 1)
+	assert.Equal(t, onCall[0].User.Name, "bob")
+	assert.Equal(t, onCall[0].Schedule, "primary")
+	assert.Equal(t, onCall[0].Layer, "weekly")
+	assert.True(t, onCall[0].Until.Equal(time.Date(2024, 3, 18, 9, 0, 0, 0, time.UTC)))
+
+	tx.overrides = models.Overrides{{ScheduleId: 1, UserId: 1, Start: models.MyTime{at.Add(-time.Hour)}, End: models.MyTime{at.Add(time.Hour)}}}
+	onCall, _ = WhoIsOnCall(tx, "neteng", at)
+	assert.Equal(t, onCall[0].User.Name, "alice")
+	assert.Equal(t, onCall[0].Layer, "")
+
+	// nobody on call before the schedule starts
+	onCall, err = WhoIsOnCall(tx, "neteng", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, len(onCall), 0)
+}
+
+func TestSaveSchedule(t *testing.T) {
+	h := &AlertHandler{}
+	ctx := context.Background()
+	tx := newOncallTx()
+
+	id, err := h.SaveSchedule(ctx, tx, "neteng", newSchedule(1, 2))
+	assert.Nil(t, err)
+	assert.Equal(t, id, int64(10))
+	assert.Equal(t, tx.inserted[0].(*models.Schedule).TeamId, int64(1))
+
+	s := newSchedule(2)
+	s.Id = 4
+	id, err = h.SaveSchedule(ctx, tx, "neteng", s)
+	assert.Nil(t, err)
+	assert.Equal(t, id, int64(4))
+	assert.Equal(t, len(tx.updated), 1)
+
+	// users have to be members of the team
+	_, err = h.SaveSchedule(ctx, tx, "neteng", newSchedule(1, 3))
+	assert.Error(t, err)
+	_, err = h.SaveSchedule(ctx, tx, "sre", newSchedule(1))
+	assert.Error(t, err)
+	_, err = h.SaveSchedule(ctx, tx, "neteng", &models.Schedule{Name: "empty", Timezone: "UTC"})
+	assert.Error(t, err)
+	assert.Equal(t, len(tx.inserted), 1)
+}
+
+func TestAddOverride(t *testing.T) {
+	h := &AlertHandler{}
+	ctx := context.Background()
+	tx := newOncallTx()
+	s := newSchedule(1)
+	s.Id, s.TeamId = 1, 1
+	tx.schedules = models.Schedules{s}
+	now := time.Now()
+
+	o := &models.Override{ScheduleId: 1, UserId: 2, Start: models.MyTime{now}, End: models.MyTime{now.Add(time.Hour)}}
+	_, err := h.AddOverride(ctx, tx, o)
+	assert.Nil(t, err)
+
+	for _, o := range []*models.Override{
+		{ScheduleId: 1, UserId: 2, Start: models.MyTime{now}, End: models.MyTime{now}},
+		{ScheduleId: 2, UserId: 2, Start: models.MyTime{now}, End: models.MyTime{now.Add(time.Hour)}},
+		{ScheduleId: 1, UserId: 3, Start: models.MyTime{now}, End: models.MyTime{now.Add(time.Hour)}},
+	} {
+		_, err := h.AddOverride(ctx, tx, o)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, len(tx.inserted), 1)
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/mayuresh82/alert_manager/internal/queue"
+	"github.com/mayuresh82/alert_manager/internal/stats"
+)
+
+const (
+	QUEUE_STATS_INTERVAL = 10 * time.Second
+	// attempts to handle a queued event that keeps failing with a non-transient error before it is dropped
+	QUEUE_MAX_ATTEMPTS = 5
+)
+
+// delays between attempts to handle a queued event that failed
+var (
+	QUEUE_RETRY_MIN = 1 * time.Second
+	QUEUE_RETRY_MAX = 1 * time.Minute
+)
+
+// eventQueue, if set, persists alert events between the listeners and the handler
+var eventQueue *queue.Queue
+
+// UseQueue makes listeners persist alert events to q, which the handler then consumes at its own pace.
+// It needs to be called before the handler and listeners are started.
+func UseQueue(q *queue.Queue) {
+	eventQueue = q
+}
+
+// queuedEvent is the on-disk representation of an alert event. The alert is converted
+// to a type without the custom api json marshaler so that all fields are kept.
+type queuedEvent struct {
+	Alert *queuedAlert
+	Type  models.EventType
+}
+
+type queuedAlert models.Alert
+
+// Send hands an alert event over to the handler. If a queue is in use, it returns once
+// the event is persisted, otherwise it blocks until the handler receives the event. Replicas
+// that elect a leader persist the event to the shared queue in the db for the leader to handle.
+func Send(event *models.AlertEvent) error {
+	if elector != nil {
+		return forward(sharedDb, models.QueueAlert, event)
+	}
+	if eventQueue == nil {
+		ListenChan <- event
+		return nil
+	}
+	data, err := encodeEvent(event)
+	if err != nil {
+		return err
+	}
+	return eventQueue.Put(data)
+}
+
+func encodeEvent(event *models.AlertEvent) ([]byte, error) {
+	return json.Marshal(&queuedEvent{Alert: (*queuedAlert)(event.Alert), Type: event.Type})
+}
+
+func decodeEvent(data []byte) (*models.AlertEvent, error) {
+	qe := &queuedEvent{}
+	if err := json.Unmarshal(data, qe); err != nil {
+		return nil, err
+	}
+	if qe.Alert == nil {
+		return nil, fmt.Errorf("No alert in queued event")
+	}
+	return &models.AlertEvent{Alert: (*models.Alert)(qe.Alert), Type: qe.Type}, nil
+}
+
+// consumeQueue handles events from the queue until ctx is done. Events are only
+// acked after they are handled, so an event interrupted by shutdown is replayed on restart.
+// An event that fails to be handled is retried with backoff. Events that cannot be decoded,
+// or keep failing with non-transient errors, are dropped so that they do not block the queue.
+func (h *AlertHandler) consumeQueue(ctx context.Context) {
+	statDepth := stats.NewGauge("handler.queue_depth")
+	statAge := stats.NewGauge("handler.queue_age_secs")
+	go func() {
+		t := time.NewTicker(QUEUE_STATS_INTERVAL)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				statDepth.Set(int64(eventQueue.Len()))
+				statAge.Set(int64(eventQueue.Age().Seconds()))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for {
+		item, err := eventQueue.Get(ctx)
+		if err != nil {
+			return
+		}
+		if !h.handleQueued(ctx, item.Data) {
+			return
+		}
+		if err := eventQueue.Ack(item); err != nil {
+			glog.Errorf("Failed to ack queued event: %v", err)
+		}
+	}
+}
+
+// handleQueued handles the queued event in data, retrying with backoff until it succeeds.
+// Transient errors are retried indefinitely, other errors up to QUEUE_MAX_ATTEMPTS times after
+// which the event is dropped. It returns false if ctx is done before the event is handled.
+func (h *AlertHandler) handleQueued(ctx context.Context, data []byte) bool {
+	delay := QUEUE_RETRY_MIN
+	var attempts int
+	for {
+		// decoded on every attempt, as handling modifies the alert
+		alertEvent, err := decodeEvent(data)
+		if err != nil {
+			glog.Errorf("Dropping invalid queued event: %v", err)
+			return ctx.Err() == nil
+		}
+		err = h.handleEvent(ctx, alertEvent)
+		if ctx.Err() != nil {
+			return false
+		}
+		if err == nil {
+			return true
+		}
+		if !models.Transient(err) {
+			if attempts++; attempts >= QUEUE_MAX_ATTEMPTS {
+				glog.Errorf("Dropping queued event after %d attempts: %v: %s", attempts, err, data)
+				h.statQueueDropped.Add(1)
+				return true
+			}
+		}
+		glog.Errorf("Unable to Handle Alert, retrying in %v: %v", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+		if delay *= 2; delay > QUEUE_RETRY_MAX {
+			delay = QUEUE_RETRY_MAX
+		}
+	}
+}
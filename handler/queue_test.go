@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/mayuresh82/alert_manager/internal/queue"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueuedEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q, err := queue.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseQueue(q)
+	defer UseQueue(nil)
+
+	alert := tu.MockAlert(0, "Test Alert 1", "desc", "d1", "e1", "src1", "scp1", "t1", "1", "CRITICAL", []string{"a"}, models.Labels{"foo": "bar"})
+	alert.SetAutoExpire(5 * time.Minute)
+	alert.AutoClear = true
+	// returns without a receiver on ListenChan
+	if err := Send(&models.AlertEvent{Alert: alert, Type: models.EventType_CLEARED}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, q.Len(), 1)
+
+	item, err := q.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := decodeEvent(item.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, event.Type, models.EventType_CLEARED)
+	got := event.Alert
+	assert.Equal(t, got.Name, alert.Name)
+	assert.Equal(t, got.Description, alert.Description)
+	assert.Equal(t, got.Device, alert.Device)
+	assert.Equal(t, got.ExternalId, alert.ExternalId)
+	assert.Equal(t, got.Severity, models.Sev_CRITICAL)
+	assert.Equal(t, got.Status, models.Status_ACTIVE)
+	assert.Equal(t, got.Tags, alert.Tags)
+	assert.Equal(t, got.Labels, alert.Labels)
+	assert.Equal(t, got.AutoExpire, true)
+	assert.Equal(t, got.ExpireAfter, alert.ExpireAfter)
+	assert.Equal(t, got.AutoClear, true)
+	assert.True(t, got.StartTime.Equal(alert.StartTime.Time))
+
+	_, err = decodeEvent([]byte(`{"Type": 1}`))
+	assert.Error(t, err)
+}
+
+// flakyDb fails to insert the first alerts
+type flakyDb struct {
+	replicaDb
+	fails, inserts int
+}
+
+func (m *flakyDb) NewTx() models.Txn {
+	return &flakyTx{replicaTx: &replicaTx{MockTx: &MockTx{}, db: &m.replicaDb}, flaky: m}
+}
+
+type flakyTx struct {
+	*replicaTx
+	flaky *flakyDb
+}
+
+func (t *flakyTx) NewInsert(query string, item interface{}) (int64, error) {
+	if _, ok := item.(*models.Alert); ok {
+		if t.flaky.fails > 0 {
+			t.flaky.fails--
+			return 0, fmt.Errorf("insert failed")
+		}
+		t.flaky.inserts++
+	}
+	return t.replicaTx.NewInsert(query, item)
+}
+
+func TestHandleQueued(t *testing.T) {
+	db := &flakyDb{fails: 1}
+	h := &AlertHandler{Db: db, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}, statQueueDropped: &tu.MockStat{}}
+	h.flapper = newFlapDetector()
+	h.Suppressor = &suppressor{db: db}
+	defer Timers.Cancel(escalationKey(200))
+
+	alert := tu.MockAlert(0, "Test Alert 2", "", "d2", "e2", "src2", "scp2", "t1", "2", "WARN", nil, nil)
+	data, err := encodeEvent(&models.AlertEvent{Alert: alert, Type: models.EventType_ACTIVE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a failed event is retried until it is handled
+	assert.True(t, h.handleQueued(context.Background(), data))
+	assert.Equal(t, db.fails, 0)
+	assert.Equal(t, db.inserts, 1)
+
+	// and is left in the queue if handling is stopped
+	db.fails = 5
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.False(t, h.handleQueued(ctx, data))
+	assert.Equal(t, db.inserts, 1)
+
+	// invalid events are dropped
+	assert.True(t, h.handleQueued(context.Background(), []byte(`{"Type": 1}`)))
+
+	// as are events that keep failing
+	defer func(min, max time.Duration) { QUEUE_RETRY_MIN, QUEUE_RETRY_MAX = min, max }(QUEUE_RETRY_MIN, QUEUE_RETRY_MAX)
+	QUEUE_RETRY_MIN, QUEUE_RETRY_MAX = time.Millisecond, time.Millisecond
+	db.fails = QUEUE_MAX_ATTEMPTS + 1
+	assert.True(t, h.handleQueued(context.Background(), data))
+	assert.Equal(t, db.fails, 1)
+	assert.Equal(t, db.inserts, 1)
+}
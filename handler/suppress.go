@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/mayuresh82/alert_manager/internal/models"
-	"sort"
 	"sync"
 	"time"
 )
@@ -14,24 +13,130 @@ const SUPPRULE_UPDATE_INTERVAL = 10 * time.Minute
 
 // suppressor manages suppression rules and alert suppressions
 type suppressor struct {
+	// all rules, indexed for matching
 	suppRules models.SuppRules
+	index     ruleIndex
 	db        models.Dbase
 
-	sync.Mutex
+	sync.RWMutex
+}
+
+// ruleIndex finds the rules that can match a set of labels. A rule that requires a label to
+// have one of a few values is indexed by these values, the others are always candidates.
+type ruleIndex struct {
+	exact map[string]map[string][]*models.SuppressionRule
+	scan  []*models.SuppressionRule
+	// the label each indexed rule is filed under
+	labels map[*models.SuppressionRule]string
+}
+
+func (i *ruleIndex) add(rule *models.SuppressionRule) {
+	keys := rule.IndexKeys()
+	if len(keys) == 0 {
+		i.scan = append(i.scan, rule)
+		return
+	}
+	if i.exact == nil {
+		i.exact = make(map[string]map[string][]*models.SuppressionRule)
+		i.labels = make(map[*models.SuppressionRule]string)
+	}
+	// file the rule under the label that leaves it with the fewest other candidates,
+	// e.g. device rather than alert_name
+	var (
+		label string
+		cost  = -1
+	)
+	for l, values := range keys {
+		c := len(values)
+		for _, v := range values {
+			c += len(i.exact[l][v])
+		}
+		if cost < 0 || c < cost || (c == cost && l < label) {
+			label, cost = l, c
+		}
+	}
+	byValue, ok := i.exact[label]
+	if !ok {
+		byValue = make(map[string][]*models.SuppressionRule)
+		i.exact[label] = byValue
+	}
+	for _, v := range keys[label] {
+		byValue[v] = append(byValue[v], rule)
+	}
+	i.labels[rule] = label
+}
+
+func (i *ruleIndex) remove(rule *models.SuppressionRule) {
+	label, ok := i.labels[rule]
+	if !ok {
+		i.scan = removeRule(i.scan, rule)
+		return
+	}
+	delete(i.labels, rule)
+	for _, v := range rule.IndexKeys()[label] {
+		rules := removeRule(i.exact[label][v], rule)
+		if len(rules) == 0 {
+			delete(i.exact[label], v)
+			continue
+		}
+		i.exact[label][v] = rules
+	}
+}
+
+func removeRule(rules []*models.SuppressionRule, rule *models.SuppressionRule) []*models.SuppressionRule {
+	for i, r := range rules {
+		if r == rule {
+			return append(rules[:i:i], rules[i+1:]...)
+		}
+	}
+	return rules
+}
+
+// candidates calls fn for every rule that can match the labels
+func (i *ruleIndex) candidates(labels models.Labels, fn func(*models.SuppressionRule)) {
+	for _, rule := range i.scan {
+		fn(rule)
+	}
+	for label, byValue := range i.exact {
+		lv, ok := labels[label]
+		if !ok {
+			continue
+		}
+		value, ok := lv.(string)
+		if !ok {
+			value = fmt.Sprint(lv)
+		}
+		for _, rule := range byValue[value] {
+			fn(rule)
+		}
+	}
 }
 
 // Global Suppressor Singleton
 var suppr *suppressor
 var suppOnce sync.Once
 
+// GetSuppressor loads the suppression rules and keeps them up to date. Rules are reloaded
+// as soon as they change in the db, and periodically to drop expired rules.
 func GetSuppressor(db models.Dbase) *suppressor {
 	suppOnce.Do(func() {
 		suppr = &suppressor{db: db}
 		ctx := context.Background()
 		suppr.loadSuppRules(ctx)
+		var changed <-chan struct{}
+		if l, ok := db.(models.Listener); ok {
+			var err error
+			if changed, err = l.Listen(models.ChannelSuppRules); err != nil {
+				glog.Errorf("Failed to listen for suppression rule changes: %v", err)
+			}
+		}
 		go func() {
 			t := time.NewTicker(SUPPRULE_UPDATE_INTERVAL)
-			for range t.C {
+			for {
+				select {
+				case <-t.C:
+				case <-changed:
+				}
 				suppr.loadSuppRules(ctx)
 			}
 		}()
@@ -40,8 +145,6 @@ func GetSuppressor(db models.Dbase) *suppressor {
 }
 
 func (s *suppressor) loadSuppRules(ctx context.Context) {
-	s.Lock()
-	defer s.Unlock()
 	glog.V(2).Infof("Updating suppression rules")
 	tx := s.db.NewTx()
 	var (
@@ -49,73 +152,152 @@ func (s *suppressor) loadSuppRules(ctx context.Context) {
 		er    error
 	)
 	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
-		if rules, er = tx.SelectRules(models.QuerySelectActive + " LIMIT 50"); er != nil {
+		if rules, er = tx.SelectRules(models.QuerySelectActive); er != nil {
 			return er
 		}
 		return nil
 	})
 	if err != nil {
 		glog.Errorf("Unable to select rules from db: %v", err)
+		return
+	}
+	var loaded models.SuppRules
+	for _, rule := range rules {
+		if err := rule.Compile(); err != nil {
+			glog.Errorf("Skipping suppression rule %d: %v", rule.Id, err)
+			continue
+		}
+		loaded = append(loaded, rule)
 	}
-	s.suppRules = rules
 
 	// load persistent rules from config
 	for _, rule := range Config.GetSuppressionRules() {
 		ents := models.Labels{}
-		for k, v := range rule.Matches {
+		for k, v := range rule.Matches.Labels {
 			ents[k] = v
 		}
 		r := models.NewSuppRule(ents, models.CondMap[rule.MatchCondition], rule.Reason, "alert manager", rule.Duration)
 		r.DontExpire = true
-		s.suppRules = append(s.suppRules, r)
+		if rule.Matches.Expr != nil {
+			r.Expr = rule.Matches.Expr.String()
+		}
+		if err := r.Compile(); err != nil {
+			glog.Errorf("Skipping suppression rule %s: %v", rule.Name, err)
+			continue
+		}
+		loaded = append(loaded, r)
 	}
+	s.setRules(loaded)
+	glog.V(2).Infof("Loaded %d suppression rules", len(loaded))
 }
 
+// setRules replaces all rules with compiled rules
+func (s *suppressor) setRules(rules models.SuppRules) {
+	index := ruleIndex{}
+	for _, rule := range rules {
+		index.add(rule)
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.suppRules = rules
+	s.index = index
+}
+
+// SaveRule saves a new rule, which is cached once tx is committed
 func (s *suppressor) SaveRule(ctx context.Context, tx models.Txn, rule *models.SuppressionRule) (int64, error) {
+	if err := rule.Compile(); err != nil {
+		return 0, err
+	}
 	id, err := tx.NewInsert(models.QueryInsertRule, rule)
 	if err != nil {
 		return 0, fmt.Errorf("Unable to save rule: %v", err)
 	}
 	rule.Id = id
+	models.AfterCommit(tx, func() {
+		s.Lock()
+		defer s.Unlock()
+		s.suppRules = append(s.suppRules, rule)
+		s.index.add(rule)
+	})
+	return id, nil
+}
+
+// UpdateRule saves a changed rule, which replaces the cached one once tx is committed
+func (s *suppressor) UpdateRule(ctx context.Context, tx models.Txn, rule *models.SuppressionRule) error {
+	if err := rule.Compile(); err != nil {
+		return err
+	}
+	if err := tx.UpdateRule(rule); err != nil {
+		return fmt.Errorf("Unable to update rule: %v", err)
+	}
+	models.AfterCommit(tx, func() { s.replaceRule(rule) })
+	return nil
+}
+
+// DeleteRule deletes a rule, which is removed from the cache once tx is committed
+func (s *suppressor) DeleteRule(ctx context.Context, tx models.Txn, id int64) error {
+	if err := tx.InQuery(models.QueryDeleteSuppRules, []int64{id}); err != nil {
+		return err
+	}
+	models.AfterCommit(tx, func() { s.uncacheRule(id) })
+	return nil
+}
+
+// replaceRule replaces the cached rule with the same id, or adds the rule if it is not cached
+func (s *suppressor) replaceRule(rule *models.SuppressionRule) {
 	s.Lock()
 	defer s.Unlock()
+	for i, r := range s.suppRules {
+		if r.Id == rule.Id {
+			s.index.remove(r)
+			s.suppRules[i] = rule
+			s.index.add(rule)
+			return
+		}
+	}
 	s.suppRules = append(s.suppRules, rule)
-	return id, nil
+	s.index.add(rule)
 }
 
-func (s *suppressor) DeleteRule(ctx context.Context, tx models.Txn, id int64) error {
+// uncacheRule removes the rule with the id from the cache
+func (s *suppressor) uncacheRule(id int64) {
 	s.Lock()
 	defer s.Unlock()
 	for i, rule := range s.suppRules {
 		if rule.Id == id {
 			s.suppRules = append(s.suppRules[:i], s.suppRules[i+1:]...)
-			break
+			s.index.remove(rule)
+			return
 		}
 	}
-	return tx.InQuery(models.QueryDeleteSuppRules, []int64{id})
 }
 
+// Match returns the latest rule in effect that matches the labels, if any. Expired rules
+// are dropped by the next reload.
 func (s *suppressor) Match(labels models.Labels) *models.SuppressionRule {
-	s.Lock()
-	defer s.Unlock()
-	var matches []*models.SuppressionRule
-	for i := 0; i < len(s.suppRules); i++ {
-		rule := s.suppRules[i]
-		if rule.Match(labels) {
-			if rule.TimeLeft() <= 0 {
-				// rule has expired, remove from cache
-				s.suppRules = append(s.suppRules[:i], s.suppRules[i+1:]...)
-				i--
-				continue
-			}
-			matches = append(matches, rule)
+	s.RLock()
+	defer s.RUnlock()
+	now := time.Now()
+	var match *models.SuppressionRule
+	s.index.candidates(labels, func(rule *models.SuppressionRule) {
+		if match != nil && !rule.CreatedAt.After(match.CreatedAt.Time) {
+			return
+		}
+		if rule.Started(now) && rule.Match(labels) && rule.TimeLeft() > 0 {
+			match = rule
+		}
+	})
+	return match
+}
+
+// Rule returns the cached rule with the given id, if any
+func (s *suppressor) Rule(id int64) *models.SuppressionRule {
+	s.RLock()
+	defer s.RUnlock()
+	for _, rule := range s.suppRules {
+		if rule.Id == id {
+			return rule
 		}
-	}
-	if len(matches) > 0 {
-		sort.Slice(matches, func(i, j int) bool {
-			return matches[i].CreatedAt.After(matches[j].CreatedAt.Time)
-		})
-		return matches[0]
 	}
 	return nil
 }
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"context"
+	"fmt"
 	"github.com/mayuresh82/alert_manager/internal/models"
 	tu "github.com/mayuresh82/alert_manager/testutil"
 	"github.com/stretchr/testify/assert"
@@ -98,13 +99,74 @@ func TestRuleMatch(t *testing.T) {
 	labels = models.Labels{"device": "dev2"}
 	rule = s.Match(labels)
 	assert.Nil(t, rule)
+
+	// test persistent rule with a match expression
+	rule = s.Match(models.Labels{"device": "lab-sw1"})
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, rule.Reason, "lab")
+	}
+	assert.Nil(t, s.Match(models.Labels{"device": "lab-sw1", "owner": "foo"}))
+}
+
+func TestWindowMatch(t *testing.T) {
+	labels := models.Labels{"alert_name": "Test Alert 1"}
+	open := models.NewSuppRule(labels, models.MatchCond_ALL, "test", "test", time.Hour)
+	open.Schedule = "* * * * *"
+	closed := models.NewSuppRule(labels, models.MatchCond_ALL, "test", "test", time.Hour)
+	closed.Schedule = fmt.Sprintf("0 %d * * *", (time.Now().UTC().Hour()+12)%24)
+	// created long ago, windows do not expire
+	closed.CreatedAt.Time = closed.CreatedAt.Add(-24 * time.Hour)
+	s := &suppressor{db: &MockDb2{}}
+	for _, r := range []*models.SuppressionRule{open, closed} {
+		if err := saveRule(s, &MockTx2{}, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, s.Match(labels), open)
+	assert.True(t, open.TimeLeft() > 59*time.Minute)
+	assert.Equal(t, closed.TimeLeft(), time.Duration(0))
+
+	// a closed window stays cached until it opens again
+	s.setRules(s.suppRules[1:])
+	assert.Nil(t, s.Match(labels))
+	assert.Equal(t, len(s.suppRules), 1)
+
+	// invalid schedules are rejected
+	bad := models.NewSuppRule(labels, models.MatchCond_ALL, "test", "test", time.Hour)
+	bad.Schedule = "* * *"
+	_, err := s.SaveRule(context.Background(), &MockTx2{}, bad)
+	assert.Error(t, err)
+	bad.Schedule = "@daily"
+	bad.Duration = 0
+	_, err = s.SaveRule(context.Background(), &MockTx2{}, bad)
+	assert.Error(t, err)
+}
+
+// saveRule saves a rule in a committed transaction
+func saveRule(s *suppressor, tx models.Txn, rule *models.SuppressionRule) error {
+	return models.WithTx(context.Background(), tx, func(ctx context.Context, tx models.Txn) error {
+		_, err := s.SaveRule(ctx, tx, rule)
+		return err
+	})
 }
 
 func TestSaveRule(t *testing.T) {
 	e := models.Labels{"alert_id": 1}
 	r := models.NewSuppRule(e, models.MatchCond_ALL, "test", "test", 5*time.Minute)
 	s := &suppressor{db: &MockDb2{}}
-	if _, err := s.SaveRule(context.Background(), &MockTx2{}, r); err != nil {
+
+	// a rule is only cached once its transaction commits
+	err := models.WithTx(context.Background(), &MockTx2{}, func(ctx context.Context, tx models.Txn) error {
+		if _, err := s.SaveRule(ctx, tx, r); err != nil {
+			return err
+		}
+		assert.Nil(t, s.Match(e))
+		return fmt.Errorf("rolled back")
+	})
+	assert.Error(t, err)
+	assert.Nil(t, s.Match(e))
+
+	if err := saveRule(s, &MockTx2{}, r); err != nil {
 		t.Fatal(err)
 	}
 	rule := s.Match(e)
@@ -123,7 +185,7 @@ func TestSuppAlert(t *testing.T) {
 	if err := s.SuppressAlert(ctx, tx, a1, 1*time.Minute); err != nil {
 		t.Fatal(err)
 	}
-	if _, err := s.SaveRule(ctx, tx, r); err != nil {
+	if err := saveRule(s, tx, r); err != nil {
 		t.Fatal(err)
 	}
 	assert.Equal(t, a1.Status, models.Status_SUPPRESSED)
@@ -145,3 +207,96 @@ func TestSuppAlert(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, a2.Status, models.Status_SUPPRESSED)
 }
+
+func TestRuleIndex(t *testing.T) {
+	m := &calendarDb{rules: make(map[int64]*models.SuppressionRule)}
+	s := &suppressor{db: m}
+	ctx := context.Background()
+	tx := m.NewTx()
+	indexed := models.NewSuppRule(models.Labels{"device": "^(dev1|dev2)$"}, models.MatchCond_ALL, "test", "test", time.Hour)
+	scanned := models.NewSuppRule(models.Labels{"device": "^dev"}, models.MatchCond_ALL, "test", "test", time.Hour)
+	scanned.CreatedAt.Time = scanned.CreatedAt.Add(-time.Minute)
+	for _, r := range []*models.SuppressionRule{indexed, scanned} {
+		if err := saveRule(s, tx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, len(s.index.exact["device"]), 2)
+	assert.Equal(t, s.index.scan, []*models.SuppressionRule{scanned})
+
+	// the latest rule wins
+	assert.Equal(t, s.Match(models.Labels{"device": "dev2"}), indexed)
+	assert.Equal(t, s.Match(models.Labels{"device": "dev3"}), scanned)
+	assert.Nil(t, s.Match(models.Labels{"device": "sw1"}))
+
+	// updates move the rule to its new key
+	moved := *indexed
+	moved.Entities = models.Labels{"device": "^dev3$"}
+	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		return s.UpdateRule(ctx, tx, &moved)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.Match(models.Labels{"device": "dev2"}), scanned)
+	assert.Equal(t, s.Match(models.Labels{"device": "dev3"}), &moved)
+	assert.Equal(t, len(s.index.exact["device"]), 1)
+
+	err = models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		return s.DeleteRule(ctx, tx, moved.Id)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(s.index.exact["device"]), 0)
+	assert.Equal(t, s.Match(models.Labels{"device": "dev3"}), scanned)
+	assert.Equal(t, len(s.suppRules), 1)
+}
+
+// benchRules returns n rules, all but one in ten of them indexable
+func benchRules(n int) models.SuppRules {
+	var rules models.SuppRules
+	for i := 0; i < n; i++ {
+		var r *models.SuppressionRule
+		switch {
+		case i%10 == 0:
+			r = models.NewSuppRule(models.Labels{"device": fmt.Sprintf("^sw%d\\.", i)}, models.MatchCond_ALL, "bench", "bench", time.Hour)
+		case i%2 == 0:
+			r = models.NewSuppRule(models.Labels{"alert_name": "^Device Down$", "device": fmt.Sprintf("^rtr%d\\.dc1$", i)}, models.MatchCond_ALL, "bench", "bench", time.Hour)
+		default:
+			r = &models.SuppressionRule{Expr: fmt.Sprintf("site in (dc%d, lab%d) and Priority < 3", i, i), CreatedAt: models.MyTime{time.Now()}, Duration: 3600}
+		}
+		if err := r.Compile(); err != nil {
+			panic(err)
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+var benchLabels = []models.Labels{
+	{"alert_name": "Device Down", "device": "rtr5000.dc1", "site": "dc1", "Priority": float64(2)},
+	{"alert_name": "Device Down", "device": "sw7.dc2", "site": "dc7", "Priority": float64(2)},
+	{"alert_name": "BGP Session Down", "device": "fw1.dc3", "site": "dc3", "Priority": float64(4)},
+}
+
+func BenchmarkMatch10k(b *testing.B) {
+	s := &suppressor{}
+	s.setRules(benchRules(10000))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Match(benchLabels[i%len(benchLabels)])
+	}
+}
+
+// BenchmarkMatch10kUnindexed checks every rule, for comparison
+func BenchmarkMatch10kUnindexed(b *testing.B) {
+	rules := benchRules(10000)
+	s := &suppressor{}
+	s.setRules(rules)
+	s.index = ruleIndex{scan: rules}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Match(benchLabels[i%len(benchLabels)])
+	}
+}
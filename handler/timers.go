@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/mayuresh82/alert_manager/internal/scheduler"
+)
+
+// Timers holds the per-alert deadlines of the handler and processors. It is run by the handler.
+var Timers = scheduler.New()
+
+func expiryKey(id int64) string     { return fmt.Sprintf("expire/%d", id) }
+func escalationKey(id int64) string { return fmt.Sprintf("escalate/%d", id) }
+func clearKey(id int64) string      { return fmt.Sprintf("clear/%d", id) }
+func policyKey(id int64) string     { return fmt.Sprintf("policy/%d", id) }
+
+// loadTimers schedules the expiry and escalation of all active alerts in the db
+func (h *AlertHandler) loadTimers(ctx context.Context) {
+	tx := h.Db.NewTx()
+	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		var active []*models.Alert
+		if err := tx.InSelect(models.QuerySelectByStatus, &active, []int64{int64(models.Status_ACTIVE)}); err != nil {
+			return err
+		}
+		for _, alert := range active {
+			h.scheduleExpiry(ctx, alert)
+			h.scheduleEscalation(ctx, alert)
+		}
+		// resume clears that were waiting out the holddown when the process stopped
+		clears, err := tx.SelectPendingClears(models.QuerySelectPendingClears)
+		if err != nil {
+			return err
+		}
+		for _, clear := range clears {
+			h.schedulePendingClear(ctx, clear.AlertId, clear.Deadline.Time)
+		}
+		// close the maintenance windows of suppressed alerts, right away if they closed while stopped
+		var suppressed []*models.Alert
+		if err := tx.InSelect(models.QuerySelectByStatus, &suppressed, []int64{int64(models.Status_SUPPRESSED)}); err != nil {
+			return err
+		}
+		windows := make(map[int64]bool)
+		for _, alert := range suppressed {
+			if alert.SuppressedBy == 0 || windows[alert.SuppressedBy] {
+				continue
+			}
+			windows[alert.SuppressedBy] = true
+			end := time.Now()
+			if rule := h.Suppressor.Rule(alert.SuppressedBy); rule != nil {
+				if e, ok := rule.WindowEnd(end); ok {
+					end = e
+				}
+			}
+			h.scheduleWindowClose(ctx, alert.SuppressedBy, end)
+		}
+		// resume the escalation policies, steps that came due while stopped run right away
+		escalations, err := tx.SelectEscalations(models.QuerySelectRunningEscalations)
+		if err != nil {
+			return err
+		}
+		for _, esc := range escalations {
+			at := time.Now()
+			if policy, ok := Config.GetEscalationPolicy(esc.Policy); ok && esc.Step < len(policy.Steps) {
+				at = esc.StartedAt.Add(policy.Steps[esc.Step].After)
+			}
+			h.scheduleEscalationStep(ctx, esc.AlertId, at)
+		}
+		glog.V(2).Infof("Scheduled timers for %d active alerts, %d pending clears, %d maintenance windows and %d escalation policies",
+			len(active), len(clears), len(windows), len(escalations))
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("Failed to load alert timers: %v", err)
+		h.statDbError.Add(1)
+	}
+}
+
+// expiresAt returns the time an alert expires if no new update is received
+func expiresAt(alert *models.Alert) (time.Time, bool) {
+	if !alert.AutoExpire || !alert.ExpireAfter.Valid || alert.IsAggregate {
+		// aggregate expiry handled by aggregators
+		return time.Time{}, false
+	}
+	return alert.LastActive.Add(time.Duration(alert.ExpireAfter.Int64) * time.Second), true
+}
+
+func (h *AlertHandler) scheduleExpiry(ctx context.Context, alert *models.Alert) {
+	at, ok := expiresAt(alert)
+	if !ok {
+		return
+	}
+	id := alert.Id
+	Timers.Schedule(expiryKey(id), at, func() { h.handleExpiry(ctx, id) })
+}
+
+// nextEscalation returns the time of the next escalation step of an unacknowledged alert
+func nextEscalation(alert *models.Alert) (time.Time, bool) {
+	if alert.Owner.Valid || alert.Status != models.Status_ACTIVE {
+		return time.Time{}, false
+	}
+	config, ok := Config.GetAlertConfig(alert.Name)
+	if !ok {
+		return time.Time{}, false
+	}
+	var next time.Time
+	for _, rule := range config.Config.EscalationRules {
+		if models.SevMap[rule.EscalateTo] >= alert.Severity {
+			continue
+		}
+		if at := alert.StartTime.Add(rule.After); next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func (h *AlertHandler) scheduleEscalation(ctx context.Context, alert *models.Alert) {
+	at, ok := nextEscalation(alert)
+	if !ok {
+		Timers.Cancel(escalationKey(alert.Id))
+		return
+	}
+	id := alert.Id
+	Timers.Schedule(escalationKey(id), at, func() { h.handleEscalation(ctx, id) })
+}
+
+func (h *AlertHandler) schedulePendingClear(ctx context.Context, id int64, at time.Time) {
+	Timers.Schedule(clearKey(id), at, func() { h.handlePendingClear(ctx, id) })
+}
+
+// cancelPendingClear cancels the pending clear of an alert, if any. The pending clear is
+// deleted even if it is not scheduled here, e.g. when it was saved by another replica.
+func (h *AlertHandler) cancelPendingClear(tx models.Txn, id int64) {
+	if Timers.Cancel(clearKey(id)) {
+		glog.V(2).Infof("Cancelled pending clear for alert %d", id)
+	}
+	if err := tx.Exec(models.QueryDeletePendingClear, id); err != nil {
+		h.statDbError.Add(1)
+		glog.Errorf("Failed to delete pending clear for alert %d: %v", id, err)
+	}
+}
+
+// cancelTimers cancels all pending timers of an alert that is no longer active
+func (h *AlertHandler) cancelTimers(tx models.Txn, id int64) {
+	Timers.Cancel(expiryKey(id))
+	Timers.Cancel(escalationKey(id))
+	h.cancelEscalation(tx, id, "alert is no longer active")
+	h.cancelPendingClear(tx, id)
+}
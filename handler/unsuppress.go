@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/internal/models"
+)
+
+const UNSUPPRESS_CHECK_INTERVAL = time.Minute
+
+// touchSuppressed extends the last active time of a suppressed or inhibited alert that fired
+// again, so that it is known to be active at the source when its suppression ends
+func (h *AlertHandler) touchSuppressed(tx models.Txn, alert *models.Alert) (bool, error) {
+	existing, err := tx.GetAlert(models.QuerySelectSuppressedByFingerprint, alert.Fingerprint)
+	if err != nil {
+		return false, nil
+	}
+	newLastActive := models.MyTime{time.Now()}
+	if err := tx.InQuery(models.QueryUpdateLastActive, newLastActive, []int64{existing.Id}); err != nil {
+		h.statDbError.Add(1)
+		return false, fmt.Errorf("Failed to update last active: %v", err)
+	}
+	return true, nil
+}
+
+// clearSuppressed clears an alert suppressed by a rule or maintenance window, or inhibited by
+// a root cause alert. There is nothing to notify, so the clear holddown does not apply.
+func (h *AlertHandler) clearSuppressed(ctx context.Context, tx models.Txn, alert *models.Alert) error {
+	existing, err := tx.GetAlert(models.QuerySelectSuppressedByFingerprint, alert.Fingerprint)
+	if err != nil {
+		glog.V(2).Infof("No existing alert found for %s:%s to clear", alert.Name, alert.Entity)
+		return nil
+	}
+	if !existing.AutoClear {
+		glog.V(2).Infof("Not auto-clearing alert %d ", existing.Id)
+		return nil
+	}
+	return h.clearAlert(ctx, tx, existing)
+}
+
+// checkUnsuppressSoon triggers checkUnsuppress without waiting for the next periodic check
+func (h *AlertHandler) checkUnsuppressSoon() {
+	select {
+	case h.unsuppress <- struct{}{}:
+	default:
+	}
+}
+
+// checkUnsuppress releases the suppressed alerts whose suppression rule has expired or was
+// deleted. Alerts matching another rule stay suppressed by it. Maintenance windows that close
+// are handled by closeWindow. Alerts inhibited by a root cause alert are released once it
+// has ended, other alerts suppressed without a rule are left alone.
+func (h *AlertHandler) checkUnsuppress(ctx context.Context) {
+	now := time.Now()
+	var released int
+	tx := h.Db.NewTx()
+	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		alerts, err := tx.SelectAlerts(models.QuerySelectAllSuppressed)
+		if err != nil {
+			return err
+		}
+		for _, alert := range alerts {
+			if alert.SuppressedBy == 0 {
+				continue
+			}
+			rule := h.Suppressor.Rule(alert.SuppressedBy)
+			if rule != nil && (rule.Scheduled() || rule.TimeLeft() > 0) {
+				continue
+			}
+			reason := fmt.Sprintf("suppression rule %d expired or was deleted", alert.SuppressedBy)
+			if rule != nil {
+				reason = fmt.Sprintf("suppression rule %d:%s expired", rule.Id, rule.Name)
+			}
+			if alert.Labels == nil {
+				alert.Labels = models.Labels{}
+			}
+			alert.ExtendLabels()
+			if other := h.Suppressor.Match(alert.Labels); other != nil && !other.Scheduled() && other.Started(now) {
+				if other.Id != alert.SuppressedBy {
+					alert.SuppressedBy = other.Id
+					if err := tx.UpdateAlert(alert); err != nil {
+						return err
+					}
+					tx.NewRecord(alert.Id, fmt.Sprintf("Alert suppressed by rule %d:%s, %s", other.Id, other.Name, reason))
+				}
+				continue
+			}
+			if err := h.releaseAlert(ctx, tx, alert, reason); err != nil {
+				return err
+			}
+			released++
+		}
+		inhibited, err := tx.SelectAlerts(models.QuerySelectRootEnded)
+		if err != nil {
+			return err
+		}
+		for _, alert := range inhibited {
+			// the topology processor checks the released alert against the other root causes
+			reason := fmt.Sprintf("root cause alert %d ended", alert.InhibitedBy)
+			if err := h.releaseAlert(ctx, tx, alert, reason); err != nil {
+				return err
+			}
+			released++
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("Failed to unsuppress alerts: %v", err)
+		h.statDbError.Add(1)
+		return
+	}
+	if released > 0 {
+		glog.V(2).Infof("Released %d suppressed alerts", released)
+	}
+}
+
+// releaseAlert ends the suppression of an alert. An alert that is still active at the source
+// becomes active again and is notified, a stale one is expired.
+func (h *AlertHandler) releaseAlert(ctx context.Context, tx models.Txn, alert *models.Alert, reason string) error {
+	alert.SuppressedBy = 0
+	alert.InhibitedBy = 0
+	if at, ok := expiresAt(alert); ok && !time.Now().Before(at) {
+		glog.V(2).Infof("Alert ID %d went stale while suppressed, expiring", alert.Id)
+		alert.Expire()
+		if err := tx.UpdateAlert(alert); err != nil {
+			return err
+		}
+		h.cancelTimers(tx, alert.Id)
+		tx.NewRecord(alert.Id, fmt.Sprintf("Alert expired, %s", reason))
+		h.notifyReceivers(alert, models.EventType_EXPIRED)
+		return nil
+	}
+	alert.Unsuppress()
+	if err := tx.UpdateAlert(alert); err != nil {
+		return err
+	}
+	tx.NewRecord(alert.Id, fmt.Sprintf("Alert unsuppressed, %s", reason))
+	h.scheduleExpiry(ctx, alert)
+	h.scheduleEscalation(ctx, alert)
+	h.notifyReceivers(alert, models.EventType_UNSUPPRESSED)
+	return nil
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckUnsuppress(t *testing.T) {
+	m := &reopenDb{}
+	h := &AlertHandler{Db: m, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
+	h.procChan = make(chan *models.AlertEvent, 10)
+	h.flapper = newFlapDetector()
+	h.Suppressor = &suppressor{db: m}
+	h.unsuppress = make(chan struct{}, 1)
+	ctx := context.Background()
+	defer Timers.Cancel(expiryKey(600))
+	defer Timers.Cancel(escalationKey(600))
+
+	newRule := func(id int64, name string, age time.Duration) *models.SuppressionRule {
+		r := models.NewSuppRule(models.Labels{"alert_name": "^Test Alert Unsuppress$"}, models.MatchCond_ALL, "test", "test", time.Hour)
+		r.Id, r.Name = id, name
+		r.CreatedAt.Time = r.CreatedAt.Add(-age)
+		if err := r.Compile(); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	suppressed := func() *models.Alert {
+		a := tu.MockAlert(600, "Test Alert Unsuppress", "", "d1", "e1", "grafana", "phy_interface", "t1", "1", "WARN", []string{}, nil)
+		a.Status = models.Status_SUPPRESSED
+		a.SuppressedBy = 5
+		m.alert = a
+		return a
+	}
+
+	// the rule is still in effect
+	h.Suppressor.setRules(models.SuppRules{newRule(5, "maint", 0)})
+	a := suppressed()
+	h.checkUnsuppress(ctx)
+	assert.Equal(t, a.Status, models.Status_SUPPRESSED)
+	assert.Equal(t, len(h.procChan), 0)
+
+	// the rule expired, another rule still matches
+	h.Suppressor.setRules(models.SuppRules{newRule(5, "maint", 2*time.Hour), newRule(8, "longer", 0)})
+	h.checkUnsuppress(ctx)
+	assert.Equal(t, a.Status, models.Status_SUPPRESSED)
+	assert.Equal(t, a.SuppressedBy, int64(8))
+	assert.Equal(t, m.records[len(m.records)-1], "Alert suppressed by rule 8:longer, suppression rule 5:maint expired")
+	assert.Equal(t, len(h.procChan), 0)
+
+	// the rule was deleted, the alert is active again
+	h.Suppressor.setRules(nil)
+	h.checkUnsuppress(ctx)
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_UNSUPPRESSED)
+	assert.Equal(t, a.Status, models.Status_ACTIVE)
+	assert.Equal(t, a.SuppressedBy, int64(0))
+	assert.Equal(t, m.records[len(m.records)-1], "Alert unsuppressed, suppression rule 8 expired or was deleted")
+
+	// stale alerts are expired
+	h.Suppressor.setRules(models.SuppRules{newRule(5, "maint", 2*time.Hour)})
+	a = suppressed()
+	a.AutoExpire = true
+	a.ExpireAfter = sql.NullInt64{600, true}
+	a.LastActive.Time = a.LastActive.Add(-time.Hour)
+	h.checkUnsuppress(ctx)
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_EXPIRED)
+	assert.Equal(t, a.Status, models.Status_EXPIRED)
+	assert.Equal(t, m.records[len(m.records)-1], "Alert expired, suppression rule 5:maint expired")
+
+	// alerts suppressed without a rule, e.g. by the inhibitor, stay suppressed
+	h.Suppressor.setRules(nil)
+	a = suppressed()
+	a.SuppressedBy = 0
+	h.checkUnsuppress(ctx)
+	assert.Equal(t, a.Status, models.Status_SUPPRESSED)
+	assert.Equal(t, len(h.procChan), 0)
+
+	// alerts inhibited by a root cause alert are released once it has ended
+	a.InhibitedBy = 7
+	m.activeRoot = 7
+	h.checkUnsuppress(ctx)
+	assert.Equal(t, a.Status, models.Status_SUPPRESSED)
+	assert.Equal(t, len(h.procChan), 0)
+	m.activeRoot = 0
+	h.checkUnsuppress(ctx)
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_UNSUPPRESSED)
+	assert.Equal(t, a.Status, models.Status_ACTIVE)
+	assert.Equal(t, a.InhibitedBy, int64(0))
+	assert.Equal(t, m.records[len(m.records)-1], "Alert unsuppressed, root cause alert 7 ended")
+
+	// checks requested while one is pending are not queued twice
+	h.checkUnsuppressSoon()
+	h.checkUnsuppressSoon()
+	assert.Equal(t, len(h.unsuppress), 1)
+}
+
+func TestTouchInhibited(t *testing.T) {
+	m := &reopenDb{}
+	h := &AlertHandler{Db: m, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
+	h.procChan = make(chan *models.AlertEvent, 10)
+	h.flapper = newFlapDetector()
+	h.Suppressor = &suppressor{db: m}
+	ctx := context.Background()
+
+	newAlert := func() *models.Alert {
+		return tu.MockAlert(0, "Test Alert Inhibited", "", "d1", "e1", "grafana", "phy_interface", "t1", "1", "WARN", []string{}, nil)
+	}
+	h.handleActive(ctx, m.NewTx(), newAlert())
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_ACTIVE)
+	assert.Equal(t, m.inserts, 1)
+	// inhibited by the topology processor
+	m.alert.Status = models.Status_SUPPRESSED
+	m.alert.InhibitedBy = 7
+
+	// fired again: the inhibited alert is kept
+	h.handleActive(ctx, m.NewTx(), newAlert())
+	assert.Equal(t, m.inserts, 1)
+	assert.Equal(t, len(h.procChan), 0)
+	assert.Equal(t, m.alert.Status, models.Status_SUPPRESSED)
+
+	// cleared at the source
+	m.alert.AutoClear = true
+	h.handleClear(ctx, m.NewTx(), newAlert(), 0)
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_CLEARED)
+	assert.Equal(t, m.alert.Status, models.Status_CLEARED)
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/internal/models"
+)
+
+// escalatedSeverity returns the severity of a re-fired alert with the escalation rules that are
+// already due applied, so that a re-fire does not undo an escalation
+func escalatedSeverity(existing *models.Alert, sev models.AlertSeverity) models.AlertSeverity {
+	if existing.Owner.Valid {
+		return sev
+	}
+	config, ok := Config.GetAlertConfig(existing.Name)
+	if !ok {
+		return sev
+	}
+	for _, rule := range config.Config.EscalationRules {
+		newSev := models.SevMap[rule.EscalateTo]
+		if newSev < sev && time.Now().Sub(existing.StartTime.Time) >= rule.After {
+			sev = newSev
+		}
+	}
+	return sev
+}
+
+// updateFields applies the severity, description and source label changes of a re-fired alert
+// to the existing alert. It returns the changes made and whether the severity went up.
+func updateFields(existing, alert *models.Alert) ([]string, bool) {
+	var (
+		changes   []string
+		escalated bool
+	)
+	if sev := escalatedSeverity(existing, alert.Severity); sev != existing.Severity {
+		changes = append(changes, fmt.Sprintf("severity %s -> %s", existing.Severity.String(), sev.String()))
+		// lower values are more severe
+		escalated = sev < existing.Severity
+		existing.SetSeverity(sev)
+	}
+	if alert.Description != "" && alert.Description != existing.Description {
+		changes = append(changes, fmt.Sprintf("description %q -> %q", existing.Description, alert.Description))
+		existing.Description = alert.Description
+	}
+	// only labels from the source are compared, the existing alert also has labels added by transforms
+	var keys []string
+	for k := range alert.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := alert.Labels[k]
+		old, ok := existing.Labels[k]
+		// values read back from the db are json decoded, compare their printed form
+		if ok && fmt.Sprint(old) == fmt.Sprint(v) {
+			continue
+		}
+		if existing.Labels == nil {
+			existing.Labels = make(models.Labels)
+		}
+		if ok {
+			changes = append(changes, fmt.Sprintf("label %s %v -> %v", k, old, v))
+		} else {
+			changes = append(changes, fmt.Sprintf("label %s added as %v", k, v))
+		}
+		existing.Labels[k] = v
+	}
+	return changes, escalated
+}
+
+// updateExisting applies the changes of a re-fired alert to the existing active alert. The changes
+// are recorded and notified as ESCALATED if the severity went up, or else as UPDATED.
+func (h *AlertHandler) updateExisting(ctx context.Context, tx models.Txn, existing, alert *models.Alert) error {
+	changes, escalated := updateFields(existing, alert)
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := tx.UpdateAlert(existing); err != nil {
+		h.statDbError.Add(1)
+		return fmt.Errorf("Unable to update alert %d: %v", existing.Id, err)
+	}
+	glog.V(2).Infof("Updated alert %s:%d: %v", existing.Name, existing.Id, changes)
+	tx.NewRecord(existing.Id, "Alert updated: "+strings.Join(changes, ", "))
+	event := models.EventType_UPDATED
+	if escalated {
+		event = models.EventType_ESCALATED
+	}
+	h.notifyReceivers(existing, event)
+	h.scheduleEscalation(ctx, existing)
+	return nil
+}
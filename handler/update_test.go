@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateFields(t *testing.T) {
+	existing := tu.MockAlert(1, "Test Alert 4", "desc", "d1", "e1", "grafana", "phy_interface", "t1", "1", "INFO", []string{},
+		models.Labels{"region": "us", "sites": []interface{}{"a", "b"}, "netbox_role": "spine"})
+
+	// labels added by transforms and json decoded values are not changes
+	alert := tu.MockAlert(0, "Test Alert 4", "desc", "d1", "e1", "grafana", "phy_interface", "t1", "1", "INFO", []string{},
+		models.Labels{"region": "us", "sites": []string{"a", "b"}})
+	changes, escalated := updateFields(existing, alert)
+	assert.Equal(t, len(changes), 0)
+	assert.False(t, escalated)
+
+	alert.Severity = models.Sev_WARN
+	alert.Description = "new desc"
+	alert.Labels["region"] = "eu"
+	alert.Labels["pop"] = "sjc"
+	changes, escalated = updateFields(existing, alert)
+	assert.Equal(t, changes, []string{
+		`severity INFO -> WARN`,
+		`description "desc" -> "new desc"`,
+		`label pop added as sjc`,
+		`label region us -> eu`,
+	})
+	assert.True(t, escalated)
+	assert.Equal(t, existing.Severity, models.Sev_WARN)
+	assert.Equal(t, existing.Labels["netbox_role"], "spine")
+
+	// a re-fire does not undo escalations that are due
+	existing.StartTime = models.MyTime{time.Now().Add(-11 * time.Minute)}
+	existing.Severity = models.Sev_CRITICAL
+	changes, _ = updateFields(existing, alert)
+	assert.Equal(t, len(changes), 0)
+	assert.Equal(t, existing.Severity, models.Sev_CRITICAL)
+}
+
+func TestHandlerAlertUpdate(t *testing.T) {
+	m := &reopenDb{}
+	h := &AlertHandler{Db: m, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
+	h.procChan = make(chan *models.AlertEvent, 10)
+	h.flapper = newFlapDetector()
+	h.Suppressor = &suppressor{db: m}
+	ctx := context.Background()
+
+	newAlert := func(sev, desc string) *models.Alert {
+		return tu.MockAlert(0, "Test Alert Reopen", desc, "d1", "e1", "grafana", "phy_interface", "t1", "1", sev, []string{}, nil)
+	}
+	h.handleActive(ctx, m.NewTx(), newAlert("WARN", "errors at 10%"))
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_ACTIVE)
+
+	// duplicates only bump last active
+	h.handleActive(ctx, m.NewTx(), newAlert("WARN", "errors at 10%"))
+	assert.Equal(t, len(h.procChan), 0)
+
+	h.handleActive(ctx, m.NewTx(), newAlert("CRITICAL", "errors at 50%"))
+	event := <-h.procChan
+	assert.Equal(t, event.Type, models.EventType_ESCALATED)
+	assert.Equal(t, event.Alert.Severity, models.Sev_CRITICAL)
+	assert.Equal(t, event.Alert.Description, "errors at 50%")
+	assert.Equal(t, m.records[len(m.records)-1], `Alert updated: severity WARN -> CRITICAL, description "errors at 10%" -> "errors at 50%"`)
+
+	h.handleActive(ctx, m.NewTx(), newAlert("WARN", "errors at 50%"))
+	event = <-h.procChan
+	assert.Equal(t, event.Type, models.EventType_UPDATED)
+	assert.Equal(t, event.Alert.Severity, models.Sev_WARN)
+	assert.Equal(t, m.inserts, 1)
+}
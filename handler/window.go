@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/internal/models"
+)
+
+func windowKey(ruleId int64) string { return fmt.Sprintf("window/%d", ruleId) }
+
+// suppressByWindow saves an alert that fired during an open maintenance window as suppressed
+// by the window. It becomes active when the window closes unless it clears before.
+func (h *AlertHandler) suppressByWindow(ctx context.Context, tx models.Txn, alert *models.Alert, rule *models.SuppressionRule) error {
+	end, ok := rule.WindowEnd(time.Now())
+	if !ok {
+		return nil
+	}
+	h.ensureTeam(tx, alert.Team)
+	alert.Suppress(end.Sub(time.Now()))
+	alert.SuppressedBy = rule.Id
+	newId, err := tx.NewInsert(models.QueryInsertAlert, alert)
+	if err != nil {
+		h.statDbError.Add(1)
+		return fmt.Errorf("Unable to insert new alert: %v", err)
+	}
+	alert.Id = newId
+	tx.NewRecord(newId, fmt.Sprintf("Alert created from source %s with severity %s",
+		alert.Source, alert.Severity.String()))
+	tx.NewRecord(newId, fmt.Sprintf("Alert suppressed by maintenance window %d:%s until %s",
+		rule.Id, rule.Name, end.Format(time.RFC3339)))
+	h.scheduleWindowClose(ctx, rule.Id, end)
+	h.notifyReceivers(alert, models.EventType_SUPPRESSED)
+	return nil
+}
+
+func (h *AlertHandler) scheduleWindowClose(ctx context.Context, ruleId int64, at time.Time) {
+	Timers.Schedule(windowKey(ruleId), at, func() { h.closeWindow(ctx, ruleId) })
+}
+
+// closeWindow makes the alerts suppressed by a maintenance window active again once the
+// window has closed or its rule was deleted
+func (h *AlertHandler) closeWindow(ctx context.Context, ruleId int64) {
+	rule := h.Suppressor.Rule(ruleId)
+	if rule != nil {
+		// the next window may have opened already
+		if end, ok := rule.WindowEnd(time.Now()); ok {
+			h.scheduleWindowClose(ctx, ruleId, end)
+			return
+		}
+	}
+	tx := h.Db.NewTx()
+	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		alerts, err := tx.SelectAlerts(models.QuerySelectSuppressedBy, ruleId)
+		if err != nil {
+			return err
+		}
+		reason := fmt.Sprintf("maintenance window %d closed", ruleId)
+		if rule != nil {
+			reason = fmt.Sprintf("maintenance window %d:%s closed", ruleId, rule.Name)
+		}
+		for _, alert := range alerts {
+			if err := h.releaseAlert(ctx, tx, alert, reason); err != nil {
+				return err
+			}
+		}
+		glog.V(2).Infof("Maintenance window %d closed, unsuppressed %d alerts", ruleId, len(alerts))
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("Failed to close maintenance window %d: %v", ruleId, err)
+		h.statDbError.Add(1)
+	}
+}
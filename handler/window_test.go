@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerWindow(t *testing.T) {
+	m := &reopenDb{}
+	h := &AlertHandler{Db: m, statTransformError: &tu.MockStat{}, statDbError: &tu.MockStat{}}
+	h.procChan = make(chan *models.AlertEvent, 10)
+	h.flapper = newFlapDetector()
+	h.Suppressor = &suppressor{db: m}
+	ctx := context.Background()
+	defer Timers.Cancel(windowKey(7))
+
+	rule := models.NewSuppRule(models.Labels{"alert_name": "Test Alert Window"}, models.MatchCond_ALL, "test", "test", time.Hour)
+	rule.Id = 7
+	rule.Name = "maint"
+	rule.Schedule = "* * * * *"
+	if err := rule.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	h.Suppressor.setRules(models.SuppRules{rule})
+
+	newAlert := func() *models.Alert {
+		a := tu.MockAlert(0, "Test Alert Window", "", "d1", "e1", "grafana", "phy_interface", "t1", "1", "WARN", []string{}, nil)
+		a.AutoClear = true
+		return a
+	}
+	// fired in the open window
+	h.handleActive(ctx, m.NewTx(), newAlert())
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_SUPPRESSED)
+	assert.Equal(t, m.alert.Status, models.Status_SUPPRESSED)
+	assert.Equal(t, m.alert.SuppressedBy, int64(7))
+	assert.True(t, strings.HasPrefix(m.records[len(m.records)-1], "Alert suppressed by maintenance window 7:maint until "))
+	end, ok := Timers.Deadline(windowKey(7))
+	assert.True(t, ok)
+	assert.True(t, end.After(time.Now().Add(59*time.Minute)))
+
+	// re-fires are folded into the suppressed alert
+	h.handleActive(ctx, m.NewTx(), newAlert())
+	assert.Equal(t, len(h.procChan), 0)
+	assert.Equal(t, m.inserts, 1)
+
+	// the window is still open
+	h.closeWindow(ctx, 7)
+	assert.Equal(t, len(h.procChan), 0)
+	assert.Equal(t, m.alert.Status, models.Status_SUPPRESSED)
+
+	// closed: the alert is active again
+	rule.Schedule = fmt.Sprintf("0 %d * * *", (time.Now().UTC().Hour()+12)%24)
+	if err := rule.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	h.closeWindow(ctx, 7)
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_UNSUPPRESSED)
+	assert.Equal(t, m.alert.Status, models.Status_ACTIVE)
+	assert.Equal(t, m.alert.SuppressedBy, int64(0))
+	assert.Equal(t, m.records[len(m.records)-1], "Alert unsuppressed, maintenance window 7:maint closed")
+
+	// cleared while suppressed
+	rule.Schedule = "* * * * *"
+	if err := rule.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	m.alert = nil
+	h.handleActive(ctx, m.NewTx(), newAlert())
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_SUPPRESSED)
+	h.handleClear(ctx, m.NewTx(), newAlert(), time.Minute)
+	assert.Equal(t, (<-h.procChan).Type, models.EventType_CLEARED)
+	assert.Equal(t, m.alert.Status, models.Status_CLEARED)
+}
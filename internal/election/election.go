@@ -0,0 +1,226 @@
+// Package election elects a leader among the replicas sharing a db, through a lease held in
+// the db. The leader renews the lease well before it expires and steps down on its own if it
+// could not renew it in time, before any other replica is able to take it over.
+package election
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/mayuresh82/alert_manager/internal/stats"
+)
+
+const (
+	// there is a single leader per db
+	LEASE_NAME    = "alert_manager"
+	DEFAULT_LEASE = 15 * time.Second
+	MIN_LEASE     = 3 * time.Second
+)
+
+// Elector campaigns for the lease on behalf of a replica
+type Elector struct {
+	db    models.Dbase
+	id    string
+	lease time.Duration
+
+	leader bool
+	// the leader steps down at this time unless the lease is renewed before
+	until time.Time
+	// the lease expires in the db at this time unless renewed or released before
+	expires time.Time
+
+	statLeader stats.Stat
+	statError  stats.Stat
+
+	sync.Mutex
+}
+
+// New returns an elector for the replica with the given id, which has to be unique among the
+// replicas. A lost leader is replaced within the lease time.
+func New(db models.Dbase, id string, lease time.Duration) *Elector {
+	if lease == 0 {
+		lease = DEFAULT_LEASE
+	}
+	if lease < MIN_LEASE {
+		glog.Errorf("Election: Lease time %v is too short, using %v", lease, MIN_LEASE)
+		lease = MIN_LEASE
+	}
+	return &Elector{
+		db:         db,
+		id:         id,
+		lease:      lease,
+		statLeader: stats.NewGauge("election.leader"),
+		statError:  stats.NewCounter("election.errors"),
+	}
+}
+
+// Id returns the id of the replica
+func (e *Elector) Id() string {
+	return e.id
+}
+
+// IsLeader reports whether the replica currently leads the others
+func (e *Elector) IsLeader() bool {
+	e.Lock()
+	defer e.Unlock()
+	return e.leader && time.Now().Before(e.until)
+}
+
+// Holds reports whether the replica still holds the lease. Unlike IsLeader, it remains true
+// while a leader that stepped down finishes its term, until the lease expires or is released,
+// so that nothing the term still sends overlaps with the next leader.
+func (e *Elector) Holds() bool {
+	e.Lock()
+	defer e.Unlock()
+	return time.Now().Before(e.expires)
+}
+
+// Lease returns the current lease, or nil if no replica holds it
+func (e *Elector) Lease(ctx context.Context) (*models.Lease, error) {
+	var lease *models.Lease
+	tx := e.db.NewTx()
+	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		leases, err := tx.SelectLeases(models.QuerySelectLease, LEASE_NAME)
+		if err != nil {
+			return err
+		}
+		if len(leases) > 0 && time.Now().Before(leases[0].ExpiresAt.Time) {
+			lease = leases[0]
+		}
+		return nil
+	})
+	return lease, err
+}
+
+// renewInterval is how often the lease is renewed. The leader gets two attempts at renewing
+// it before stepping down.
+func (e *Elector) renewInterval() time.Duration {
+	return e.lease / 3
+}
+
+// acquire takes or renews the lease. It reports whether the replica holds it, and until when
+// it may consider itself the leader: one renewal interval before the lease expires in the db.
+func (e *Elector) acquire() (bool, time.Time, error) {
+	start := time.Now()
+	tx := e.db.NewTx()
+	if err := tx.Exec(models.QueryAcquireLease, LEASE_NAME, e.id, int64(e.lease/time.Second)); err != nil {
+		tx.Rollback()
+		return false, time.Time{}, err
+	}
+	leases, err := tx.SelectLeases(models.QuerySelectLease, LEASE_NAME)
+	if err != nil {
+		tx.Rollback()
+		return false, time.Time{}, err
+	}
+	// the lease is only renewed once the transaction is committed
+	if err := tx.Commit(); err != nil {
+		return false, time.Time{}, err
+	}
+	held := len(leases) > 0 && leases[0].Holder == e.id
+	return held, start.Add(e.lease - e.renewInterval()), nil
+}
+
+// release gives up the lease so that another replica can take over right away
+func (e *Elector) release() {
+	e.dropLease()
+	tx := e.db.NewTx()
+	err := models.WithTx(context.Background(), tx, func(ctx context.Context, tx models.Txn) error {
+		return tx.Exec(models.QueryReleaseLease, LEASE_NAME, e.id)
+	})
+	if err != nil {
+		glog.Errorf("Election: Unable to release lease: %v", err)
+		e.statError.Add(1)
+	}
+}
+
+func (e *Elector) setLeader(leader bool, until time.Time) {
+	e.Lock()
+	e.leader, e.until = leader, until
+	if leader {
+		e.expires = until.Add(e.renewInterval())
+	}
+	e.Unlock()
+	if leader {
+		e.statLeader.Set(1)
+	} else {
+		e.statLeader.Set(0)
+	}
+}
+
+// dropLease records that the replica no longer holds the lease
+func (e *Elector) dropLease() {
+	e.Lock()
+	e.expires = time.Time{}
+	e.Unlock()
+}
+
+// term is the time a replica leads the others
+type term struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// ends the term once the leader could not renew the lease in time
+	expiry *time.Timer
+	done   chan struct{}
+}
+
+func (t *term) stop() {
+	t.expiry.Stop()
+	t.cancel()
+	<-t.done
+}
+
+// Run campaigns for the lease until ctx is done, and then releases it if held. lead is called
+// each time the replica is elected, with a context that is cancelled once it is no longer the
+// leader. Run waits for lead to return before campaigning again. What lead still does after
+// its context is cancelled should be fenced with Holds.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	glog.Infof("Election: Campaigning for leadership as %s, lease %v", e.id, e.lease)
+	t := time.NewTicker(e.renewInterval())
+	defer t.Stop()
+	var current *term
+	for {
+		held, until, err := e.acquire()
+		if err != nil {
+			glog.Errorf("Election: Unable to acquire lease: %v", err)
+			e.statError.Add(1)
+		}
+		if current != nil && (current.ctx.Err() != nil || (err == nil && !held)) {
+			glog.Infof("Election: %s is no longer the leader", e.id)
+			e.setLeader(false, time.Time{})
+			if err == nil && !held {
+				// another replica took over
+				e.dropLease()
+			}
+			current.stop()
+			current = nil
+		}
+		if held {
+			e.setLeader(true, until)
+			if current == nil {
+				glog.Infof("Election: %s is now the leader", e.id)
+				current = &term{done: make(chan struct{})}
+				current.ctx, current.cancel = context.WithCancel(ctx)
+				current.expiry = time.AfterFunc(time.Until(until), current.cancel)
+				go func(t *term) {
+					defer close(t.done)
+					lead(t.ctx)
+				}(current)
+			} else {
+				current.expiry.Reset(time.Until(until))
+			}
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			if current != nil {
+				e.setLeader(false, time.Time{})
+				current.stop()
+				e.release()
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockDb holds the lease table shared by the replicas
+type mockDb struct {
+	lease *models.Lease
+	// offset of the db clock
+	offset time.Duration
+	// overrides the lease time, which is in seconds in the db
+	leaseTime time.Duration
+	// replicas that cannot reach the db
+	down map[string]bool
+	sync.Mutex
+}
+
+func (m *mockDb) NewTx() models.Txn {
+	return &mockTx{db: m}
+}
+
+func (m *mockDb) Close() error {
+	return nil
+}
+
+type mockTx struct {
+	*models.Tx
+	db *mockDb
+}
+
+func (tx *mockTx) Exec(query string, args ...interface{}) error {
+	m := tx.db
+	m.Lock()
+	defer m.Unlock()
+	holder := args[1].(string)
+	if m.down[holder] {
+		return fmt.Errorf("connection refused")
+	}
+	now := time.Now().Add(m.offset)
+	switch query {
+	case models.QueryAcquireLease:
+		expires := now.Add(time.Duration(args[2].(int64)) * time.Second)
+		if m.leaseTime != 0 {
+			expires = now.Add(m.leaseTime)
+		}
+		switch {
+		case m.lease == nil || !now.Before(m.lease.ExpiresAt.Time):
+			m.lease = &models.Lease{Name: args[0].(string), Holder: holder, AcquiredAt: models.MyTime{now}, ExpiresAt: models.MyTime{expires}}
+		case m.lease.Holder == holder:
+			m.lease.ExpiresAt = models.MyTime{expires}
+		}
+	case models.QueryReleaseLease:
+		if m.lease != nil && m.lease.Holder == holder {
+			m.lease = nil
+		}
+	}
+	return nil
+}
+
+func (tx *mockTx) SelectLeases(query string, args ...interface{}) (models.Leases, error) {
+	m := tx.db
+	m.Lock()
+	defer m.Unlock()
+	if m.lease == nil {
+		return nil, nil
+	}
+	lease := *m.lease
+	return models.Leases{&lease}, nil
+}
+
+func (tx *mockTx) Commit() error {
+	return nil
+}
+
+func (tx *mockTx) Rollback() error {
+	return nil
+}
+
+func newElector(db *mockDb, id string, lease time.Duration) *Elector {
+	return &Elector{db: db, id: id, lease: lease, statLeader: &tu.MockStat{}, statError: &tu.MockStat{}}
+}
+
+func TestAcquire(t *testing.T) {
+	db := &mockDb{}
+	a := newElector(db, "a", 15*time.Second)
+	b := newElector(db, "b", 15*time.Second)
+
+	held, until, err := a.acquire()
+	assert.Nil(t, err)
+	assert.True(t, held)
+	// the leader steps down a renewal interval before the lease expires
+	assert.True(t, until.Before(db.lease.ExpiresAt.Time.Add(-4*time.Second)))
+	held, _, _ = b.acquire()
+	assert.False(t, held)
+	acquired := db.lease.AcquiredAt
+
+	// renewing keeps the lease
+	held, _, _ = a.acquire()
+	assert.True(t, held)
+	assert.Equal(t, db.lease.AcquiredAt, acquired)
+
+	// an expired lease is taken over
+	db.offset = 16 * time.Second
+	held, _, _ = b.acquire()
+	assert.True(t, held)
+	held, _, _ = a.acquire()
+	assert.False(t, held)
+	lease, err := a.Lease(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, lease.Holder, "b")
+
+	// a released lease is taken over right away
+	db.offset = 0
+	b.release()
+	lease, _ = a.Lease(context.Background())
+	assert.Nil(t, lease)
+	held, _, _ = a.acquire()
+	assert.True(t, held)
+}
+
+func TestNew(t *testing.T) {
+	assert.Equal(t, New(&mockDb{}, "a", 0).lease, DEFAULT_LEASE)
+	assert.Equal(t, New(&mockDb{}, "a", time.Second).lease, MIN_LEASE)
+}
+
+func TestRun(t *testing.T) {
+	// shorter than the minimum lease time to keep the test quick
+	db := &mockDb{down: make(map[string]bool), leaseTime: 300 * time.Millisecond}
+	a := newElector(db, "a", 300*time.Millisecond)
+	b := newElector(db, "b", 300*time.Millisecond)
+
+	type event struct {
+		id      string
+		leading bool
+	}
+	events := make(chan event, 10)
+	// whether the lease is still held once the term ends
+	holds := make(chan bool, 10)
+	lead := func(id string, e *Elector) func(ctx context.Context) {
+		return func(ctx context.Context) {
+			events <- event{id, true}
+			<-ctx.Done()
+			holds <- e.Holds()
+			events <- event{id, false}
+		}
+	}
+	ctxA, stopA := context.WithCancel(context.Background())
+	doneA := make(chan struct{})
+	go func() {
+		a.Run(ctxA, lead("a", a))
+		close(doneA)
+	}()
+	assert.Equal(t, <-events, event{"a", true})
+	assert.True(t, a.IsLeader())
+
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+	go b.Run(ctxB, lead("b", b))
+	time.Sleep(250 * time.Millisecond)
+	assert.False(t, b.IsLeader())
+	assert.Equal(t, len(events), 0)
+
+	// a leader that cannot renew its lease steps down before it expires, then b takes over
+	db.Lock()
+	db.down["a"] = true
+	db.Unlock()
+	assert.Equal(t, <-events, event{"a", false})
+	assert.False(t, a.IsLeader())
+	assert.True(t, <-holds)
+	assert.Equal(t, <-events, event{"b", true})
+	assert.True(t, b.IsLeader())
+	assert.False(t, a.Holds())
+	assert.True(t, b.Holds())
+
+	// a follows again once it is back
+	db.Lock()
+	db.down["a"] = false
+	db.Unlock()
+	time.Sleep(250 * time.Millisecond)
+	assert.False(t, a.IsLeader())
+	stopA()
+	<-doneA
+	assert.Equal(t, db.lease.Holder, "b")
+	assert.False(t, a.Holds())
+}
@@ -0,0 +1,303 @@
+// Package ical parses the events of an iCalendar ( RFC 5545 ) feed.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Property is a content line of a component
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Event is a VEVENT. Properties of nested components such as VALARM are not included.
+type Event struct {
+	Props map[string]*Property
+}
+
+// Calendar holds the events of a VCALENDAR
+type Calendar struct {
+	Events []*Event
+	// time zone of floating times, from X-WR-TIMEZONE. UTC if not set.
+	Location *time.Location
+}
+
+// Parse reads a calendar. Only the first VCALENDAR of the stream is read.
+func Parse(r io.Reader) (*Calendar, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	cal := &Calendar{Location: time.UTC}
+	var (
+		event   *Event
+		nested  []string
+		started bool
+	)
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		prop, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		value := strings.ToUpper(prop.Value)
+		switch {
+		case prop.Name == "BEGIN" && !started:
+			if value != "VCALENDAR" {
+				return nil, fmt.Errorf("line %d: expected BEGIN:VCALENDAR", i+1)
+			}
+			started = true
+		case !started:
+			return nil, fmt.Errorf("line %d: expected BEGIN:VCALENDAR", i+1)
+		case prop.Name == "BEGIN":
+			if value == "VEVENT" && event == nil && len(nested) == 0 {
+				event = &Event{Props: make(map[string]*Property)}
+			} else {
+				nested = append(nested, value)
+			}
+		case prop.Name == "END":
+			switch {
+			case len(nested) > 0:
+				if nested[len(nested)-1] != value {
+					return nil, fmt.Errorf("line %d: unexpected END:%s", i+1, prop.Value)
+				}
+				nested = nested[:len(nested)-1]
+			case value == "VEVENT" && event != nil:
+				cal.Events = append(cal.Events, event)
+				event = nil
+			case value == "VCALENDAR" && event == nil:
+				return cal, nil
+			default:
+				return nil, fmt.Errorf("line %d: unexpected END:%s", i+1, prop.Value)
+			}
+		case len(nested) > 0:
+		case event != nil:
+			// the first occurrence of a property is kept
+			if _, ok := event.Props[prop.Name]; !ok {
+				event.Props[prop.Name] = prop
+			}
+		case prop.Name == "X-WR-TIMEZONE":
+			loc, err := time.LoadLocation(prop.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid calendar time zone %s: %v", prop.Value, err)
+			}
+			cal.Location = loc
+		}
+	}
+	return nil, fmt.Errorf("Missing END:VCALENDAR")
+}
+
+// unfold joins folded content lines
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseLine parses name *(";" param) ":" value. Parameter values may be quoted.
+func parseLine(line string) (*Property, error) {
+	prop := &Property{Params: make(map[string]string)}
+	var (
+		quoted bool
+		part   int
+	)
+	parts := []string{}
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == ';':
+			parts = append(parts, line[part:i])
+			part = i + 1
+		case c == ':':
+			parts = append(parts, line[part:i])
+			prop.Value = line[i+1:]
+			prop.Name = strings.ToUpper(parts[0])
+			if prop.Name == "" {
+				return nil, fmt.Errorf("missing property name")
+			}
+			for _, p := range parts[1:] {
+				kv := strings.SplitN(p, "=", 2)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("invalid parameter %s", p)
+				}
+				prop.Params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+			}
+			return prop, nil
+		}
+	}
+	return nil, fmt.Errorf("missing ':' in %q", line)
+}
+
+// Get returns the unescaped text value of a property, empty if the event does not have it
+func (e *Event) Get(name string) string {
+	prop, ok := e.Props[strings.ToUpper(name)]
+	if !ok {
+		return ""
+	}
+	return unescape(prop.Value)
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func (e *Event) UID() string { return e.Get("UID") }
+
+// Recurring reports whether the event has recurrences or overrides one
+func (e *Event) Recurring() bool {
+	for _, name := range []string{"RRULE", "RDATE", "RECURRENCE-ID"} {
+		if _, ok := e.Props[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Event) Cancelled() bool {
+	return strings.EqualFold(e.Get("STATUS"), "CANCELLED")
+}
+
+// Start returns DTSTART. Floating times are in loc.
+func (e *Event) Start(loc *time.Location) (time.Time, error) {
+	prop, ok := e.Props["DTSTART"]
+	if !ok {
+		return time.Time{}, fmt.Errorf("Event %s has no DTSTART", e.UID())
+	}
+	return parseTime(prop, loc)
+}
+
+// End returns DTEND, or DTSTART plus DURATION. An event starting on a date without either
+// lasts the day.
+func (e *Event) End(loc *time.Location) (time.Time, error) {
+	start, err := e.Start(loc)
+	if err != nil {
+		return start, err
+	}
+	if prop, ok := e.Props["DTEND"]; ok {
+		end, err := parseTime(prop, loc)
+		if err != nil {
+			return end, err
+		}
+		if end.Before(start) {
+			return end, fmt.Errorf("Event %s ends before it starts", e.UID())
+		}
+		return end, nil
+	}
+	if prop, ok := e.Props["DURATION"]; ok {
+		d, err := ParseDuration(prop.Value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return start.Add(d), nil
+	}
+	if strings.EqualFold(e.Props["DTSTART"].Params["VALUE"], "DATE") {
+		return start.AddDate(0, 0, 1), nil
+	}
+	return start, nil
+}
+
+func parseTime(prop *Property, loc *time.Location) (time.Time, error) {
+	if tzid, ok := prop.Params["TZID"]; ok {
+		var err error
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, fmt.Errorf("Invalid TZID %s: %v", tzid, err)
+		}
+	}
+	return ParseTime(prop.Value, loc)
+}
+
+// ParseTime parses a date, a UTC time or a floating time in loc, e.g. the UNTIL of an RRULE
+func ParseTime(value string, loc *time.Location) (time.Time, error) {
+	switch {
+	case len(value) == 8:
+		return time.ParseInLocation("20060102", value, loc)
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+// ParseDuration parses a duration value such as PT1H30M or P1D
+func ParseDuration(s string) (time.Duration, error) {
+	orig := s
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign = -1
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, fmt.Errorf("Invalid duration %s", orig)
+	}
+	var (
+		d      time.Duration
+		inTime bool
+		num    string
+	)
+	for _, c := range s[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			num += string(c)
+			continue
+		case c == 'T' && !inTime && num == "":
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid duration %s", orig)
+		}
+		num = ""
+		unit := map[bool]map[rune]time.Duration{
+			false: {'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour},
+			true:  {'H': time.Hour, 'M': time.Minute, 'S': time.Second},
+		}[inTime][c]
+		if unit == 0 {
+			return 0, fmt.Errorf("Invalid duration %s", orig)
+		}
+		d += time.Duration(n) * unit
+	}
+	if num != "" {
+		return 0, fmt.Errorf("Invalid duration %s", orig)
+	}
+	return sign * d, nil
+}
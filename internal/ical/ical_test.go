@@ -0,0 +1,123 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testCal = strings.Join([]string{
+	"BEGIN:VCALENDAR",
+	"VERSION:2.0",
+	"PRODID:-//change mgmt//EN",
+	"X-WR-TIMEZONE:Europe/Berlin",
+	"BEGIN:VEVENT",
+	"UID:chg-1@example.com",
+	"SUMMARY:Core router upgrade\\, phase 1",
+	"DTSTART:20240305T020000Z",
+	"DTEND:20240305T040000Z",
+	"LOCATION:rtr1.dc1",
+	"X-DEVICES:rtr1.dc1,",
+	" rtr2.dc1",
+	"BEGIN:VALARM",
+	"DESCRIPTION:reminder",
+	"TRIGGER:-PT15M",
+	"END:VALARM",
+	"DESCRIPTION:line one\\nline two",
+	"END:VEVENT",
+	"BEGIN:VEVENT",
+	"UID:chg-2@example.com",
+	`DTSTART;TZID="America/New_York":20240306T220000`,
+	"DURATION:PT1H30M",
+	"STATUS:CANCELLED",
+	"END:VEVENT",
+	"BEGIN:VEVENT",
+	"UID:chg-3@example.com",
+	"DTSTART;VALUE=DATE:20240307",
+	"RRULE:FREQ=WEEKLY",
+	"END:VEVENT",
+	"BEGIN:VEVENT",
+	"UID:chg-4@example.com",
+	"DTSTART:20240308T100000",
+	"END:VEVENT",
+	"END:VCALENDAR",
+}, "\r\n")
+
+func TestParse(t *testing.T) {
+	cal, err := Parse(strings.NewReader(testCal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(cal.Events), 4)
+	assert.Equal(t, cal.Location.String(), "Europe/Berlin")
+
+	e := cal.Events[0]
+	assert.Equal(t, e.UID(), "chg-1@example.com")
+	assert.Equal(t, e.Get("summary"), "Core router upgrade, phase 1")
+	assert.Equal(t, e.Get("LOCATION"), "rtr1.dc1")
+	assert.Equal(t, e.Get("X-DEVICES"), "rtr1.dc1,rtr2.dc1")
+	// not from the alarm
+	assert.Equal(t, e.Get("DESCRIPTION"), "line one\nline two")
+	assert.Equal(t, e.Get("TRIGGER"), "")
+	start, _ := e.Start(cal.Location)
+	end, _ := e.End(cal.Location)
+	assert.True(t, start.Equal(time.Date(2024, 3, 5, 2, 0, 0, 0, time.UTC)))
+	assert.True(t, end.Equal(time.Date(2024, 3, 5, 4, 0, 0, 0, time.UTC)))
+	assert.False(t, e.Recurring() || e.Cancelled())
+
+	e = cal.Events[1]
+	assert.True(t, e.Cancelled())
+	start, _ = e.Start(cal.Location)
+	end, _ = e.End(cal.Location)
+	assert.True(t, start.Equal(time.Date(2024, 3, 7, 3, 0, 0, 0, time.UTC)))
+	assert.Equal(t, end.Sub(start), 90*time.Minute)
+
+	// all day
+	e = cal.Events[2]
+	assert.True(t, e.Recurring())
+	start, _ = e.Start(cal.Location)
+	end, _ = e.End(cal.Location)
+	assert.Equal(t, end.Sub(start), 24*time.Hour)
+
+	// floating time in the calendar zone
+	start, _ = cal.Events[3].Start(cal.Location)
+	assert.True(t, start.Equal(time.Date(2024, 3, 8, 9, 0, 0, 0, time.UTC)))
+
+	until, _ := ParseTime("20240310", cal.Location)
+	assert.True(t, until.Equal(time.Date(2024, 3, 9, 23, 0, 0, 0, time.UTC)))
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"BEGIN:VEVENT\nEND:VEVENT",
+		"BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:1\n",
+		"BEGIN:VCALENDAR\nBEGIN:VEVENT\nEND:VALARM\nEND:VCALENDAR",
+		"BEGIN:VCALENDAR\nno colon\nEND:VCALENDAR",
+	} {
+		_, err := Parse(strings.NewReader(s))
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"PT1H30M":  90 * time.Minute,
+		"P1D":      24 * time.Hour,
+		"P1W":      7 * 24 * time.Hour,
+		"P1DT2H":   26 * time.Hour,
+		"-PT15M":   -15 * time.Minute,
+		"PT0S":     0,
+		"+PT1M30S": 90 * time.Second,
+	} {
+		d, err := ParseDuration(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, d, want, s)
+	}
+	for _, s := range []string{"", "P", "1H", "PT1D", "P1H", "PT1", "PTT1H"} {
+		_, err := ParseDuration(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -12,11 +12,12 @@ var (
 	QueryInsertAlert = `INSERT INTO
     alerts (
       name, description, entity, external_id, source, device, site, owner, team, tags, start_time, last_active,
-      agg_id, auto_expire, auto_clear, expire_after, severity, status, labels, scope, is_aggregate
+      agg_id, auto_expire, auto_clear, expire_after, severity, status, labels, scope, is_aggregate, fingerprint,
+      occurrences, suppressed_by, ended_at, inhibited_by
     ) VALUES (
       :name, :description, :entity, :external_id, :source, :device, :site, :owner, :team, :tags,
       :start_time, :last_active, :agg_id, :auto_expire, :auto_clear, :expire_after,
-      :severity, :status, :labels, :scope, :is_aggregate
+      :severity, :status, :labels, :scope, :is_aggregate, :fingerprint, :occurrences, :suppressed_by, :ended_at, :inhibited_by
     ) RETURNING id`
 
 	QueryUpdateAlertById = `UPDATE alerts SET
@@ -24,25 +25,39 @@ var (
     device=:device, site=:site, owner=:owner, team=:team, tags=:tags, start_time=:start_time,
     last_active=:last_active, agg_id=:agg_id, auto_expire=:auto_expire, auto_clear=:auto_clear,
     expire_after=:expire_after, severity=:severity, status=:status, labels=:labels, scope=:scope,
-    is_aggregate=:is_aggregate
+    is_aggregate=:is_aggregate, fingerprint=:fingerprint, occurrences=:occurrences,
+    suppressed_by=:suppressed_by, ended_at=:ended_at, inhibited_by=:inhibited_by
       WHERE id=:id`
 
-	queryUpdateAlerts     = "UPDATE alerts"
-	QueryUpdateLastActive = queryUpdateAlerts + " SET last_active=? WHERE id IN (?)"
-	QueryUpdateAggId      = queryUpdateAlerts + " SET agg_id=? WHERE id IN (?)"
-	QueryUpdateStatus     = queryUpdateAlerts + " SET status=$1 WHERE id=$2 OR id IN (SELECT id from alerts WHERE agg_id=$2)"
-	QueryUpdateManyStatus = queryUpdateAlerts + " SET status=? WHERE id in (?)"
-
-	querySelectAlerts       = "SELECT * from alerts"
-	QuerySelectByNames      = querySelectAlerts + " WHERE name IN (?) AND status=1 AND agg_id=0 FOR UPDATE"
-	QuerySelectById         = querySelectAlerts + " WHERE id=$1 FOR UPDATE"
-	QuerySelectByIds        = querySelectAlerts + " WHERE id IN (?) ORDER BY id FOR UPDATE"
-	QuerySelectByStatus     = querySelectAlerts + " WHERE status IN (?) ORDER BY id FOR UPDATE"
-	QuerySelectNoOwner      = querySelectAlerts + " WHERE owner is NULL AND status=1 ORDER BY id FOR UPDATE"
-	QuerySelectByNameEntity = querySelectAlerts + " WHERE name=$1 AND entity=$2 AND status=1 FOR UPDATE"
-	QuerySelectByDevice     = querySelectAlerts + " WHERE name=$1 AND entity=$2 AND device=$3 AND status=1 FOR UPDATE"
-	QuerySelectExpired      = querySelectAlerts + ` WHERE
-    status=1 AND auto_expire AND (cast(extract(epoch from now()) as integer) - last_active) > expire_after ORDER BY id FOR UPDATE`
+	queryUpdateAlerts      = "UPDATE alerts"
+	QueryUpdateLastActive  = queryUpdateAlerts + " SET last_active=? WHERE id IN (?)"
+	QueryUpdateAggId       = queryUpdateAlerts + " SET agg_id=? WHERE id IN (?)"
+	QueryUpdateEnded       = queryUpdateAlerts + " SET status=$1, ended_at=$2 WHERE id=$3 OR id IN (SELECT id from alerts WHERE agg_id=$3)"
+	QueryUpdateManyStatus  = queryUpdateAlerts + " SET status=? WHERE id in (?)"
+	QuerySuppressMany      = queryUpdateAlerts + " SET status=?, suppressed_by=? WHERE id in (?)"
+	QueryUpdateFingerprint = queryUpdateAlerts + " SET fingerprint=$1 WHERE id=$2"
+
+	querySelectAlerts        = "SELECT * from alerts"
+	QuerySelectByNames       = querySelectAlerts + " WHERE name IN (?) AND status=1 AND agg_id=0 FOR UPDATE"
+	QuerySelectById          = querySelectAlerts + " WHERE id=$1 FOR UPDATE"
+	QuerySelectByIds         = querySelectAlerts + " WHERE id IN (?) ORDER BY id FOR UPDATE"
+	QuerySelectByStatus      = querySelectAlerts + " WHERE status IN (?) ORDER BY id FOR UPDATE"
+	QuerySelectByFingerprint = querySelectAlerts + " WHERE fingerprint=$1 AND status=1 FOR UPDATE"
+	// active alerts created before fingerprints, identified by Name:Entity:Device
+	QuerySelectUnfingerprinted         = querySelectAlerts + " WHERE fingerprint='' AND name=$1 AND entity=$2 AND status=1 FOR UPDATE"
+	QuerySelectUnfingerprintedByDevice = querySelectAlerts + " WHERE fingerprint='' AND name=$1 AND entity=$2 AND device=$3 AND status=1 FOR UPDATE"
+	// alerts suppressed by a suppression rule or maintenance window
+	QuerySelectAllSuppressed = querySelectAlerts + " WHERE status=2 AND suppressed_by != 0 ORDER BY id FOR UPDATE"
+	QuerySelectSuppressedBy  = querySelectAlerts + " WHERE suppressed_by=$1 AND status=2 ORDER BY id FOR UPDATE"
+	// alerts suppressed by a rule or window, or inhibited by a root cause alert
+	QuerySelectSuppressedByFingerprint = querySelectAlerts + " WHERE fingerprint=$1 AND status=2 AND (suppressed_by != 0 OR inhibited_by != 0) FOR UPDATE"
+	QuerySelectByAggId                 = querySelectAlerts + " WHERE agg_id=$1 ORDER BY id FOR UPDATE"
+	// alerts inhibited by a root cause alert that is no longer active or suppressed
+	QuerySelectRootEnded = querySelectAlerts + ` WHERE status=2 AND inhibited_by != 0 AND inhibited_by NOT IN (
+    SELECT id FROM alerts WHERE status IN (1, 2)
+  ) ORDER BY id FOR UPDATE`
+	// the last alert with a fingerprint that cleared or expired after a given time
+	QuerySelectReopenable    = querySelectAlerts + " WHERE fingerprint=$1 AND status IN (3, 4) AND NOT is_aggregate AND ended_at >= $2 ORDER BY id DESC LIMIT 1 FOR UPDATE"
 	QuerySelectAllAggregated = querySelectAlerts + " WHERE agg_id IN (SELECT id from alerts WHERE is_aggregate AND status = 1)"
 	QuerySelectSuppressed    = querySelectAlerts + ` WHERE status=2 AND id IN (
     select (entities->>'alert_id')::int from suppression_rules where rtype = 1 AND
@@ -111,7 +126,12 @@ type Alert struct {
 	ExpireAfter  sql.NullInt64 `db:"expire_after"`
 	Severity     AlertSeverity
 	Status       AlertStatus
-	Labels       Labels // json encoded k-v labels
+	Labels       Labels        // json encoded k-v labels
+	Fingerprint  string        // hash of the fields and labels identifying the alert
+	Occurrences  int           // number of times the alert fired, including reopens
+	SuppressedBy int64         `db:"suppressed_by"` // suppression rule or maintenance window suppressing the alert
+	EndedAt      sql.NullInt64 `db:"ended_at"`      // unix time the alert last cleared or expired
+	InhibitedBy  int64         `db:"inhibited_by"`  // root cause alert inhibiting the alert
 	History      []*Record
 }
 
@@ -129,6 +149,8 @@ func (a Alert) MarshalJSON() ([]byte, error) {
 		IsAggregate                              bool  `json:"is_aggregate"`
 		Severity                                 string
 		Status                                   string
+		Fingerprint                              string
+		Occurrences                              int
 		History                                  []struct {
 			Timestamp int64
 			Event     string
@@ -152,6 +174,8 @@ func (a Alert) MarshalJSON() ([]byte, error) {
 		IsAggregate:  a.IsAggregate,
 		Severity:     a.Severity.String(),
 		Status:       a.Status.String(),
+		Fingerprint:  a.Fingerprint,
+		Occurrences:  a.Occurrences,
 	}
 	for _, h := range a.History {
 		tmp.History = append(tmp.History, struct {
@@ -184,6 +208,7 @@ func NewAlert(name, description, entity, source, scope, team string, extId strin
 		AutoExpire:  false,
 		IsAggregate: isAgg,
 		Labels:      make(Labels),
+		Occurrences: 1,
 	}
 }
 
@@ -244,6 +269,13 @@ func (a *Alert) SetSeverity(sev AlertSeverity) {
 func (a *Alert) Clear() {
 	glog.V(2).Infof("Clearing out alert %d", a.Id)
 	a.Status = Status_CLEARED
+	a.EndedAt = sql.NullInt64{time.Now().Unix(), true}
+}
+
+func (a *Alert) Expire() {
+	glog.V(2).Infof("Expiring alert %d", a.Id)
+	a.Status = Status_EXPIRED
+	a.EndedAt = sql.NullInt64{time.Now().Unix(), true}
 }
 
 func (a *Alert) ExtendLabels() {
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+var (
+	QueryInsertPendingClear = `INSERT INTO pending_clears (alert_id, deadline, created_at)
+    VALUES ($1, $2, $3) ON CONFLICT (alert_id) DO NOTHING`
+	QueryDeletePendingClear  = "DELETE FROM pending_clears WHERE alert_id=$1"
+	QuerySelectPendingClears = `SELECT pending_clears.*, alerts.name, alerts.entity, alerts.device
+    FROM pending_clears JOIN alerts ON alerts.id = pending_clears.alert_id ORDER BY deadline`
+)
+
+// PendingClear is a clear received for an active alert that is waiting out the clear holddown
+type PendingClear struct {
+	AlertId   int64 `db:"alert_id"`
+	Deadline  MyTime
+	CreatedAt MyTime `db:"created_at"`
+	// from the alert
+	Name   string
+	Entity string
+	Device sql.NullString
+}
+
+func (p *PendingClear) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		AlertId   int64  `json:"alert_id"`
+		Name      string `json:"name"`
+		Entity    string `json:"entity"`
+		Device    string `json:"device,omitempty"`
+		Deadline  int64  `json:"deadline"`
+		CreatedAt int64  `json:"created_at"`
+		// seconds until the alert clears
+		TimeLeft int64 `json:"time_left"`
+	}{
+		AlertId:   p.AlertId,
+		Name:      p.Name,
+		Entity:    p.Entity,
+		Device:    p.Device.String,
+		Deadline:  p.Deadline.Unix(),
+		CreatedAt: p.CreatedAt.Unix(),
+		TimeLeft:  int64(time.Until(p.Deadline.Time).Seconds()),
+	})
+}
+
+type PendingClears []*PendingClear
+
+func (tx *Tx) SelectPendingClears(query string, args ...interface{}) (PendingClears, error) {
+	var clears PendingClears
+	err := tx.Select(&clears, query, args...)
+	return clears, err
+}
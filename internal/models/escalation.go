@@ -0,0 +1,38 @@
+package models
+
+var (
+	// starting a policy again, e.g. for a reopened alert, runs it from the first step
+	QueryInsertEscalation = `INSERT INTO alert_escalations (alert_id, policy, step, state, started_at, updated_at)
+    VALUES ($1, $2, 0, $3, $4, $4) ON CONFLICT (alert_id) DO UPDATE SET
+    policy=$2, step=0, state=$3, started_at=$4, updated_at=$4`
+	QueryUpdateEscalation = "UPDATE alert_escalations SET step=$2, state=$3, updated_at=$4 WHERE alert_id=$1"
+	QueryStopEscalation   = `UPDATE alert_escalations SET state=$2, updated_at=$3
+    WHERE alert_id=$1 AND state='running'`
+	QuerySelectEscalation         = "SELECT * FROM alert_escalations WHERE alert_id=$1"
+	QuerySelectRunningEscalations = "SELECT * FROM alert_escalations WHERE state='running'"
+)
+
+const (
+	EscalationRunning   = "running"
+	EscalationCompleted = "completed"
+	EscalationStopped   = "stopped"
+)
+
+// Escalation tracks the escalation policy of an alert. The steps run at their delay after
+// StartedAt, Step is the next step to run.
+type Escalation struct {
+	AlertId   int64 `db:"alert_id"`
+	Policy    string
+	Step      int
+	State     string
+	StartedAt MyTime `db:"started_at"`
+	UpdatedAt MyTime `db:"updated_at"`
+}
+
+type Escalations []*Escalation
+
+func (tx *Tx) SelectEscalations(query string, args ...interface{}) (Escalations, error) {
+	var escalations Escalations
+	err := tx.Select(&escalations, query, args...)
+	return escalations, err
+}
@@ -3,21 +3,29 @@ package models
 type EventType int
 
 const (
-	EventType_ACTIVE     EventType = 1
-	EventType_EXPIRED    EventType = 2
-	EventType_SUPPRESSED EventType = 3
-	EventType_CLEARED    EventType = 4
-	EventType_ACKD       EventType = 5
-	EventType_ESCALATED  EventType = 6
+	EventType_ACTIVE       EventType = 1
+	EventType_EXPIRED      EventType = 2
+	EventType_SUPPRESSED   EventType = 3
+	EventType_CLEARED      EventType = 4
+	EventType_ACKD         EventType = 5
+	EventType_ESCALATED    EventType = 6
+	EventType_FLAPPING     EventType = 7
+	EventType_FLAP_ENDED   EventType = 8
+	EventType_UPDATED      EventType = 9
+	EventType_UNSUPPRESSED EventType = 10
 )
 
 var EventMap = map[string]EventType{
-	"ACTIVE":     EventType_ACTIVE,
-	"EXPIRED":    EventType_EXPIRED,
-	"SUPPRESSED": EventType_SUPPRESSED,
-	"CLEARED":    EventType_CLEARED,
-	"ACKD":       EventType_ACKD,
-	"ESCALATED":  EventType_ESCALATED,
+	"ACTIVE":       EventType_ACTIVE,
+	"EXPIRED":      EventType_EXPIRED,
+	"SUPPRESSED":   EventType_SUPPRESSED,
+	"CLEARED":      EventType_CLEARED,
+	"ACKD":         EventType_ACKD,
+	"ESCALATED":    EventType_ESCALATED,
+	"FLAPPING":     EventType_FLAPPING,
+	"FLAP_ENDED":   EventType_FLAP_ENDED,
+	"UPDATED":      EventType_UPDATED,
+	"UNSUPPRESSED": EventType_UNSUPPRESSED,
 }
 
 func (e EventType) String() string {
@@ -33,4 +41,8 @@ func (e EventType) String() string {
 type AlertEvent struct {
 	Alert *Alert
 	Type  EventType
+	// who is on call for the alert, set by the notifier when the on-call is paged
+	OnCall []*OnCall
+	// the outputs a step of an escalation policy notifies, instead of those of the alert
+	Outputs []string
 }
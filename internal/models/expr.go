@@ -0,0 +1,506 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MatchExpr is a parsed match expression over alert labels, e.g.
+//
+//	alert_name = "Device Down" and device !~ ^lab- and (site in (dc1, dc2) or Priority < 3)
+//
+// Conditions are:
+//
+//	label = value, label != value       equality, numeric if both sides are numbers
+//	label =~ regex, label !~ regex      unanchored regex match
+//	label < n, <=, >, >=                numeric comparison
+//	label in (v1, v2), label not in (..) set membership
+//	exists(label)                       the label is set
+//
+// combined with and, or, not and parentheses ( also &&, || and ! ). A condition on a label
+// that is not set is false, and its negation ( !=, !~, not in ) is true. Values containing
+// spaces, commas or parentheses have to be quoted.
+type MatchExpr struct {
+	src  string
+	root exprNode
+}
+
+type exprNode interface {
+	eval(labels Labels) bool
+}
+
+type andNode []exprNode
+
+func (n andNode) eval(labels Labels) bool {
+	for _, c := range n {
+		if !c.eval(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []exprNode
+
+func (n orNode) eval(labels Labels) bool {
+	for _, c := range n {
+		if c.eval(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+type notNode struct{ exprNode }
+
+func (n notNode) eval(labels Labels) bool { return !n.exprNode.eval(labels) }
+
+type existsNode string
+
+func (n existsNode) eval(labels Labels) bool {
+	_, ok := labels[string(n)]
+	return ok
+}
+
+type condNode struct {
+	label, op string
+	values    []string
+	re        *regexp.Regexp
+	num       float64
+}
+
+func (n *condNode) eval(labels Labels) bool {
+	lv, ok := labels[n.label]
+	switch n.op {
+	case "=":
+		return ok && valueEqual(lv, n.values[0])
+	case "!=":
+		return !ok || !valueEqual(lv, n.values[0])
+	case "=~":
+		return ok && n.re.MatchString(labelString(lv))
+	case "!~":
+		return !ok || !n.re.MatchString(labelString(lv))
+	case "in", "not in":
+		var in bool
+		for _, v := range n.values {
+			if ok && valueEqual(lv, v) {
+				in = true
+				break
+			}
+		}
+		return in == (n.op == "in")
+	}
+	if !ok {
+		return false
+	}
+	num, ok := labelNumber(lv)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return num < n.num
+	case "<=":
+		return num <= n.num
+	case ">":
+		return num > n.num
+	}
+	return num >= n.num
+}
+
+func labelString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func labelNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	f, err := strconv.ParseFloat(labelString(v), 64)
+	return f, err == nil
+}
+
+func valueEqual(lv interface{}, value string) bool {
+	if n, ok := labelNumber(lv); ok {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return n == f
+		}
+	}
+	return labelString(lv) == value
+}
+
+// MatchValue matches a label value against a rule value, a regex if both are strings
+func MatchValue(want, have interface{}) bool {
+	ws, wok := want.(string)
+	hs, hok := have.(string)
+	if wok && hok {
+		match, _ := regexp.MatchString(ws, hs)
+		return match
+	}
+	return labelString(want) == labelString(have)
+}
+
+// ParseMatchExpr parses a match expression
+func ParseMatchExpr(s string) (*MatchExpr, error) {
+	p := &exprParser{s: s}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.pos:])
+	}
+	return &MatchExpr{src: s, root: root}, nil
+}
+
+// Match reports whether the labels match the expression
+func (e *MatchExpr) Match(labels Labels) bool {
+	return e.root.eval(labels)
+}
+
+func (e *MatchExpr) String() string {
+	return e.src
+}
+
+type exprParser struct {
+	s   string
+	pos int
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// keyword consumes a case insensitive keyword or one of the symbols
+func (p *exprParser) keyword(word string, symbols ...string) bool {
+	p.skipSpace()
+	rest := p.s[p.pos:]
+	for _, sym := range symbols {
+		if strings.HasPrefix(rest, sym) {
+			p.pos += len(sym)
+			return true
+		}
+	}
+	if word != "" && len(rest) >= len(word) && strings.EqualFold(rest[:len(word)], word) &&
+		(len(rest) == len(word) || !isIdentChar(rest[len(word)])) {
+		p.pos += len(word)
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	var nodes orNode
+	for {
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+		if !p.keyword("or", "||") {
+			break
+		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	var nodes andNode
+	for {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+		if !p.keyword("and", "&&") {
+			break
+		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	p.skipSpace()
+	if p.keyword("not", "!") {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	if p.keyword("", "(") {
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("", ")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return n, nil
+	}
+	start := p.pos
+	if p.keyword("exists") {
+		if p.keyword("", "(") {
+			label := p.ident()
+			if label == "" {
+				return nil, p.errorf("expected a label name")
+			}
+			if !p.keyword("", ")") {
+				return nil, p.errorf("expected ')'")
+			}
+			return existsNode(label), nil
+		}
+		// a label named exists
+		p.pos = start
+	}
+	return p.parseCond()
+}
+
+func (p *exprParser) ident() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && isIdentChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+var exprOps = []string{"=~", "!~", "!=", "<=", ">=", "==", "=", "<", ">"}
+
+func (p *exprParser) parseCond() (exprNode, error) {
+	label := p.ident()
+	if label == "" {
+		if p.pos == len(p.s) {
+			return nil, p.errorf("unexpected end of expression")
+		}
+		return nil, p.errorf("expected a label name, got %q", p.s[p.pos:])
+	}
+	n := &condNode{label: label}
+	switch {
+	case p.keyword("in"):
+		n.op = "in"
+	case p.keyword("not"):
+		if !p.keyword("in") {
+			return nil, p.errorf("expected 'in' after 'not'")
+		}
+		n.op = "not in"
+	default:
+		p.skipSpace()
+		for _, op := range exprOps {
+			if strings.HasPrefix(p.s[p.pos:], op) {
+				n.op = op
+				p.pos += len(op)
+				break
+			}
+		}
+		if n.op == "" {
+			return nil, p.errorf("expected an operator after %s", label)
+		}
+	}
+	if n.op == "==" {
+		n.op = "="
+	}
+	if n.op == "in" || n.op == "not in" {
+		if !p.keyword("", "(") {
+			return nil, p.errorf("expected '(' after %s", n.op)
+		}
+		for {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, v)
+			if p.keyword("", ")") {
+				return n, nil
+			}
+			if !p.keyword("", ",") {
+				return nil, p.errorf("expected ',' or ')'")
+			}
+		}
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	n.values = []string{v}
+	switch n.op {
+	case "=~", "!~":
+		if n.re, err = regexp.Compile(v); err != nil {
+			return nil, fmt.Errorf("invalid regex %q for %s: %v", v, label, err)
+		}
+	case "<", "<=", ">", ">=":
+		if n.num, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("%s %s needs a number, got %q", label, n.op, v)
+		}
+	}
+	return n, nil
+}
+
+// value reads a quoted string or a bare word up to a space, comma or parenthesis
+func (p *exprParser) value() (string, error) {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return "", p.errorf("expected a value")
+	}
+	if q := p.s[p.pos]; q == '"' || q == '\'' {
+		var b strings.Builder
+		for i := p.pos + 1; i < len(p.s); i++ {
+			switch c := p.s[i]; {
+			case c == '\\' && i+1 < len(p.s) && (p.s[i+1] == q || p.s[i+1] == '\\'):
+				// other backslashes are kept for regexes
+				i++
+				b.WriteByte(p.s[i])
+			case c == q:
+				p.pos = i + 1
+				return b.String(), nil
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", p.errorf("unterminated string")
+	}
+	start := p.pos
+	for p.pos < len(p.s) && !unicode.IsSpace(rune(p.s[p.pos])) && !strings.ContainsRune("(),", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a value")
+	}
+	return p.s[start:p.pos], nil
+}
+
+// required adds the labels and the values one of which each of them must have for the
+// expression to match to keys
+func (e *MatchExpr) required(keys map[string][]string) {
+	nodes := []exprNode{e.root}
+	if and, ok := e.root.(andNode); ok {
+		nodes = and
+	}
+	for _, node := range nodes {
+		n, ok := node.(*condNode)
+		if !ok {
+			continue
+		}
+		switch n.op {
+		case "=", "in":
+			// numbers compare by value, not by their string
+			numeric := false
+			for _, v := range n.values {
+				if _, err := strconv.ParseFloat(v, 64); err == nil {
+					numeric = true
+				}
+			}
+			if !numeric {
+				keys[n.label] = n.values
+			}
+		case "=~":
+			if values, ok := exactValues(n.values[0]); ok {
+				keys[n.label] = values
+			}
+		}
+	}
+}
+
+// maximum number of strings an anchored regex can match to be indexed by them
+const maxExactValues = 32
+
+// exactValues returns the strings matched by an anchored regex such as ^(rtr1|rtr2)\.dc1$,
+// if there are only a few
+func exactValues(expr string) ([]string, bool) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil || re.Op != syntax.OpConcat || len(re.Sub) < 3 {
+		return nil, false
+	}
+	last := len(re.Sub) - 1
+	if re.Sub[0].Op != syntax.OpBeginText || re.Sub[last].Op != syntax.OpEndText {
+		return nil, false
+	}
+	return enumerate(&syntax.Regexp{Op: syntax.OpConcat, Sub: re.Sub[1:last]})
+}
+
+func enumerate(re *syntax.Regexp) ([]string, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return []string{""}, true
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+		return []string{string(re.Rune)}, true
+	case syntax.OpCapture:
+		return enumerate(re.Sub[0])
+	case syntax.OpCharClass:
+		var out []string
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				if len(out) == maxExactValues {
+					return nil, false
+				}
+				out = append(out, string(r))
+			}
+		}
+		return out, true
+	case syntax.OpAlternate:
+		var out []string
+		for _, sub := range re.Sub {
+			values, ok := enumerate(sub)
+			if !ok || len(out)+len(values) > maxExactValues {
+				return nil, false
+			}
+			out = append(out, values...)
+		}
+		return out, true
+	case syntax.OpConcat:
+		out := []string{""}
+		for _, sub := range re.Sub {
+			values, ok := enumerate(sub)
+			if !ok || len(out)*len(values) > maxExactValues {
+				return nil, false
+			}
+			var next []string
+			for _, prefix := range out {
+				for _, v := range values {
+					next = append(next, prefix+v)
+				}
+			}
+			out = next
+		}
+		return out, true
+	}
+	return nil, false
+}
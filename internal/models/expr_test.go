@@ -0,0 +1,160 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchExpr(t *testing.T) {
+	labels := Labels{
+		"alert_name": "Device Down",
+		"device":     "rtr1.dc1",
+		"site":       "dc2",
+		"Priority":   float64(2),
+		"rack":       "12",
+	}
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{`alert_name = "Device Down"`, true},
+		{`alert_name == 'Device Down'`, true},
+		{`device != rtr1.dc1`, false},
+		{`device !~ ^lab-`, true},
+		{`device =~ ^rtr`, true},
+		{`device =~ "^rtr\d\.dc1$"`, true},
+		{`site in (dc1, dc2)`, true},
+		{`site not in (dc1, dc2)`, false},
+		{`Priority < 3`, true},
+		{`Priority >= 3`, false},
+		{`Priority = 2`, true},
+		{`Priority in (1, 2.0)`, true},
+		{`rack > 10`, true},
+		{`exists(device)`, true},
+		{`!exists(owner)`, true},
+		{`NOT exists(device)`, false},
+		// missing labels
+		{`owner = foo`, false},
+		{`owner != foo`, true},
+		{`owner !~ foo`, true},
+		{`owner < 3`, false},
+		{`owner not in (a)`, true},
+		// non numeric label
+		{`device < 3`, false},
+		// nested groups
+		{`alert_name = "Device Down" and (site = dc1 or Priority < 3)`, true},
+		{`alert_name = "Device Down" && (site = dc1 || Priority > 3)`, false},
+		{`site = dc1 or site = dc2 and device =~ rtr`, true},
+		{`not (site = dc1 or site = dc3) and exists(rack)`, true},
+		{`((device=~rtr1))`, true},
+	}
+	for _, tt := range tests {
+		e, err := ParseMatchExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		assert.Equal(t, e.Match(labels), tt.match, tt.expr)
+	}
+	e, _ := ParseMatchExpr("exists = yes")
+	assert.True(t, e.Match(Labels{"exists": "yes"}))
+}
+
+func TestMatchExprErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"device",
+		"device =",
+		"device =~ (",
+		`device =~ "[a"`,
+		"Priority < high",
+		"site in dc1",
+		"site in (dc1",
+		"site not dc1",
+		"(device = a",
+		"device = a and",
+		"device = a b",
+		`device = "a`,
+		"exists()",
+		"= a",
+	} {
+		_, err := ParseMatchExpr(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestMatchValue(t *testing.T) {
+	assert.True(t, MatchValue("^dev", "dev1"))
+	assert.False(t, MatchValue("^dev", "xdev1"))
+	// rule value is not a string but the label is
+	assert.True(t, MatchValue(3, "3"))
+	assert.False(t, MatchValue(3, "dev1"))
+	assert.True(t, MatchValue(float64(3), 3))
+}
+
+func TestRuleMatchExpr(t *testing.T) {
+	r := &SuppressionRule{Name: "r", Mcond: MatchCond_ALL, Expr: "device !~ ^lab- and Priority < 3"}
+	assert.Nil(t, r.Compile())
+	assert.True(t, r.Match(Labels{"device": "rtr1", "Priority": 1}))
+	assert.False(t, r.Match(Labels{"device": "lab-rtr1", "Priority": 1}))
+
+	// with entities, both have to match
+	r.Entities = Labels{"site": "dc1"}
+	assert.False(t, r.Match(Labels{"device": "rtr1", "Priority": 1}))
+	assert.True(t, r.Match(Labels{"device": "rtr1", "Priority": 1, "site": "dc1"}))
+
+	// entity values that are not strings
+	r = &SuppressionRule{Mcond: MatchCond_ALL, Entities: Labels{"alert_id": float64(5)}}
+	assert.True(t, r.Match(Labels{"alert_id": "5"}))
+	assert.False(t, r.Match(Labels{"alert_id": "6"}))
+
+	r = &SuppressionRule{Name: "bad", Expr: "device ="}
+	assert.Error(t, r.Compile())
+}
+
+func TestExactValues(t *testing.T) {
+	tests := map[string][]string{
+		`^dev1$`:                  {"dev1"},
+		`^rtr1\.dc1$`:             {"rtr1.dc1"},
+		`^(rtr1\.dc1|rtr2\.dc1)$`: {"rtr1.dc1", "rtr2.dc1"},
+		`^sw[1-3]$`:               {"sw1", "sw2", "sw3"},
+		`^Test Alert \(1\)$`:      {"Test Alert (1)"},
+		`^(a|b)-(x|y)$`:           {"a-x", "a-y", "b-x", "b-y"},
+	}
+	for expr, want := range tests {
+		got, ok := exactValues(expr)
+		assert.True(t, ok, expr)
+		assert.ElementsMatch(t, got, want, expr)
+	}
+	for _, expr := range []string{`dev1`, `^dev1`, `dev1$`, `^dev.$`, `^dev\d+$`, `(?i)^dev1$`, `^[a-z][a-z]$`, `[`} {
+		_, ok := exactValues(expr)
+		assert.False(t, ok, expr)
+	}
+}
+
+func TestRuleIndexKeys(t *testing.T) {
+	tests := []struct {
+		rule SuppressionRule
+		keys map[string][]string
+	}{
+		{SuppressionRule{Mcond: MatchCond_ALL, Entities: Labels{"alert_name": "^Device Down$", "entity": "e.*"}}, map[string][]string{"alert_name": {"Device Down"}}},
+		{SuppressionRule{Mcond: MatchCond_ALL, Entities: Labels{"alert_id": float64(5)}}, map[string][]string{"alert_id": {"5"}}},
+		{SuppressionRule{Mcond: MatchCond_ALL, Entities: Labels{"a": "^(x|y)$", "b": "^z$"}}, map[string][]string{"a": {"x", "y"}, "b": {"z"}}},
+		{SuppressionRule{Expr: "site in (dc1, dc2) and exists(device)"}, map[string][]string{"site": {"dc1", "dc2"}}},
+		{SuppressionRule{Expr: `Priority < 3 and device =~ "^(r1|r2)$"`}, map[string][]string{"device": {"r1", "r2"}}},
+		// not indexable
+		{SuppressionRule{Mcond: MatchCond_ALL, Entities: Labels{"device": "dev1"}}, map[string][]string{}},
+		{SuppressionRule{Mcond: MatchCond_ANY, Entities: Labels{"device": "^dev1$"}}, map[string][]string{}},
+		{SuppressionRule{Expr: "device = a or device = b"}, map[string][]string{}},
+		{SuppressionRule{Expr: "Priority = 3"}, map[string][]string{}},
+	}
+	for _, tt := range tests {
+		r := tt.rule
+		assert.Nil(t, r.Compile())
+		keys := r.IndexKeys()
+		assert.Equal(t, len(keys), len(tt.keys), "%v %s", r.Entities, r.Expr)
+		for label, values := range tt.keys {
+			assert.ElementsMatch(t, keys[label], values)
+		}
+	}
+}
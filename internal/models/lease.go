@@ -0,0 +1,44 @@
+package models
+
+import "encoding/json"
+
+var (
+	// the lease is taken over once it has expired. The times come from the db clock so that
+	// the replicas do not depend on their own clocks being in sync.
+	QueryAcquireLease = `INSERT INTO leader_lease (name, holder, acquired_at, expires_at)
+    VALUES ($1, $2, extract(epoch from now())::bigint, extract(epoch from now())::bigint + $3)
+    ON CONFLICT (name) DO UPDATE SET holder=$2,
+    acquired_at=CASE WHEN leader_lease.holder=$2 THEN leader_lease.acquired_at ELSE EXCLUDED.acquired_at END,
+    expires_at=EXCLUDED.expires_at
+    WHERE leader_lease.holder=$2 OR leader_lease.expires_at <= extract(epoch from now())::bigint`
+	QueryReleaseLease = "DELETE FROM leader_lease WHERE name=$1 AND holder=$2"
+	QuerySelectLease  = "SELECT * FROM leader_lease WHERE name=$1"
+)
+
+// Lease is held by the replica elected to lead the others, until it expires
+type Lease struct {
+	Name       string
+	Holder     string
+	AcquiredAt MyTime `db:"acquired_at"`
+	ExpiresAt  MyTime `db:"expires_at"`
+}
+
+func (l *Lease) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Holder     string `json:"holder"`
+		AcquiredAt int64  `json:"acquired_at"`
+		ExpiresAt  int64  `json:"expires_at"`
+	}{
+		Holder:     l.Holder,
+		AcquiredAt: l.AcquiredAt.Unix(),
+		ExpiresAt:  l.ExpiresAt.Unix(),
+	})
+}
+
+type Leases []*Lease
+
+func (tx *Tx) SelectLeases(query string, args ...interface{}) (Leases, error) {
+	var leases Leases
+	err := tx.Select(&leases, query, args...)
+	return leases, err
+}
@@ -5,11 +5,15 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	tpl "github.com/mayuresh82/alert_manager/template"
 	"net"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,8 +23,21 @@ type Dbase interface {
 	Close() error
 }
 
+// Listener is implemented by databases that notify about changes
+type Listener interface {
+	// Listen returns a channel that receives a value whenever there is a notification on the channel
+	Listen(channel string) (<-chan struct{}, error)
+}
+
+// ChannelSuppRules is notified when suppression rules are added, changed or removed
+const ChannelSuppRules = "suppression_rules"
+
+// ChannelAlertQueue is notified when alert events are added to the shared alert queue
+const ChannelAlertQueue = "alert_queue"
+
 type DB struct {
 	*sqlx.DB
+	connStr string
 }
 
 func (d *DB) NewTx() Txn {
@@ -42,7 +59,33 @@ func NewDB(addr, username, password, dbName string, timeout int) Dbase {
 		glog.Fatalf("Cant open DB: %v", err)
 	}
 	db.MustExec(tpl.Schema)
-	return &DB{db}
+	return &DB{DB: db, connStr: connStr}
+}
+
+func (d *DB) Listen(channel string) (<-chan struct{}, error) {
+	l := pq.NewListener(d.connStr, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			glog.Errorf("DB listener for %s: %v", channel, err)
+		}
+	})
+	if err := l.Listen(channel); err != nil {
+		l.Close()
+		return nil, err
+	}
+	c := make(chan struct{}, 1)
+	go func() {
+		for n := range l.Notify {
+			// a nil notification means the connection was re-established and changes may have been missed
+			if n == nil {
+				glog.V(2).Infof("DB listener for %s reconnected", channel)
+			}
+			select {
+			case c <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return c, nil
 }
 
 func NewPartition(team string) string {
@@ -62,9 +105,18 @@ type Txn interface {
 	SelectAlertsWithHistory(query string, args ...interface{}) (Alerts, error)
 	AddAlertHistory(alerts Alerts) error
 	SelectRules(query string, args ...interface{}) (SuppRules, error)
+	UpdateRule(rule *SuppressionRule) error
 	NewRecord(alertId int64, event string) (int64, error)
 	SelectTeams(query string, args ...interface{}) (Teams, error)
 	SelectUsers(query string, args ...interface{}) (Users, error)
+	UpdateUser(u *User) error
+	SelectPendingClears(query string, args ...interface{}) (PendingClears, error)
+	SelectSchedules(query string, args ...interface{}) (Schedules, error)
+	UpdateSchedule(s *Schedule) error
+	SelectOverrides(query string, args ...interface{}) (Overrides, error)
+	SelectEscalations(query string, args ...interface{}) (Escalations, error)
+	SelectLeases(query string, args ...interface{}) (Leases, error)
+	SelectQueued(query string, args ...interface{}) (QueuedEvents, error)
 	Rollback() error
 	Commit() error
 	Exec(query string, args ...interface{}) error
@@ -97,15 +149,82 @@ func (tx *Tx) Exec(query string, args ...interface{}) error {
 	return err
 }
 
-// WithTx wraps a transaction around a function call.
+// WithTx wraps a transaction around a function call. The functions registered with
+// AfterCommit during the call run once the transaction is committed.
 func WithTx(ctx context.Context, tx Txn, cb func(ctx context.Context, tx Txn) error) error {
 	err := cb(ctx, tx)
+	hooks := takeCommitHooks(tx)
 	if err != nil {
 		tx.Rollback()
-	} else {
-		tx.Commit()
+		return err
 	}
-	return err
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, fn := range hooks {
+		fn()
+	}
+	return nil
+}
+
+var (
+	commitHooks   = make(map[Txn][]func())
+	commitHooksMu sync.Mutex
+)
+
+// AfterCommit runs fn once tx is committed by WithTx, e.g. to apply the changes made in tx
+// to a cache. fn is dropped if tx is rolled back.
+func AfterCommit(tx Txn, fn func()) {
+	commitHooksMu.Lock()
+	defer commitHooksMu.Unlock()
+	commitHooks[tx] = append(commitHooks[tx], fn)
+}
+
+func takeCommitHooks(tx Txn) []func() {
+	commitHooksMu.Lock()
+	defer commitHooksMu.Unlock()
+	hooks := commitHooks[tx]
+	delete(commitHooks, tx)
+	return hooks
+}
+
+// transientMessages match errors that are wrapped without keeping the underlying error
+var transientMessages = []string{
+	"bad connection", "connection refused", "connection reset", "broken pipe", "i/o timeout",
+	"deadlock detected", "could not serialize", "lock timeout",
+}
+
+// Transient returns true if err looks like a temporary failure, such as a lost db connection
+// or a serialization conflict, after which the same operation can succeed when retried.
+func Transient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		// connection exception, transaction rollback, insufficient resources, operator intervention
+		case "08", "40", "53", "57":
+			return true
+		}
+		// lock not available
+		return pqErr.Code == "55P03"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	msg := err.Error()
+	for _, m := range transientMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
 }
 
 func (tx *Tx) NewInsert(query string, item interface{}) (int64, error) {
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTransient(t *testing.T) {
+	assert.True(t, Transient(context.DeadlineExceeded))
+	assert.True(t, Transient(fmt.Errorf("Unable to insert alert: %v", driver.ErrBadConn)))
+	assert.True(t, Transient(&pq.Error{Code: "40001"}))
+	assert.False(t, Transient(&pq.Error{Code: "23505"}))
+	assert.False(t, Transient(fmt.Errorf("insert failed")))
+	assert.False(t, Transient(nil))
+}
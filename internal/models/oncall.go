@@ -0,0 +1,369 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	QueryInsertSchedule = `INSERT INTO
+    oncall_schedules (name, team_id, timezone, layers) VALUES (:name, :team_id, :timezone, :layers) RETURNING id`
+	QueryUpdateSchedule = `UPDATE oncall_schedules SET
+    name=:name, team_id=:team_id, timezone=:timezone, layers=:layers WHERE id=:id`
+	QueryDeleteSchedule = "DELETE FROM oncall_schedules WHERE id=$1"
+
+	querySelectSchedules      = "SELECT oncall_schedules.* FROM oncall_schedules"
+	QuerySelectSchedules      = querySelectSchedules + " ORDER BY id"
+	QuerySelectScheduleById   = querySelectSchedules + " WHERE id=$1"
+	QuerySelectScheduleByTeam = querySelectSchedules + `
+    JOIN teams ON teams.id = oncall_schedules.team_id WHERE teams.name=$1 ORDER BY oncall_schedules.id`
+
+	QueryInsertOverride = `INSERT INTO
+    oncall_overrides (schedule_id, user_id, start_time, end_time) VALUES (:schedule_id, :user_id, :start_time, :end_time) RETURNING id`
+	QueryDeleteOverride = "DELETE FROM oncall_overrides WHERE id=$1"
+	// overrides of a schedule that have not ended at a time
+	QuerySelectOverrides = "SELECT * FROM oncall_overrides WHERE schedule_id=$1 AND end_time > $2 ORDER BY id"
+
+	QuerySelectUserById = `
+		SELECT users.*, teams.id "team.id", teams.name "team.name", teams.organization "team.organization"
+		FROM users
+		JOIN teams ON users.team_id = teams.id
+		WHERE users.id=$1`
+	QuerySelectUsersByTeam = `
+		SELECT users.*, teams.id "team.id", teams.name "team.name", teams.organization "team.organization"
+		FROM users
+		JOIN teams ON users.team_id = teams.id
+		WHERE teams.id=$1`
+)
+
+const (
+	RotationDaily  = "daily"
+	RotationWeekly = "weekly"
+)
+
+// layer times are wall clock times in the schedule time zone
+const layerTimeFormat = "2006-01-02T15:04"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Layer rotates its users through shifts of one or more days or weeks. A layer can be
+// restricted to some days of the week and hours of the day, e.g. business hours.
+type Layer struct {
+	Name     string
+	Rotation string
+	// number of days or weeks in a shift, 1 by default
+	ShiftLength int `json:",omitempty"`
+	// the first handoff, e.g. 2024-03-04T09:00, and the optional end of the layer
+	Start string
+	End   string `json:",omitempty"`
+	// user ids in rotation order
+	Users []int64
+	// restrict the layer to these days ( mon, tue ... ) and to From - To each day, e.g.
+	// 09:00 - 17:00. To may be earlier than From for a restriction that spans midnight.
+	Days []string `json:",omitempty"`
+	From string   `json:",omitempty"`
+	To   string   `json:",omitempty"`
+
+	start, end time.Time
+	days       map[time.Weekday]bool
+	from, to   time.Duration
+}
+
+type Layers []*Layer
+
+func (l Layers) Value() (driver.Value, error) {
+	d, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return driver.Value(string(d)), nil
+}
+
+func (l *Layers) Scan(src interface{}) error {
+	var source []byte
+	switch src.(type) {
+	case []byte:
+		source = src.([]byte)
+	case string:
+		source = []byte(src.(string))
+	default:
+		return fmt.Errorf("Layers.Scan: Incompatible source type")
+	}
+	return json.Unmarshal(source, l)
+}
+
+// Schedule is an on-call schedule of a team. Later layers take precedence over earlier
+// ones while they are in effect, and overrides take precedence over all layers.
+type Schedule struct {
+	Id       int64
+	Name     string
+	TeamId   int64 `db:"team_id"`
+	Timezone string
+	Layers   Layers
+	loc      *time.Location
+}
+
+type Schedules []*Schedule
+
+// Override puts a user on call for a schedule for a while
+type Override struct {
+	Id         int64
+	ScheduleId int64  `db:"schedule_id"`
+	UserId     int64  `db:"user_id"`
+	Start      MyTime `db:"start_time"`
+	End        MyTime `db:"end_time"`
+}
+
+type Overrides []*Override
+
+// OnCall is who is on call for a schedule at a time
+type OnCall struct {
+	Schedule string
+	User     *User
+	// the layer the user is on call in, empty for an override
+	Layer string
+	// end of the shift or override
+	Until MyTime
+}
+
+// Compile validates the schedule and prepares it for OnCall
+func (s *Schedule) Compile() error {
+	if s.Name == "" {
+		return fmt.Errorf("Schedule needs a name")
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return fmt.Errorf("Invalid timezone for schedule %s: %v", s.Name, err)
+	}
+	s.loc = loc
+	if len(s.Layers) == 0 {
+		return fmt.Errorf("Schedule %s has no layers", s.Name)
+	}
+	for i, l := range s.Layers {
+		if l == nil {
+			return fmt.Errorf("Schedule %s: layer %d is empty", s.Name, i)
+		}
+		if err := l.compile(loc); err != nil {
+			return fmt.Errorf("Schedule %s: layer %d: %v", s.Name, i, err)
+		}
+	}
+	return nil
+}
+
+// UserIds returns the ids of all users of the schedule layers
+func (s *Schedule) UserIds() []int64 {
+	seen := make(map[int64]bool)
+	var ids []int64
+	for _, l := range s.Layers {
+		for _, id := range l.Users {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+func (l *Layer) compile(loc *time.Location) error {
+	switch l.Rotation {
+	case RotationDaily, RotationWeekly:
+	default:
+		return fmt.Errorf("Invalid rotation %q, should be daily or weekly", l.Rotation)
+	}
+	if l.ShiftLength < 0 {
+		return fmt.Errorf("Invalid shift length %d", l.ShiftLength)
+	}
+	if len(l.Users) == 0 {
+		return fmt.Errorf("No users")
+	}
+	var err error
+	if l.start, err = time.ParseInLocation(layerTimeFormat, l.Start, loc); err != nil {
+		return fmt.Errorf("Invalid start %q", l.Start)
+	}
+	l.end = time.Time{}
+	if l.End != "" {
+		if l.end, err = time.ParseInLocation(layerTimeFormat, l.End, loc); err != nil || !l.end.After(l.start) {
+			return fmt.Errorf("Invalid end %q", l.End)
+		}
+	}
+	l.days = nil
+	for _, d := range l.Days {
+		wd, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return fmt.Errorf("Invalid day %q", d)
+		}
+		if l.days == nil {
+			l.days = make(map[time.Weekday]bool)
+		}
+		l.days[wd] = true
+	}
+	l.from, l.to = 0, 0
+	if (l.From == "") != (l.To == "") {
+		return fmt.Errorf("Both from and to are needed to restrict the hours")
+	}
+	if l.From != "" {
+		if l.from, err = parseTimeOfDay(l.From); err != nil {
+			return err
+		}
+		if l.to, err = parseTimeOfDay(l.To); err != nil {
+			return err
+		}
+		if l.from == l.to {
+			return fmt.Errorf("Empty hours %s - %s", l.From, l.To)
+		}
+	}
+	return nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// at returns the user on call in the layer at t and when the shift, or the restricted
+// hours, end
+func (l *Layer) at(t time.Time) (int64, time.Time, bool) {
+	t = t.In(l.start.Location())
+	if t.Before(l.start) || (!l.end.IsZero() && !t.Before(l.end)) {
+		return 0, time.Time{}, false
+	}
+	until, ok := l.restrictedUntil(t)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	days := l.ShiftLength
+	if days == 0 {
+		days = 1
+	}
+	if l.Rotation == RotationWeekly {
+		days *= 7
+	}
+	// shifts are counted in calendar days so that handoffs keep their wall clock time
+	// across DST changes
+	n := int(t.Sub(l.start).Hours() / 24 / float64(days))
+	for n > 0 && l.start.AddDate(0, 0, n*days).After(t) {
+		n--
+	}
+	for !l.start.AddDate(0, 0, (n+1)*days).After(t) {
+		n++
+	}
+	if end := l.start.AddDate(0, 0, (n+1)*days); until.IsZero() || end.Before(until) {
+		until = end
+	}
+	if !l.end.IsZero() && l.end.Before(until) {
+		until = l.end
+	}
+	return l.Users[n%len(l.Users)], until, true
+}
+
+// restrictedUntil reports whether t is within the layer restrictions and when the
+// restricted hours end, if the hours are restricted
+func (l *Layer) restrictedUntil(t time.Time) (time.Time, bool) {
+	if l.From == "" {
+		if l.days != nil && !l.days[t.Weekday()] {
+			return time.Time{}, false
+		}
+		if l.days == nil {
+			return time.Time{}, true
+		}
+		// until the end of the day
+		y, m, d := t.Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()), true
+	}
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	sinceMidnight := t.Sub(midnight)
+	// the restricted hours started today or, when they span midnight, yesterday
+	day := midnight
+	switch {
+	case l.from < l.to:
+		if sinceMidnight < l.from || sinceMidnight >= l.to {
+			return time.Time{}, false
+		}
+	case sinceMidnight >= l.from:
+	case sinceMidnight < l.to:
+		day = time.Date(y, m, d-1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Time{}, false
+	}
+	if l.days != nil && !l.days[day.Weekday()] {
+		return time.Time{}, false
+	}
+	end := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, t.Location()).Add(l.to)
+	if l.to < l.from {
+		end = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, t.Location()).Add(l.to)
+	}
+	return end, true
+}
+
+// OnCall returns the id of the user on call at t, the layer, empty for an override, and
+// until when. The schedule has to be compiled.
+func (s *Schedule) OnCall(t time.Time, overrides Overrides) (int64, string, time.Time, bool) {
+	// the latest override wins
+	for i := len(overrides) - 1; i >= 0; i-- {
+		o := overrides[i]
+		if o.ScheduleId == s.Id && !t.Before(o.Start.Time) && t.Before(o.End.Time) {
+			return o.UserId, "", o.End.Time, true
+		}
+	}
+	for i := len(s.Layers) - 1; i >= 0; i-- {
+		l := s.Layers[i]
+		if user, until, ok := l.at(t); ok {
+			// a higher layer may take over before the shift ends
+			for _, h := range s.Layers[i+1:] {
+				if start := h.nextStart(t, until); !start.IsZero() && start.Before(until) {
+					until = start
+				}
+			}
+			return user, l.Name, until, true
+		}
+	}
+	return 0, "", time.Time{}, false
+}
+
+// nextStart returns when the layer next takes effect after t and before limit, if it does
+func (l *Layer) nextStart(t, limit time.Time) time.Time {
+	loc := l.start.Location()
+	// a layer takes effect at its start or when its restricted hours begin
+	candidates := []time.Time{l.start}
+	y, m, d := t.In(loc).Date()
+	for day := time.Date(y, m, d, 0, 0, 0, 0, loc); day.Before(limit); day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc) {
+		candidates = append(candidates, day.Add(l.from))
+	}
+	for _, c := range candidates {
+		if !c.After(t) || !c.Before(limit) {
+			continue
+		}
+		if _, _, ok := l.at(c); ok {
+			return c
+		}
+	}
+	return time.Time{}
+}
+
+func (tx *Tx) SelectSchedules(query string, args ...interface{}) (Schedules, error) {
+	var schedules Schedules
+	err := tx.Select(&schedules, query, args...)
+	return schedules, err
+}
+
+func (tx *Tx) UpdateSchedule(s *Schedule) error {
+	_, err := tx.NamedExec(QueryUpdateSchedule, s)
+	return err
+}
+
+func (tx *Tx) SelectOverrides(query string, args ...interface{}) (Overrides, error) {
+	var overrides Overrides
+	err := tx.Select(&overrides, query, args...)
+	return overrides, err
+}
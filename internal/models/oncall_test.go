@@ -0,0 +1,165 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustLoc(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestScheduleCompile(t *testing.T) {
+	layer := func() *Layer {
+		return &Layer{Name: "l", Rotation: RotationWeekly, Start: "2024-03-04T09:00", Users: []int64{1}}
+	}
+	s := &Schedule{Name: "s", Timezone: "America/New_York", Layers: Layers{layer()}}
+	assert.Nil(t, s.Compile())
+
+	tests := []func(s *Schedule){
+		func(s *Schedule) { s.Name = "" },
+		func(s *Schedule) { s.Timezone = "Mars/Olympus" },
+		func(s *Schedule) { s.Layers = nil },
+		func(s *Schedule) { s.Layers = Layers{nil} },
+		func(s *Schedule) { s.Layers[0].Rotation = "monthly" },
+		func(s *Schedule) { s.Layers[0].ShiftLength = -1 },
+		func(s *Schedule) { s.Layers[0].Users = nil },
+		func(s *Schedule) { s.Layers[0].Start = "2024-03-04" },
+		func(s *Schedule) { s.Layers[0].End = "2024-03-01T09:00" },
+		func(s *Schedule) { s.Layers[0].Days = []string{"mon", "someday"} },
+		func(s *Schedule) { s.Layers[0].From = "09:00" },
+		func(s *Schedule) { s.Layers[0].From, s.Layers[0].To = "09:00", "25:00" },
+		func(s *Schedule) { s.Layers[0].From, s.Layers[0].To = "09:00", "09:00" },
+	}
+	for i, tt := range tests {
+		s := &Schedule{Name: "s", Timezone: "America/New_York", Layers: Layers{layer()}}
+		tt(s)
+		assert.Error(t, s.Compile(), "case %d", i)
+	}
+}
+
+func TestScheduleOnCall(t *testing.T) {
+	ny := mustLoc(t, "America/New_York")
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation(layerTimeFormat, s, ny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	s := &Schedule{
+		Id:       1,
+		Name:     "primary",
+		Timezone: "America/New_York",
+		Layers: Layers{
+			{Name: "weekly", Rotation: RotationWeekly, Start: "2024-03-04T09:00", Users: []int64{1, 2, 3}},
+			{Name: "business", Rotation: RotationDaily, Start: "2024-03-04T00:00", Users: []int64{4, 5},
+				Days: []string{"Mon", "tue", "wed", "thu", "fri"}, From: "09:00", To: "17:00"},
+		},
+	}
+	if err := s.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		at    string
+		user  int64
+		layer string
+		until string
+	}{
+		// business hours on a weekday
+		{"2024-03-05T12:00", 5, "business", "2024-03-05T17:00"},
+		{"2024-03-04T09:00", 4, "business", "2024-03-04T17:00"},
+		// outside business hours until they begin again
+		{"2024-03-05T20:00", 1, "weekly", "2024-03-06T09:00"},
+		{"2024-03-08T17:00", 1, "weekly", "2024-03-11T09:00"},
+		{"2024-03-09T12:00", 1, "weekly", "2024-03-11T09:00"},
+		// handoffs keep their wall clock time across the DST change on 2024-03-10
+		{"2024-03-11T08:59", 1, "weekly", "2024-03-11T09:00"},
+		{"2024-03-16T09:00", 2, "weekly", "2024-03-18T09:00"},
+		{"2024-03-30T09:00", 1, "weekly", "2024-04-01T09:00"},
+	}
+	for _, tt := range tests {
+		user, layer, until, ok := s.OnCall(at(tt.at), nil)
+		assert.True(t, ok, tt.at)
+		assert.Equal(t, user, tt.user, tt.at)
+		assert.Equal(t, layer, tt.layer, tt.at)
+		assert.True(t, until.Equal(at(tt.until)), "%s: until %v", tt.at, until)
+	}
+
+	// before the layers start
+	_, _, _, ok := s.OnCall(at("2024-03-03T12:00"), nil)
+	assert.False(t, ok)
+
+	// the latest override wins over the layers, overrides of other schedules are ignored
+	overrides := Overrides{
+		{ScheduleId: 1, UserId: 7, Start: MyTime{at("2024-03-05T10:00")}, End: MyTime{at("2024-03-05T14:00")}},
+		{ScheduleId: 1, UserId: 8, Start: MyTime{at("2024-03-05T11:00")}, End: MyTime{at("2024-03-05T12:00")}},
+		{ScheduleId: 2, UserId: 9, Start: MyTime{at("2024-03-05T10:00")}, End: MyTime{at("2024-03-05T14:00")}},
+	}
+	user, layer, until, _ := s.OnCall(at("2024-03-05T10:30"), overrides)
+	assert.Equal(t, user, int64(7))
+	assert.Equal(t, layer, "")
+	assert.True(t, until.Equal(at("2024-03-05T14:00")))
+	user, _, until, _ = s.OnCall(at("2024-03-05T11:30"), overrides)
+	assert.Equal(t, user, int64(8))
+	assert.True(t, until.Equal(at("2024-03-05T12:00")))
+	user, layer, _, _ = s.OnCall(at("2024-03-05T14:00"), overrides)
+	assert.Equal(t, user, int64(5))
+	assert.Equal(t, layer, "business")
+}
+
+func TestLayerAt(t *testing.T) {
+	loc := mustLoc(t, "Europe/Berlin")
+	at := func(s string) time.Time {
+		tm, _ := time.ParseInLocation(layerTimeFormat, s, loc)
+		return tm
+	}
+	// two day shifts that end with the layer
+	l := &Layer{Rotation: RotationDaily, ShiftLength: 2, Start: "2024-03-30T08:00", End: "2024-04-05T12:00", Users: []int64{1, 2}}
+	assert.Nil(t, l.compile(loc))
+	user, until, ok := l.at(at("2024-04-01T07:59"))
+	assert.True(t, ok)
+	assert.Equal(t, user, int64(1))
+	assert.True(t, until.Equal(at("2024-04-01T08:00")))
+	user, _, _ = l.at(at("2024-04-01T08:00"))
+	assert.Equal(t, user, int64(2))
+	user, until, _ = l.at(at("2024-04-05T10:00"))
+	assert.Equal(t, user, int64(2))
+	assert.True(t, until.Equal(at("2024-04-05T12:00")))
+	_, _, ok = l.at(at("2024-04-05T12:00"))
+	assert.False(t, ok)
+
+	// nights on fridays, spanning midnight
+	l = &Layer{Rotation: RotationWeekly, Start: "2024-03-01T00:00", Users: []int64{3}, Days: []string{"fri"}, From: "22:00", To: "06:00"}
+	assert.Nil(t, l.compile(loc))
+	for ts, want := range map[string]bool{
+		"2024-03-08T21:59": false,
+		"2024-03-08T22:00": true,
+		"2024-03-09T03:00": true,
+		"2024-03-09T06:00": false,
+		"2024-03-09T23:00": false,
+		"2024-03-08T03:00": false,
+	} {
+		_, until, ok := l.at(at(ts))
+		assert.Equal(t, ok, want, ts)
+		if ok {
+			assert.True(t, until.Equal(at("2024-03-09T06:00")), ts)
+		}
+	}
+}
+
+func TestLayersValue(t *testing.T) {
+	layers := Layers{{Name: "l", Rotation: RotationDaily, Start: "2024-03-04T09:00", Users: []int64{1, 2}, Days: []string{"mon"}}}
+	v, err := layers.Value()
+	assert.Nil(t, err)
+	var scanned Layers
+	assert.Nil(t, scanned.Scan(v))
+	assert.Equal(t, scanned, layers)
+	assert.Error(t, scanned.Scan(1))
+}
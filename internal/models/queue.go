@@ -0,0 +1,33 @@
+package models
+
+var (
+	QueryInsertQueued = "INSERT INTO alert_queue (kind, data, queued_at) VALUES ($1, $2, $3)"
+	QuerySelectQueued = "SELECT * FROM alert_queue ORDER BY id LIMIT $1"
+	QueryDeleteQueued = "DELETE FROM alert_queue WHERE id=$1"
+	QueryFailQueued   = "UPDATE alert_queue SET attempts=attempts+1 WHERE id=$1"
+)
+
+const (
+	// an alert event received from a listener, to be handled
+	QueueAlert = "alert"
+	// an event for the processor pipeline, e.g. an alert acknowledged through the api
+	QueueProcess = "process"
+)
+
+// QueuedEvent is an alert event that a replica hands over to the leader through the db
+type QueuedEvent struct {
+	Id       int64
+	Kind     string
+	Data     string
+	QueuedAt MyTime `db:"queued_at"`
+	// failed attempts to handle the event
+	Attempts int
+}
+
+type QueuedEvents []*QueuedEvent
+
+func (tx *Tx) SelectQueued(query string, args ...interface{}) (QueuedEvents, error) {
+	var events QueuedEvents
+	err := tx.Select(&events, query, args...)
+	return events, err
+}
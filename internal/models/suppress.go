@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"regexp"
 	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/recur"
 )
 
 type MatchCondition int
@@ -18,13 +20,22 @@ var CondMap = map[string]MatchCondition{"all": MatchCond_ALL, "any": MatchCond_A
 var (
 	QueryInsertRule = `INSERT INTO
     suppression_rules (
-      name, mcond, entities, created_at, duration, reason, creator
+      name, mcond, entities, created_at, duration, reason, creator, schedule, timezone, external_id, expr
     ) VALUES (
-    :name, :mcond, :entities, :created_at, :duration, :reason, :creator
+    :name, :mcond, :entities, :created_at, :duration, :reason, :creator, :schedule, :timezone, :external_id, :expr
     ) RETURNING id`
 
-	querySelectRules     = "SELECT * FROM suppression_rules"
-	QuerySelectActive    = querySelectRules + " WHERE (cast(extract(epoch from now()) as integer) - created_at) < duration"
+	QueryUpdateRule = `UPDATE suppression_rules SET
+    name=:name, mcond=:mcond, entities=:entities, created_at=:created_at, duration=:duration,
+    reason=:reason, creator=:creator, schedule=:schedule, timezone=:timezone, external_id=:external_id,
+    expr=:expr
+      WHERE id=:id`
+
+	querySelectRules = "SELECT * FROM suppression_rules"
+	// maintenance windows never expire
+	QuerySelectActive = querySelectRules + " WHERE schedule != '' OR (cast(extract(epoch from now()) as integer) - created_at) < duration"
+	// rules imported from an external source, by external id prefix
+	QuerySelectExternal  = querySelectRules + " WHERE external_id LIKE $1 ORDER BY id"
 	queryUpdateRules     = "UPDATE suppression_rules"
 	QueryDeleteSuppRules = "DELETE FROM suppression_rules WHERE id IN (?)"
 )
@@ -39,40 +50,49 @@ type SuppressionRule struct {
 	Reason     string
 	Creator    string
 	DontExpire bool
+	// a recurring maintenance window, open for Duration at every occurrence of the cron or
+	// RRULE Schedule in Timezone
+	Schedule string
+	Timezone string
+	sched    *recur.Schedule
+	// id of the rule in the source it was imported from, e.g. a calendar event. Imported
+	// rules take effect at CreatedAt, which may be in the future.
+	ExternalId string `db:"external_id"`
+	// a match expression, see MatchExpr. Entities, if any, have to match as well.
+	Expr    string
+	matcher *MatchExpr
+	// compiled entity regexes
+	entityRes map[string]*regexp.Regexp
 }
 
 func (s SuppressionRule) Match(labels Labels) bool {
+	if s.Expr != "" {
+		matcher := s.matcher
+		if matcher == nil {
+			var err error
+			if matcher, err = ParseMatchExpr(s.Expr); err != nil {
+				return false
+			}
+		}
+		if !matcher.Match(labels) {
+			return false
+		}
+		if len(s.Entities) == 0 {
+			return true
+		}
+	}
 	switch s.Mcond {
 	case MatchCond_ALL:
 		for ek, ev := range s.Entities {
 			lv, ok := labels[ek]
-			if !ok {
-				return false
-			}
-			var match bool
-			if _, ok = lv.(string); ok {
-				match, _ = regexp.MatchString(ev.(string), lv.(string))
-			} else {
-				match = lv == ev
-			}
-			if !match {
+			if !ok || !s.matchEntity(ek, ev, lv) {
 				return false
 			}
 		}
 		return true
 	case MatchCond_ANY:
 		for ek, ev := range s.Entities {
-			lv, ok := labels[ek]
-			if !ok {
-				continue
-			}
-			var match bool
-			if _, ok = lv.(string); ok {
-				match, _ = regexp.MatchString(ev.(string), lv.(string))
-			} else {
-				match = lv == ev
-			}
-			if match {
+			if lv, ok := labels[ek]; ok && s.matchEntity(ek, ev, lv) {
 				return true
 			}
 		}
@@ -80,7 +100,110 @@ func (s SuppressionRule) Match(labels Labels) bool {
 	return false
 }
 
+func (s SuppressionRule) matchEntity(key string, ev, lv interface{}) bool {
+	if re, ok := s.entityRes[key]; ok {
+		if ls, ok := lv.(string); ok {
+			return re.MatchString(ls)
+		}
+	}
+	return MatchValue(ev, lv)
+}
+
+// IndexKeys returns the labels the rule requires to have one of a few values, with these
+// values. The rule has to be compiled.
+func (s SuppressionRule) IndexKeys() map[string][]string {
+	keys := make(map[string][]string)
+	if s.matcher != nil {
+		s.matcher.required(keys)
+	}
+	if s.Mcond != MatchCond_ALL {
+		return keys
+	}
+	for k, ev := range s.Entities {
+		if str, ok := ev.(string); ok {
+			if values, ok := exactValues(str); ok {
+				keys[k] = values
+			}
+			continue
+		}
+		keys[k] = []string{labelString(ev)}
+	}
+	return keys
+}
+
+// Started reports whether the rule has taken effect at t. Imported maintenance windows take
+// effect at their first window, the others right away.
+func (s SuppressionRule) Started(t time.Time) bool {
+	return s.Scheduled() && s.ExternalId == "" || !s.CreatedAt.After(t)
+}
+
+// Scheduled reports whether the rule is a recurring maintenance window
+func (s SuppressionRule) Scheduled() bool {
+	return s.Schedule != ""
+}
+
+// Compile parses the entity regexes, the match expression and the schedule of a maintenance window
+func (s *SuppressionRule) Compile() error {
+	s.entityRes = make(map[string]*regexp.Regexp)
+	for k, v := range s.Entities {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		re, err := regexp.Compile(str)
+		if err != nil {
+			return fmt.Errorf("Invalid regex for %s in rule %s: %v", k, s.Name, err)
+		}
+		s.entityRes[k] = re
+	}
+	if s.Expr != "" {
+		matcher, err := ParseMatchExpr(s.Expr)
+		if err != nil {
+			return fmt.Errorf("Invalid match expression for rule %s: %v", s.Name, err)
+		}
+		s.matcher = matcher
+	}
+	if !s.Scheduled() {
+		return nil
+	}
+	if s.Duration <= 0 {
+		return fmt.Errorf("Maintenance window %s needs a duration", s.Name)
+	}
+	sched, err := recur.Parse(s.Schedule, s.Timezone)
+	if err != nil {
+		return fmt.Errorf("Invalid schedule for maintenance window %s: %v", s.Name, err)
+	}
+	s.sched = sched
+	return nil
+}
+
+// WindowEnd returns the end of the window of a maintenance window rule that is open at t
+func (s SuppressionRule) WindowEnd(t time.Time) (time.Time, bool) {
+	sched := s.sched
+	if sched == nil {
+		var err error
+		if sched, err = recur.Parse(s.Schedule, s.Timezone); err != nil {
+			return time.Time{}, false
+		}
+	}
+	d := time.Duration(s.Duration) * time.Second
+	start, ok := sched.Window(t, d)
+	if !ok {
+		return time.Time{}, false
+	}
+	return start.Add(d), true
+}
+
+// TimeLeft returns how long the rule stays in effect. For maintenance windows it is the time
+// left in the open window, 0 if the window is closed.
 func (s SuppressionRule) TimeLeft() time.Duration {
+	if s.Scheduled() {
+		end, ok := s.WindowEnd(time.Now())
+		if !ok {
+			return 0
+		}
+		return end.Sub(time.Now())
+	}
 	if s.DontExpire {
 		return time.Duration(s.Duration) * time.Second
 	}
@@ -101,6 +224,11 @@ func NewSuppRule(entities Labels, mcond MatchCondition, reason, creator string,
 
 type SuppRules []*SuppressionRule
 
+func (tx *Tx) UpdateRule(rule *SuppressionRule) error {
+	_, err := tx.NamedExec(QueryUpdateRule, rule)
+	return err
+}
+
 func (tx *Tx) SelectRules(query string, args ...interface{}) (SuppRules, error) {
 	var rules SuppRules
 	err := tx.Select(&rules, query, args...)
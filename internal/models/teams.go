@@ -8,15 +8,17 @@ import (
 var (
 	QueryInsertTeam         = "INSERT INTO teams (name, organization) VALUES (:name, :organization) RETURNING id"
 	QueryDeleteTeam         = "DELETE FROM teams WHERE id=$1"
-	QueryInsertUser         = "INSERT INTO users (name, team_id) VALUES (:name, :team_id) RETURNING id"
+	QueryInsertUser         = "INSERT INTO users (name, team_id, email, slack_id) VALUES (:name, :team_id, :email, :slack_id) RETURNING id"
+	QueryUpdateUser         = "UPDATE users SET name=:name, team_id=:team_id, email=:email, slack_id=:slack_id WHERE id=:id"
 	QueryDeleteUser         = "DELETE FROM users WHERE id=$1"
 	QueryDeleteUsersForTeam = "DELETE FROM USERS WHERE team_id=$1"
 
-	QuerySelectTeams = "SELECT * FROM teams"
-	QuerySelectUsers = `
+	QuerySelectTeams      = "SELECT * FROM teams"
+	QuerySelectTeamByName = "SELECT * FROM teams WHERE name=$1"
+	QuerySelectUsers      = `
 		SELECT users.*, teams.id "team.id", teams.name "team.name", teams.organization "team.organization"
 		FROM users
-		JOIN teams ON users.team_id = teams.id"
+		JOIN teams ON users.team_id = teams.id
 	`
 )
 
@@ -39,7 +41,23 @@ type User struct {
 	Id     int64
 	Name   string
 	TeamId int64 `db:"team_id"`
-	Team   `db:"team"`
+	// contact details used to page the user when on call
+	Email   string
+	SlackId string `db:"slack_id"`
+	Team    `db:"team"`
+}
+
+// MarshalJSON keeps the user fields, the embedded Team would marshal only the team
+func (u *User) MarshalJSON() ([]byte, error) {
+	usr := struct {
+		Id      int64
+		Name    string
+		TeamId  int64
+		Email   string
+		SlackId string
+		Team    *Team
+	}{Id: u.Id, Name: u.Name, TeamId: u.TeamId, Email: u.Email, SlackId: u.SlackId, Team: &u.Team}
+	return json.Marshal(&usr)
 }
 
 func NewUser(name string, teamId int64) *User {
@@ -69,3 +87,8 @@ func (tx *Tx) SelectUsers(query string, args ...interface{}) (Users, error) {
 	err := tx.Select(&users, query, args...)
 	return users, err
 }
+
+func (tx *Tx) UpdateUser(u *User) error {
+	_, err := tx.NamedExec(QueryUpdateUser, u)
+	return err
+}
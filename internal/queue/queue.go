@@ -0,0 +1,214 @@
+// Package queue implements a durable on-disk FIFO queue. Every item is written to
+// its own file in the queue directory and fsynced before Put returns, and is only
+// removed once the consumer acks it, so that unacked items are replayed after a restart.
+package queue
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	itemSuffix = ".item"
+	tmpSuffix  = ".tmp"
+)
+
+type entry struct {
+	seq  uint64
+	time time.Time
+}
+
+// Item is a queued item handed out to the consumer
+type Item struct {
+	Data []byte
+	// time the item was queued at
+	Time time.Time
+	seq  uint64
+}
+
+// Queue is a durable FIFO queue with a single consumer
+type Queue struct {
+	dir     string
+	entries []entry
+	// number of entries handed out by Get and not yet acked
+	inflight int
+	nextSeq  uint64
+	notify   chan struct{}
+	sync.Mutex
+}
+
+// Open opens the queue in dir, creating it if needed, and loads all unacked items
+func Open(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{dir: dir, notify: make(chan struct{}, 1), nextSeq: 1}
+	for _, f := range files {
+		name := f.Name()
+		switch {
+		case strings.HasSuffix(name, tmpSuffix):
+			// partially written item from a crash
+			os.Remove(filepath.Join(dir, name))
+		case strings.HasSuffix(name, itemSuffix):
+			seq, err := strconv.ParseUint(strings.TrimSuffix(name, itemSuffix), 10, 64)
+			if err != nil {
+				glog.Errorf("Queue: ignoring unknown file %s", name)
+				continue
+			}
+			q.entries = append(q.entries, entry{seq: seq, time: f.ModTime()})
+			if seq >= q.nextSeq {
+				q.nextSeq = seq + 1
+			}
+		}
+	}
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].seq < q.entries[j].seq })
+	if len(q.entries) > 0 {
+		glog.Infof("Queue: replaying %d items from %s", len(q.entries), dir)
+	}
+	return q, nil
+}
+
+func (q *Queue) path(seq uint64, suffix string) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, suffix))
+}
+
+// Put persists data to the queue and returns once it is synced to disk
+func (q *Queue) Put(data []byte) error {
+	q.Lock()
+	seq := q.nextSeq
+	q.nextSeq++
+	q.Unlock()
+
+	tmp := q.path(seq, tmpSuffix)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, q.path(seq, itemSuffix)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := q.syncDir(); err != nil {
+		return err
+	}
+
+	q.Lock()
+	// keep entries ordered, concurrent puts may finish out of order
+	e := entry{seq: seq, time: time.Now()}
+	i := sort.Search(len(q.entries), func(i int) bool { return q.entries[i].seq > seq })
+	if i < q.inflight {
+		i = q.inflight
+	}
+	q.entries = append(q.entries, entry{})
+	copy(q.entries[i+1:], q.entries[i:])
+	q.entries[i] = e
+	q.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *Queue) syncDir() error {
+	d, err := os.Open(q.dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Get blocks until an item is available or ctx is done. Items are handed out in
+// order and must be acked once processed.
+func (q *Queue) Get(ctx context.Context) (*Item, error) {
+	for {
+		q.Lock()
+		if q.inflight < len(q.entries) {
+			e := q.entries[q.inflight]
+			q.inflight++
+			q.Unlock()
+			data, err := ioutil.ReadFile(q.path(e.seq, itemSuffix))
+			if err != nil {
+				glog.Errorf("Queue: dropping unreadable item %d: %v", e.seq, err)
+				q.remove(e.seq)
+				continue
+			}
+			return &Item{Data: data, Time: e.time, seq: e.seq}, nil
+		}
+		q.Unlock()
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Ack removes a processed item from the queue
+func (q *Queue) Ack(item *Item) error {
+	err := os.Remove(q.path(item.seq, itemSuffix))
+	q.remove(item.seq)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (q *Queue) remove(seq uint64) {
+	q.Lock()
+	defer q.Unlock()
+	for i := 0; i < q.inflight; i++ {
+		if q.entries[i].seq == seq {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			q.inflight--
+			return
+		}
+	}
+}
+
+// Len returns the number of unacked items
+func (q *Queue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.entries)
+}
+
+// Age returns how long the oldest unacked item has been queued
+func (q *Queue) Age() time.Duration {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.entries) == 0 {
+		return 0
+	}
+	return time.Since(q.entries[0].time)
+}
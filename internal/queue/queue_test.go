@@ -0,0 +1,106 @@
+package queue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, q.Len(), 0)
+	assert.Equal(t, q.Age(), time.Duration(0))
+	for _, d := range []string{"one", "two", "three"} {
+		if err := q.Put([]byte(d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, q.Len(), 3)
+	assert.True(t, q.Age() > 0)
+
+	ctx := context.Background()
+	item, err := q.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(item.Data), "one")
+	if err := q.Ack(item); err != nil {
+		t.Fatal(err)
+	}
+	// handed out but not acked
+	item, err = q.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(item.Data), "two")
+	assert.Equal(t, q.Len(), 2)
+
+	// simulate a crash during a write
+	ioutil.WriteFile(filepath.Join(dir, "00000000000000000009.tmp"), []byte("partial"), 0644)
+
+	// unacked items are replayed after reopening
+	q, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, q.Len(), 2)
+	for _, expected := range []string{"two", "three"} {
+		item, err = q.Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, string(item.Data), expected)
+		q.Ack(item)
+	}
+	assert.Equal(t, q.Len(), 0)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, len(files), 0)
+
+	// new items continue after the replayed ones
+	q.Put([]byte("four"))
+	item, _ = q.Get(ctx)
+	assert.Equal(t, string(item.Data), "four")
+}
+
+func TestQueueBlockingGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan string)
+	go func() {
+		item, err := q.Get(context.Background())
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		got <- string(item.Data)
+	}()
+	q.Put([]byte("one"))
+	assert.Equal(t, <-got, "one")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = q.Get(ctx)
+	assert.Equal(t, err, context.DeadlineExceeded)
+}
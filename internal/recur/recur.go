@@ -0,0 +1,297 @@
+// Package recur parses recurrence expressions, cron or a subset of iCalendar RRULEs, and
+// finds their occurrences.
+package recur
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bitset of allowed values of a field
+type bits uint64
+
+func (b bits) has(i int) bool { return b&(1<<uint(i)) != 0 }
+
+func span(min, max int) bits {
+	var b bits
+	for i := min; i <= max; i++ {
+		b |= 1 << uint(i)
+	}
+	return b
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is also sunday
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var shortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// Schedule is a parsed recurrence, evaluated in its time zone at minute granularity
+type Schedule struct {
+	minute, hour, dom, month, dow bits
+	// a restricted day of month or day of week matches either, as in cron
+	domStar, dowStar bool
+	// both days have to match, as in RRULE
+	andDays bool
+	loc     *time.Location
+}
+
+// Parse parses a cron expression ( minute hour day-of-month month day-of-week, or one of
+// @hourly, @daily, @weekly, @monthly, @yearly ) or an RRULE with FREQ, BYMONTH, BYMONTHDAY,
+// BYDAY, BYHOUR and BYMINUTE, in the given IANA time zone. An empty zone means UTC.
+func Parse(expr, tz string) (*Schedule, error) {
+	loc := time.UTC
+	if tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("Invalid time zone %s: %v", tz, err)
+		}
+	}
+	expr = strings.TrimSpace(expr)
+	upper := strings.ToUpper(expr)
+	if strings.HasPrefix(upper, "RRULE:") || strings.HasPrefix(upper, "FREQ=") {
+		return parseRRule(strings.TrimPrefix(upper, "RRULE:"), loc)
+	}
+	if s, ok := shortcuts[strings.ToLower(expr)]; ok {
+		expr = s
+	}
+	return parseCron(expr, loc)
+}
+
+func parseCron(expr string, loc *time.Location) (*Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Expected 5 fields in cron expression %q, got %d", expr, len(fields))
+	}
+	s := &Schedule{loc: loc, domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	var err error
+	for i, f := range []struct {
+		b *bits
+		field
+	}{{&s.minute, minuteField}, {&s.hour, hourField}, {&s.dom, domField}, {&s.month, monthField}, {&s.dow, dowField}} {
+		if *f.b, err = parseField(fields[i], f.field); err != nil {
+			return nil, err
+		}
+	}
+	if s.dow.has(7) {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseField parses a comma separated list of values, ranges and steps
+func parseField(expr string, f field) (bits, error) {
+	var b bits
+	for _, part := range strings.Split(expr, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("Invalid step in %s field: %s", f.name, part)
+			}
+			part = part[:i]
+		}
+		min, max := f.min, f.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if min, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			max = min
+			if len(bounds) == 2 {
+				if max, err = f.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				max = f.max
+			}
+			if max < min {
+				return 0, fmt.Errorf("Invalid range in %s field: %s", f.name, part)
+			}
+		}
+		for i := min; i <= max; i += step {
+			b |= 1 << uint(i)
+		}
+	}
+	return b, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("Invalid value in %s field: %s", f.name, s)
+	}
+	return v, nil
+}
+
+var rruleDays = map[string]int{"SU": 0, "MO": 1, "TU": 2, "WE": 3, "TH": 4, "FR": 5, "SA": 6}
+
+func parseRRule(expr string, loc *time.Location) (*Schedule, error) {
+	s := &Schedule{
+		loc: loc, minute: 1, hour: 1,
+		dom: span(domField.min, domField.max), month: span(monthField.min, monthField.max), dow: span(0, 6),
+		andDays: true,
+	}
+	var (
+		freq                          string
+		byHour, byDay, byDom, byMonth bool
+		err                           error
+	)
+	for _, part := range strings.Split(expr, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid RRULE part: %s", part)
+		}
+		key, value := kv[0], kv[1]
+		switch key {
+		case "FREQ":
+			freq = value
+		case "BYMINUTE":
+			s.minute, err = parseField(value, minuteField)
+		case "BYHOUR":
+			s.hour, err = parseField(value, hourField)
+			byHour = true
+		case "BYMONTHDAY":
+			s.dom, err = parseField(value, domField)
+			byDom = true
+		case "BYMONTH":
+			s.month, err = parseField(value, monthField)
+			byMonth = true
+		case "BYDAY":
+			s.dow = 0
+			for _, d := range strings.Split(value, ",") {
+				i, ok := rruleDays[d]
+				if !ok {
+					return nil, fmt.Errorf("Unsupported RRULE day: %s", d)
+				}
+				s.dow |= 1 << uint(i)
+			}
+			byDay = true
+		case "WKST":
+		default:
+			// INTERVAL, COUNT, UNTIL and BYSETPOS need an anchor that a window does not have
+			return nil, fmt.Errorf("Unsupported RRULE part: %s", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch freq {
+	case "":
+		return nil, fmt.Errorf("RRULE requires FREQ")
+	case "HOURLY":
+		if !byHour {
+			s.hour = span(hourField.min, hourField.max)
+		}
+	case "DAILY":
+	case "WEEKLY":
+		if !byDay {
+			return nil, fmt.Errorf("Weekly RRULE requires BYDAY")
+		}
+	case "MONTHLY":
+		if !byDom && !byDay {
+			return nil, fmt.Errorf("Monthly RRULE requires BYMONTHDAY or BYDAY")
+		}
+	case "YEARLY":
+		if !byMonth || !byDom && !byDay {
+			return nil, fmt.Errorf("Yearly RRULE requires BYMONTH and BYMONTHDAY or BYDAY")
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported RRULE frequency: %s", freq)
+	}
+	return s, nil
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom, dow := s.dom.has(t.Day()), s.dow.has(int(t.Weekday()))
+	if s.andDays || s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first occurrence strictly after t, or the zero time if there is none
+// within five years
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + 5
+wrap:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+	for !s.month.has(int(t.Month())) {
+		t = s.advance(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc))
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !s.dayMatches(t) {
+		t = s.advance(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc))
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	for !s.hour.has(t.Hour()) {
+		// by elapsed time, the next hour on the clock may not exist
+		t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for !s.minute.has(t.Minute()) {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	return t
+}
+
+// advance returns the local time next, or an hour after t if the day starts in a clock
+// change and next is not after t
+func (s *Schedule) advance(t, next time.Time) time.Time {
+	if !next.After(t) {
+		return t.Add(time.Hour)
+	}
+	return next
+}
+
+// Window returns the start of the latest window of length d that is open at t, if any
+func (s *Schedule) Window(t time.Time, d time.Duration) (time.Time, bool) {
+	var start time.Time
+	for next := s.Next(t.Add(-d)); !next.IsZero() && !next.After(t); next = s.Next(next) {
+		start = next
+	}
+	return start, !start.IsZero()
+}
@@ -0,0 +1,102 @@
+package recur
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"FREQ=WEEKLY;BYHOUR=2",
+		"FREQ=DAILY;INTERVAL=2",
+		"FREQ=SECONDLY",
+		"BYHOUR=2",
+		"RRULE:FREQ=MONTHLY",
+		"FREQ=WEEKLY;BYDAY=1TU",
+	} {
+		_, err := Parse(expr, "")
+		assert.Error(t, err, expr)
+	}
+	_, err := Parse("@daily", "Mars/Olympus")
+	assert.Error(t, err)
+}
+
+func TestNext(t *testing.T) {
+	// a tuesday
+	base := time.Date(2024, 3, 5, 1, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expr, tz string
+		want     time.Time
+	}{
+		{"0 2 * * 2", "", time.Date(2024, 3, 5, 2, 0, 0, 0, time.UTC)},
+		{"0 2 * * tue", "", time.Date(2024, 3, 5, 2, 0, 0, 0, time.UTC)},
+		{"30 1 * * *", "", time.Date(2024, 3, 6, 1, 30, 0, 0, time.UTC)},
+		{"*/15 * * * *", "", time.Date(2024, 3, 5, 1, 45, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", "", time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+		// restricted day of month or day of week
+		{"0 0 31 * 5", "", time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", "", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", "", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 2 * * 2", "America/New_York", time.Date(2024, 3, 5, 7, 0, 0, 0, time.UTC)},
+		{"FREQ=WEEKLY;BYDAY=TU;BYHOUR=2", "", time.Date(2024, 3, 5, 2, 0, 0, 0, time.UTC)},
+		{"RRULE:FREQ=DAILY;BYHOUR=3;BYMINUTE=15", "", time.Date(2024, 3, 5, 3, 15, 0, 0, time.UTC)},
+		{"FREQ=HOURLY;BYMINUTE=0,45", "", time.Date(2024, 3, 5, 1, 45, 0, 0, time.UTC)},
+		// all parts of an RRULE have to match
+		{"FREQ=MONTHLY;BYMONTHDAY=1,2,3,4,5,6,7;BYDAY=SU", "", time.Date(2024, 4, 7, 0, 0, 0, 0, time.UTC)},
+		{"FREQ=YEARLY;BYMONTH=12;BYMONTHDAY=25", "", time.Date(2024, 12, 25, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr, tt.tz)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		assert.True(t, s.Next(base).Equal(tt.want), "%s: got %v, want %v", tt.expr, s.Next(base), tt.want)
+	}
+
+	// strictly after
+	s, _ := Parse("30 1 * * *", "")
+	assert.True(t, s.Next(base.Add(-time.Second)).Equal(base))
+
+	// daylight saving: 02:30 does not exist on 2024-03-10 in New York
+	s, _ = Parse("30 2 * * *", "America/New_York")
+	next := s.Next(time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC))
+	next = s.Next(next)
+	assert.True(t, next.After(time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)))
+
+	// never
+	s, _ = Parse("0 0 31 2 *", "")
+	assert.True(t, s.Next(base).IsZero())
+}
+
+func TestWindow(t *testing.T) {
+	// tuesdays 02:00-04:00
+	s, err := Parse("0 2 * * 2", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := 2 * time.Hour
+	_, open := s.Window(time.Date(2024, 3, 5, 1, 59, 0, 0, time.UTC), d)
+	assert.False(t, open)
+	start, open := s.Window(time.Date(2024, 3, 5, 2, 0, 0, 0, time.UTC), d)
+	assert.True(t, open)
+	assert.True(t, start.Equal(time.Date(2024, 3, 5, 2, 0, 0, 0, time.UTC)))
+	start, open = s.Window(time.Date(2024, 3, 5, 3, 59, 59, 0, time.UTC), d)
+	assert.True(t, open)
+	assert.True(t, start.Equal(time.Date(2024, 3, 5, 2, 0, 0, 0, time.UTC)))
+	_, open = s.Window(time.Date(2024, 3, 5, 4, 0, 0, 0, time.UTC), d)
+	assert.False(t, open)
+
+	// overlapping windows: the latest one
+	s, _ = Parse("0 * * * *", "")
+	start, open = s.Window(time.Date(2024, 3, 5, 4, 30, 0, 0, time.UTC), 3*time.Hour)
+	assert.True(t, open)
+	assert.True(t, start.Equal(time.Date(2024, 3, 5, 4, 0, 0, 0, time.UTC)))
+}
@@ -113,6 +113,8 @@ func (n *InfluxReporter) Start(ctx context.Context) {
 			}
 			n.addToBuffer(data)
 		case <-ctx.Done():
+			// send what is left in the buffer before exiting
+			n.flush()
 			return
 		}
 	}
@@ -0,0 +1,165 @@
+// Package scheduler runs functions at exact deadlines off a single timer.
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+type timer struct {
+	key   string
+	at    time.Time
+	fn    func()
+	index int
+}
+
+// timerHeap is a min-heap of timers ordered by deadline
+type timerHeap []*timer
+
+func (h timerHeap) Len() int           { return len(h) }
+func (h timerHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
+
+// Scheduler keeps a heap of keyed deadlines and calls the function of each one when it is
+// due. Functions run one at a time on the goroutine calling Run, so a slow function delays
+// the ones after it.
+type Scheduler struct {
+	timers timerHeap
+	keys   map[string]*timer
+	wake   chan struct{}
+	mu     sync.Mutex
+}
+
+func New() *Scheduler {
+	return &Scheduler{keys: make(map[string]*timer), wake: make(chan struct{}, 1)}
+}
+
+// Schedule calls fn at the given time, replacing any pending timer with the same key.
+// Deadlines in the past fire right away.
+func (s *Scheduler) Schedule(key string, at time.Time, fn func()) {
+	s.mu.Lock()
+	if t, ok := s.keys[key]; ok {
+		t.at = at
+		t.fn = fn
+		heap.Fix(&s.timers, t.index)
+	} else {
+		t := &timer{key: key, at: at, fn: fn}
+		heap.Push(&s.timers, t)
+		s.keys[key] = t
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// Cancel removes the pending timer with the given key and reports whether there was one
+func (s *Scheduler) Cancel(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.keys[key]
+	if !ok {
+		return false
+	}
+	heap.Remove(&s.timers, t.index)
+	delete(s.keys, key)
+	return true
+}
+
+// Clear removes all pending timers
+func (s *Scheduler) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timers = nil
+	s.keys = make(map[string]*timer)
+}
+
+// Deadline returns the time of the pending timer with the given key
+func (s *Scheduler) Deadline(key string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.keys[key]; ok {
+		return t.at, true
+	}
+	return time.Time{}, false
+}
+
+// Len returns the number of pending timers
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.timers)
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next pops the first timer if it is due, otherwise it returns the time until it is due
+func (s *Scheduler) next(now time.Time) (*timer, time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.timers) == 0 {
+		return nil, 0, false
+	}
+	if wait := s.timers[0].at.Sub(now); wait > 0 {
+		return nil, wait, true
+	}
+	t := heap.Pop(&s.timers).(*timer)
+	delete(s.keys, t.key)
+	return t, 0, true
+}
+
+// Run fires timers as they become due until the context is done
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		t, wait, ok := s.next(time.Now())
+		if t != nil {
+			t.fn()
+			continue
+		}
+		var (
+			tm  *time.Timer
+			due <-chan time.Time
+		)
+		if ok {
+			tm = time.NewTimer(wait)
+			due = tm.C
+		}
+		select {
+		case <-due:
+		case <-s.wake:
+		case <-ctx.Done():
+		}
+		if tm != nil {
+			tm.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerOrder(t *testing.T) {
+	s := New()
+	now := time.Now()
+	var fired []string
+	add := func(key string, at time.Time) {
+		s.Schedule(key, at, func() { fired = append(fired, key) })
+	}
+	add("c", now.Add(3*time.Second))
+	add("a", now.Add(1*time.Second))
+	add("b", now.Add(2*time.Second))
+	add("d", now.Add(4*time.Second))
+	assert.Equal(t, s.Len(), 4)
+
+	// rescheduling replaces the pending timer
+	add("c", now.Add(500*time.Millisecond))
+	assert.Equal(t, s.Len(), 4)
+	at, ok := s.Deadline("c")
+	assert.True(t, ok)
+	assert.True(t, at.Equal(now.Add(500*time.Millisecond)))
+
+	assert.True(t, s.Cancel("b"))
+	assert.False(t, s.Cancel("b"))
+	_, ok = s.Deadline("b")
+	assert.False(t, ok)
+
+	_, wait, ok := s.next(now)
+	assert.True(t, ok)
+	assert.Equal(t, wait, 500*time.Millisecond)
+	for {
+		tm, _, _ := s.next(now.Add(10 * time.Second))
+		if tm == nil {
+			break
+		}
+		tm.fn()
+	}
+	assert.Equal(t, fired, []string{"c", "a", "d"})
+	assert.Equal(t, s.Len(), 0)
+
+	add("e", now)
+	s.Clear()
+	assert.Equal(t, s.Len(), 0)
+	_, ok = s.Deadline("e")
+	assert.False(t, ok)
+}
+
+func TestSchedulerRun(t *testing.T) {
+	s := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	fired := make(chan time.Time, 2)
+	start := time.Now()
+	s.Schedule("later", start.Add(time.Hour), func() { fired <- time.Now() })
+	// an earlier timer wakes up the running scheduler
+	s.Schedule("soon", start.Add(20*time.Millisecond), func() { fired <- time.Now() })
+	select {
+	case at := <-fired:
+		assert.True(t, at.Sub(start) >= 20*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timer did not fire")
+	}
+	// timers scheduled from a timer function
+	s.Schedule("chain", time.Now(), func() {
+		s.Schedule("chained", time.Now(), func() { fired <- time.Now() })
+	})
+	select {
+	case <-fired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("chained timer did not fire")
+	}
+	assert.Equal(t, s.Len(), 1)
+}
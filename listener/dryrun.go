@@ -0,0 +1,69 @@
+package listener
+
+import (
+	"encoding/json"
+	"net/http"
+
+	ah "github.com/mayuresh82/alert_manager/handler"
+	"github.com/mayuresh82/alert_manager/internal/models"
+)
+
+// dryRunAlert is the normalized alert that would be created from a webhook request
+type dryRunAlert struct {
+	Index       int           `json:"index"`
+	Event       string        `json:"event"`
+	Alert       *models.Alert `json:"alert"`
+	Labels      models.Labels `json:"labels"`
+	AutoExpire  bool          `json:"auto_expire"`
+	ExpireAfter int64         `json:"expire_after,omitempty"`
+	AutoClear   bool          `json:"auto_clear"`
+	// the suppression rule that would suppress the alert
+	SuppressedBy    *models.SuppressionRule `json:"suppressed_by,omitempty"`
+	TransformErrors []string                `json:"transform_errors,omitempty"`
+}
+
+type dryRunResponse struct {
+	Alerts   []dryRunAlert  `json:"alerts"`
+	Rejected []rejectedItem `json:"rejected"`
+}
+
+// dryRunHandler takes the same requests as httpHandler and returns the alerts that would be
+// created, after transforms and suppression rule matching, without handling them
+func (k WebHookListener) dryRunHandler(w http.ResponseWriter, r *http.Request) {
+	datas, itemErrs, _, team, ok := k.parseRequest(w, r)
+	if !ok {
+		return
+	}
+	resp := dryRunResponse{Alerts: []dryRunAlert{}, Rejected: []rejectedItem{}}
+	for i, data := range datas {
+		if data == nil {
+			var reason string
+			if itemErr, ok := itemErrs[i]; ok {
+				reason = itemErr.Error()
+			}
+			resp.Rejected = append(resp.Rejected, rejectedItem{Index: i, Error: reason})
+			continue
+		}
+		event, err := formatAlertEvent(data, team)
+		if err != nil {
+			resp.Rejected = append(resp.Rejected, rejectedItem{Index: i, Name: data.Name, Error: err.Error()})
+			continue
+		}
+		alert := event.Alert
+		result := dryRunAlert{Index: i, Event: event.Type.String(), Alert: alert}
+		if event.Type == models.EventType_ACTIVE {
+			var errs []error
+			result.SuppressedBy, errs = ah.DryRun(alert)
+			for _, err := range errs {
+				result.TransformErrors = append(result.TransformErrors, err.Error())
+			}
+		}
+		result.Labels = alert.Labels
+		result.AutoExpire = alert.AutoExpire
+		result.ExpireAfter = alert.ExpireAfter.Int64
+		result.AutoClear = alert.AutoClear
+		resp.Alerts = append(resp.Alerts, result)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
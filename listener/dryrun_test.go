@@ -0,0 +1,61 @@
+package listener
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ah "github.com/mayuresh82/alert_manager/handler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRunHandler(t *testing.T) {
+	lis := newMockListener()
+	req, err := http.NewRequest("POST", "/listener/dryrun/?source=mocked_batch&team=t1", bytes.NewReader([]byte("blah")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(lis.dryRunHandler).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	// nothing is sent to the handler
+	select {
+	case event := <-ah.ListenChan:
+		t.Fatalf("Unexpected event for %s", event.Alert.Name)
+	default:
+	}
+
+	var resp struct {
+		Alerts []struct {
+			Index  int
+			Event  string
+			Alert  map[string]interface{}
+			Labels map[string]interface{}
+		}
+		Rejected []rejectedItem
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(resp.Alerts), 2)
+	assert.Equal(t, len(resp.Rejected), 2)
+	a := resp.Alerts[0]
+	assert.Equal(t, a.Index, 0)
+	assert.Equal(t, a.Event, "ACTIVE")
+	assert.Equal(t, a.Alert["Name"], "Test Alert")
+	assert.Equal(t, a.Alert["Team"], "t1")
+	assert.Equal(t, a.Alert["Severity"], "INFO")
+	assert.Equal(t, a.Labels["entity"], "ent1")
+	assert.Equal(t, a.Labels["alert_name"], "Test Alert")
+	assert.Equal(t, resp.Alerts[1].Index, 3)
+	assert.Equal(t, resp.Alerts[1].Event, "CLEARED")
+
+	// same validation as the alert endpoint
+	req, _ = http.NewRequest("POST", "/listener/dryrun/", bytes.NewReader([]byte("blah")))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(lis.dryRunHandler).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+}
@@ -0,0 +1,346 @@
+package listener
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/internal/models"
+)
+
+// ParserMapping defines a parser in config by mapping fields of a json payload to
+// alert fields. Field values starting with '$' are JSONPath-style expressions
+// (e.g. $.alert.tags[0].value) that are looked up in the payload, anything else is
+// used as a literal value.
+type ParserMapping struct {
+	// source name used to look up the parser in ?source=
+	Source string
+	// optional path to an array of alerts, each mapped separately
+	Items       string
+	Id          string
+	Name        string
+	Entity      string
+	Device      string
+	Description string
+	Severity    string
+	Status      string
+	Timestamp   string
+	// layout of the timestamp, defaults to RFC3339. "unix" and "unix_ms" are also supported
+	TimeFormat string `mapstructure:"time_format"`
+	// map of label names to expressions
+	Labels map[string]string
+	// maps source severity values to INFO, WARN or CRITICAL
+	SeverityMap map[string]string `mapstructure:"severity_map"`
+	// maps source status values to alerting or cleared
+	StatusMap map[string]string `mapstructure:"status_map"`
+}
+
+func (m *ParserMapping) validate() error {
+	if m.Source == "" {
+		return fmt.Errorf("Parser mapping requires a source")
+	}
+	if m.Name == "" {
+		return fmt.Errorf("Parser mapping %s requires a name", m.Source)
+	}
+	exprs := []string{m.Items, m.Id, m.Name, m.Entity, m.Device, m.Description, m.Severity, m.Status, m.Timestamp}
+	for _, e := range m.Labels {
+		exprs = append(exprs, e)
+	}
+	for _, e := range exprs {
+		if !isPath(e) {
+			continue
+		}
+		if _, err := parsePath(e); err != nil {
+			return fmt.Errorf("Parser mapping %s: %v", m.Source, err)
+		}
+	}
+	for k, v := range m.SeverityMap {
+		if _, ok := models.SevMap[strings.ToUpper(v)]; !ok {
+			return fmt.Errorf("Parser mapping %s: invalid severity %s for %s", m.Source, v, k)
+		}
+	}
+	for k, v := range m.StatusMap {
+		if v != Status_ALERTING && v != Status_CLEARED {
+			return fmt.Errorf("Parser mapping %s: invalid status %s for %s", m.Source, v, k)
+		}
+	}
+	return nil
+}
+
+// MappingParser is a parser built from a ParserMapping
+type MappingParser struct {
+	mapping *ParserMapping
+}
+
+// NewMappingParser validates the mapping and returns a parser for it
+func NewMappingParser(mapping *ParserMapping) (*MappingParser, error) {
+	if err := mapping.validate(); err != nil {
+		return nil, err
+	}
+	return &MappingParser{mapping: mapping}, nil
+}
+
+func (p *MappingParser) Name() string {
+	return p.mapping.Source
+}
+
+func (p *MappingParser) Parse(data []byte) ([]*WebHookAlertData, error) {
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		glog.Errorf("Unable to decode json: %v", err)
+		return nil, err
+	}
+	if p.mapping.Items == "" {
+		d, err := p.parseItem(doc)
+		if err != nil {
+			return nil, err
+		}
+		return []*WebHookAlertData{d}, nil
+	}
+	items, err := evalPath(doc, p.mapping.Items)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := items.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("Invalid data received, no alerts found at %s", p.mapping.Items)
+	}
+	results := make([]*WebHookAlertData, len(list))
+	errs := make(ItemErrors)
+	for i, item := range list {
+		d, err := p.parseItem(item)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		results[i] = d
+	}
+	if len(errs) > 0 {
+		return results, errs
+	}
+	return results, nil
+}
+
+func (p *MappingParser) parseItem(doc interface{}) (*WebHookAlertData, error) {
+	m := p.mapping
+	d := &WebHookAlertData{
+		Id:      lookupString(doc, m.Id),
+		Name:    lookupString(doc, m.Name),
+		Details: lookupString(doc, m.Description),
+		Device:  lookupString(doc, m.Device),
+		Entity:  lookupString(doc, m.Entity),
+		Source:  m.Source,
+		Status:  Status_ALERTING,
+		Time:    time.Now(),
+		Labels:  make(map[string]interface{}),
+	}
+	if d.Name == "" {
+		return nil, fmt.Errorf("Required fields missing: [name]")
+	}
+	if sev := lookupString(doc, m.Severity); sev != "" {
+		if mapped, ok := lookupMap(m.SeverityMap, sev); ok {
+			sev = mapped
+		}
+		if _, ok := models.SevMap[strings.ToUpper(sev)]; ok {
+			d.Level = strings.ToUpper(sev)
+		}
+	}
+	if status := lookupString(doc, m.Status); status != "" {
+		if mapped, ok := lookupMap(m.StatusMap, status); ok {
+			d.Status = mapped
+		} else if strings.ToLower(status) == Status_CLEARED {
+			d.Status = Status_CLEARED
+		}
+	}
+	if ts := lookupString(doc, m.Timestamp); ts != "" {
+		t, err := parseTime(ts, m.TimeFormat)
+		if err != nil {
+			glog.Errorf("Unable to parse time string , using current time")
+		} else {
+			d.Time = t
+		}
+	}
+	for label, expr := range m.Labels {
+		if v, err := lookup(doc, expr); err == nil && v != nil {
+			d.Labels[label] = v
+		}
+	}
+	return d, nil
+}
+
+// lookupMap looks up a source value in a value mapping table, ignoring case
+func lookupMap(m map[string]string, key string) (string, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func parseTime(ts, layout string) (time.Time, error) {
+	switch layout {
+	case "", "rfc3339":
+		return time.Parse(time.RFC3339, ts)
+	case "unix", "unix_ms":
+		f, err := strconv.ParseFloat(ts, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if layout == "unix_ms" {
+			return time.Unix(0, int64(f*float64(time.Millisecond))), nil
+		}
+		return time.Unix(0, int64(f*float64(time.Second))), nil
+	}
+	return time.Parse(layout, ts)
+}
+
+func isPath(expr string) bool {
+	return strings.HasPrefix(expr, "$")
+}
+
+// lookup evaluates expr against doc if it is a path, otherwise returns the literal expr
+func lookup(doc interface{}, expr string) (interface{}, error) {
+	if !isPath(expr) {
+		return expr, nil
+	}
+	v, err := evalPath(doc, expr)
+	if err != nil {
+		return nil, err
+	}
+	return normalize(v), nil
+}
+
+func lookupString(doc interface{}, expr string) string {
+	if !isPath(expr) {
+		return expr
+	}
+	v, err := evalPath(doc, expr)
+	if err != nil || v == nil {
+		return ""
+	}
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	case bool:
+		return strconv.FormatBool(val)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// normalize converts json.Numbers to float64 like a plain json.Unmarshal would
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		f, _ := val.Float64()
+		return f
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, e := range val {
+			m[k] = normalize(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(val))
+		for i, e := range val {
+			l[i] = normalize(e)
+		}
+		return l
+	}
+	return v
+}
+
+// pathElem is either an object key or an array index
+type pathElem struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+// parsePath parses a JSONPath-style expression such as $.a.b[0]['c.d']
+func parsePath(expr string) ([]pathElem, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("Invalid path %s: must start with $", expr)
+	}
+	var elems []pathElem
+	rest := expr[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("Invalid path %s: empty key", expr)
+			}
+			elems = append(elems, pathElem{key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("Invalid path %s: missing ]", expr)
+			}
+			inner := rest[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				elems = append(elems, pathElem{key: inner[1 : len(inner)-1]})
+			} else {
+				idx, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid path %s: bad index %s", expr, inner)
+				}
+				elems = append(elems, pathElem{index: idx, isIdx: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("Invalid path %s: unexpected %q", expr, rest[0])
+		}
+	}
+	return elems, nil
+}
+
+func evalPath(doc interface{}, expr string) (interface{}, error) {
+	elems, err := parsePath(expr)
+	if err != nil {
+		return nil, err
+	}
+	cur := doc
+	for _, e := range elems {
+		if e.isIdx {
+			arr, ok := cur.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: not an array", expr)
+			}
+			idx := e.index
+			if idx < 0 {
+				idx += len(arr)
+			}
+			if idx < 0 || idx >= len(arr) {
+				return nil, fmt.Errorf("%s: index out of range", expr)
+			}
+			cur = arr[idx]
+			continue
+		}
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: not an object", expr)
+		}
+		if cur, ok = obj[e.key]; !ok {
+			return nil, fmt.Errorf("%s: key %s not found", expr, e.key)
+		}
+	}
+	return cur, nil
+}
@@ -0,0 +1,116 @@
+package listener
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePath(t *testing.T) {
+	elems, err := parsePath(`$.alert.tags[1]['host.name']`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, elems, []pathElem{{key: "alert"}, {key: "tags"}, {index: 1, isIdx: true}, {key: "host.name"}})
+
+	for _, bad := range []string{"alert.name", "$..name", "$.tags[x]", "$.tags[0", "$name"} {
+		_, err = parsePath(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestMappingParser(t *testing.T) {
+	mapping := &ParserMapping{
+		Source:      "custom_nms",
+		Items:       "$.events",
+		Id:          "$.id",
+		Name:        "$.check.name",
+		Entity:      "$.check.target",
+		Device:      "$.host",
+		Description: "$.text",
+		Severity:    "$.prio",
+		Status:      "$.state",
+		Timestamp:   "$.ts",
+		TimeFormat:  "unix",
+		Labels:      map[string]string{"region": "$.tags[0]", "priority": "$.prio", "team": "neteng"},
+		SeverityMap: map[string]string{"P1": "CRITICAL", "P2": "WARN"},
+		StatusMap:   map[string]string{"firing": Status_ALERTING, "ok": Status_CLEARED},
+	}
+	parser, err := NewMappingParser(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, parser.Name(), "custom_nms")
+
+	raw := `{"events": [
+      {"id": 12345678901234, "check": {"name": "Custom Link Down", "target": "et-0/0/1"}, "host": "br1-sjc1",
+       "text": "link is down", "prio": "p1", "state": "FIRING", "ts": 1551434400, "tags": ["us-west", "bb"]},
+      {"check": {"target": "et-0/0/2"}},
+      {"id": 2, "check": {"name": "Custom Link Down", "target": "et-0/0/3"}, "host": "br2-sjc1", "prio": "p3", "state": "ok"}
+    ]}`
+	results, err := parser.Parse([]byte(raw))
+	itemErrs, ok := err.(ItemErrors)
+	assert.True(t, ok)
+	assert.Error(t, itemErrs[1])
+	assert.Equal(t, len(results), 3)
+	assert.Nil(t, results[1])
+
+	r := results[0]
+	assert.Equal(t, r.Id, "12345678901234")
+	assert.Equal(t, r.Name, "Custom Link Down")
+	assert.Equal(t, r.Entity, "et-0/0/1")
+	assert.Equal(t, r.Device, "br1-sjc1")
+	assert.Equal(t, r.Details, "link is down")
+	assert.Equal(t, r.Level, "CRITICAL")
+	assert.Equal(t, r.Status, Status_ALERTING)
+	assert.Equal(t, r.Source, "custom_nms")
+	assert.Equal(t, r.Time.Unix(), int64(1551434400))
+	assert.Equal(t, r.Labels, map[string]interface{}{"region": "us-west", "priority": "p1", "team": "neteng"})
+
+	r = results[2]
+	assert.Equal(t, r.Level, "")
+	assert.Equal(t, r.Status, Status_CLEARED)
+	_, ok = r.Labels["region"]
+	assert.False(t, ok)
+
+	// single alert payload
+	mapping = &ParserMapping{Source: "custom_single", Name: "Custom Alert", Entity: "$.obj", Severity: "$.sev"}
+	parser, err = NewMappingParser(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = parser.Parse([]byte(`{"obj": 5, "sev": "warn"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].Name, "Custom Alert")
+	assert.Equal(t, results[0].Entity, "5")
+	assert.Equal(t, results[0].Level, "WARN")
+
+	// invalid mappings
+	_, err = NewMappingParser(&ParserMapping{Source: "bad", Name: "$.a[b]"})
+	assert.Error(t, err)
+	_, err = NewMappingParser(&ParserMapping{Source: "bad", Name: "n", SeverityMap: map[string]string{"x": "HIGH"}})
+	assert.Error(t, err)
+	_, err = NewMappingParser(&ParserMapping{Source: "bad", Name: "n", StatusMap: map[string]string{"x": "down"}})
+	assert.Error(t, err)
+}
+
+func TestRegisterMappings(t *testing.T) {
+	lis := newMockListener()
+	lis.Mappings = []*ParserMapping{
+		{Source: "mapped_src", Name: "$.name"},
+		{Source: "mocked", Name: "$.name"},
+		{Source: "mapped_bad"},
+	}
+	lis.registerMappings()
+	p, ok := GetParser("mapped_src")
+	assert.True(t, ok)
+	assert.IsType(t, &MappingParser{}, p)
+	p, _ = GetParser("mocked")
+	assert.IsType(t, &mockParser{}, p)
+	_, ok = GetParser("mapped_bad")
+	assert.False(t, ok)
+	assert.Contains(t, lis.GetParsersList(), "mapped_src")
+}
@@ -1,14 +1,68 @@
 package listener
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
 // parser is an interface that satisfies the parse method for parsing incoming URL data
-// from various sources
+// from various sources. A single request body may carry more than one alert, so
+// parsers return every alert found in the payload.
+// If only some of the alerts in a payload are invalid, parsers return ItemErrors
+// along with the valid alerts, leaving a nil entry at the index of each invalid one.
 type Parser interface {
 	Name() string
-	Parse(data []byte) (*WebHookAlertData, error)
+	Parse(data []byte) ([]*WebHookAlertData, error)
+}
+
+// ItemErrors holds per-item parse errors for a batch payload, keyed by the index
+// of the item in the payload
+type ItemErrors map[int]error
+
+func (e ItemErrors) Error() string {
+	var idx []int
+	for i := range e {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	var errs []string
+	for _, i := range idx {
+		errs = append(errs, fmt.Sprintf("item %d: %v", i, e[i]))
+	}
+	return strings.Join(errs, "; ")
 }
 
-var parsers []Parser
+var (
+	parsers []Parser
+	pMu     sync.RWMutex
+)
 
 func AddParser(parser Parser) {
+	pMu.Lock()
+	defer pMu.Unlock()
 	parsers = append(parsers, parser)
 }
+
+// GetParser returns the registered parser for a source
+func GetParser(name string) (Parser, bool) {
+	pMu.RLock()
+	defer pMu.RUnlock()
+	for _, p := range parsers {
+		if p.Name() == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func parserNames() []string {
+	pMu.RLock()
+	defer pMu.RUnlock()
+	var names []string
+	for _, p := range parsers {
+		names = append(names, p.Name())
+	}
+	return names
+}
@@ -1,6 +1,7 @@
 package parsers
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/golang/glog"
@@ -34,12 +35,45 @@ func (p *GenericParser) Name() string {
 	return p.name
 }
 
-func (p *GenericParser) Parse(data []byte) (*listener.WebHookAlertData, error) {
-	d := alertData{}
-	if err := json.Unmarshal(data, &d); err != nil {
-		glog.Errorf("Unable to decode json: %v", err)
-		return nil, err
+// Parse decodes either a single alertData object or a JSON array of them
+func (p *GenericParser) Parse(data []byte) ([]*listener.WebHookAlertData, error) {
+	var items []alertData
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(data, &items); err != nil {
+			glog.Errorf("Unable to decode json: %v", err)
+			return nil, err
+		}
+		if len(items) == 0 {
+			return nil, fmt.Errorf("Invalid data received, no alerts found")
+		}
+	} else {
+		d := alertData{}
+		if err := json.Unmarshal(data, &d); err != nil {
+			glog.Errorf("Unable to decode json: %v", err)
+			return nil, err
+		}
+		items = append(items, d)
 	}
+	results := make([]*listener.WebHookAlertData, len(items))
+	errs := make(listener.ItemErrors)
+	for i, d := range items {
+		r, err := p.parseItem(d)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		results[i] = r
+	}
+	if len(errs) > 0 {
+		if len(items) == 1 {
+			return nil, errs[0]
+		}
+		return results, errs
+	}
+	return results, nil
+}
+
+func (p *GenericParser) parseItem(d alertData) (*listener.WebHookAlertData, error) {
 	t, err := time.Parse(time.RFC3339, d.Timestamp)
 	if err != nil {
 		glog.Errorf("Unable to parse time string , using current time")
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/mayuresh82/alert_manager/listener"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -32,7 +33,7 @@ func (p *GrafanaParser) Name() string {
 	return p.name
 }
 
-func (p *GrafanaParser) Parse(data []byte) (*listener.WebHookAlertData, error) {
+func (p *GrafanaParser) Parse(data []byte) ([]*listener.WebHookAlertData, error) {
 	d := grafanaData{}
 	if err := json.Unmarshal(data, &d); err != nil {
 		glog.Errorf("Unable to decode json: %v", err)
@@ -44,28 +45,38 @@ func (p *GrafanaParser) Parse(data []byte) (*listener.WebHookAlertData, error) {
 	if len(d.EvalMatches) == 0 {
 		return nil, fmt.Errorf("Invalid data received in alert")
 	}
-	metricText := fmt.Sprintf("\nMetric: %v, Value: %v\n", d.EvalMatches[0].Metric, d.EvalMatches[0].Value)
-	l := &listener.WebHookAlertData{
-		Id:      strconv.FormatInt(int64(d.RuleId), 10),
-		Name:    d.RuleName,
-		Details: d.Message + metricText,
-		Time:    time.Now(),
-		Status:  listener.Status_ALERTING,
-		Source:  "grafana",
+	status := listener.Status_ALERTING
+	if strings.ToLower(d.State) != "alerting" {
+		status = listener.Status_CLEARED
 	}
-	var tags []string
-	for tagName, tagValue := range d.EvalMatches[0].Tags {
-		if strings.ToLower(tagName) == "device" {
-			l.Device = tagValue
-			continue
+	// every eval match is a separate alert for the matched series
+	var results []*listener.WebHookAlertData
+	for _, match := range d.EvalMatches {
+		metricText := fmt.Sprintf("\nMetric: %v, Value: %v\n", match.Metric, match.Value)
+		l := &listener.WebHookAlertData{
+			Id:      strconv.FormatInt(int64(d.RuleId), 10),
+			Name:    d.RuleName,
+			Details: d.Message + metricText,
+			Time:    time.Now(),
+			Status:  status,
+			Source:  "grafana",
 		}
-		tags = append(tags, tagValue)
-	}
-	l.Entity = strings.Join(tags, ":")
-	if strings.ToLower(d.State) != "alerting" {
-		l.Status = listener.Status_CLEARED
+		var tagNames, tags []string
+		for tagName := range match.Tags {
+			tagNames = append(tagNames, tagName)
+		}
+		sort.Strings(tagNames)
+		for _, tagName := range tagNames {
+			if strings.ToLower(tagName) == "device" {
+				l.Device = match.Tags[tagName]
+				continue
+			}
+			tags = append(tags, match.Tags[tagName])
+		}
+		l.Entity = strings.Join(tags, ":")
+		results = append(results, l)
 	}
-	return l, nil
+	return results, nil
 }
 
 func init() {
@@ -26,7 +26,7 @@ func (p *KapacitorParser) Name() string {
 	return p.name
 }
 
-func (p *KapacitorParser) Parse(data []byte) (*listener.WebHookAlertData, error) {
+func (p *KapacitorParser) Parse(data []byte) ([]*listener.WebHookAlertData, error) {
 	d := kapacitorData{}
 	if err := json.Unmarshal(data, &d); err != nil {
 		glog.Errorf("Unable to decode json: %v", err)
@@ -45,36 +45,48 @@ func (p *KapacitorParser) Parse(data []byte) (*listener.WebHookAlertData, error)
 	if d.Level == "OK" {
 		status = listener.Status_CLEARED
 	}
-	r := &listener.WebHookAlertData{
-		Name:    d.Id,
-		Details: details,
-		Time:    t,
-		Status:  status,
-		Level:   d.Level,
-		Source:  "kapacitor",
-	}
 	// extract tags
 	series, ok := d.Data["series"]
 	if !ok {
 		return nil, fmt.Errorf("Invalid data received, no tags found")
 	}
-	s := series.([]interface{})
-	if len(s) == 0 {
-		return nil, fmt.Errorf("Invalid data received, no tags found")
-	}
-	sMap := s[0].(map[string]interface{})
-	tags, ok := sMap["tags"]
-	if !ok || tags == nil {
+	s, ok := series.([]interface{})
+	if !ok || len(s) == 0 {
 		return nil, fmt.Errorf("Invalid data received, no tags found")
 	}
-	tagMap := tags.(map[string]interface{})
-	if device, ok := tagMap["device"]; ok {
-		r.Device = device.(string)
+	// every series is a separate alert for its set of tags
+	results := make([]*listener.WebHookAlertData, len(s))
+	errs := make(listener.ItemErrors)
+	for i, ser := range s {
+		r := &listener.WebHookAlertData{
+			Name:    d.Id,
+			Details: details,
+			Time:    t,
+			Status:  status,
+			Level:   d.Level,
+			Source:  "kapacitor",
+		}
+		sMap, _ := ser.(map[string]interface{})
+		tagMap, ok := sMap["tags"].(map[string]interface{})
+		if !ok {
+			errs[i] = fmt.Errorf("Invalid data received, no tags found")
+			continue
+		}
+		if device, ok := tagMap["device"].(string); ok {
+			r.Device = device
+		}
+		if entity, ok := tagMap["entity"].(string); ok {
+			r.Entity = entity
+		}
+		results[i] = r
 	}
-	if entity, ok := tagMap["entity"]; ok {
-		r.Entity = entity.(string)
+	if len(errs) > 0 {
+		if len(s) == 1 {
+			return nil, errs[0]
+		}
+		return results, errs
 	}
-	return r, nil
+	return results, nil
 }
 
 func init() {
@@ -34,7 +34,7 @@ func (p *Ns1Parser) Name() string {
 	return p.name
 }
 
-func (p *Ns1Parser) Parse(data []byte) (*listener.WebHookAlertData, error) {
+func (p *Ns1Parser) Parse(data []byte) ([]*listener.WebHookAlertData, error) {
 	d := ns1Data{}
 
 	if err := json.Unmarshal(data, &d); err != nil {
@@ -69,7 +69,7 @@ func (p *Ns1Parser) Parse(data []byte) (*listener.WebHookAlertData, error) {
 		l.Status = listener.Status_CLEARED
 	}
 
-	return l, nil
+	return []*listener.WebHookAlertData{l}, nil
 }
 
 func init() {
@@ -30,7 +30,7 @@ func (p *ObserviumParser) Name() string {
 	return p.name
 }
 
-func (p *ObserviumParser) Parse(data []byte) (*listener.WebHookAlertData, error) {
+func (p *ObserviumParser) Parse(data []byte) ([]*listener.WebHookAlertData, error) {
 	d := observiumData{}
 	if err := json.Unmarshal(data, &d); err != nil {
 		glog.Errorf("Unable to decode json: %v", err)
@@ -57,7 +57,7 @@ func (p *ObserviumParser) Parse(data []byte) (*listener.WebHookAlertData, error)
 	if strings.ToLower(d.State) == "recover" {
 		l.Status = listener.Status_CLEARED
 	}
-	return l, nil
+	return []*listener.WebHookAlertData{l}, nil
 }
 
 func init() {
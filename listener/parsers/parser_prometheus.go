@@ -0,0 +1,129 @@
+package parsers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mayuresh82/alert_manager/listener"
+)
+
+// prometheusData corresponds to the standard prometheus alertmanager webhook payload
+type prometheusData struct {
+	Version           string
+	GroupKey          string `json:"groupKey"`
+	Status            string // either "firing" or "resolved"
+	Receiver          string
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []prometheusAlert
+}
+
+type prometheusAlert struct {
+	Status       string
+	Labels       map[string]string
+	Annotations  map[string]string
+	StartsAt     string `json:"startsAt"`
+	EndsAt       string `json:"endsAt"`
+	GeneratorURL string `json:"generatorURL"`
+	Fingerprint  string
+}
+
+type PrometheusParser struct {
+	name string
+}
+
+func (p *PrometheusParser) Name() string {
+	return p.name
+}
+
+func (p *PrometheusParser) Parse(data []byte) ([]*listener.WebHookAlertData, error) {
+	d := prometheusData{}
+	if err := json.Unmarshal(data, &d); err != nil {
+		glog.Errorf("Unable to decode json: %v", err)
+		return nil, err
+	}
+	if len(d.Alerts) == 0 {
+		return nil, fmt.Errorf("Invalid data received, no alerts found")
+	}
+	// alerts without a name are reported per item, the others are still handled
+	results := make([]*listener.WebHookAlertData, len(d.Alerts))
+	errs := make(listener.ItemErrors)
+	for i, a := range d.Alerts {
+		name := a.Labels["alertname"]
+		if name == "" {
+			errs[i] = fmt.Errorf("Invalid data received, alertname label is mandatory")
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, a.StartsAt)
+		if err != nil {
+			glog.Errorf("Unable to parse time string , using current time")
+			t = time.Now()
+		}
+		l := &listener.WebHookAlertData{
+			Id:      a.Fingerprint,
+			Name:    name,
+			Details: prometheusDetails(a.Annotations),
+			Device:  a.Labels["device"],
+			Entity:  a.Labels["entity"],
+			Time:    t,
+			Level:   sevToLevel[strings.ToLower(a.Labels["severity"])],
+			Status:  listener.Status_ALERTING,
+			Source:  "prometheus",
+			Labels:  make(map[string]interface{}),
+		}
+		if l.Entity == "" {
+			l.Entity = a.Labels["instance"]
+		}
+		for k, v := range a.Labels {
+			l.Labels[k] = v
+		}
+		status := a.Status
+		if status == "" {
+			status = d.Status
+		}
+		if strings.ToLower(status) == "resolved" {
+			l.Status = listener.Status_CLEARED
+		}
+		results[i] = l
+	}
+	if len(errs) > 0 {
+		if len(d.Alerts) == 1 {
+			return nil, errs[0]
+		}
+		return results, errs
+	}
+	return results, nil
+}
+
+// prometheusDetails builds the alert description from the summary and
+// description annotations, falling back to any other annotations present.
+func prometheusDetails(annotations map[string]string) string {
+	var parts []string
+	for _, key := range []string{"summary", "description", "message"} {
+		if v, ok := annotations[key]; ok && v != "" {
+			parts = append(parts, v)
+		}
+	}
+	if len(parts) == 0 {
+		var keys []string
+		for k := range annotations {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			parts = append(parts, fmt.Sprintf("%s: %s", k, annotations[k]))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func init() {
+	parser := &PrometheusParser{name: "prometheus"}
+	listener.AddParser(parser)
+}
@@ -89,10 +89,12 @@ func TestParsing(t *testing.T) {
 		case "ns1":
 			parser = &Ns1Parser{name: "ns1"}
 		}
-		result, err := parser.Parse([]byte(data.raw))
+		results, err := parser.Parse([]byte(data.raw))
 		if err != nil {
 			t.Fatal(err)
 		}
+		assert.Equal(t, len(results), 1)
+		result := results[0]
 		assert.Equal(t, result.Id, data.parsed.Id)
 		assert.Equal(t, result.Name, data.parsed.Name)
 		assert.Equal(t, result.Details, data.parsed.Details)
@@ -106,10 +108,11 @@ func TestParsing(t *testing.T) {
 func TestParsingGeneric(t *testing.T) {
 	raw := `{"id": 1, "name": "Generic JSON alert", "entity": "ent1", "device": "dev1", "description": "its down", "timestamp": "2018-12-04T14:57:34-06:00", "severity": "info", "status": "alerting"}`
 	parser := &GenericParser{name: "generic"}
-	result, err := parser.Parse([]byte(raw))
+	results, err := parser.Parse([]byte(raw))
 	if err != nil {
 		t.Fatal(err)
 	}
+	result := results[0]
 	assert.Equal(t, result.Id, "1")
 	assert.Equal(t, result.Name, "Generic JSON alert")
 	assert.Equal(t, result.Entity, "ent1")
@@ -119,6 +122,139 @@ func TestParsingGeneric(t *testing.T) {
 	assert.Equal(t, result.Level, "INFO")
 
 	raw = `{"id": 1, "name": "Generic JSON alert", "entity": "ent1"}`
-	result, err = parser.Parse([]byte(raw))
+	_, err = parser.Parse([]byte(raw))
 	assert.Error(t, err)
+
+	// batch of alerts with one invalid item
+	raw = `[
+      {"id": 1, "name": "Generic JSON alert", "entity": "ent1", "description": "its down", "status": "alerting"},
+      {"id": 2, "name": "Generic JSON alert", "entity": "ent2"},
+      {"id": 3, "name": "Generic JSON alert", "entity": "ent3", "description": "its up", "status": "recover"}
+    ]`
+	results, err = parser.Parse([]byte(raw))
+	itemErrs, ok := err.(listener.ItemErrors)
+	assert.True(t, ok)
+	assert.Equal(t, len(itemErrs), 1)
+	assert.Error(t, itemErrs[1])
+	assert.Equal(t, len(results), 3)
+	assert.Equal(t, results[0].Entity, "ent1")
+	assert.Nil(t, results[1])
+	assert.Equal(t, results[2].Entity, "ent3")
+	assert.Equal(t, results[2].Status, listener.Status_CLEARED)
+}
+
+func TestParsingBatch(t *testing.T) {
+	raw := `{
+      "ruleId": 1,
+      "ruleName": "Neteng BB Input Errors Test",
+      "state": "alerting",
+      "message": "A BB Link is experiencing input errors",
+      "evalMatches": [
+        {"metric": "input_errors", "tags": {"device": "br1-sjc1", "interface": "et-0/0/3:0"}, "value": 1222},
+        {"metric": "input_errors", "tags": {"device": "br2-sjc1", "interface": "et-0/0/4:0"}, "value": 1500}
+      ]
+    }`
+	parser := &GrafanaParser{name: "grafana"}
+	results, err := parser.Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(results), 2)
+	assert.Equal(t, results[0].Device, "br1-sjc1")
+	assert.Equal(t, results[0].Entity, "et-0/0/3:0")
+	assert.Equal(t, results[1].Device, "br2-sjc1")
+	assert.Equal(t, results[1].Entity, "et-0/0/4:0")
+	assert.Equal(t, results[1].Details, "A BB Link is experiencing input errors\nMetric: input_errors, Value: 1500\n")
+
+	raw = `{"id":"Neteng Transit Util Out","message":"Transit util","details":"Transit Util exceeds","level":"CRITICAL","data":{"series":[
+      {"name":"jnpr_interface_stat","tags":{"device":"br2-lhr1","entity":"et-0/0/9:1"}},
+      {"name":"jnpr_interface_stat"},
+      {"name":"jnpr_interface_stat","tags":{"device":"br3-lhr1","entity":"et-0/0/1:0"}}
+    ]}}`
+	kparser := &KapacitorParser{name: "kapacitor"}
+	results, err = kparser.Parse([]byte(raw))
+	itemErrs, ok := err.(listener.ItemErrors)
+	assert.True(t, ok)
+	assert.Error(t, itemErrs[1])
+	assert.Equal(t, len(results), 3)
+	assert.Equal(t, results[0].Device, "br2-lhr1")
+	assert.Nil(t, results[1])
+	assert.Equal(t, results[2].Device, "br3-lhr1")
+	assert.Equal(t, results[2].Entity, "et-0/0/1:0")
+}
+
+func TestParsingPrometheus(t *testing.T) {
+	raw := `{
+      "version": "4",
+      "groupKey": "{}:{alertname=\"Neteng Device Down\"}",
+      "status": "firing",
+      "receiver": "alert_manager",
+      "groupLabels": {"alertname": "Neteng Device Down"},
+      "commonLabels": {"alertname": "Neteng Device Down", "severity": "critical"},
+      "commonAnnotations": {},
+      "externalURL": "http://prometheus:9093",
+      "alerts": [
+        {
+          "status": "firing",
+          "labels": {"alertname": "Neteng Device Down", "device": "br1-sjc1", "instance": "10.1.1.1:9100", "severity": "critical", "region": "us-west"},
+          "annotations": {"summary": "Device br1-sjc1 is down", "description": "No response for 5m"},
+          "startsAt": "2018-12-04T14:57:34Z",
+          "endsAt": "0001-01-01T00:00:00Z",
+          "generatorURL": "http://prometheus:9090/graph",
+          "fingerprint": "6a2b0d1f3c4e5a6b"
+        },
+        {
+          "status": "resolved",
+          "labels": {"alertname": "Neteng Device Down", "device": "br2-sjc1", "entity": "br2-sjc1", "severity": "warning"},
+          "annotations": {"runbook": "http://wiki/device_down"},
+          "startsAt": "2018-12-04T14:50:00Z",
+          "endsAt": "2018-12-04T14:58:00Z",
+          "fingerprint": "7b3c1e2f4d5a6b7c"
+        }
+      ]
+    }`
+	parser := &PrometheusParser{name: "prometheus"}
+	results, err := parser.Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(results), 2)
+
+	assert.Equal(t, results[0].Id, "6a2b0d1f3c4e5a6b")
+	assert.Equal(t, results[0].Name, "Neteng Device Down")
+	assert.Equal(t, results[0].Details, "Device br1-sjc1 is down\nNo response for 5m")
+	assert.Equal(t, results[0].Device, "br1-sjc1")
+	assert.Equal(t, results[0].Entity, "10.1.1.1:9100")
+	assert.Equal(t, results[0].Level, "CRITICAL")
+	assert.Equal(t, results[0].Status, listener.Status_ALERTING)
+	assert.Equal(t, results[0].Source, "prometheus")
+	assert.Equal(t, results[0].Labels["region"], "us-west")
+
+	assert.Equal(t, results[1].Id, "7b3c1e2f4d5a6b7c")
+	assert.Equal(t, results[1].Details, "runbook: http://wiki/device_down")
+	assert.Equal(t, results[1].Entity, "br2-sjc1")
+	assert.Equal(t, results[1].Level, "WARN")
+	assert.Equal(t, results[1].Status, listener.Status_CLEARED)
+
+	// missing alertname
+	raw = `{"status": "firing", "alerts": [{"status": "firing", "labels": {"instance": "foo"}}]}`
+	_, err = parser.Parse([]byte(raw))
+	assert.Error(t, err)
+
+	// the other alerts of the payload are still returned
+	raw = `{"status": "firing", "alerts": [
+      {"status": "firing", "labels": {"alertname": "Neteng Device Down", "instance": "foo"}},
+      {"status": "firing", "labels": {"instance": "bar"}},
+      {"status": "resolved", "labels": {"alertname": "Neteng Device Down", "instance": "baz"}}
+    ]}`
+	results, err = parser.Parse([]byte(raw))
+	itemErrs, ok := err.(listener.ItemErrors)
+	assert.True(t, ok)
+	assert.Equal(t, len(itemErrs), 1)
+	assert.Error(t, itemErrs[1])
+	assert.Equal(t, len(results), 3)
+	assert.Equal(t, results[0].Entity, "foo")
+	assert.Nil(t, results[1])
+	assert.Equal(t, results[2].Entity, "baz")
+	assert.Equal(t, results[2].Status, listener.Status_CLEARED)
 }
@@ -0,0 +1,207 @@
+package listener
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	STORM_ALERT_NAME     = "Alert Storm"
+	STORM_SWEEP_INTERVAL = 10 * time.Second
+	defaultLimitInterval = time.Minute
+)
+
+// RateLimit is a token bucket limit on the number of alerts accepted by the webhook listener.
+// Source and team select the requests the limit applies to: an empty value matches all
+// requests with a single shared bucket, "*" matches all requests with a separate bucket per
+// value, and anything else only matches that value.
+type RateLimit struct {
+	Source string
+	Team   string
+	// number of alerts allowed per interval
+	Rate float64
+	// defaults to 1m
+	Interval time.Duration
+	// max alerts allowed in a burst, defaults to rate
+	Burst float64
+	// if set, exceeding the limit raises a single storm alert in place of the dropped alerts
+	// until the rate of incoming alerts drops below the limit for a whole interval
+	Storm bool
+}
+
+func (l *RateLimit) init() error {
+	if l.Rate <= 0 {
+		return fmt.Errorf("Rate limit for source %q team %q requires a positive rate", l.Source, l.Team)
+	}
+	if l.Interval == 0 {
+		l.Interval = defaultLimitInterval
+	}
+	if l.Burst == 0 {
+		l.Burst = l.Rate
+	}
+	return nil
+}
+
+func matchLimit(limit, value string) bool {
+	return limit == "" || limit == "*" || limit == value
+}
+
+// key returns the bucket key of a request for this limit
+func (l *RateLimit) key(source, team string) (string, string) {
+	if l.Source == "" {
+		source = ""
+	}
+	if l.Team == "" {
+		team = ""
+	}
+	return source, team
+}
+
+// storm describes an ongoing alert storm on a bucket
+type storm struct {
+	Source, Team string
+	Limit        *RateLimit
+	Start        time.Time
+	// alerts dropped since the storm started
+	Dropped int
+}
+
+// Entity identifies the bucket of a storm
+func (s *storm) Entity() string {
+	var parts []string
+	if s.Source != "" {
+		parts = append(parts, "source="+s.Source)
+	}
+	if s.Team != "" {
+		parts = append(parts, "team="+s.Team)
+	}
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, ",")
+}
+
+type bucket struct {
+	limit  *RateLimit
+	tokens float64
+	last   time.Time
+	// alerts received in the current interval
+	windowStart time.Time
+	windowCount float64
+	storm       *storm
+}
+
+type rateLimiter struct {
+	limits  []*RateLimit
+	buckets map[string]*bucket
+	sync.Mutex
+}
+
+func newRateLimiter(limits []*RateLimit) (*rateLimiter, error) {
+	for _, l := range limits {
+		if err := l.init(); err != nil {
+			return nil, err
+		}
+	}
+	return &rateLimiter{limits: limits, buckets: make(map[string]*bucket)}, nil
+}
+
+func (r *rateLimiter) bucket(i int, source, team string, now time.Time) *bucket {
+	key := fmt.Sprintf("%d/%s/%s", i, source, team)
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &bucket{limit: r.limits[i], tokens: r.limits[i].Burst, last: now, windowStart: now}
+		r.buckets[key] = b
+	}
+	return b
+}
+
+// refill adds tokens for the time passed since the last update and ends the storm on
+// the bucket if the previous interval saw no more alerts than the limit allows
+func (b *bucket) refill(now time.Time) (ended *storm) {
+	l := b.limit
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(l.Burst, b.tokens+l.Rate*float64(elapsed)/float64(l.Interval))
+		b.last = now
+	}
+	if now.Sub(b.windowStart) < l.Interval {
+		return nil
+	}
+	if b.storm != nil && b.windowCount <= l.Rate {
+		ended = b.storm
+		b.storm = nil
+	}
+	b.windowStart = now
+	b.windowCount = 0
+	return ended
+}
+
+// allow checks whether n alerts from source and team are within all matching limits and takes
+// the tokens if they are. It also returns storms that started or ended on the way.
+func (r *rateLimiter) allow(source, team string, n int, now time.Time) (ok bool, retryAfter time.Duration, started, ended []*storm) {
+	r.Lock()
+	defer r.Unlock()
+	type match struct {
+		limit *RateLimit
+		b     *bucket
+		src   string
+		team  string
+	}
+	var matches []match
+	ok = true
+	for i, l := range r.limits {
+		if !matchLimit(l.Source, source) || !matchLimit(l.Team, team) {
+			continue
+		}
+		src, tm := l.key(source, team)
+		b := r.bucket(i, src, tm, now)
+		if s := b.refill(now); s != nil {
+			ended = append(ended, s)
+		}
+		b.windowCount += float64(n)
+		matches = append(matches, match{l, b, src, tm})
+		if b.storm == nil && b.tokens >= float64(n) {
+			continue
+		}
+		ok = false
+		wait := l.Interval
+		if b.storm == nil && float64(n) <= l.Burst {
+			wait = time.Duration((float64(n) - b.tokens) / l.Rate * float64(l.Interval))
+		}
+		if wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	for _, m := range matches {
+		if ok {
+			m.b.tokens -= float64(n)
+			continue
+		}
+		if m.b.storm == nil && m.limit.Storm && m.b.tokens < float64(n) {
+			m.b.storm = &storm{Source: m.src, Team: m.team, Limit: m.limit, Start: now}
+			started = append(started, m.b.storm)
+		}
+		if m.b.storm != nil {
+			m.b.storm.Dropped += n
+		}
+	}
+	return ok, retryAfter, started, ended
+}
+
+// sweep ends storms on buckets that have gone quiet and removes idle buckets
+func (r *rateLimiter) sweep(now time.Time) (ended []*storm) {
+	r.Lock()
+	defer r.Unlock()
+	for key, b := range r.buckets {
+		if s := b.refill(now); s != nil {
+			ended = append(ended, s)
+		}
+		if b.storm == nil && b.tokens >= b.limit.Burst && b.windowCount == 0 {
+			delete(r.buckets, key)
+		}
+	}
+	return ended
+}
@@ -0,0 +1,116 @@
+package listener
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ah "github.com/mayuresh82/alert_manager/handler"
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter, err := newRateLimiter([]*RateLimit{
+		{Source: "grafana", Rate: 10, Interval: time.Minute},
+		{Source: "*", Team: "*", Rate: 5, Interval: time.Minute, Burst: 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	// per source and team bucket allows a burst of 8
+	ok, _, _, _ := limiter.allow("kapacitor", "t1", 8, now)
+	assert.True(t, ok)
+	ok, retry, _, _ := limiter.allow("kapacitor", "t1", 1, now)
+	assert.False(t, ok)
+	assert.Equal(t, retry, 12*time.Second)
+	// separate bucket for a different team
+	ok, _, _, _ = limiter.allow("kapacitor", "t2", 1, now)
+	assert.True(t, ok)
+	// refilled after 12s
+	ok, _, _, _ = limiter.allow("kapacitor", "t1", 1, now.Add(12*time.Second))
+	assert.True(t, ok)
+
+	// grafana is also limited to 10 across all teams
+	for _, team := range []string{"t1", "t2"} {
+		ok, _, _, _ = limiter.allow("grafana", team, 5, now)
+		assert.True(t, ok)
+	}
+	ok, _, _, _ = limiter.allow("grafana", "t3", 1, now)
+	assert.False(t, ok)
+	// a rejected request does not take tokens from the other buckets
+	ok, _, _, _ = limiter.allow("grafana", "t3", 1, now.Add(6*time.Second))
+	assert.True(t, ok)
+
+	_, err = newRateLimiter([]*RateLimit{{Source: "grafana"}})
+	assert.Error(t, err)
+}
+
+func TestRateLimiterStorm(t *testing.T) {
+	limiter, _ := newRateLimiter([]*RateLimit{{Team: "*", Rate: 10, Interval: time.Minute, Storm: true}})
+	now := time.Now()
+
+	ok, _, started, _ := limiter.allow("grafana", "t1", 10, now)
+	assert.True(t, ok)
+	assert.Equal(t, len(started), 0)
+	ok, _, started, _ = limiter.allow("grafana", "t1", 5, now)
+	assert.False(t, ok)
+	assert.Equal(t, len(started), 1)
+	s := started[0]
+	assert.Equal(t, s.Entity(), "team=t1")
+	// storm is only started once, and drops alerts even when tokens are available
+	ok, _, started, _ = limiter.allow("grafana", "t1", 1, now.Add(30*time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, len(started), 0)
+	assert.Equal(t, s.Dropped, 6)
+
+	// rate is still above the limit in the next interval
+	ok, _, _, ended := limiter.allow("grafana", "t1", 11, now.Add(61*time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, len(ended), 0)
+	assert.Equal(t, len(limiter.sweep(now.Add(90*time.Second))), 0)
+	// quiet interval ends the storm
+	ended = limiter.sweep(now.Add(122 * time.Second))
+	assert.Equal(t, len(ended), 0)
+	ended = limiter.sweep(now.Add(183 * time.Second))
+	assert.Equal(t, ended, []*storm{s})
+	assert.Equal(t, s.Dropped, 17)
+	ok, _, _, _ = limiter.allow("grafana", "t1", 1, now.Add(184*time.Second))
+	assert.True(t, ok)
+}
+
+func TestAlertHandlerRateLimit(t *testing.T) {
+	lis := newMockListener()
+	lis.limiter, _ = newRateLimiter([]*RateLimit{{Source: "mocked", Rate: 1, Storm: true}})
+
+	send := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/listener/alert/?source=mocked&team=t1", bytes.NewReader([]byte("blah")))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(lis.httpHandler).ServeHTTP(rr, req)
+		return rr
+	}
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send() }()
+	event := <-ah.ListenChan
+	assert.Equal(t, event.Alert.Name, "Test Alert")
+	assert.Equal(t, (<-done).Code, http.StatusOK)
+
+	// over the limit, a single storm alert is sent
+	go func() { done <- send() }()
+	event = <-ah.ListenChan
+	assert.Equal(t, event.Type, models.EventType_ACTIVE)
+	assert.Equal(t, event.Alert.Name, STORM_ALERT_NAME)
+	assert.Equal(t, event.Alert.Entity, "source=mocked")
+	assert.Equal(t, event.Alert.Team, "default")
+	assert.True(t, event.Alert.AutoClear)
+	rr := <-done
+	assert.Equal(t, rr.Code, http.StatusTooManyRequests)
+	assert.Equal(t, rr.Header().Get("Retry-After"), "60")
+
+	rr = send()
+	assert.Equal(t, rr.Code, http.StatusTooManyRequests)
+}
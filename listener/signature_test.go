@@ -0,0 +1,144 @@
+package listener
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mayuresh82/alert_manager/internal/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+// countStat is a stat that records the total added to it
+type countStat struct {
+	Value int64
+}
+
+func (c *countStat) Add(value int64) { c.Value += value }
+func (c *countStat) Set(value int64) { c.Value = value }
+func (c *countStat) Reset()          { c.Value = 0 }
+
+func sign(h func() hash.Hash, secret string, data []byte) []byte {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func TestSignatureVerify(t *testing.T) {
+	body := []byte(`{"name": "Test Alert"}`)
+	now := time.Now()
+
+	// github style
+	s := &SourceSecret{Source: "github", Secret: "s3cr3t", Header: "X-Hub-Signature-256", Prefix: "sha256="}
+	if err := s.init(); err != nil {
+		t.Fatal(err)
+	}
+	hdr := http.Header{}
+	hdr.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(sign(sha256.New, "s3cr3t", body)))
+	assert.Nil(t, s.verify(hdr, body, now))
+	assert.Error(t, s.verify(hdr, []byte(`{"name": "Other Alert"}`), now))
+	hdr.Set("X-Hub-Signature-256", hex.EncodeToString(sign(sha256.New, "s3cr3t", body)))
+	assert.Error(t, s.verify(hdr, body, now))
+	assert.Error(t, s.verify(http.Header{}, body, now))
+
+	// base64 sha1
+	s = &SourceSecret{Source: "custom", Secret: "s3cr3t", Algorithm: "sha1", Encoding: "base64"}
+	if err := s.init(); err != nil {
+		t.Fatal(err)
+	}
+	hdr = http.Header{}
+	hdr.Set(defaultSignatureHeader, base64.StdEncoding.EncodeToString(sign(sha1.New, "s3cr3t", body)))
+	assert.Nil(t, s.verify(hdr, body, now))
+	hdr.Set(defaultSignatureHeader, base64.StdEncoding.EncodeToString(sign(sha1.New, "wrong", body)))
+	assert.Error(t, s.verify(hdr, body, now))
+
+	// timestamped
+	s = &SourceSecret{Source: "custom", Secret: "s3cr3t", TimestampHeader: "X-Timestamp", Tolerance: time.Minute}
+	if err := s.init(); err != nil {
+		t.Fatal(err)
+	}
+	signed := func(at time.Time) http.Header {
+		ts := strconv.FormatInt(at.Unix(), 10)
+		h := http.Header{}
+		h.Set("X-Timestamp", ts)
+		h.Set(defaultSignatureHeader, hex.EncodeToString(sign(sha256.New, "s3cr3t", append([]byte(ts+"."), body...))))
+		return h
+	}
+	hdr = signed(now.Add(-30 * time.Second))
+	assert.Nil(t, s.verify(hdr, body, now))
+	// replay of the same request
+	assert.Error(t, s.verify(hdr, body, now))
+	// outside the tolerance window
+	assert.Error(t, s.verify(signed(now.Add(-2*time.Minute)), body, now))
+	assert.Error(t, s.verify(signed(now.Add(2*time.Minute)), body, now))
+	// timestamp not covered by the signature
+	hdr = signed(now)
+	hdr.Set("X-Timestamp", strconv.FormatInt(now.Unix()-1, 10))
+	assert.Error(t, s.verify(hdr, body, now))
+
+	// invalid configs
+	assert.Error(t, (&SourceSecret{Source: "custom"}).init())
+	assert.Error(t, (&SourceSecret{Source: "custom", Secret: "x", Algorithm: "md5"}).init())
+	assert.Error(t, (&SourceSecret{Source: "custom", Secret: "x", Encoding: "base32"}).init())
+}
+
+func TestVerifySignatureHandler(t *testing.T) {
+	lis := newMockListener()
+	lis.Secrets = []*SourceSecret{{Source: "mocked", Secret: "s3cr3t"}}
+	lis.registerSecrets()
+	total, mocked, batch := &countStat{}, &countStat{}, &countStat{}
+	lis.statsAuthFailures = total
+	lis.statsSourceAuthFailures = map[string]stats.Stat{"mocked": mocked, "mocked_batch": batch}
+
+	var received []byte
+	handler := lis.basicAuth(lis.verifySignature(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	body := []byte("blah")
+
+	req, _ := http.NewRequest("POST", "/listener/alert/?source=mocked", bytes.NewReader(body))
+	req.Header.Set(defaultSignatureHeader, hex.EncodeToString(sign(sha256.New, "s3cr3t", body)))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, received, body)
+
+	received = nil
+	req, _ = http.NewRequest("POST", "/listener/alert/?source=mocked", bytes.NewReader(body))
+	req.Header.Set(defaultSignatureHeader, hex.EncodeToString(sign(sha256.New, "wrong", body)))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+	assert.Nil(t, received)
+	assert.Equal(t, total.Value, int64(1))
+	assert.Equal(t, mocked.Value, int64(1))
+
+	// sources without a secret fall back to basic auth
+	lis.UseAuth = true
+	lis.Username, lis.Password = "user", "pass"
+	req, _ = http.NewRequest("POST", "/listener/alert/?source=mocked_batch", bytes.NewReader(body))
+	req.SetBasicAuth("user", "bad")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+	assert.Nil(t, received)
+	assert.Equal(t, total.Value, int64(2))
+	assert.Equal(t, batch.Value, int64(1))
+
+	req, _ = http.NewRequest("POST", "/listener/alert/?source=mocked_batch", bytes.NewReader(body))
+	req.SetBasicAuth("user", "pass")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, received, body)
+}
@@ -0,0 +1,231 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// minimal BER decoding of SNMPv2c trap messages (RFC 3416)
+
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOid         = 0x06
+	berSequence    = 0x30
+	berIpAddress   = 0x40
+	berCounter32   = 0x41
+	berGauge32     = 0x42
+	berTimeTicks   = 0x43
+	berOpaque      = 0x44
+	berCounter64   = 0x46
+
+	berNoSuchObject   = 0x80
+	berNoSuchInstance = 0x81
+	berEndOfMibView   = 0x82
+
+	pduTrapV2 = 0xa7
+
+	snmpVersion2c = 1
+)
+
+const (
+	oidSnmpTrapOid     = "1.3.6.1.6.3.1.1.4.1.0"
+	oidSnmpTrapAddress = "1.3.6.1.6.3.18.1.3.0"
+)
+
+type varbind struct {
+	Oid   string
+	Value interface{}
+}
+
+// snmpTrap is a decoded SNMPv2c trap
+type snmpTrap struct {
+	Community string
+	TrapOid   string
+	Varbinds  []varbind
+}
+
+// Get returns the value of the first varbind whose oid equals or is a child of oid
+func (t *snmpTrap) Get(oid string) (interface{}, bool) {
+	for _, vb := range t.Varbinds {
+		if vb.Oid == oid || strings.HasPrefix(vb.Oid, oid+".") {
+			return vb.Value, true
+		}
+	}
+	return nil, false
+}
+
+type berElem struct {
+	tag   byte
+	value []byte
+}
+
+// readElem reads a single TLV element and returns it with the remaining bytes
+func readElem(data []byte) (berElem, []byte, error) {
+	if len(data) < 2 {
+		return berElem{}, nil, fmt.Errorf("SNMP: truncated element")
+	}
+	tag := data[0]
+	length := int(data[1])
+	offset := 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return berElem{}, nil, fmt.Errorf("SNMP: invalid length")
+		}
+		length = 0
+		for _, b := range data[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		offset += n
+	}
+	if length < 0 || len(data) < offset+length {
+		return berElem{}, nil, fmt.Errorf("SNMP: truncated element")
+	}
+	return berElem{tag: tag, value: data[offset : offset+length]}, data[offset+length:], nil
+}
+
+func decodeInt(b []byte) int64 {
+	var v int64
+	for i, c := range b {
+		if i == 0 && c&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+func decodeUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func decodeOid(b []byte) (string, error) {
+	if len(b) == 0 {
+		return "", fmt.Errorf("SNMP: empty oid")
+	}
+	var parts []string
+	var v uint64
+	for i, c := range b {
+		v = v<<7 | uint64(c&0x7f)
+		if c&0x80 != 0 {
+			if i == len(b)-1 {
+				return "", fmt.Errorf("SNMP: truncated oid")
+			}
+			continue
+		}
+		if len(parts) == 0 {
+			// first sub-identifier encodes the first two arcs
+			first := v / 40
+			if first > 2 {
+				first = 2
+			}
+			parts = append(parts, strconv.FormatUint(first, 10), strconv.FormatUint(v-first*40, 10))
+		} else {
+			parts = append(parts, strconv.FormatUint(v, 10))
+		}
+		v = 0
+	}
+	return strings.Join(parts, "."), nil
+}
+
+func decodeValue(e berElem) (interface{}, error) {
+	switch e.tag {
+	case berInteger:
+		return decodeInt(e.value), nil
+	case berOctetString, berOpaque:
+		return string(e.value), nil
+	case berNull, berNoSuchObject, berNoSuchInstance, berEndOfMibView:
+		return nil, nil
+	case berOid:
+		return decodeOid(e.value)
+	case berIpAddress:
+		if len(e.value) != 4 {
+			return nil, fmt.Errorf("SNMP: invalid IpAddress")
+		}
+		return net.IP(e.value).String(), nil
+	case berCounter32, berGauge32, berTimeTicks, berCounter64:
+		return decodeUint(e.value), nil
+	}
+	return nil, fmt.Errorf("SNMP: unsupported value type 0x%x", e.tag)
+}
+
+// parseTrap decodes an SNMPv2c trap message
+func parseTrap(data []byte) (*snmpTrap, error) {
+	msg, _, err := readElem(data)
+	if err != nil {
+		return nil, err
+	}
+	if msg.tag != berSequence {
+		return nil, fmt.Errorf("SNMP: invalid message")
+	}
+	version, rest, err := readElem(msg.value)
+	if err != nil {
+		return nil, err
+	}
+	if version.tag != berInteger || decodeInt(version.value) != snmpVersion2c {
+		return nil, fmt.Errorf("SNMP: only v2c traps are supported")
+	}
+	community, rest, err := readElem(rest)
+	if err != nil {
+		return nil, err
+	}
+	pdu, _, err := readElem(rest)
+	if err != nil {
+		return nil, err
+	}
+	if pdu.tag != pduTrapV2 {
+		return nil, fmt.Errorf("SNMP: unsupported pdu type 0x%x", pdu.tag)
+	}
+	// skip request-id, error-status and error-index
+	rest = pdu.value
+	for i := 0; i < 3; i++ {
+		if _, rest, err = readElem(rest); err != nil {
+			return nil, err
+		}
+	}
+	vbList, _, err := readElem(rest)
+	if err != nil {
+		return nil, err
+	}
+	trap := &snmpTrap{Community: string(community.value)}
+	rest = vbList.value
+	for len(rest) > 0 {
+		var (
+			vbSeq, oidElem, valElem berElem
+			valRest                 []byte
+		)
+		if vbSeq, rest, err = readElem(rest); err != nil {
+			return nil, err
+		}
+		if oidElem, valRest, err = readElem(vbSeq.value); err != nil {
+			return nil, err
+		}
+		if valElem, _, err = readElem(valRest); err != nil {
+			return nil, err
+		}
+		oid, err := decodeOid(oidElem.value)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeValue(valElem)
+		if err != nil {
+			return nil, err
+		}
+		if oid == oidSnmpTrapOid {
+			trap.TrapOid, _ = value.(string)
+		}
+		trap.Varbinds = append(trap.Varbinds, varbind{Oid: oid, Value: value})
+	}
+	if trap.TrapOid == "" {
+		return nil, fmt.Errorf("SNMP: trap has no snmpTrapOID")
+	}
+	return trap, nil
+}
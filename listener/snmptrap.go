@@ -0,0 +1,204 @@
+package listener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	ah "github.com/mayuresh82/alert_manager/handler"
+	"github.com/mayuresh82/alert_manager/internal/stats"
+	"github.com/mayuresh82/alert_manager/plugins"
+)
+
+const snmpMaxMsgSize = 65535
+
+// TrapMapping maps a trap oid (and an optional paired clear trap oid) to an alert
+type TrapMapping struct {
+	// Name of the alert to raise
+	Name string
+	// snmpTrapOID of the trap that raises the alert
+	TrapOid string `mapstructure:"trap_oid"`
+	// snmpTrapOID of the trap that clears the alert (optional)
+	ClearOid string `mapstructure:"clear_oid"`
+	// varbind oid ( or oid prefix ) whose value becomes the alert entity
+	EntityOid string `mapstructure:"entity_oid"`
+	// alert severity, defaults to WARN
+	Severity string
+	// map of label names to varbind oids ( or oid prefixes ) to add as alert labels
+	Labels map[string]string
+}
+
+func (m *TrapMapping) apply(trap *snmpTrap, device string) *WebHookAlertData {
+	status := Status_ALERTING
+	switch trap.TrapOid {
+	case m.TrapOid:
+	case m.ClearOid:
+		if m.ClearOid == "" {
+			return nil
+		}
+		status = Status_CLEARED
+	default:
+		return nil
+	}
+	d := &WebHookAlertData{
+		Name:   m.Name,
+		Device: device,
+		Entity: device,
+		Time:   time.Now(),
+		Level:  m.Severity,
+		Status: status,
+		Source: "snmptrap",
+		Labels: make(map[string]interface{}),
+	}
+	if d.Level == "" {
+		d.Level = "WARN"
+	}
+	if m.EntityOid != "" {
+		if v, ok := trap.Get(m.EntityOid); ok && v != nil {
+			d.Entity = fmt.Sprintf("%v", v)
+		}
+	}
+	for label, oid := range m.Labels {
+		if v, ok := trap.Get(oid); ok && v != nil {
+			d.Labels[label] = v
+		}
+	}
+	var vbs []string
+	for _, vb := range trap.Varbinds {
+		if vb.Oid == oidSnmpTrapOid {
+			continue
+		}
+		vbs = append(vbs, fmt.Sprintf("%s = %v", vb.Oid, vb.Value))
+	}
+	d.Details = fmt.Sprintf("Trap %s from %s\n%s", trap.TrapOid, device, strings.Join(vbs, "\n"))
+	return d
+}
+
+// SnmpTrapListener receives SNMPv2c traps over UDP and converts traps matching
+// the configured mappings into alerts
+type SnmpTrapListener struct {
+	ListenAddr string `mapstructure:"listen_addr"`
+	// if set, traps with a different community string are dropped
+	Community string
+	// team to assign trap alerts to
+	Team     string
+	Mappings []*TrapMapping
+
+	statTrapsRecvd   stats.Stat
+	statTrapsError   stats.Stat
+	statTrapsMatched stats.Stat
+}
+
+func NewSnmpTrapListener() *SnmpTrapListener {
+	return &SnmpTrapListener{
+		statTrapsRecvd:   stats.NewCounter("listener.snmptrap.traps_recvd"),
+		statTrapsError:   stats.NewCounter("listener.snmptrap.traps_err"),
+		statTrapsMatched: stats.NewCounter("listener.snmptrap.traps_matched"),
+	}
+}
+
+func (s *SnmpTrapListener) Name() string {
+	return "snmptrap"
+}
+
+func (s *SnmpTrapListener) GetParsersList() []string {
+	return []string{}
+}
+
+func (s *SnmpTrapListener) Uri() string {
+	return "udp://" + s.ListenAddr
+}
+
+// handleTrap decodes a raw trap received from addr and sends an alert event for the first matching mapping
+func (s *SnmpTrapListener) handleTrap(data []byte, addr net.Addr) {
+	s.statTrapsRecvd.Add(1)
+	trap, err := parseTrap(data)
+	if err != nil {
+		glog.V(2).Infof("SnmpTrap: %v", err)
+		s.statTrapsError.Add(1)
+		return
+	}
+	if s.Community != "" && trap.Community != s.Community {
+		glog.V(2).Infof("SnmpTrap: Dropping trap from %v with invalid community", addr)
+		s.statTrapsError.Add(1)
+		return
+	}
+	// prefer the agent address from the trap if it was forwarded
+	var device string
+	if v, ok := trap.Get(oidSnmpTrapAddress); ok {
+		device, _ = v.(string)
+	}
+	if device == "" && addr != nil {
+		device = addr.String()
+		if host, _, err := net.SplitHostPort(device); err == nil {
+			device = host
+		}
+	}
+	team := s.Team
+	if team == "" {
+		team = "default"
+	}
+	for _, m := range s.Mappings {
+		data := m.apply(trap, device)
+		if data == nil {
+			continue
+		}
+		s.statTrapsMatched.Add(1)
+		event, err := formatAlertEvent(data, team)
+		if err != nil {
+			glog.Errorf("SnmpTrap: %v", err)
+			s.statTrapsError.Add(1)
+			return
+		}
+		if err := ah.Send(event); err != nil {
+			glog.Errorf("Failed to send alert event: %v", err)
+		}
+		return
+	}
+	glog.V(4).Infof("SnmpTrap: No mapping found for trap %s from %s", trap.TrapOid, device)
+}
+
+func (s *SnmpTrapListener) Listen(ctx context.Context) {
+	if s.ListenAddr == "" {
+		glog.V(2).Infof("SnmpTrap: no listen address configured, not starting")
+		return
+	}
+	for _, m := range s.Mappings {
+		m.TrapOid = strings.TrimPrefix(m.TrapOid, ".")
+		m.ClearOid = strings.TrimPrefix(m.ClearOid, ".")
+		m.EntityOid = strings.TrimPrefix(m.EntityOid, ".")
+		for label, oid := range m.Labels {
+			m.Labels[label] = strings.TrimPrefix(oid, ".")
+		}
+	}
+	conn, err := net.ListenPacket("udp", s.ListenAddr)
+	if err != nil {
+		glog.Errorf("SnmpTrap: Unable to listen on %s: %v", s.ListenAddr, err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, snmpMaxMsgSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				glog.Errorf("SnmpTrap: read error: %v", err)
+			}
+			return
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		s.handleTrap(data, addr)
+	}
+}
+
+func init() {
+	listener := NewSnmpTrapListener()
+	plugins.AddListener(listener)
+}
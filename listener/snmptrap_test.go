@@ -0,0 +1,160 @@
+package listener
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	ah "github.com/mayuresh82/alert_manager/handler"
+	"github.com/mayuresh82/alert_manager/internal/models"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// helpers to BER encode test traps
+
+func berEncode(tag byte, value []byte) []byte {
+	l := len(value)
+	var hdr []byte
+	switch {
+	case l < 0x80:
+		hdr = []byte{tag, byte(l)}
+	case l < 0x100:
+		hdr = []byte{tag, 0x81, byte(l)}
+	default:
+		hdr = []byte{tag, 0x82, byte(l >> 8), byte(l)}
+	}
+	return append(hdr, value...)
+}
+
+func berOidValue(oid string) []byte {
+	parts := strings.Split(oid, ".")
+	var ids []uint64
+	for _, p := range parts {
+		v, _ := strconv.ParseUint(p, 10, 64)
+		ids = append(ids, v)
+	}
+	out := []byte{byte(ids[0]*40 + ids[1])}
+	for _, id := range ids[2:] {
+		var enc []byte
+		enc = append(enc, byte(id&0x7f))
+		for id >>= 7; id > 0; id >>= 7 {
+			enc = append([]byte{byte(id&0x7f) | 0x80}, enc...)
+		}
+		out = append(out, enc...)
+	}
+	return out
+}
+
+func berVarbind(oid string, tag byte, value []byte) []byte {
+	return berEncode(berSequence, append(berEncode(berOid, berOidValue(oid)), berEncode(tag, value)...))
+}
+
+func buildTrap(community, trapOid string, varbinds ...[]byte) []byte {
+	vbs := berVarbind("1.3.6.1.2.1.1.3.0", berTimeTicks, []byte{0x01, 0x00})
+	vbs = append(vbs, berVarbind(oidSnmpTrapOid, berOid, berOidValue(trapOid))...)
+	for _, vb := range varbinds {
+		vbs = append(vbs, vb...)
+	}
+	pdu := berEncode(berInteger, []byte{0x01})
+	pdu = append(pdu, berEncode(berInteger, []byte{0x00})...)
+	pdu = append(pdu, berEncode(berInteger, []byte{0x00})...)
+	pdu = append(pdu, berEncode(berSequence, vbs)...)
+	msg := berEncode(berInteger, []byte{snmpVersion2c})
+	msg = append(msg, berEncode(berOctetString, []byte(community))...)
+	msg = append(msg, berEncode(pduTrapV2, pdu)...)
+	return berEncode(berSequence, msg)
+}
+
+func TestParseTrap(t *testing.T) {
+	raw := buildTrap("public", "1.3.6.1.6.3.1.1.5.3",
+		berVarbind("1.3.6.1.2.1.2.2.1.1.520", berInteger, []byte{0x02, 0x08}),
+		berVarbind("1.3.6.1.2.1.2.2.1.2.520", berOctetString, []byte("et-0/0/1")),
+		berVarbind(oidSnmpTrapAddress, berIpAddress, []byte{10, 1, 1, 1}),
+	)
+	trap, err := parseTrap(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, trap.Community, "public")
+	assert.Equal(t, trap.TrapOid, "1.3.6.1.6.3.1.1.5.3")
+	assert.Equal(t, len(trap.Varbinds), 5)
+	v, ok := trap.Get("1.3.6.1.2.1.2.2.1.1")
+	assert.True(t, ok)
+	assert.Equal(t, v, int64(520))
+	v, ok = trap.Get("1.3.6.1.2.1.2.2.1.2")
+	assert.True(t, ok)
+	assert.Equal(t, v, "et-0/0/1")
+	v, _ = trap.Get(oidSnmpTrapAddress)
+	assert.Equal(t, v, "10.1.1.1")
+
+	// truncated
+	_, err = parseTrap(raw[:len(raw)-5])
+	assert.Error(t, err)
+}
+
+func TestSnmpTrapHandle(t *testing.T) {
+	s := &SnmpTrapListener{
+		Community: "public",
+		Mappings: []*TrapMapping{
+			{
+				Name:      "Trap Link Down",
+				TrapOid:   "1.3.6.1.6.3.1.1.5.3",
+				ClearOid:  "1.3.6.1.6.3.1.1.5.4",
+				EntityOid: "1.3.6.1.2.1.2.2.1.2",
+				Labels:    map[string]string{"ifIndex": "1.3.6.1.2.1.2.2.1.1"},
+			},
+			{
+				Name:      "Trap BGP Down",
+				TrapOid:   "1.3.6.1.2.1.15.0.2",
+				ClearOid:  "1.3.6.1.2.1.15.0.1",
+				EntityOid: "1.3.6.1.2.1.15.3.1.7",
+				Severity:  "CRITICAL",
+			},
+		},
+		statTrapsRecvd:   &tu.MockStat{},
+		statTrapsError:   &tu.MockStat{},
+		statTrapsMatched: &tu.MockStat{},
+	}
+	addr := &net.UDPAddr{IP: net.ParseIP("10.2.2.2"), Port: 1162}
+	linkVbs := [][]byte{
+		berVarbind("1.3.6.1.2.1.2.2.1.1.520", berInteger, []byte{0x02, 0x08}),
+		berVarbind("1.3.6.1.2.1.2.2.1.2.520", berOctetString, []byte("et-0/0/1")),
+	}
+
+	go s.handleTrap(buildTrap("public", "1.3.6.1.6.3.1.1.5.3", linkVbs...), addr)
+	event := <-ah.ListenChan
+	assert.Equal(t, event.Type, models.EventType_ACTIVE)
+	assert.Equal(t, event.Alert.Name, "Trap Link Down")
+	assert.Equal(t, event.Alert.Device.String, "10.2.2.2")
+	assert.Equal(t, event.Alert.Entity, "et-0/0/1")
+	assert.Equal(t, event.Alert.Severity.String(), "WARN")
+	assert.Equal(t, event.Alert.Source, "snmptrap")
+	assert.Equal(t, event.Alert.Team, "default")
+	assert.Equal(t, event.Alert.Labels["ifIndex"], int64(520))
+
+	go s.handleTrap(buildTrap("public", "1.3.6.1.6.3.1.1.5.4", linkVbs...), addr)
+	event = <-ah.ListenChan
+	assert.Equal(t, event.Type, models.EventType_CLEARED)
+	assert.Equal(t, event.Alert.Name, "Trap Link Down")
+	assert.Equal(t, event.Alert.Entity, "et-0/0/1")
+
+	go s.handleTrap(buildTrap("public", "1.3.6.1.2.1.15.0.2",
+		berVarbind("1.3.6.1.2.1.15.3.1.7.10.1.1.2", berIpAddress, []byte{10, 1, 1, 2}),
+	), addr)
+	event = <-ah.ListenChan
+	assert.Equal(t, event.Type, models.EventType_ACTIVE)
+	assert.Equal(t, event.Alert.Name, "Trap BGP Down")
+	assert.Equal(t, event.Alert.Entity, "10.1.1.2")
+	assert.Equal(t, event.Alert.Severity.String(), "CRITICAL")
+
+	// wrong community and unmapped traps are dropped
+	s.handleTrap(buildTrap("private", "1.3.6.1.6.3.1.1.5.3", linkVbs...), addr)
+	s.handleTrap(buildTrap("public", "1.3.6.1.6.3.1.1.5.1"), addr)
+	select {
+	case event := <-ah.ListenChan:
+		t.Fatalf("Unexpected event for %s", event.Alert.Name)
+	default:
+	}
+}
@@ -0,0 +1,435 @@
+package listener
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	ah "github.com/mayuresh82/alert_manager/handler"
+	"github.com/mayuresh82/alert_manager/internal/stats"
+	"github.com/mayuresh82/alert_manager/plugins"
+)
+
+const (
+	syslogMaxMsgSize = 8192
+	// tcp connections that send nothing for this long are closed
+	syslogIdleTimeout = 5 * time.Minute
+	rfc3164TimeFmt    = "Jan _2 15:04:05"
+)
+
+// syslog severities 0-2 (emerg, alert, crit) are critical, 3-4 (err, warning) are
+// warnings and everything else is informational
+var syslogSevToLevel = []string{"CRITICAL", "CRITICAL", "CRITICAL", "WARN", "WARN", "INFO", "INFO", "INFO"}
+
+// syslogMessage is a decoded RFC 5424 or RFC 3164 message
+type syslogMessage struct {
+	Facility  int
+	Severity  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	Message   string
+}
+
+// parseSyslog decodes a single syslog message in either RFC 5424 or RFC 3164 format
+func parseSyslog(raw string) (*syslogMessage, error) {
+	raw = strings.TrimRight(raw, "\r\n\x00")
+	if !strings.HasPrefix(raw, "<") {
+		return nil, fmt.Errorf("Invalid syslog message: missing PRI")
+	}
+	end := strings.Index(raw, ">")
+	if end < 2 || end > 4 {
+		return nil, fmt.Errorf("Invalid syslog message: bad PRI")
+	}
+	pri, err := strconv.Atoi(raw[1:end])
+	if err != nil || pri > 191 {
+		return nil, fmt.Errorf("Invalid syslog message: bad PRI %s", raw[1:end])
+	}
+	msg := &syslogMessage{Facility: pri / 8, Severity: pri % 8}
+	rest := raw[end+1:]
+	if strings.HasPrefix(rest, "1 ") {
+		return parseRFC5424(msg, rest[2:])
+	}
+	return parseRFC3164(msg, rest)
+}
+
+func parseRFC5424(msg *syslogMessage, rest string) (*syslogMessage, error) {
+	// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+	fields := strings.SplitN(rest, " ", 6)
+	if len(fields) < 6 {
+		return nil, fmt.Errorf("Invalid RFC5424 syslog message: %s", rest)
+	}
+	msg.Timestamp = time.Now()
+	if fields[0] != "-" {
+		if t, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+			msg.Timestamp = t
+		}
+	}
+	msg.Hostname = nilValue(fields[1])
+	msg.AppName = nilValue(fields[2])
+	msg.Message = skipStructuredData(fields[5])
+	return msg, nil
+}
+
+func parseRFC3164(msg *syslogMessage, rest string) (*syslogMessage, error) {
+	// Mmm dd hh:mm:ss HOSTNAME TAG: MSG
+	msg.Timestamp = time.Now()
+	if len(rest) >= len(rfc3164TimeFmt) {
+		if t, err := time.ParseInLocation(rfc3164TimeFmt, rest[:len(rfc3164TimeFmt)], time.Local); err == nil {
+			now := time.Now()
+			msg.Timestamp = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+			rest = strings.TrimLeft(rest[len(rfc3164TimeFmt):], " ")
+			parts := strings.SplitN(rest, " ", 2)
+			msg.Hostname = parts[0]
+			rest = ""
+			if len(parts) == 2 {
+				rest = parts[1]
+			}
+		}
+	}
+	if i := strings.Index(rest, ": "); i > 0 && !strings.Contains(rest[:i], " ") {
+		msg.AppName = strings.SplitN(rest[:i], "[", 2)[0]
+		rest = rest[i+2:]
+	}
+	msg.Message = rest
+	return msg, nil
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// skipStructuredData strips the RFC5424 structured data element(s) preceding the msg
+func skipStructuredData(s string) string {
+	if strings.HasPrefix(s, "-") {
+		return strings.TrimPrefix(strings.TrimPrefix(s, "-"), " ")
+	}
+	var depth int
+	var escaped bool
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ' ' && depth == 0:
+			return s[i+1:]
+		}
+	}
+	return ""
+}
+
+// SyslogRule defines how matching syslog messages are turned into alerts
+type SyslogRule struct {
+	// Name of the alert to raise
+	Name string
+	// regex matched against the syslog msg to raise the alert
+	Match string
+	// optional regex matched against the syslog msg to clear the alert
+	Clear string
+	// named capture group in the match/clear regex that identifies the entity
+	EntityGroup string `mapstructure:"entity_group"`
+	// optional severity override, the syslog PRI is used if not set
+	Severity string
+	// optional app-name/tag the message must be from
+	AppName string `mapstructure:"app_name"`
+
+	matchRe, clearRe *regexp.Regexp
+}
+
+func (r *SyslogRule) compile() error {
+	var err error
+	if r.Name == "" || r.Match == "" {
+		return fmt.Errorf("Syslog rule requires a name and a match regex")
+	}
+	if r.matchRe, err = regexp.Compile(r.Match); err != nil {
+		return fmt.Errorf("Invalid match regex for rule %s: %v", r.Name, err)
+	}
+	if r.Clear != "" {
+		if r.clearRe, err = regexp.Compile(r.Clear); err != nil {
+			return fmt.Errorf("Invalid clear regex for rule %s: %v", r.Name, err)
+		}
+	}
+	if r.EntityGroup == "" {
+		r.EntityGroup = "entity"
+	}
+	return nil
+}
+
+// apply checks the message against the rule and returns the resulting alert data
+func (r *SyslogRule) apply(msg *syslogMessage) *WebHookAlertData {
+	if r.AppName != "" && r.AppName != msg.AppName {
+		return nil
+	}
+	status := Status_ALERTING
+	re := r.matchRe
+	groups := re.FindStringSubmatch(msg.Message)
+	if groups == nil && r.clearRe != nil {
+		re = r.clearRe
+		groups = re.FindStringSubmatch(msg.Message)
+		status = Status_CLEARED
+	}
+	if groups == nil {
+		return nil
+	}
+	d := &WebHookAlertData{
+		Name:    r.Name,
+		Details: msg.Message,
+		Device:  msg.Hostname,
+		Time:    msg.Timestamp,
+		Level:   r.Severity,
+		Status:  status,
+		Source:  "syslog",
+		Labels:  make(map[string]interface{}),
+	}
+	if d.Level == "" {
+		d.Level = syslogSevToLevel[msg.Severity]
+	}
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if name == r.EntityGroup {
+			d.Entity = groups[i]
+			continue
+		}
+		d.Labels[name] = groups[i]
+	}
+	if d.Entity == "" {
+		d.Entity = msg.Hostname
+	}
+	return d
+}
+
+// SyslogListener listens for syslog messages over UDP and/or TCP and converts
+// messages that match the configured rules into alerts
+type SyslogListener struct {
+	UdpAddr string `mapstructure:"udp_addr"`
+	TcpAddr string `mapstructure:"tcp_addr"`
+	// team to assign syslog alerts to
+	Team  string
+	Rules []*SyslogRule
+
+	statMsgsRecvd   stats.Stat
+	statMsgsError   stats.Stat
+	statMsgsMatched stats.Stat
+}
+
+func NewSyslogListener() *SyslogListener {
+	return &SyslogListener{
+		statMsgsRecvd:   stats.NewCounter("listener.syslog.msgs_recvd"),
+		statMsgsError:   stats.NewCounter("listener.syslog.msgs_err"),
+		statMsgsMatched: stats.NewCounter("listener.syslog.msgs_matched"),
+	}
+}
+
+func (s *SyslogListener) Name() string {
+	return "syslog"
+}
+
+func (s *SyslogListener) GetParsersList() []string {
+	return []string{}
+}
+
+func (s *SyslogListener) Uri() string {
+	var uris []string
+	if s.UdpAddr != "" {
+		uris = append(uris, "udp://"+s.UdpAddr)
+	}
+	if s.TcpAddr != "" {
+		uris = append(uris, "tcp://"+s.TcpAddr)
+	}
+	return strings.Join(uris, ",")
+}
+
+// handleMessage parses a raw syslog message and sends an alert event for the first matching rule
+func (s *SyslogListener) handleMessage(raw string) {
+	s.statMsgsRecvd.Add(1)
+	msg, err := parseSyslog(raw)
+	if err != nil {
+		glog.V(2).Infof("Syslog: %v", err)
+		s.statMsgsError.Add(1)
+		return
+	}
+	team := s.Team
+	if team == "" {
+		team = "default"
+	}
+	for _, rule := range s.Rules {
+		data := rule.apply(msg)
+		if data == nil {
+			continue
+		}
+		s.statMsgsMatched.Add(1)
+		event, err := formatAlertEvent(data, team)
+		if err != nil {
+			glog.Errorf("Syslog: %v", err)
+			s.statMsgsError.Add(1)
+			return
+		}
+		if err := ah.Send(event); err != nil {
+			glog.Errorf("Failed to send alert event: %v", err)
+		}
+		return
+	}
+}
+
+func (s *SyslogListener) listenUDP(ctx context.Context) {
+	conn, err := net.ListenPacket("udp", s.UdpAddr)
+	if err != nil {
+		glog.Errorf("Syslog: Unable to listen on udp %s: %v", s.UdpAddr, err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, syslogMaxMsgSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				glog.Errorf("Syslog: udp read error: %v", err)
+			}
+			return
+		}
+		s.handleMessage(string(buf[:n]))
+	}
+}
+
+func (s *SyslogListener) listenTCP(ctx context.Context) {
+	l, err := net.Listen("tcp", s.TcpAddr)
+	if err != nil {
+		glog.Errorf("Syslog: Unable to listen on tcp %s: %v", s.TcpAddr, err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				glog.Errorf("Syslog: tcp accept error: %v", err)
+			}
+			return
+		}
+		go s.handleConn(ctx, conn)
+	}
+}
+
+// handleConn reads messages framed either by octet counting or by newlines (RFC 6587)
+// until the connection is closed, idle for syslogIdleTimeout or ctx is done.
+func (s *SyslogListener) handleConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	// room for the largest message and its newline
+	r := bufio.NewReaderSize(conn, syslogMaxMsgSize+1)
+	for {
+		conn.SetReadDeadline(time.Now().Add(syslogIdleTimeout))
+		msg, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				glog.V(2).Infof("Syslog: tcp read error: %v", err)
+			}
+			return
+		}
+		if msg != "" {
+			s.handleMessage(msg)
+		}
+	}
+}
+
+// readFrame reads the next message from r. A frame that does not fit in the buffer of r is
+// an error, so that the size of the buffer bounds the memory used per connection.
+func readFrame(r *bufio.Reader) (string, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if first[0] >= '0' && first[0] <= '9' {
+		slice, err := r.ReadSlice(' ')
+		if err == bufio.ErrBufferFull {
+			return "", fmt.Errorf("Invalid octet count: no length in %d bytes", len(slice))
+		}
+		if err != nil {
+			return "", err
+		}
+		lenStr := string(slice)
+		n, err := strconv.Atoi(strings.TrimSpace(lenStr))
+		if err != nil || n <= 0 || n > syslogMaxMsgSize {
+			return "", fmt.Errorf("Invalid octet count: %s", lenStr)
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+	slice, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("Syslog message exceeds %d bytes", len(slice))
+	}
+	if err != nil && !(err == io.EOF && len(slice) > 0) {
+		return "", err
+	}
+	return strings.TrimRight(string(slice), "\r\n"), nil
+}
+
+func (s *SyslogListener) Listen(ctx context.Context) {
+	if s.UdpAddr == "" && s.TcpAddr == "" {
+		glog.V(2).Infof("Syslog: no listen address configured, not starting")
+		return
+	}
+	for _, rule := range s.Rules {
+		if err := rule.compile(); err != nil {
+			glog.Errorf("Syslog: %v", err)
+			return
+		}
+	}
+	var wg sync.WaitGroup
+	if s.UdpAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.listenUDP(ctx)
+		}()
+	}
+	if s.TcpAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.listenTCP(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func init() {
+	listener := NewSyslogListener()
+	plugins.AddListener(listener)
+}
@@ -0,0 +1,122 @@
+package listener
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	ah "github.com/mayuresh82/alert_manager/handler"
+	"github.com/mayuresh82/alert_manager/internal/models"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSyslog(t *testing.T) {
+	// RFC 5424
+	msg, err := parseSyslog(`<187>1 2019-03-01T10:00:00.000Z br1-sjc1 mib2d 1234 SNMP_TRAP_LINK_DOWN [junos@2636 snmp-interface-index="520"] ifIndex 520, ifAdminStatus up(1), ifOperStatus down(2), ifName et-0/0/1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, msg.Facility, 23)
+	assert.Equal(t, msg.Severity, 3)
+	assert.Equal(t, msg.Hostname, "br1-sjc1")
+	assert.Equal(t, msg.AppName, "mib2d")
+	assert.Equal(t, msg.Timestamp.Year(), 2019)
+	assert.Equal(t, msg.Message, "ifIndex 520, ifAdminStatus up(1), ifOperStatus down(2), ifName et-0/0/1")
+
+	// RFC 5424 - nil structured data
+	msg, err = parseSyslog(`<14>1 - sw1 - - - - hello world`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, msg.Hostname, "sw1")
+	assert.Equal(t, msg.AppName, "")
+	assert.Equal(t, msg.Message, "hello world")
+
+	// RFC 3164
+	msg, err = parseSyslog(`<28>Mar  1 10:00:00 br2-lhr1 rpd[1234]: bgp_peer_mgmt_clear: NOTIFICATION sent to 10.1.1.1 (External AS 65101)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, msg.Severity, 4)
+	assert.Equal(t, msg.Hostname, "br2-lhr1")
+	assert.Equal(t, msg.AppName, "rpd")
+	assert.Equal(t, msg.Message, "bgp_peer_mgmt_clear: NOTIFICATION sent to 10.1.1.1 (External AS 65101)")
+
+	// invalid
+	_, err = parseSyslog("no pri here")
+	assert.Error(t, err)
+	_, err = parseSyslog("<999>1 - - - - - -")
+	assert.Error(t, err)
+}
+
+func TestReadFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("11 <14>1 - a b17 <14>1 - - - - - x<14>hello\n"))
+	frame, err := readFrame(r)
+	assert.Nil(t, err)
+	assert.Equal(t, frame, "<14>1 - a b")
+	frame, err = readFrame(r)
+	assert.Nil(t, err)
+	assert.Equal(t, frame, "<14>1 - - - - - x")
+	frame, err = readFrame(r)
+	assert.Nil(t, err)
+	assert.Equal(t, frame, "<14>hello")
+
+	// frames larger than the buffer are rejected
+	r = bufio.NewReaderSize(strings.NewReader("<14>"+strings.Repeat("x", 64)+"\n"), 32)
+	_, err = readFrame(r)
+	assert.Error(t, err)
+	r = bufio.NewReaderSize(strings.NewReader(strings.Repeat("1", 64)+" <14>x"), 32)
+	_, err = readFrame(r)
+	assert.Error(t, err)
+}
+
+func TestSyslogHandleMessage(t *testing.T) {
+	s := &SyslogListener{
+		Team: "neteng",
+		Rules: []*SyslogRule{
+			{
+				Name:  "Syslog Link Down",
+				Match: `ifOperStatus down\(2\), ifName (?P<entity>\S+)`,
+				Clear: `ifOperStatus up\(1\), ifName (?P<entity>\S+)`,
+			},
+			{
+				Name:     "Syslog BGP Down",
+				Match:    `NOTIFICATION sent to (?P<peer>\S+) \(External AS (?P<asn>\d+)\)`,
+				Severity: "CRITICAL",
+				AppName:  "rpd",
+			},
+		},
+		statMsgsRecvd:   &tu.MockStat{},
+		statMsgsError:   &tu.MockStat{},
+		statMsgsMatched: &tu.MockStat{},
+	}
+	for _, r := range s.Rules {
+		if err := r.compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	go s.handleMessage(`<187>1 2019-03-01T10:00:00Z br1-sjc1 mib2d - SNMP_TRAP_LINK_DOWN - ifIndex 520, ifAdminStatus up(1), ifOperStatus down(2), ifName et-0/0/1`)
+	event := <-ah.ListenChan
+	assert.Equal(t, event.Type, models.EventType_ACTIVE)
+	assert.Equal(t, event.Alert.Name, "Syslog Link Down")
+	assert.Equal(t, event.Alert.Device.String, "br1-sjc1")
+	assert.Equal(t, event.Alert.Entity, "et-0/0/1")
+	assert.Equal(t, event.Alert.Severity.String(), "WARN")
+	assert.Equal(t, event.Alert.Source, "syslog")
+	assert.Equal(t, event.Alert.Team, "neteng")
+
+	go s.handleMessage(`<189>1 2019-03-01T10:01:00Z br1-sjc1 mib2d - SNMP_TRAP_LINK_UP - ifIndex 520, ifAdminStatus up(1), ifOperStatus up(1), ifName et-0/0/1`)
+	event = <-ah.ListenChan
+	assert.Equal(t, event.Type, models.EventType_CLEARED)
+	assert.Equal(t, event.Alert.Entity, "et-0/0/1")
+
+	go s.handleMessage(`<28>Mar  1 10:00:00 br2-lhr1 rpd[1234]: bgp_peer_mgmt_clear: NOTIFICATION sent to 10.1.1.1 (External AS 65101)`)
+	event = <-ah.ListenChan
+	assert.Equal(t, event.Alert.Name, "Syslog BGP Down")
+	assert.Equal(t, event.Alert.Entity, "br2-lhr1")
+	assert.Equal(t, event.Alert.Severity.String(), "CRITICAL")
+	assert.Equal(t, event.Alert.Labels["peer"], "10.1.1.1")
+	assert.Equal(t, event.Alert.Labels["asn"], "65101")
+}
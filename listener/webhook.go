@@ -1,11 +1,15 @@
 package listener
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -34,26 +38,59 @@ type WebHookAlertData struct {
 	Labels  map[string]interface{}
 }
 
+// rejectedItem describes an alert in a webhook request that was not accepted
+type rejectedItem struct {
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+// batchResponse is returned for every webhook request that could be parsed
+type batchResponse struct {
+	Accepted int            `json:"accepted"`
+	Rejected []rejectedItem `json:"rejected"`
+}
+
 type WebHookListener struct {
 	ListenAddr         string `mapstructure:"listen_addr"`
 	UseAuth            bool   `mapstructure:"use_auth"`
 	Username, Password string
+	// parsers defined in config
+	Mappings []*ParserMapping
+	// per source secrets for request signature verification
+	Secrets []*SourceSecret
+
+	// ingestion rate limits
+	RateLimits []*RateLimit `mapstructure:"rate_limits"`
+
+	secrets map[string]*SourceSecret
+	limiter *rateLimiter
 
-	statRequestsRecvd stats.Stat
-	statRequestsError stats.Stat
-	statsAuthFailures stats.Stat
+	statRequestsRecvd  stats.Stat
+	statRequestsError  stats.Stat
+	statsAuthFailures  stats.Stat
+	statAlertsAccepted stats.Stat
+	statAlertsRejected stats.Stat
+	statRateLimited    stats.Stat
+	statStorms         stats.Stat
+	// auth failures broken down by known sources
+	statsSourceAuthFailures map[string]stats.Stat
 }
 
 func NewWebHookListener() *WebHookListener {
 
 	return &WebHookListener{
-		statRequestsRecvd: stats.NewCounter("listener.webhook.requests_recvd"),
-		statRequestsError: stats.NewCounter("listener.webhook.requests_err"),
-		statsAuthFailures: stats.NewCounter("listener.webhook.auth_failures"),
+		statRequestsRecvd:  stats.NewCounter("listener.webhook.requests_recvd"),
+		statRequestsError:  stats.NewCounter("listener.webhook.requests_err"),
+		statsAuthFailures:  stats.NewCounter("listener.webhook.auth_failures"),
+		statAlertsAccepted: stats.NewCounter("listener.webhook.alerts_accepted"),
+		statAlertsRejected: stats.NewCounter("listener.webhook.alerts_rejected"),
+		statRateLimited:    stats.NewCounter("listener.webhook.alerts_rate_limited"),
+		statStorms:         stats.NewCounter("listener.webhook.storms"),
 	}
 }
 
-func (k *WebHookListener) sanityCheck(d *WebHookAlertData) error {
+func sanityCheck(d *WebHookAlertData) error {
 	// alert name should only contain alpha-numeric chars, spaces or underscores
 	reg, err := regexp.Compile("[^a-zA-Z0-9_\\s]+")
 	if err != nil {
@@ -66,8 +103,10 @@ func (k *WebHookListener) sanityCheck(d *WebHookAlertData) error {
 	return nil
 }
 
-func (k *WebHookListener) formatAlertEvent(d *WebHookAlertData, team string) (*models.AlertEvent, error) {
-	if err := k.sanityCheck(d); err != nil {
+// formatAlertEvent converts parsed alert data from any listener into an alert event,
+// applying the alert config definition if one exists
+func formatAlertEvent(d *WebHookAlertData, team string) (*models.AlertEvent, error) {
+	if err := sanityCheck(d); err != nil {
 		return nil, err
 	}
 	// check if the alert exists in the definition
@@ -115,27 +154,66 @@ func (k *WebHookListener) formatAlertEvent(d *WebHookAlertData, team string) (*m
 	return event, nil
 }
 
+func (k *WebHookListener) authFailed(source string) {
+	k.statsAuthFailures.Add(1)
+	if stat, ok := k.statsSourceAuthFailures[source]; ok {
+		stat.Add(1)
+	}
+}
+
 func (k *WebHookListener) basicAuth(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-
-		defer h.ServeHTTP(w, r)
-		if !k.UseAuth {
+		source := r.URL.Query().Get("source")
+		// sources with a secret are authenticated by their signature instead
+		if _, ok := k.secrets[source]; ok || !k.UseAuth {
+			h.ServeHTTP(w, r)
 			return
 		}
 		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 
 		username, password, authOK := r.BasicAuth()
 		if !authOK {
-			k.statsAuthFailures.Add(1)
+			k.authFailed(source)
 			http.Error(w, "Not authorized", http.StatusUnauthorized)
 			return
 		}
 
 		if username != k.Username || password != k.Password {
-			k.statsAuthFailures.Add(1)
+			k.authFailed(source)
 			http.Error(w, "Not authorized", http.StatusUnauthorized)
 			return
 		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+// verifySignature checks the request body signature for sources that have a secret configured
+func (k *WebHookListener) verifySignature(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source := r.URL.Query().Get("source")
+		secret, ok := k.secrets[source]
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := secret.verify(r.Header, body, time.Now()); err != nil {
+			glog.Errorf("Signature verification failed for source %s: %v", source, err)
+			k.authFailed(source)
+			http.Error(w, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h.ServeHTTP(w, r)
 	}
 }
 
@@ -143,8 +221,9 @@ func (k WebHookListener) pingHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "I-AM-ALIVE")
 }
 
-func (k WebHookListener) httpHandler(w http.ResponseWriter, r *http.Request) {
-	k.statRequestsRecvd.Add(1)
+// parseRequest reads the body of a webhook request and parses it with the parser for the source
+// in the url. It writes an error response and returns false if the request is invalid.
+func (k WebHookListener) parseRequest(w http.ResponseWriter, r *http.Request) (datas []*WebHookAlertData, itemErrs ItemErrors, source, team string, ok bool) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -162,50 +241,109 @@ func (k WebHookListener) httpHandler(w http.ResponseWriter, r *http.Request) {
 	glog.V(4).Infof("New alert create request: %v", string(body))
 
 	queries := r.URL.Query()
-	source, ok := queries["source"]
-	if !ok || len(source) != 1 {
+	sources, found := queries["source"]
+	if !found || len(sources) != 1 {
 		glog.Errorf("No query found in URL: %v", r.URL)
 		k.statRequestsError.Add(1)
 		http.Error(w, "No query found in URL", http.StatusBadRequest)
 		return
 	}
-	teams, ok := queries["team"]
-	team := "default"
-	if ok {
+	source = sources[0]
+	teams, found := queries["team"]
+	team = "default"
+	if found {
 		team = teams[0]
 	} else {
 		glog.V(2).Infof("No team specified in URL, using 'default'")
 	}
-	var parser Parser
-	for _, p := range parsers {
-		if p.Name() == source[0] {
-			parser = p
-			break
-		}
-	}
-	if parser == nil {
+	parser, found := GetParser(source)
+	if !found {
 		k.statRequestsError.Add(1)
 		glog.Errorf("No parser found in alert definition")
 		http.Error(w, "No parser found in alert definition", http.StatusInternalServerError)
 		return
 	}
 
-	data, err := parser.Parse(body)
-	if err != nil {
+	datas, err = parser.Parse(body)
+	itemErrs, partial := err.(ItemErrors)
+	if err != nil && !partial {
 		glog.Error(err)
 		k.statRequestsError.Add(1)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	event, err := k.formatAlertEvent(data, team)
-	if err != nil {
-		glog.Error(err)
-		k.statRequestsError.Add(1)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	return datas, itemErrs, source, team, true
+}
+
+func (k WebHookListener) httpHandler(w http.ResponseWriter, r *http.Request) {
+	k.statRequestsRecvd.Add(1)
+	datas, itemErrs, source, team, ok := k.parseRequest(w, r)
+	if !ok {
 		return
 	}
 
-	ah.ListenChan <- event
+	if k.limiter != nil {
+		var n int
+		for _, data := range datas {
+			if data != nil {
+				n++
+			}
+		}
+		if n > 0 {
+			ok, retryAfter, started, ended := k.limiter.allow(source, team, n, time.Now())
+			k.sendStorms(started, ended)
+			if !ok {
+				glog.V(2).Infof("Rate limited %d alerts from source %s team %s", n, source, team)
+				k.statRateLimited.Add(int64(n))
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+		}
+	}
+
+	resp := batchResponse{Rejected: []rejectedItem{}}
+	var sendFailed bool
+	for i, data := range datas {
+		if data == nil {
+			var reason string
+			if itemErr, ok := itemErrs[i]; ok {
+				reason = itemErr.Error()
+			}
+			resp.Rejected = append(resp.Rejected, rejectedItem{Index: i, Error: reason})
+			continue
+		}
+		event, err := formatAlertEvent(data, team)
+		if err != nil {
+			glog.Error(err)
+			resp.Rejected = append(resp.Rejected, rejectedItem{Index: i, Name: data.Name, Error: err.Error()})
+			continue
+		}
+		// with a queue in use, the alert is accepted once persisted
+		if err := ah.Send(event); err != nil {
+			glog.Errorf("Failed to send alert event: %v", err)
+			sendFailed = true
+			resp.Rejected = append(resp.Rejected, rejectedItem{Index: i, Name: data.Name, Error: err.Error()})
+			continue
+		}
+		resp.Accepted++
+	}
+	k.statAlertsAccepted.Add(int64(resp.Accepted))
+	if len(resp.Rejected) > 0 {
+		glog.Errorf("Rejected %d alerts from source %s", len(resp.Rejected), source)
+		k.statAlertsRejected.Add(int64(len(resp.Rejected)))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Accepted == 0 {
+		k.statRequestsError.Add(1)
+		if sendFailed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
 }
 
 func (k *WebHookListener) Name() string {
@@ -213,12 +351,7 @@ func (k *WebHookListener) Name() string {
 }
 
 func (k *WebHookListener) GetParsersList() []string {
-
-	var plist []string
-	for _, p := range parsers {
-		plist = append(plist, p.Name())
-	}
-	return plist
+	return parserNames()
 }
 
 func (k *WebHookListener) Uri() string {
@@ -229,8 +362,126 @@ func (k *WebHookListener) Uri() string {
 	return fmt.Sprintf("http://%s/listener/alert/", addr)
 }
 
+// registerMappings adds a parser for every configured mapping
+func (k *WebHookListener) registerMappings() {
+	for _, m := range k.Mappings {
+		if _, ok := GetParser(m.Source); ok {
+			glog.Errorf("Parser for source %s already exists, ignoring mapping", m.Source)
+			continue
+		}
+		parser, err := NewMappingParser(m)
+		if err != nil {
+			glog.Errorf("Invalid parser mapping: %v", err)
+			continue
+		}
+		glog.V(2).Infof("Adding parser mapping for source %s", m.Source)
+		AddParser(parser)
+	}
+}
+
+// registerSecrets sets up signature verification for every configured source secret
+func (k *WebHookListener) registerSecrets() {
+	k.secrets = make(map[string]*SourceSecret)
+	for _, s := range k.Secrets {
+		if err := s.init(); err != nil {
+			glog.Errorf("Invalid source secret: %v", err)
+			continue
+		}
+		k.secrets[s.Source] = s
+	}
+	// only known sources get their own stat, so that arbitrary ?source= values
+	// cannot create new stats
+	k.statsSourceAuthFailures = make(map[string]stats.Stat)
+	sources := parserNames()
+	for source := range k.secrets {
+		sources = append(sources, source)
+	}
+	for _, source := range sources {
+		if _, ok := k.statsSourceAuthFailures[source]; !ok {
+			k.statsSourceAuthFailures[source] = stats.NewCounter("listener.webhook.auth_failures." + source)
+		}
+	}
+}
+
+// stormEvent returns the synthetic alert event that is sent in place of the alerts dropped during a storm
+func stormEvent(s *storm, status string) (*models.AlertEvent, error) {
+	d := &WebHookAlertData{
+		Name:   STORM_ALERT_NAME,
+		Entity: s.Entity(),
+		Source: "webhook",
+		Time:   s.Start,
+		Level:  "WARN",
+		Status: status,
+		Labels: map[string]interface{}{"storm_source": s.Source, "storm_team": s.Team},
+	}
+	if status == Status_CLEARED {
+		d.Time = time.Now()
+		d.Details = fmt.Sprintf("Alert storm from %s ended, %d alerts were dropped", s.Entity(), s.Dropped)
+	} else {
+		d.Details = fmt.Sprintf("Alert storm from %s: more than %v alerts in %v, alerts are dropped until the rate drops",
+			s.Entity(), s.Limit.Rate, s.Limit.Interval)
+	}
+	team := s.Team
+	if team == "" {
+		team = "default"
+	}
+	event, err := formatAlertEvent(d, team)
+	if err != nil {
+		return nil, err
+	}
+	event.Alert.AutoClear = true
+	return event, nil
+}
+
+func (k *WebHookListener) sendStorms(started, ended []*storm) {
+	send := func(s *storm, status string) {
+		event, err := stormEvent(s, status)
+		if err == nil {
+			err = ah.Send(event)
+		}
+		if err != nil {
+			glog.Errorf("Failed to send storm alert for %s: %v", s.Entity(), err)
+		}
+	}
+	for _, s := range ended {
+		glog.Infof("Alert storm from %s ended, %d alerts dropped", s.Entity(), s.Dropped)
+		send(s, Status_CLEARED)
+	}
+	for _, s := range started {
+		glog.Errorf("Alert storm detected from %s", s.Entity())
+		k.statStorms.Add(1)
+		send(s, Status_ALERTING)
+	}
+}
+
+// sweepStorms periodically ends storms on sources that stopped sending alerts
+func (k *WebHookListener) sweepStorms(ctx context.Context) {
+	t := time.NewTicker(STORM_SWEEP_INTERVAL)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			k.sendStorms(nil, k.limiter.sweep(time.Now()))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func (k WebHookListener) Listen(ctx context.Context) {
-	http.HandleFunc("/listener/alert/", k.basicAuth(k.httpHandler))
+	k.registerMappings()
+	k.registerSecrets()
+	if len(k.RateLimits) > 0 {
+		limiter, err := newRateLimiter(k.RateLimits)
+		if err != nil {
+			glog.Errorf("Invalid rate limits, rate limiting is disabled: %v", err)
+		} else {
+			k.limiter = limiter
+			go k.sweepStorms(ctx)
+		}
+	}
+	http.HandleFunc("/listener/alert/", k.basicAuth(k.verifySignature(k.httpHandler)))
+	http.HandleFunc("/listener/dryrun/", k.basicAuth(k.verifySignature(k.dryRunHandler)))
 	http.HandleFunc("/listener/ping/", k.pingHandler)
 	srv := &http.Server{Addr: k.ListenAddr}
 	idleConnsClosed := make(chan struct{})
@@ -2,7 +2,9 @@ package listener
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
+	"fmt"
 	ah "github.com/mayuresh82/alert_manager/handler"
 	"github.com/mayuresh82/alert_manager/internal/models"
 	tu "github.com/mayuresh82/alert_manager/testutil"
@@ -18,8 +20,8 @@ type mockParser struct{}
 
 func (m *mockParser) Name() string { return "mocked" }
 
-func (m *mockParser) Parse(data []byte) (*WebHookAlertData, error) {
-	return &WebHookAlertData{
+func (m *mockParser) Parse(data []byte) ([]*WebHookAlertData, error) {
+	return []*WebHookAlertData{{
 		Id:      "1",
 		Name:    "Test Alert",
 		Details: "Test Alert 123",
@@ -28,11 +30,35 @@ func (m *mockParser) Parse(data []byte) (*WebHookAlertData, error) {
 		Time:    time.Now(),
 		Level:   "WARN",
 		Status:  "ACTIVE",
-		Source:  "mocked"}, nil
+		Source:  "mocked"}}, nil
+}
+
+type mockBatchParser struct{}
+
+func (m *mockBatchParser) Name() string { return "mocked_batch" }
+
+func (m *mockBatchParser) Parse(data []byte) ([]*WebHookAlertData, error) {
+	return []*WebHookAlertData{
+		{Name: "Test Alert", Entity: "ent1", Time: time.Now(), Source: "mocked_batch"},
+		nil,
+		{Name: "Test-Alert!", Entity: "ent2", Time: time.Now(), Source: "mocked_batch"},
+		{Name: "Test Alert", Entity: "ent3", Time: time.Now(), Source: "mocked_batch", Status: Status_CLEARED},
+	}, ItemErrors{1: fmt.Errorf("Required fields missing: [name]")}
+}
+
+func newMockListener() *WebHookListener {
+	return &WebHookListener{
+		statRequestsRecvd:  &tu.MockStat{},
+		statRequestsError:  &tu.MockStat{},
+		statAlertsAccepted: &tu.MockStat{},
+		statAlertsRejected: &tu.MockStat{},
+		statRateLimited:    &tu.MockStat{},
+		statStorms:         &tu.MockStat{},
+	}
 }
 
 func TestAlertHandlerBadRequest(t *testing.T) {
-	lis := &WebHookListener{statRequestsRecvd: &tu.MockStat{}, statRequestsError: &tu.MockStat{}}
+	lis := newMockListener()
 
 	// test empty request
 	req, err := http.NewRequest("POST", "/listener/alert", nil)
@@ -70,7 +96,7 @@ func TestAlertHandlerBadRequest(t *testing.T) {
 }
 
 func TestAlertHandlerParsing(t *testing.T) {
-	lis := &WebHookListener{statRequestsRecvd: &tu.MockStat{}, statRequestsError: &tu.MockStat{}}
+	lis := newMockListener()
 
 	req, err := http.NewRequest("POST", "/listener/alert/?source=mocked&team=foo", bytes.NewReader([]byte("blah")))
 	if err != nil {
@@ -92,9 +118,48 @@ func TestAlertHandlerParsing(t *testing.T) {
 	assert.Equal(t, event.Alert.Team, "foo")
 }
 
+func TestAlertHandlerBatch(t *testing.T) {
+	lis := newMockListener()
+
+	req, err := http.NewRequest("POST", "/listener/alert/?source=mocked_batch", bytes.NewReader([]byte("blah")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(lis.httpHandler)
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rr, req)
+		close(done)
+	}()
+
+	event := <-ah.ListenChan
+	assert.Equal(t, event.Type, models.EventType_ACTIVE)
+	assert.Equal(t, event.Alert.Entity, "ent1")
+	assert.Equal(t, event.Alert.Team, "default")
+	event = <-ah.ListenChan
+	assert.Equal(t, event.Type, models.EventType_CLEARED)
+	assert.Equal(t, event.Alert.Entity, "ent3")
+	<-done
+
+	assert.Equal(t, rr.Code, http.StatusOK)
+	resp := batchResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, resp.Accepted, 2)
+	assert.Equal(t, len(resp.Rejected), 2)
+	assert.Equal(t, resp.Rejected[0].Index, 1)
+	assert.Equal(t, resp.Rejected[0].Error, "Required fields missing: [name]")
+	assert.Equal(t, resp.Rejected[1].Index, 2)
+	assert.Equal(t, resp.Rejected[1].Name, "Test-Alert!")
+}
+
 func TestMain(m *testing.M) {
 	p := &mockParser{}
 	AddParser(p)
+	AddParser(&mockBatchParser{})
 	flag.Parse()
 	ah.Config = ah.NewConfigHandler("../testutil/testdata/test_config.yaml")
 	ah.Config.LoadConfig()
@@ -140,6 +140,12 @@ func (e *EmailNotifier) start(event *models.AlertEvent) {
 		glog.Errorf("Failed to get recipient for team %s", event.Alert.Team)
 		return
 	}
+	to := recp.To
+	for _, oc := range event.OnCall {
+		if oc.User.Email != "" {
+			to = append(to[:len(to):len(to)], oc.User.Email)
+		}
+	}
 	if err := e.Emailer.send(
 		e.SmtpAddr,
 		e.SmtpUsername,
@@ -147,7 +153,7 @@ func (e *EmailNotifier) start(event *models.AlertEvent) {
 		recp.From,
 		data.Subject,
 		body,
-		recp.To); err != nil {
+		to); err != nil {
 		glog.Errorf("Output: Email : Unable to send email : %v", err)
 	}
 }
@@ -57,6 +57,12 @@ func (n *InfluxNotifier) parseFromEvent(event *models.AlertEvent) *reporting.Dat
 		fields["num_ackd"] = 1
 	case models.EventType_ESCALATED:
 		fields["num_escalated"] = 1
+	case models.EventType_FLAPPING:
+		fields["num_flapping"] = 1
+	case models.EventType_UPDATED:
+		fields["num_updated"] = 1
+	case models.EventType_UNSUPPRESSED:
+		fields["num_unsuppressed"] = 1
 	}
 	return &reporting.Datapoint{
 		Measurement: n.Measurement,
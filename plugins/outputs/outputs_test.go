@@ -63,6 +63,15 @@ func TestOutputSlack(t *testing.T) {
 		}
 	}
 	assert.Equal(t, res["channel"].(string), "#test")
+
+	// the on-call is mentioned
+	s.Recipients[0].Mention = "@neteng"
+	event.OnCall = []*models.OnCall{{User: &models.User{Name: "alice", SlackId: "U123"}}}
+	data, _ = s.formatBody(event)
+	res = make(map[string]interface{})
+	json.Unmarshal(data, &res)
+	exp = res["attachments"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, exp["text"].(string), "@neteng <@U123> This alert has fired")
 }
 
 type mockEmailer struct {
@@ -121,4 +130,10 @@ func TestOutputEmail(t *testing.T) {
 	assert.Equal(t, emailer.body, renderedTpl)
 	assert.Equal(t, emailer.from, "a@foo.com")
 	assert.Equal(t, emailer.to, []string{"b@bar.com"})
+
+	// the on-call is added to the recipients
+	event.OnCall = []*models.OnCall{{User: &models.User{Name: "alice", Email: "alice@bar.com"}}, {User: &models.User{Name: "bob"}}}
+	n.start(event)
+	assert.Equal(t, emailer.to, []string{"b@bar.com", "alice@bar.com"})
+	assert.Equal(t, n.Recipients[0].To, []string{"b@bar.com"})
 }
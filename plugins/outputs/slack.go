@@ -54,6 +54,11 @@ func (n *SlackNotifier) formatBody(event *models.AlertEvent) ([]byte, error) {
 		return []byte{}, fmt.Errorf("Failed to get recipient for team %s", event.Alert.Team)
 	}
 	message := recipient.Mention
+	for _, oc := range event.OnCall {
+		if oc.User.SlackId != "" {
+			message += fmt.Sprintf(" <@%s>", oc.User.SlackId)
+		}
+	}
 	// dont send message on clear
 	if event.Type != models.EventType_CLEARED {
 		message += " " + event.Alert.Description
@@ -74,7 +79,12 @@ func (n *SlackNotifier) formatBody(event *models.AlertEvent) ([]byte, error) {
 		},
 	}
 
-	title := fmt.Sprintf("[%s][%s] %s", event.Alert.Severity.String(), event.Alert.Status.String(), event.Alert.Name)
+	status := event.Alert.Status.String()
+	switch event.Type {
+	case models.EventType_FLAPPING, models.EventType_FLAP_ENDED, models.EventType_UPDATED, models.EventType_ESCALATED, models.EventType_UNSUPPRESSED:
+		status = event.Type.String()
+	}
+	title := fmt.Sprintf("[%s][%s] %s", event.Alert.Severity.String(), status, event.Alert.Name)
 	body := map[string]interface{}{
 		"attachments": []map[string]interface{}{
 			{
@@ -50,12 +50,15 @@ func (n *VictorOpsNotifier) getRecipient(team string) *VoRecipient {
 func (n *VictorOpsNotifier) formatBody(event *models.AlertEvent) ([]byte, error) {
 	m := &victorOpsMsg{}
 	switch event.Type {
-	case models.EventType_ACTIVE, models.EventType_ESCALATED:
+	case models.EventType_ACTIVE, models.EventType_ESCALATED, models.EventType_UNSUPPRESSED:
 		m.MessageType = "CRITICAL"
 	case models.EventType_CLEARED:
 		m.MessageType = "RECOVERY"
 	case models.EventType_ACKD:
 		m.MessageType = "ACKNOWLEDGEMENT"
+	case models.EventType_FLAPPING, models.EventType_FLAP_ENDED, models.EventType_UPDATED:
+		// informational, does not change the incident state
+		m.MessageType = "INFO"
 	}
 
 	var device string
@@ -9,7 +9,7 @@ import (
 // Pipeline is a pipeline of alert processors
 type Pipeline interface {
 	Next() Processor
-	Run(ctx context.Context, db models.Dbase, in chan *models.AlertEvent)
+	Run(ctx context.Context, db models.Dbase, in chan *models.AlertEvent) <-chan struct{}
 }
 
 type ProcessorPipeline struct {
@@ -17,7 +17,7 @@ type ProcessorPipeline struct {
 }
 
 func NewProcessorPipeline() Pipeline {
-	sort.Slice(Processors, func(i, j int) bool { return Processors[i].Stage() < Processors[j].Stage() })
+	sort.SliceStable(Processors, func(i, j int) bool { return Processors[i].Stage() < Processors[j].Stage() })
 	pChan := make(chan Processor, len(Processors)+1)
 	for _, p := range Processors {
 		pChan <- p
@@ -30,21 +30,20 @@ func (p ProcessorPipeline) Next() Processor {
 	return <-p.processors
 }
 
-// Run starts the processor pipeline
-func (p ProcessorPipeline) Run(ctx context.Context, db models.Dbase, in chan *models.AlertEvent) {
+// Run starts the processor pipeline. Each processor closes its output once its input is
+// closed and its buffered events are flushed. The returned channel is closed once the
+// last stage is done, after the input of the pipeline is closed.
+func (p ProcessorPipeline) Run(ctx context.Context, db models.Dbase, in chan *models.AlertEvent) <-chan struct{} {
 	processor := p.Next()
 	if processor == nil {
+		done := make(chan struct{})
 		go func() {
-			for {
-				select {
-				case <-in:
-				case <-ctx.Done():
-					return
-				}
+			for range in {
 			}
+			close(done)
 		}()
-		return
+		return done
 	}
 	out := processor.Process(ctx, db, in)
-	p.Run(ctx, db, out)
+	return p.Run(ctx, db, out)
 }
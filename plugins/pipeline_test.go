@@ -5,6 +5,7 @@ import (
 	"github.com/mayuresh82/alert_manager/internal/models"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 type MockDb struct{}
@@ -96,3 +97,44 @@ func TestPipelineStages(t *testing.T) {
 	assert.Equal(t, len(c.recvd), 1)
 	assert.Equal(t, c.recvd[0], event2)
 }
+
+type passthrough struct{ delay time.Duration }
+
+func (p *passthrough) Name() string { return "Passthrough" }
+
+func (p *passthrough) Stage() int { return 1 }
+
+func (p *passthrough) Process(ctx context.Context, db models.Dbase, in chan *models.AlertEvent) chan *models.AlertEvent {
+	out := make(chan *models.AlertEvent)
+	go func() {
+		for event := range in {
+			out <- event
+		}
+		// a buffered event flushed on close
+		time.Sleep(p.delay)
+		out <- &models.AlertEvent{Type: models.EventType_ACTIVE}
+		close(out)
+	}()
+	return out
+}
+
+func TestPipelineDone(t *testing.T) {
+	Processors = []Processor{}
+	AddProcessor(&passthrough{delay: 50 * time.Millisecond})
+
+	in := make(chan *models.AlertEvent)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := NewProcessorPipeline().Run(ctx, &MockDb{}, in)
+	in <- &models.AlertEvent{Type: models.EventType_ACTIVE}
+	// cancelling the context does not stop the pipeline
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("pipeline done before its input closed")
+	case <-time.After(20 * time.Millisecond):
+	}
+	start := time.Now()
+	close(in)
+	<-done
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
@@ -2,6 +2,7 @@ package plugins
 
 import (
 	"context"
+	"sync"
 
 	"github.com/golang/glog"
 	"github.com/mayuresh82/alert_manager/internal/models"
@@ -65,21 +66,34 @@ func AddOutput(o Output) {
 	Outputs[o.Name()] = o
 }
 
-func Init(ctx context.Context, db models.Dbase) error {
-
-	// start all the listeners
+// StartListeners starts all the listeners. The returned WaitGroup is done once all of them
+// have stopped accepting alerts after the context is cancelled.
+func StartListeners(ctx context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
 	for name, listener := range Listeners {
 		glog.Infof("Starting Listener: %s on %s", name, listener.Uri())
-		go listener.Listen(ctx)
+		wg.Add(1)
+		go func(l Listener) {
+			defer wg.Done()
+			l.Listen(ctx)
+		}(listener)
 	}
+	return &wg
+}
 
-	// start all the outputs
+// StartOutputs starts all the outputs. The returned WaitGroup is done once all of them
+// have finished their in-flight sends after the context is cancelled.
+func StartOutputs(ctx context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
 	for name, output := range Outputs {
 		glog.Infof("Starting output: %s", name)
-		go output.Start(ctx)
+		wg.Add(1)
+		go func(o Output) {
+			defer wg.Done()
+			o.Start(ctx)
+		}(output)
 	}
-
-	return nil
+	return &wg
 }
 
 func GetApiPluginsList() ApiPlugins {
@@ -2,6 +2,7 @@ package aggregator
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"github.com/golang/glog"
 	ah "github.com/mayuresh82/alert_manager/handler"
@@ -9,12 +10,9 @@ import (
 	"github.com/mayuresh82/alert_manager/internal/stats"
 	"github.com/mayuresh82/alert_manager/plugins"
 	"github.com/mayuresh82/alert_manager/plugins/processors/aggregator/groupers"
-	"regexp"
 	"time"
 )
 
-const EXPIRY_CHECK_INTERVAL = 2 * time.Minute
-
 // alertGroup represents a set of grouped alerts for a given grouper and agg rule
 type alertGroup struct {
 	groupedAlerts []*models.Alert
@@ -92,8 +90,8 @@ func (ag alertGroup) SuppAgg(tx models.Txn, agg *models.Alert, ruleId int64) err
 		origIds = append(origIds, o.Id)
 		tx.NewRecord(o.Id, fmt.Sprintf("Alert suppressed due to matching supp rule: %d", ruleId))
 	}
-	// suppress all the original alerts
-	err := tx.InQuery(models.QueryUpdateManyStatus, models.Status_SUPPRESSED, origIds)
+	// suppress all the original alerts, which are released once the rule expires
+	err := tx.InQuery(models.QuerySuppressMany, models.Status_SUPPRESSED, ruleId, origIds)
 	if err != nil {
 		return fmt.Errorf("Unable to update many status: %v", err)
 	}
@@ -116,7 +114,7 @@ func (a *Aggregator) Name() string {
 }
 
 func (a *Aggregator) Stage() int {
-	return 1
+	return 2
 }
 
 func (a *Aggregator) handleGrouped(ctx context.Context, group *alertGroup, out chan *models.AlertEvent) error {
@@ -145,6 +143,7 @@ func (a *Aggregator) handleGrouped(ctx context.Context, group *alertGroup, out c
 	})
 }
 
+// checkExpired checks all active aggregate alerts for clear or expiry
 func (a *Aggregator) checkExpired(ctx context.Context, out chan *models.AlertEvent) error {
 	tx := a.db.NewTx()
 	return models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
@@ -163,53 +162,69 @@ func (a *Aggregator) checkExpired(ctx context.Context, out chan *models.AlertEve
 			if err != nil {
 				return fmt.Errorf("Agg: Unable to query agg alert %d: %v", aggId, err)
 			}
-			rule, ok := ah.Config.GetAggregationRuleConfig(aggAlert.Source)
-			if !ok {
-				glog.Errorf("Agg: Cant find rule : %s", aggAlert.Source)
-				continue
-			}
-			var status string
-			if alerts.AllExpired() && rule.Alert.Config.AutoExpire != nil && *rule.Alert.Config.AutoExpire {
-				status = "EXPIRED"
-				glog.V(2).Infof("Agg : Agg Alert %d has now expired", aggId)
-			} else if alerts.AllInactive() {
-				glog.V(2).Infof("Agg : Agg Alert %d has now cleared", aggId)
-				status = "CLEARED"
-			}
-			if status != "" {
-				aggAlert.Status = models.StatusMap[status]
-				if err := tx.UpdateAlert(aggAlert); err != nil {
-					return fmt.Errorf("Agg: Unable to update agg status: %v", err)
-				}
-				a.statAggsActive.Add(-1)
-				tx.NewRecord(aggAlert.Id, fmt.Sprintf("Alert %s", status))
-				out <- &models.AlertEvent{Alert: aggAlert, Type: models.EventMap[status]}
+			if err := a.updateAgg(tx, aggAlert, alerts, out); err != nil {
+				return err
 			}
 		}
 		return nil
 	})
 }
 
+// checkAggregate checks a single aggregate alert for clear or expiry after one of its
+// component alerts has cleared or expired
+func (a *Aggregator) checkAggregate(ctx context.Context, aggId int64, out chan *models.AlertEvent) error {
+	tx := a.db.NewTx()
+	return models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		aggAlert, err := tx.GetAlert(models.QuerySelectById, aggId)
+		if err != nil {
+			return fmt.Errorf("Agg: Unable to query agg alert %d: %v", aggId, err)
+		}
+		if aggAlert.Status != models.Status_ACTIVE {
+			return nil
+		}
+		// waits for the component update to be committed
+		alerts, err := tx.SelectAlerts(models.QuerySelectByAggId, aggId)
+		if err != nil {
+			return fmt.Errorf("Agg: Unable to query aggregated: %v", err)
+		}
+		return a.updateAgg(tx, aggAlert, alerts, out)
+	})
+}
+
+// updateAgg clears or expires an aggregate alert based on its component alerts
+func (a *Aggregator) updateAgg(tx models.Txn, aggAlert *models.Alert, alerts models.Alerts, out chan *models.AlertEvent) error {
+	rule, ok := ah.Config.GetAggregationRuleConfig(aggAlert.Source)
+	if !ok {
+		glog.Errorf("Agg: Cant find rule : %s", aggAlert.Source)
+		return nil
+	}
+	var status string
+	if alerts.AllExpired() && rule.Alert.Config.AutoExpire != nil && *rule.Alert.Config.AutoExpire {
+		status = "EXPIRED"
+		glog.V(2).Infof("Agg : Agg Alert %d has now expired", aggAlert.Id)
+	} else if alerts.AllInactive() {
+		glog.V(2).Infof("Agg : Agg Alert %d has now cleared", aggAlert.Id)
+		status = "CLEARED"
+	}
+	if status == "" {
+		return nil
+	}
+	aggAlert.Status = models.StatusMap[status]
+	aggAlert.EndedAt = sql.NullInt64{time.Now().Unix(), true}
+	if err := tx.UpdateAlert(aggAlert); err != nil {
+		return fmt.Errorf("Agg: Unable to update agg status: %v", err)
+	}
+	a.statAggsActive.Add(-1)
+	tx.NewRecord(aggAlert.Id, fmt.Sprintf("Alert %s", status))
+	out <- &models.AlertEvent{Alert: aggAlert, Type: models.EventMap[status]}
+	return nil
+}
+
 func (a *Aggregator) grouperForAlert(alert *models.Alert, ruleName string) groupers.Grouper {
 	var grouper groupers.Grouper
 	rule, ok := ah.Config.GetAggregationRuleConfig(ruleName)
 	if ok && len(rule.GroupBy) > 0 {
-		var match bool
-		for k, v := range rule.Matches {
-			lv, ok := alert.Labels[k]
-			if !ok {
-				break
-			}
-			if _, ok := v.(string); ok {
-				match, _ = regexp.MatchString(v.(string), lv.(string))
-			} else {
-				match = v == lv
-			}
-			if !match {
-				break
-			}
-		}
-		if match {
+		if rule.Matches.Match(alert.Labels) {
 			grouper = groupers.AllGroupers["default_label_grouper"]
 			g := grouper.(*groupers.LabelGrouper)
 			g.SetGroupby(rule.GroupBy)
@@ -221,7 +236,7 @@ func (a *Aggregator) grouperForAlert(alert *models.Alert, ruleName string) group
 	return grouper
 }
 
-func (a *Aggregator) startProcess(in, out chan *models.AlertEvent) {
+func (a *Aggregator) startProcess(ctx context.Context, in, out chan *models.AlertEvent) {
 	var labelRules []string
 	for _, rule := range ah.Config.GetAggRules() {
 		if len(rule.GroupBy) > 0 {
@@ -230,6 +245,14 @@ func (a *Aggregator) startProcess(in, out chan *models.AlertEvent) {
 	}
 	glog.Info("Starting processor - Aggregator")
 	for event := range in {
+		if aggId := event.Alert.AggregatorId; aggId != 0 && (event.Type == models.EventType_CLEARED || event.Type == models.EventType_EXPIRED) {
+			ah.Timers.Schedule(fmt.Sprintf("aggregate/%d", aggId), time.Now(), func() {
+				if err := a.checkAggregate(ctx, aggId, out); err != nil {
+					a.statError.Add(1)
+					glog.Errorf("Agg: Unable to Update Agg Alert: %v", err)
+				}
+			})
+		}
 		if event.Alert.AggregatorId != 0 || (event.Type != models.EventType_ACTIVE && event.Type != models.EventType_CLEARED) {
 			out <- event
 			continue
@@ -262,40 +285,50 @@ func (a *Aggregator) startProcess(in, out chan *models.AlertEvent) {
 			out <- event
 		}
 	}
-	close(out)
 }
 
 // Process / group the alerts from the handler and grouping based on configured time windows.
 func (a *Aggregator) Process(ctx context.Context, db models.Dbase, in chan *models.AlertEvent) chan *models.AlertEvent {
 	a.db = db
+	// the windows of a previous run were flushed when it stopped
+	a.grouper = newGrouper()
 	out := make(chan *models.AlertEvent)
+	done := make(chan struct{})
+	grouped := make(chan struct{})
 	go func() {
-		t := time.NewTicker(EXPIRY_CHECK_INTERVAL)
+		defer close(grouped)
+		// catch up on components that changed while not running
+		if err := a.checkExpired(ctx, out); err != nil {
+			a.statError.Add(1)
+			glog.Errorf("Agg: Unable to Update Agg Alerts: %v", err)
+		}
 		for {
 			select {
-			case <-t.C:
-				if err := a.checkExpired(ctx, out); err != nil {
-					a.statError.Add(1)
-					glog.Errorf("Agg: Unable to Update Agg Alerts: %v", err)
-				}
 			case ag := <-groupedChan:
 				if err := a.handleGrouped(ctx, ag, out); err != nil {
 					glog.Errorf("Agg: Unable to save Agg alert: %v", err)
 					a.statError.Add(1)
 				}
-			case <-ctx.Done():
+			case <-done:
 				return
 			}
 		}
 	}()
-	go a.startProcess(in, out)
+	go func() {
+		a.startProcess(ctx, in, out)
+		// input closed on shutdown: aggregate the open windows before closing the output
+		a.grouper.flushWindows()
+		close(done)
+		<-grouped
+		close(out)
+	}()
 	return out
 }
 
 func init() {
 	agg := &Aggregator{
 		Notif:          make(chan *models.AlertEvent),
-		grouper:        &Grouper{recvBuffers: make(map[string][]*models.Alert)},
+		grouper:        newGrouper(),
 		statAggsActive: stats.NewGauge("processors.aggregator.aggs_active"),
 		statError:      stats.NewCounter("processors.aggregator.errors"),
 	}
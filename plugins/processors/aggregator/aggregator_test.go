@@ -3,6 +3,7 @@ package aggregator
 import (
 	"context"
 	"flag"
+	"fmt"
 	ah "github.com/mayuresh82/alert_manager/handler"
 	"github.com/mayuresh82/alert_manager/internal/models"
 	"github.com/mayuresh82/alert_manager/plugins/processors/aggregator/groupers"
@@ -66,9 +67,11 @@ func (t *MockTx) InQuery(query string, args ...interface{}) error {
 	case models.QueryUpdateAggId:
 		mockAlerts["bgp_1"].AggregatorId = mockAlerts["agg_bgp_12"].Id
 		mockAlerts["bgp_2"].AggregatorId = mockAlerts["agg_bgp_12"].Id
-	case models.QueryUpdateManyStatus:
-		mockAlerts["bgp_1"].Status = models.Status_SUPPRESSED
-		mockAlerts["bgp_2"].Status = models.Status_SUPPRESSED
+	case models.QuerySuppressMany:
+		for _, name := range []string{"bgp_1", "bgp_2"} {
+			mockAlerts[name].Status = args[0].(models.AlertStatus)
+			mockAlerts[name].SuppressedBy = args[1].(int64)
+		}
 	}
 	return nil
 }
@@ -153,12 +156,19 @@ func TestAlertGrouping(t *testing.T) {
 		models.Labels{"alert_name": "Neteng_Aggregated BGP Down", "entity": "Various"},
 		models.MatchCond_ALL,
 		"test", "test", 5*time.Minute)
-	supp.SaveRule(ctx, &MockTx{}, r)
+	models.WithTx(ctx, &MockTx{}, func(ctx context.Context, tx models.Txn) error {
+		_, err := supp.SaveRule(ctx, tx, r)
+		return err
+	})
 	if err := a.handleGrouped(ctx, &ag, out); err != nil {
 		t.Fatal(err)
 	}
 	assert.Equal(t, mockAlerts["bgp_1"].Status, models.Status_SUPPRESSED)
 	assert.Equal(t, mockAlerts["bgp_2"].Status, models.Status_SUPPRESSED)
+	// the alerts are released once the rule expires
+	assert.NotEqual(t, r.Id, int64(0))
+	assert.Equal(t, mockAlerts["bgp_1"].SuppressedBy, r.Id)
+	assert.Equal(t, mockAlerts["bgp_2"].SuppressedBy, r.Id)
 }
 
 func TestAggExpiry(t *testing.T) {
@@ -184,6 +194,45 @@ func TestAggExpiry(t *testing.T) {
 	assert.Equal(t, event.Alert.Id, mockAlerts["agg_bgp_12"].Id)
 }
 
+func TestAggComponentCleared(t *testing.T) {
+	a := &Aggregator{db: &MockDb{}, statAggsActive: &tu.MockStat{}, statError: &tu.MockStat{}}
+	ctx := context.Background()
+	out := make(chan *models.AlertEvent, 1)
+	aggId := mockAlerts["agg_bgp_12"].Id
+	mockAlerts["agg_bgp_12"].Status = models.Status_ACTIVE
+	mockAlerts["bgp_1"].Status = models.Status_CLEARED
+	mockAlerts["bgp_2"].Status = models.Status_ACTIVE
+	defer func() {
+		mockAlerts["bgp_1"].Status = models.Status_ACTIVE
+		mockAlerts["bgp_2"].Status = models.Status_ACTIVE
+		mockAlerts["agg_bgp_12"].Status = models.Status_ACTIVE
+	}()
+
+	// a cleared component schedules a check of its aggregate right away
+	in := make(chan *models.AlertEvent, 1)
+	in <- &models.AlertEvent{Type: models.EventType_CLEARED, Alert: &models.Alert{Id: 1, AggregatorId: aggId}}
+	close(in)
+	passed := make(chan *models.AlertEvent, 1)
+	a.startProcess(ctx, in, passed)
+	at, ok := ah.Timers.Deadline(fmt.Sprintf("aggregate/%d", aggId))
+	assert.True(t, ok)
+	assert.False(t, at.After(time.Now()))
+	ah.Timers.Cancel(fmt.Sprintf("aggregate/%d", aggId))
+
+	if err := a.checkAggregate(ctx, aggId, out); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(out), 0)
+
+	mockAlerts["bgp_2"].Status = models.Status_CLEARED
+	if err := a.checkAggregate(ctx, aggId, out); err != nil {
+		t.Fatal(err)
+	}
+	event := <-out
+	assert.Equal(t, event.Type, models.EventType_CLEARED)
+	assert.Equal(t, event.Alert.Id, aggId)
+}
+
 func TestGrouperMatch(t *testing.T) {
 	a := &Aggregator{db: &MockDb{}, statAggsActive: &tu.MockStat{}, statError: &tu.MockStat{}}
 	for _, a := range mockAlerts {
@@ -201,6 +250,10 @@ func TestGrouperMatch(t *testing.T) {
 	g := grouper.(*groupers.LabelGrouper)
 	rule, _ := ah.Config.GetAggregationRuleConfig("label_group")
 	assert.ElementsMatch(t, g.Groupby, rule.GroupBy)
+	// match expression
+	assert.Nil(t, a.grouperForAlert(mockAlerts["a3"], "expr_group"))
+	grouper = a.grouperForAlert(mockAlerts["a4"], "expr_group")
+	assert.Equal(t, grouper.Name(), "default_label_grouper")
 }
 
 func TestMain(m *testing.M) {
@@ -209,3 +262,26 @@ func TestMain(m *testing.M) {
 	ah.Config.LoadConfig()
 	os.Exit(m.Run())
 }
+
+func TestGrouperFlush(t *testing.T) {
+	g := newGrouper()
+	grouper := &mockGrouper{name: "bgp_session"}
+	g.addAlert(grouper, "bgp_session", tu.MockAlert(1, "Neteng BGP Down", "Alert1", "d1", "e1", "src1", "scp1", "t1", "1", "WARN", []string{}, nil))
+	g.addAlert(grouper, "bgp_session", tu.MockAlert(2, "Neteng BGP Down", "Alert2", "d2", "e2", "src2", "scp2", "t1", "2", "WARN", []string{}, nil))
+
+	// the open window is grouped right away instead of after a minute
+	flushed := make(chan struct{})
+	go func() {
+		g.flushWindows()
+		close(flushed)
+	}()
+	select {
+	case ag := <-groupedChan:
+		assert.Equal(t, len(ag.groupedAlerts), 2)
+		assert.Equal(t, ag.ruleName, "bgp_session")
+	case <-time.After(5 * time.Second):
+		t.Fatal("window was not flushed")
+	}
+	<-flushed
+	assert.Equal(t, len(g.recvBuffers["bgp_session"]), 0)
+}
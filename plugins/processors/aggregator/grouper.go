@@ -11,14 +11,24 @@ import (
 // Grouper manages the alert buffers for the different groupers and their grouping for time-window based grouping methods.
 type Grouper struct {
 	recvBuffers map[string][]*models.Alert
+	// closed on shutdown to group the open windows right away
+	flush chan struct{}
+	wg    sync.WaitGroup
 
 	sync.Mutex
 }
 
+func newGrouper() *Grouper {
+	return &Grouper{recvBuffers: make(map[string][]*models.Alert), flush: make(chan struct{})}
+}
+
 func (g *Grouper) startWindow(grouper groupers.Grouper, ruleName string) {
 	name := grouper.Name()
 	rule, _ := ah.Config.GetAggregationRuleConfig(ruleName)
-	time.Sleep(rule.Window)
+	select {
+	case <-time.After(rule.Window):
+	case <-g.flush:
+	}
 	g.Lock()
 	defer g.Unlock()
 	for _, group := range groupers.DoGrouping(grouper, g.recvBuffers[name]) {
@@ -32,7 +42,11 @@ func (g *Grouper) addAlert(grouper groupers.Grouper, ruleName string, alert *mod
 	defer g.Unlock()
 	name := grouper.Name()
 	if len(g.recvBuffers[name]) == 0 {
-		go g.startWindow(grouper, ruleName)
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			g.startWindow(grouper, ruleName)
+		}()
 	}
 	for _, a := range g.recvBuffers[name] {
 		if a.Id == alert.Id {
@@ -42,6 +56,12 @@ func (g *Grouper) addAlert(grouper groupers.Grouper, ruleName string, alert *mod
 	g.recvBuffers[name] = append(g.recvBuffers[name], alert)
 }
 
+// flushWindows ends all open windows early and waits for their groups to be sent
+func (g *Grouper) flushWindows() {
+	close(g.flush)
+	g.wg.Wait()
+}
+
 func (g *Grouper) removeAlert(grouperName string, alert *models.Alert) {
 	g.Lock()
 	defer g.Unlock()
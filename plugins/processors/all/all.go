@@ -4,4 +4,5 @@ import (
 	_ "github.com/mayuresh82/alert_manager/plugins/processors/aggregator"
 	_ "github.com/mayuresh82/alert_manager/plugins/processors/inhibitor"
 	_ "github.com/mayuresh82/alert_manager/plugins/processors/notifier"
+	_ "github.com/mayuresh82/alert_manager/plugins/processors/topology"
 )
@@ -15,6 +15,9 @@ import (
 type Inhibitor struct {
 	db       models.Dbase
 	alertBuf map[string][]*models.Alert
+	// closed on shutdown to check delayed rules right away
+	flush chan struct{}
+	wg    sync.WaitGroup
 
 	statAlertsInhibited stats.Stat
 	statError           stats.Stat
@@ -48,7 +51,12 @@ func (i *Inhibitor) addAlert(name string, alert *models.Alert) {
 }
 
 func (i *Inhibitor) checkRule(ctx context.Context, rule ah.InhibitRuleConfig, out chan *models.AlertEvent) {
-	time.Sleep(rule.Delay)
+	if rule.Delay > 0 {
+		select {
+		case <-time.After(rule.Delay):
+		case <-i.flush:
+		}
+	}
 	srcNames := []string{rule.SrcMatch.Alert}
 	tx := i.db.NewTx()
 	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
@@ -110,6 +118,7 @@ func (i *Inhibitor) checkRule(ctx context.Context, rule ah.InhibitRuleConfig, ou
 func (i *Inhibitor) Process(ctx context.Context, db models.Dbase, in chan *models.AlertEvent) chan *models.AlertEvent {
 	i.db = db
 	out := make(chan *models.AlertEvent)
+	i.flush = make(chan struct{})
 	go func() {
 		glog.Info("Starting processor - Inhibitor")
 		for event := range in {
@@ -141,8 +150,11 @@ func (i *Inhibitor) Process(ctx context.Context, db models.Dbase, in chan *model
 				l := len(i.alertBuf[rule.Name])
 				i.Unlock()
 				if l == 0 {
-					go i.checkRule(ctx, rule, out)
-
+					i.wg.Add(1)
+					go func(rule ah.InhibitRuleConfig) {
+						defer i.wg.Done()
+						i.checkRule(ctx, rule, out)
+					}(rule)
 				}
 				i.addAlert(rule.Name, event.Alert)
 				anyMatched = true
@@ -151,6 +163,9 @@ func (i *Inhibitor) Process(ctx context.Context, db models.Dbase, in chan *model
 				out <- event
 			}
 		}
+		// input closed on shutdown: check the pending rules before closing the output
+		close(i.flush)
+		i.wg.Wait()
 		close(out)
 	}()
 	return out
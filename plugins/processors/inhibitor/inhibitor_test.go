@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+	"time"
 )
 
 var mockAlerts = map[string]*models.Alert{
@@ -90,6 +91,37 @@ func TestInhibit(t *testing.T) {
 	assert.Equal(t, len(i.alertBuf["Device down"]), 0)
 }
 
+func TestInhibitFlush(t *testing.T) {
+	i := &Inhibitor{
+		alertBuf:            make(map[string][]*models.Alert),
+		db:                  &MockDb{},
+		flush:               make(chan struct{}),
+		statAlertsInhibited: &tu.MockStat{},
+		statError:           &tu.MockStat{},
+	}
+	rule, ok := ah.Config.GetInhibitRuleConfig("Device down")
+	if !ok {
+		t.Fatal("Rule not found")
+	}
+	rule.Delay = time.Hour
+	out := make(chan *models.AlertEvent, 1)
+	i.addAlert(rule.Name, mockAlerts["link_2"])
+	done := make(chan struct{})
+	go func() {
+		i.checkRule(context.Background(), rule, out)
+		close(done)
+	}()
+	// the rule is checked right away on shutdown instead of after its delay
+	close(i.flush)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("rule was not flushed")
+	}
+	event := <-out
+	assert.Equal(t, event.Alert.Id, mockAlerts["link_2"].Id)
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	ah.Config = ah.NewConfigHandler("../../../testutil/testdata/test_config.yaml")
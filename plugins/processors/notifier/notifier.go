@@ -7,21 +7,23 @@ import (
 	ah "github.com/mayuresh82/alert_manager/handler"
 	"github.com/mayuresh82/alert_manager/internal/models"
 	"github.com/mayuresh82/alert_manager/plugins"
+	"strings"
 	"sync"
 	"time"
 )
 
-const remindCheckInterval = 2 * time.Minute
-
 type notification struct {
 	event        *models.AlertEvent
 	lastNotified time.Time
+	flapping     bool
 }
 
 type Notifier struct {
 	notifiedAlerts map[int64]*notification
 	db             models.Dbase
 	name           string
+	// in-flight notifications
+	wg sync.WaitGroup
 
 	sync.Mutex
 }
@@ -31,12 +33,14 @@ func (n *Notifier) Name() string {
 }
 
 func (n *Notifier) Stage() int {
-	return 2
+	return 3
 }
 
 func (n *Notifier) loadActiveAlerts() {
 	n.Lock()
 	defer n.Unlock()
+	// alerts may have cleared since a previous run, e.g. while another replica was the leader
+	n.notifiedAlerts = make(map[int64]*notification)
 	tx := n.db.NewTx()
 	ctx := context.Background()
 	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
@@ -45,7 +49,10 @@ func (n *Notifier) loadActiveAlerts() {
 			return err
 		}
 		for _, a := range active {
-			n.notifiedAlerts[a.Id] = &notification{event: &models.AlertEvent{Type: models.EventType_ACTIVE, Alert: a}}
+			// reminders continue one interval after a restart
+			notif := &notification{event: &models.AlertEvent{Type: models.EventType_ACTIVE, Alert: a}, lastNotified: time.Now()}
+			n.notifiedAlerts[a.Id] = notif
+			n.scheduleRemind(notif)
 		}
 		return nil
 	})
@@ -54,38 +61,55 @@ func (n *Notifier) loadActiveAlerts() {
 	}
 }
 
-func (n *Notifier) remind() {
+func remindKey(id int64) string { return fmt.Sprintf("remind/%d", id) }
+
+// scheduleRemind schedules the next reminder for an alert with notify_remind configured. The
+// reminder is sent from its own go-routine, as sending blocks on the outputs and the db and
+// would hold up the other timers.
+func (n *Notifier) scheduleRemind(notif *notification) {
+	alertConfig, ok := ah.Config.GetAlertConfig(notif.event.Alert.Name)
+	if !ok || alertConfig.Config.NotifyRemind == 0 {
+		return
+	}
+	id := notif.event.Alert.Id
+	ah.Timers.Schedule(remindKey(id), notif.lastNotified.Add(alertConfig.Config.NotifyRemind), func() {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.remind(id)
+		}()
+	})
+}
+
+// remind re-sends the last notification of an alert that is still unactioned
+func (n *Notifier) remind(id int64) {
 	n.Lock()
 	defer n.Unlock()
-	var toNotify []int64
-	for alertId, notif := range n.notifiedAlerts {
-		if notif.event.Alert.Status == models.Status_SUPPRESSED {
-			continue
-		}
-		if notif.event.Alert.Owner.Valid {
-			// dont notify for ackd alerts
-			continue
-		}
-		if alertConfig, ok := ah.Config.GetAlertConfig(notif.event.Alert.Name); ok {
-			if alertConfig.Config.NotifyRemind == 0 {
-				continue
-			}
-			if time.Now().Sub(notif.lastNotified) >= alertConfig.Config.NotifyRemind {
-				toNotify = append(toNotify, alertId)
-			}
-		}
+	notif, ok := n.notifiedAlerts[id]
+	if !ok {
+		return
+	}
+	if notif.event.Alert.Status == models.Status_SUPPRESSED {
+		return
+	}
+	if notif.event.Alert.Owner.Valid {
+		// dont notify for ackd alerts
+		return
 	}
-	for _, a := range toNotify {
-		notif := n.notifiedAlerts[a]
+	if notif.flapping {
+		// flapping alerts are notified once
+		return
+	}
+	alertConfig, ok := ah.Config.GetAlertConfig(notif.event.Alert.Name)
+	if !ok || alertConfig.Config.NotifyRemind == 0 {
+		return
+	}
+	if time.Now().Sub(notif.lastNotified) >= alertConfig.Config.NotifyRemind {
 		notif.lastNotified = time.Now()
 		glog.V(2).Infof("Sending notification reminder for %d:%s", notif.event.Alert.Id, notif.event.Alert.Name)
-		if alertConfig, ok := ah.Config.GetAlertConfig(notif.event.Alert.Name); ok {
-			n.send(notif.event, alertConfig.Config.Outputs.Get(notif.event.Alert.Severity.String()))
-		} else {
-			generalConf := ah.Config.GetGeneralConfig()
-			n.send(notif.event, generalConf.DefaultOutputs.Get(notif.event.Alert.Severity.String()))
-		}
+		n.send(notif.event, alertConfig.Config.Outputs.Get(notif.event.Alert.Severity.String()))
 	}
+	n.scheduleRemind(notif)
 }
 
 // Notify notifies about an alert based on the below rules:
@@ -98,6 +122,10 @@ func (n *Notifier) remind() {
 //    - if alert is cleared then notify iff notify_on_clear is set
 //    - if alert is expired then notify to configured or default outputs
 //    - if alert is suppressed then dont notify
+//    - if alert starts flapping then notify once, and again when it stops flapping
+//    - if alert is updated by a re-fire then notify iff it was notified before
+//    - start the escalation policy of the alert with its first notification
+//    - notify the steps of an escalation policy to their own outputs
 // - else send it to the default output
 func (n *Notifier) Notify(event *models.AlertEvent) {
 	alert := event.Alert
@@ -105,6 +133,11 @@ func (n *Notifier) Notify(event *models.AlertEvent) {
 	if ok && alertConfig.Config.DisableNotify {
 		return
 	}
+	if event.Type == models.EventType_ESCALATED && len(event.Outputs) > 0 {
+		// a step of the escalation policy of the alert, which the handler records
+		n.sendTo(event, event.Outputs)
+		return
+	}
 	n.Lock()
 	defer n.Unlock()
 	var outputs []string
@@ -116,7 +149,8 @@ func (n *Notifier) Notify(event *models.AlertEvent) {
 		outputs = generalConf.DefaultOutputs.Get(event.Alert.Severity.String())
 	}
 	notif, alreadyNotified := n.notifiedAlerts[alert.Id]
-	if alreadyNotified {
+	isFlapEvent := event.Type == models.EventType_FLAPPING || event.Type == models.EventType_FLAP_ENDED
+	if alreadyNotified && !isFlapEvent {
 		notif.event = event
 	}
 	switch event.Type {
@@ -127,9 +161,13 @@ func (n *Notifier) Notify(event *models.AlertEvent) {
 		if ok && alert.LastActive.Sub(alert.StartTime.Time) < alertConfig.Config.NotifyDelay {
 			return
 		}
-		n.notifiedAlerts[alert.Id] = &notification{event: event, lastNotified: time.Now()}
+		notif = &notification{event: event, lastNotified: time.Now()}
+		n.notifiedAlerts[alert.Id] = notif
+		n.scheduleRemind(notif)
+		event, outputs = startEscalation(event, outputs)
 	case models.EventType_CLEARED, models.EventType_EXPIRED:
 		delete(n.notifiedAlerts, alert.Id)
+		ah.Timers.Cancel(remindKey(alert.Id))
 		if event.Type == models.EventType_CLEARED {
 			var notifyOnClear bool
 			if ok {
@@ -139,14 +177,52 @@ func (n *Notifier) Notify(event *models.AlertEvent) {
 				return
 			}
 		}
+	case models.EventType_FLAPPING:
+		if alreadyNotified {
+			if notif.flapping {
+				return
+			}
+			notif.flapping = true
+			notif.lastNotified = time.Now()
+		} else {
+			n.notifiedAlerts[alert.Id] = &notification{
+				event:        &models.AlertEvent{Type: models.EventType_ACTIVE, Alert: alert},
+				lastNotified: time.Now(),
+				flapping:     true,
+			}
+		}
+	case models.EventType_FLAP_ENDED:
+		if alreadyNotified {
+			notif.flapping = false
+			notif.lastNotified = time.Now()
+			n.scheduleRemind(notif)
+		}
+	case models.EventType_UPDATED:
+		// nothing to update if the alert was never notified
+		if !alreadyNotified {
+			return
+		}
+	case models.EventType_UNSUPPRESSED:
+		// notified like a new alert now that it is no longer held back
+		notif = &notification{event: &models.AlertEvent{Type: models.EventType_ACTIVE, Alert: alert}, lastNotified: time.Now()}
+		n.notifiedAlerts[alert.Id] = notif
+		n.scheduleRemind(notif)
+		event, outputs = startEscalation(event, outputs)
 	case models.EventType_SUPPRESSED, models.EventType_ACKD:
 		return
 	}
-	n.send(event, outputs)
+	onCall := n.send(event, outputs)
 	tx := n.db.NewTx()
 	ctx := context.Background()
 	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
 		msg := fmt.Sprintf("Alert notification sent to %v", outputs)
+		if len(onCall) > 0 {
+			var names []string
+			for _, oc := range onCall {
+				names = append(names, oc.User.Name)
+			}
+			msg += fmt.Sprintf(", on call: %s", strings.Join(names, ", "))
+		}
 		_, err := tx.NewRecord(event.Alert.Id, msg)
 		return err
 	})
@@ -155,7 +231,61 @@ func (n *Notifier) Notify(event *models.AlertEvent) {
 	}
 }
 
-func (n *Notifier) send(event *models.AlertEvent, outputs []string) {
+// startEscalation starts the escalation policy of an alert notified for the first time. The
+// steps that are due right away are notified along with it, instead of separately.
+func startEscalation(event *models.AlertEvent, outputs []string) (*models.AlertEvent, []string) {
+	steps := ah.StartEscalationPolicy(event.Alert)
+	if len(steps) == 0 {
+		return event, outputs
+	}
+	ev := *event
+	outputs = append([]string{}, outputs...)
+	for _, step := range steps {
+		for _, output := range step.Outputs {
+			found := false
+			for _, o := range outputs {
+				found = found || o == output
+			}
+			if !found {
+				outputs = append(outputs, output)
+			}
+		}
+		ev.OnCall = mergeOnCall(ev.OnCall, step.OnCall)
+	}
+	return &ev, outputs
+}
+
+// mergeOnCall adds the users on call in b that are not in a
+func mergeOnCall(a, b []*models.OnCall) []*models.OnCall {
+	for _, oc := range b {
+		found := false
+		for _, o := range a {
+			found = found || (o.Schedule == oc.Schedule && o.User.Id == oc.User.Id)
+		}
+		if !found {
+			a = append(a, oc)
+		}
+	}
+	return a
+}
+
+// send sends the event to the outputs along with who is on call, and returns who is on call
+func (n *Notifier) send(event *models.AlertEvent, outputs []string) []*models.OnCall {
+	ev := *event
+	if len(outputs) > 0 {
+		ev.OnCall = mergeOnCall(n.onCall(event.Alert), event.OnCall)
+	}
+	n.sendTo(&ev, outputs)
+	return ev.OnCall
+}
+
+// sendTo sends the event to the outputs as is
+func (n *Notifier) sendTo(event *models.AlertEvent, outputs []string) {
+	if !ah.HoldsLease() {
+		// the replica is no longer the leader, and the next one may already notify
+		glog.Errorf("Not sending alert %s: the leader lease was lost", event.Alert.Name)
+		return
+	}
 	for _, output := range outputs {
 		if outChan, ok := ah.GetOutput(output); ok {
 			glog.V(2).Infof("Sending alert %s to %s", event.Alert.Name, output)
@@ -164,21 +294,43 @@ func (n *Notifier) send(event *models.AlertEvent, outputs []string) {
 	}
 }
 
+// onCall returns who is on call for an alert if the on-call is paged: the on-call of the
+// oncall_team of the alert config, or of the alert team if notify_oncall is set
+func (n *Notifier) onCall(alert *models.Alert) []*models.OnCall {
+	team := alert.Team
+	if alertConfig, ok := ah.Config.GetAlertConfig(alert.Name); ok && alertConfig.Config.OnCallTeam != "" {
+		team = alertConfig.Config.OnCallTeam
+	} else if !ah.Config.GetGeneralConfig().NotifyOnCall {
+		return nil
+	}
+	var onCall []*models.OnCall
+	tx := n.db.NewTx()
+	err := models.WithTx(context.Background(), tx, func(ctx context.Context, tx models.Txn) error {
+		var er error
+		onCall, er = ah.WhoIsOnCall(tx, team, time.Now())
+		return er
+	})
+	if err != nil {
+		glog.Errorf("Failed to get on-call for team %s: %v", team, err)
+	}
+	return onCall
+}
+
 func (n *Notifier) Process(ctx context.Context, db models.Dbase, in chan *models.AlertEvent) chan *models.AlertEvent {
 	n.db = db
 	n.loadActiveAlerts()
-	go func() {
-		t := time.NewTicker(remindCheckInterval)
-		for range t.C {
-			n.remind()
-		}
-	}()
 	out := make(chan *models.AlertEvent)
 	go func() {
 		glog.Info("Starting processor - Notifier")
 		for event := range in {
-			go n.Notify(event)
+			n.wg.Add(1)
+			go func(event *models.AlertEvent) {
+				defer n.wg.Done()
+				n.Notify(event)
+			}(event)
 		}
+		// input closed on shutdown: finish handing the notifications to the outputs
+		n.wg.Wait()
 		close(out)
 	}()
 	return out
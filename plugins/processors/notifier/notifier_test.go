@@ -1,9 +1,11 @@
 package notifier
 
 import (
+	"context"
 	"flag"
 	ah "github.com/mayuresh82/alert_manager/handler"
 	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/mayuresh82/alert_manager/internal/scheduler"
 	tu "github.com/mayuresh82/alert_manager/testutil"
 	"github.com/stretchr/testify/assert"
 	"os"
@@ -92,20 +94,23 @@ func TestNotifyReminder(t *testing.T) {
 	assert.Equal(t, recvd.Type, models.EventType_ACTIVE)
 	assert.Equal(t, recvd.Alert, mockAlert)
 	lastNotified := notif.notifiedAlerts[1].lastNotified
+	at, ok := ah.Timers.Deadline(remindKey(1))
+	assert.True(t, ok)
+	assert.True(t, at.Equal(lastNotified.Add(15*time.Minute)))
 
 	// not remind
-	notif.remind()
+	notif.remind(1)
 	assert.Equal(t, notif.notifiedAlerts[1].lastNotified.Equal(lastNotified), true)
 
 	// ackd alert - not remind
 	event.Alert.Owner.Valid = true
-	notif.remind()
+	notif.remind(1)
 	assert.Equal(t, notif.notifiedAlerts[1].lastNotified.Equal(lastNotified), true)
 	event.Alert.Owner.Valid = false
 
 	// remind
 	notif.notifiedAlerts[mockAlert.Id].lastNotified = time.Now().Add(-20 * time.Minute)
-	notif.remind()
+	notif.remind(1)
 	recvd = <-notifyChan
 	assert.Equal(t, recvd.Type, models.EventType_ACTIVE)
 	assert.Equal(t, recvd.Alert, mockAlert)
@@ -118,7 +123,7 @@ func TestNotifyReminder(t *testing.T) {
 	assert.Equal(t, recvd.Type, models.EventType_ESCALATED)
 	assert.Equal(t, recvd.Alert, mockAlert)
 	notif.notifiedAlerts[mockAlert.Id].lastNotified = time.Now().Add(-20 * time.Minute)
-	notif.remind()
+	notif.remind(1)
 	recvd = <-notifyChan
 	assert.Equal(t, recvd.Type, models.EventType_ESCALATED)
 	assert.Equal(t, recvd.Alert, mockAlert)
@@ -128,7 +133,7 @@ func TestNotifyReminder(t *testing.T) {
 	mockAlert.Suppress(30 * time.Minute)
 	event = &models.AlertEvent{Type: models.EventType_SUPPRESSED, Alert: mockAlert}
 	notif.Notify(event)
-	notif.remind()
+	notif.remind(1)
 	assert.Equal(t, notif.notifiedAlerts[1].lastNotified.Equal(lastNotified), true)
 
 	// alert expired - no remind
@@ -140,7 +145,124 @@ func TestNotifyReminder(t *testing.T) {
 	assert.Equal(t, recvd.Alert, mockAlert)
 
 	assert.Equal(t, len(notif.notifiedAlerts), 0)
-	notif.remind()
+	_, ok = ah.Timers.Deadline(remindKey(1))
+	assert.False(t, ok)
+	notif.remind(1)
+}
+
+func TestNotifyReminderAsync(t *testing.T) {
+	timers := ah.Timers
+	ah.Timers = scheduler.New()
+	defer func() { ah.Timers = timers }()
+	mockAlert := tu.MockAlert(2, "Test Alert 5", "", "d1", "e1", "src1", "scp1", "t1", "1", "WARN", []string{}, nil)
+	db := &MockDb{}
+	notif := &Notifier{notifiedAlerts: make(map[int64]*notification), db: db}
+	// nothing reads the output until the end of the test
+	notifyChan := make(chan *models.AlertEvent)
+	ah.RegisterOutput("slack", notifyChan)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ah.Timers.Run(ctx)
+
+	// a reminder blocked on its outputs does not hold up the other timers
+	n := &notification{event: &models.AlertEvent{Type: models.EventType_ACTIVE, Alert: mockAlert}, lastNotified: time.Now().Add(-20 * time.Minute)}
+	notif.notifiedAlerts[mockAlert.Id] = n
+	notif.scheduleRemind(n)
+	fired := make(chan struct{})
+	ah.Timers.Schedule("other", time.Now(), func() { close(fired) })
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("Timers blocked by a reminder")
+	}
+	recvd := <-notifyChan
+	assert.Equal(t, recvd.Type, models.EventType_ACTIVE)
+	assert.Equal(t, recvd.Alert, mockAlert)
+	notif.wg.Wait()
+}
+
+func TestNotifyFlapping(t *testing.T) {
+	mockAlert := tu.MockAlert(2, "Test Alert 5", "", "d1", "e1", "src1", "scp1", "t1", "1", "WARN", []string{}, nil)
+	db := &MockDb{}
+	notif := &Notifier{notifiedAlerts: make(map[int64]*notification), db: db}
+	notifyChan := make(chan *models.AlertEvent, 1)
+	ah.RegisterOutput("slack", notifyChan)
+
+	mockAlert.LastActive.Time = mockAlert.LastActive.Add(10 * time.Minute)
+	notif.Notify(&models.AlertEvent{Type: models.EventType_ACTIVE, Alert: mockAlert})
+	<-notifyChan
+
+	// flapping is notified once
+	event := &models.AlertEvent{Type: models.EventType_FLAPPING, Alert: mockAlert}
+	notif.Notify(event)
+	recvd := <-notifyChan
+	assert.Equal(t, recvd.Type, models.EventType_FLAPPING)
+	notif.Notify(event)
+	assert.Equal(t, len(notifyChan), 0)
+
+	// no reminders while flapping
+	notif.notifiedAlerts[mockAlert.Id].lastNotified = time.Now().Add(-20 * time.Minute)
+	notif.remind(2)
+	assert.Equal(t, len(notifyChan), 0)
+
+	notif.Notify(&models.AlertEvent{Type: models.EventType_FLAP_ENDED, Alert: mockAlert})
+	recvd = <-notifyChan
+	assert.Equal(t, recvd.Type, models.EventType_FLAP_ENDED)
+	assert.False(t, notif.notifiedAlerts[mockAlert.Id].flapping)
+
+	// reminders resume for the active alert
+	notif.notifiedAlerts[mockAlert.Id].lastNotified = time.Now().Add(-20 * time.Minute)
+	notif.remind(2)
+	recvd = <-notifyChan
+	assert.Equal(t, recvd.Type, models.EventType_ACTIVE)
+}
+
+func TestNotifyUpdated(t *testing.T) {
+	mockAlert := tu.MockAlert(3, "Test Alert 5", "", "d1", "e1", "src1", "scp1", "t1", "1", "WARN", []string{}, nil)
+	db := &MockDb{}
+	notif := &Notifier{notifiedAlerts: make(map[int64]*notification), db: db}
+	notifyChan := make(chan *models.AlertEvent, 1)
+	ah.RegisterOutput("slack", notifyChan)
+
+	// not notified yet, nothing to update
+	notif.Notify(&models.AlertEvent{Type: models.EventType_UPDATED, Alert: mockAlert})
+	assert.Equal(t, len(notifyChan), 0)
+
+	mockAlert.LastActive.Time = mockAlert.LastActive.Add(10 * time.Minute)
+	notif.Notify(&models.AlertEvent{Type: models.EventType_ACTIVE, Alert: mockAlert})
+	<-notifyChan
+	notif.Notify(&models.AlertEvent{Type: models.EventType_UPDATED, Alert: mockAlert})
+	recvd := <-notifyChan
+	assert.Equal(t, recvd.Type, models.EventType_UPDATED)
+	// reminders repeat the latest event
+	assert.Equal(t, notif.notifiedAlerts[mockAlert.Id].event.Type, models.EventType_UPDATED)
+}
+
+func TestNotifyEscalationStep(t *testing.T) {
+	mockAlert := tu.MockAlert(4, "Test Alert 5", "", "d1", "e1", "src1", "scp1", "t1", "1", "WARN", []string{}, nil)
+	db := &MockDb{}
+	notif := &Notifier{notifiedAlerts: make(map[int64]*notification), db: db}
+	slack := make(chan *models.AlertEvent, 1)
+	victorops := make(chan *models.AlertEvent, 1)
+	ah.RegisterOutput("slack", slack)
+	ah.RegisterOutput("victorops", victorops)
+
+	// a step of an escalation policy is sent to its own outputs, along with its on-call
+	onCall := []*models.OnCall{{Schedule: "primary", User: &models.User{Id: 1, Name: "alice"}}}
+	notif.Notify(&models.AlertEvent{Type: models.EventType_ESCALATED, Alert: mockAlert, OnCall: onCall, Outputs: []string{"victorops"}})
+	recvd := <-victorops
+	assert.Equal(t, recvd.Type, models.EventType_ESCALATED)
+	assert.Equal(t, recvd.OnCall, onCall)
+	assert.Equal(t, len(slack), 0)
+	assert.Equal(t, len(notif.notifiedAlerts), 0)
+
+	// users on call for both the alert and a step are paged once
+	merged := mergeOnCall(onCall, []*models.OnCall{
+		{Schedule: "primary", User: &models.User{Id: 1, Name: "alice"}},
+		{Schedule: "secondary", User: &models.User{Id: 2, Name: "bob"}},
+	})
+	assert.Equal(t, len(merged), 2)
+	assert.Equal(t, merged[1].User.Name, "bob")
 }
 
 func TestMain(m *testing.M) {
@@ -0,0 +1,145 @@
+package topology
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"gopkg.in/yaml.v2"
+)
+
+// labels of the netbox transform naming the device at the other end of a link or session
+var peerLabels = []string{"PeerDevice", "ASideDeviceName", "ZSideDeviceName", "LocalDeviceName", "RemoteDeviceName"}
+
+// Graph is the network topology: devices, their sites and the links between them
+type Graph struct {
+	neighbors map[string]map[string]bool
+	sites     map[string]string
+	sync.RWMutex
+}
+
+// graphFile is the format of a topology file
+type graphFile struct {
+	Devices []struct {
+		Name string
+		Site string
+	}
+	Links []struct {
+		A string
+		Z string
+	}
+}
+
+func NewGraph() *Graph {
+	return &Graph{neighbors: make(map[string]map[string]bool), sites: make(map[string]string)}
+}
+
+// LoadGraph reads a topology file
+func LoadGraph(file string) (*Graph, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var f graphFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("Unable to decode topology %s: %v", file, err)
+	}
+	g := NewGraph()
+	for _, d := range f.Devices {
+		if d.Name == "" {
+			return nil, fmt.Errorf("Topology %s: device without a name", file)
+		}
+		g.AddDevice(d.Name, d.Site)
+	}
+	for _, l := range f.Links {
+		if l.A == "" || l.Z == "" {
+			return nil, fmt.Errorf("Topology %s: link %s - %s needs both ends", file, l.A, l.Z)
+		}
+		g.AddLink(l.A, l.Z)
+	}
+	return g, nil
+}
+
+// AddDevice adds a device, and its site if known
+func (g *Graph) AddDevice(name, site string) {
+	g.Lock()
+	defer g.Unlock()
+	if site != "" {
+		g.sites[name] = site
+	}
+}
+
+// AddLink adds a link between two devices. It reports whether the link is new.
+func (g *Graph) AddLink(a, z string) bool {
+	if a == z {
+		return false
+	}
+	g.Lock()
+	defer g.Unlock()
+	if g.neighbors[a][z] {
+		return false
+	}
+	for _, end := range [][2]string{{a, z}, {z, a}} {
+		if g.neighbors[end[0]] == nil {
+			g.neighbors[end[0]] = make(map[string]bool)
+		}
+		g.neighbors[end[0]][end[1]] = true
+	}
+	return true
+}
+
+// Neighbors reports whether two devices are linked
+func (g *Graph) Neighbors(a, z string) bool {
+	g.RLock()
+	defer g.RUnlock()
+	return g.neighbors[a][z]
+}
+
+// Site returns the site of a device, if known
+func (g *Graph) Site(device string) string {
+	g.RLock()
+	defer g.RUnlock()
+	return g.sites[device]
+}
+
+// Learn adds the devices, sites and links named by the netbox transform labels of an alert
+func (g *Graph) Learn(alert *models.Alert) {
+	device := alert.Device.String
+	if name, ok := alert.Labels["Name"].(string); ok && alert.Labels["LabelType"] == "Device" {
+		device = name
+	}
+	if device != "" && alert.Site.Valid {
+		g.AddDevice(device, alert.Site.String)
+	}
+	switch alert.Labels["LabelType"] {
+	case "Circuit":
+		a, _ := alert.Labels["ASideDeviceName"].(string)
+		z, _ := alert.Labels["ZSideDeviceName"].(string)
+		if a != "" && z != "" {
+			g.AddLink(a, z)
+		}
+		return
+	case "Bgp":
+		local, _ := alert.Labels["LocalDeviceName"].(string)
+		remote, _ := alert.Labels["RemoteDeviceName"].(string)
+		if local != "" && remote != "" {
+			g.AddLink(local, remote)
+		}
+		return
+	}
+	if peer, ok := alert.Labels["PeerDevice"].(string); ok && peer != "" && device != "" {
+		g.AddLink(device, peer)
+	}
+}
+
+// peers returns the devices at the other end of the link or session of an alert
+func peers(alert *models.Alert) []string {
+	var devices []string
+	for _, label := range peerLabels {
+		if d, ok := alert.Labels[label].(string); ok && d != "" && d != alert.Device.String {
+			devices = append(devices, d)
+		}
+	}
+	return devices
+}
@@ -0,0 +1,84 @@
+package topology
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mayuresh82/alert_manager/internal/models"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTopology(t *testing.T, dir, data string) string {
+	file := filepath.Join(dir, "topology.yaml")
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadGraph(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := writeTopology(t, dir, `
+devices:
+  - name: rtr1
+    site: dc1
+  - name: sw1
+    site: dc1
+links:
+  - a: rtr1
+    z: sw1
+  - a: rtr1
+    z: rtr2
+`)
+	g, err := LoadGraph(file)
+	assert.Nil(t, err)
+	assert.True(t, g.Neighbors("rtr1", "sw1"))
+	assert.True(t, g.Neighbors("sw1", "rtr1"))
+	assert.True(t, g.Neighbors("rtr2", "rtr1"))
+	assert.False(t, g.Neighbors("sw1", "rtr2"))
+	assert.Equal(t, g.Site("sw1"), "dc1")
+	assert.Equal(t, g.Site("rtr2"), "")
+
+	for _, data := range []string{"links: [ { a: rtr1 } ]", "devices: [ { site: dc1 } ]", "links: rtr1"} {
+		file = writeTopology(t, dir, data)
+		_, err = LoadGraph(file)
+		assert.Error(t, err, data)
+	}
+	_, err = LoadGraph(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestGraphLearn(t *testing.T) {
+	g := NewGraph()
+	device := tu.MockAlert(1, "Neteng Device Down", "", "rtr1", "rtr1", "src", "device", "t1", "1", "WARN", nil,
+		models.Labels{"LabelType": "Device", "Name": "rtr1", "Site": "dc1"})
+	device.AddSite("dc1")
+	g.Learn(device)
+	assert.Equal(t, g.Site("rtr1"), "dc1")
+
+	iface := tu.MockAlert(2, "Neteng DC Link Down", "", "sw1", "et-0/0/1", "src", "phy_interface", "t1", "2", "WARN", nil,
+		models.Labels{"LabelType": "Interface", "PeerDevice": "rtr1", "PeerIntf": "et-0/0/2"})
+	g.Learn(iface)
+	assert.True(t, g.Neighbors("sw1", "rtr1"))
+
+	circuit := tu.MockAlert(3, "Neteng BB Link Down", "", "rtr1", "et-0/0/3", "src", "link", "t1", "3", "WARN", nil,
+		models.Labels{"LabelType": "Circuit", "ASideDeviceName": "rtr1", "ZSideDeviceName": "rtr9"})
+	g.Learn(circuit)
+	assert.True(t, g.Neighbors("rtr9", "rtr1"))
+
+	bgp := tu.MockAlert(4, "Neteng BGP Down", "", "rtr2", "10.0.0.1", "src", "bgp_peer", "t1", "4", "WARN", nil,
+		models.Labels{"LabelType": "Bgp", "LocalDeviceName": "rtr2", "RemoteDeviceName": "rtr3"})
+	g.Learn(bgp)
+	assert.True(t, g.Neighbors("rtr2", "rtr3"))
+	assert.Equal(t, peers(bgp), []string{"rtr3"})
+	assert.Equal(t, peers(circuit), []string{"rtr9"})
+	assert.Equal(t, len(peers(device)), 0)
+}
@@ -0,0 +1,282 @@
+package topology
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	ah "github.com/mayuresh82/alert_manager/handler"
+	"github.com/mayuresh82/alert_manager/internal/models"
+	"github.com/mayuresh82/alert_manager/internal/stats"
+	"github.com/mayuresh82/alert_manager/plugins"
+)
+
+const RELOAD_INTERVAL = time.Minute
+
+// Topology inhibits alerts that are downstream of an active root cause alert in the network
+// topology, according to the root cause rules of the alert config
+type Topology struct {
+	// topology file, reloaded when it changes
+	File string
+	// learn devices, sites and links from the netbox transform labels of incoming alerts
+	LearnLabels bool `mapstructure:"learn_labels"`
+
+	db      models.Dbase
+	static  *Graph
+	modTime time.Time
+	learned *Graph
+	// closed on shutdown to check held back alerts right away
+	flush chan struct{}
+	wg    sync.WaitGroup
+
+	statAlertsInhibited stats.Stat
+	statError           stats.Stat
+
+	sync.Mutex
+}
+
+func (t *Topology) Name() string {
+	return "topology"
+}
+
+// Stage runs the topology after the inhibitor and before the aggregator, so that aggregates
+// are not made of alerts it inhibits
+func (t *Topology) Stage() int {
+	return 1
+}
+
+// loadFile reads the topology file if it changed since it was last read
+func (t *Topology) loadFile() {
+	if t.File == "" {
+		return
+	}
+	info, err := os.Stat(t.File)
+	if err != nil {
+		glog.Errorf("Topology: Unable to read %s: %v", t.File, err)
+		t.statError.Add(1)
+		return
+	}
+	t.Lock()
+	unchanged := info.ModTime().Equal(t.modTime)
+	t.Unlock()
+	if unchanged {
+		return
+	}
+	g, err := LoadGraph(t.File)
+	if err != nil {
+		glog.Errorf("Topology: %v", err)
+		t.statError.Add(1)
+		return
+	}
+	t.Lock()
+	t.static, t.modTime = g, info.ModTime()
+	t.Unlock()
+	glog.V(2).Infof("Topology: Loaded %s", t.File)
+}
+
+func (t *Topology) graphs() []*Graph {
+	t.Lock()
+	defer t.Unlock()
+	return []*Graph{t.static, t.learned}
+}
+
+func (t *Topology) neighbors(a, z string) bool {
+	for _, g := range t.graphs() {
+		if g.Neighbors(a, z) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Topology) site(alert *models.Alert) string {
+	if alert.Site.Valid && alert.Site.String != "" {
+		return alert.Site.String
+	}
+	for _, g := range t.graphs() {
+		if s := g.Site(alert.Device.String); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// downstream reports whether an alert is downstream of a root cause alert, and how
+func (t *Topology) downstream(rule ah.RootCauseRuleConfig, root, alert *models.Alert) (string, bool) {
+	if root.Id == alert.Id || !rule.Targets(alert.Scope) {
+		return "", false
+	}
+	switch rule.Scope {
+	case "site":
+		site := t.site(root)
+		if site != "" && t.site(alert) == site {
+			return "in site " + site, true
+		}
+	case "device":
+		device := root.Device.String
+		if device == "" {
+			return "", false
+		}
+		if alert.Device.String == device {
+			return "on device " + device, true
+		}
+		alertPeers := peers(alert)
+		for _, p := range alertPeers {
+			if p == device {
+				return "towards " + device, true
+			}
+		}
+		// an alert on a neighbor that does not name the other end can be towards the root
+		if len(alertPeers) == 0 && alert.Device.Valid && t.neighbors(alert.Device.String, device) {
+			return "on a neighbor of " + device, true
+		}
+	}
+	return "", false
+}
+
+// rules returns the root cause rules that can inhibit an alert
+func (t *Topology) rules(alert *models.Alert) []ah.RootCauseRuleConfig {
+	var rules []ah.RootCauseRuleConfig
+	for _, rule := range ah.Config.GetRootCauseRules() {
+		if rule.Alert != alert.Name && rule.Targets(alert.Scope) {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// check inhibits an alert if it is downstream of an active root cause alert, or else sends it
+// on to the next stage. An alert that is no longer active, e.g. cleared while held back, is
+// dropped.
+func (t *Topology) check(ctx context.Context, event *models.AlertEvent, rules []ah.RootCauseRuleConfig, out chan *models.AlertEvent) {
+	var inhibited, dropped bool
+	tx := t.db.NewTx()
+	err := models.WithTx(ctx, tx, func(ctx context.Context, tx models.Txn) error {
+		alert, err := tx.GetAlert(models.QuerySelectById, event.Alert.Id)
+		if err != nil {
+			return err
+		}
+		if alert.Status != models.Status_ACTIVE {
+			glog.V(2).Infof("Topology: Alert %d is no longer active, dropping", alert.Id)
+			dropped = true
+			return nil
+		}
+		event.Alert = alert
+		var names []string
+		for _, rule := range rules {
+			names = append(names, rule.Alert)
+		}
+		var roots models.Alerts
+		if err := tx.InSelect(models.QuerySelectByNames, &roots, names); err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			for _, root := range roots {
+				if root.Name != rule.Alert {
+					continue
+				}
+				how, ok := t.downstream(rule, root, alert)
+				if !ok {
+					continue
+				}
+				glog.V(2).Infof("Topology: Alert %d:%s is downstream of root cause %d:%s", alert.Id, alert.Name, root.Id, root.Name)
+				alert.Status = models.Status_SUPPRESSED
+				alert.InhibitedBy = root.Id
+				if err := tx.UpdateAlert(alert); err != nil {
+					alert.Status = models.Status_ACTIVE
+					alert.InhibitedBy = 0
+					return err
+				}
+				tx.NewRecord(alert.Id, fmt.Sprintf("Alert Inhibited by root cause alert %d:%s (%s), rule: %s",
+					root.Id, root.Name, how, rule.Name))
+				t.statAlertsInhibited.Add(1)
+				inhibited = true
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("Topology: Unable to check alert %d: %v", event.Alert.Id, err)
+		t.statError.Add(1)
+	}
+	if !inhibited && !dropped {
+		out <- event
+	}
+}
+
+func (t *Topology) Process(ctx context.Context, db models.Dbase, in chan *models.AlertEvent) chan *models.AlertEvent {
+	t.db = db
+	out := make(chan *models.AlertEvent)
+	t.flush = make(chan struct{})
+	t.loadFile()
+	go func() {
+		glog.Info("Starting processor - Topology")
+		reload := time.NewTicker(RELOAD_INTERVAL)
+		defer reload.Stop()
+	loop:
+		for {
+			select {
+			case <-reload.C:
+				t.loadFile()
+			case event, ok := <-in:
+				if !ok {
+					break loop
+				}
+				// released alerts, e.g. when their root cause alert ended, are checked again
+				if event.Type != models.EventType_ACTIVE && event.Type != models.EventType_UNSUPPRESSED {
+					out <- event
+					continue
+				}
+				if t.LearnLabels {
+					t.learned.Learn(event.Alert)
+				}
+				rules := t.rules(event.Alert)
+				if len(rules) == 0 {
+					out <- event
+					continue
+				}
+				var delay time.Duration
+				for _, rule := range rules {
+					if rule.Delay > delay {
+						delay = rule.Delay
+					}
+				}
+				if delay == 0 {
+					t.check(ctx, event, rules, out)
+					continue
+				}
+				// hold the alert back for its root cause to come in
+				t.wg.Add(1)
+				go func(event *models.AlertEvent) {
+					defer t.wg.Done()
+					select {
+					case <-time.After(delay):
+					case <-t.flush:
+					}
+					t.check(ctx, event, rules, out)
+				}(event)
+			}
+		}
+		// input closed on shutdown: check the held back alerts before closing the output
+		close(t.flush)
+		t.wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func init() {
+	t := &Topology{
+		static:              NewGraph(),
+		learned:             NewGraph(),
+		statAlertsInhibited: stats.NewCounter("processors.topology.alerts_inhibited"),
+		statError:           stats.NewCounter("processors.topology.errors"),
+	}
+	plugins.AddProcessor(t)
+}
@@ -0,0 +1,229 @@
+package topology
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+
+	ah "github.com/mayuresh82/alert_manager/handler"
+	"github.com/mayuresh82/alert_manager/internal/models"
+	tu "github.com/mayuresh82/alert_manager/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockDb struct {
+	tx *MockTx
+}
+
+func (m *MockDb) NewTx() models.Txn {
+	return m.tx
+}
+
+func (m *MockDb) Close() error {
+	return nil
+}
+
+type MockTx struct {
+	*models.Tx
+	roots   models.Alerts
+	alerts  map[int64]*models.Alert
+	records []string
+}
+
+// add keeps alerts by id, as they are in the db
+func (tx *MockTx) add(alerts ...*models.Alert) {
+	if tx.alerts == nil {
+		tx.alerts = make(map[int64]*models.Alert)
+	}
+	for _, a := range alerts {
+		tx.alerts[a.Id] = a
+	}
+}
+
+func (tx *MockTx) GetAlert(q string, args ...interface{}) (*models.Alert, error) {
+	if a, ok := tx.alerts[args[0].(int64)]; ok {
+		return a, nil
+	}
+	return nil, fmt.Errorf("No alert found")
+}
+
+func (tx *MockTx) InSelect(q string, to interface{}, args ...interface{}) error {
+	if to, ok := to.(*models.Alerts); ok {
+		*to = append(*to, tx.roots...)
+	}
+	return nil
+}
+
+func (tx *MockTx) UpdateAlert(a *models.Alert) error {
+	return nil
+}
+
+func (tx *MockTx) NewRecord(alertId int64, event string) (int64, error) {
+	tx.records = append(tx.records, event)
+	return 1, nil
+}
+
+func (tx *MockTx) Rollback() error {
+	return nil
+}
+
+func (tx *MockTx) Commit() error {
+	return nil
+}
+
+func newTopology(tx *MockTx) *Topology {
+	t := &Topology{
+		db:                  &MockDb{tx: tx},
+		static:              NewGraph(),
+		learned:             NewGraph(),
+		statAlertsInhibited: &tu.MockStat{},
+		statError:           &tu.MockStat{},
+	}
+	t.static.AddLink("rtr1", "sw1")
+	t.static.AddLink("rtr1", "sw2")
+	t.static.AddLink("sw2", "sw3")
+	t.static.AddDevice("sw2", "dc1")
+	t.static.AddDevice("sw3", "dc1")
+	return t
+}
+
+func TestRootCauseRules(t *testing.T) {
+	// rules without a valid scope are ignored
+	assert.Equal(t, len(ah.Config.GetRootCauseRules()), 2)
+	rule := ah.RootCauseRuleConfig{TargetScopes: []string{"bgp_peer"}}
+	assert.True(t, rule.Targets("bgp_peer"))
+	assert.False(t, rule.Targets("device"))
+	assert.True(t, ah.RootCauseRuleConfig{}.Targets("device"))
+}
+
+func TestTopologyCheck(t *testing.T) {
+	tx := &MockTx{}
+	topo := newTopology(tx)
+	ctx := context.Background()
+	out := make(chan *models.AlertEvent, 1)
+	root := tu.MockAlert(1, "Neteng Device Down", "", "rtr1", "rtr1", "src", "device", "t1", "1", "CRITICAL", nil, nil)
+	site := tu.MockAlert(2, "Neteng Site Down", "", "", "dc1", "src", "site", "t1", "2", "CRITICAL", nil, nil)
+	site.AddSite("dc1")
+	tx.roots = models.Alerts{root}
+
+	tests := []struct {
+		alert  *models.Alert
+		record string
+	}{
+		{
+			tu.MockAlert(10, "Neteng BGP Down", "", "sw1", "10.0.0.1", "src", "bgp_peer", "t1", "10", "WARN", nil,
+				models.Labels{"LocalDeviceName": "sw1", "RemoteDeviceName": "rtr1"}),
+			"Alert Inhibited by root cause alert 1:Neteng Device Down (towards rtr1), rule: Device down",
+		},
+		{
+			tu.MockAlert(11, "Neteng DC Link Down", "", "sw1", "et-0/0/1", "src", "phy_interface", "t1", "11", "WARN", nil, nil),
+			"Alert Inhibited by root cause alert 1:Neteng Device Down (on a neighbor of rtr1), rule: Device down",
+		},
+		{
+			tu.MockAlert(12, "Neteng DC Link Down", "", "rtr1", "et-0/0/1", "src", "phy_interface", "t1", "12", "WARN", nil, nil),
+			"Alert Inhibited by root cause alert 1:Neteng Device Down (on device rtr1), rule: Device down",
+		},
+		// the link of a neighbor towards another device
+		{
+			tu.MockAlert(13, "Neteng DC Link Down", "", "sw2", "et-0/0/2", "src", "phy_interface", "t1", "13", "WARN", nil,
+				models.Labels{"PeerDevice": "sw3"}),
+			"",
+		},
+		// not a neighbor
+		{
+			tu.MockAlert(14, "Neteng DC Link Down", "", "sw3", "et-0/0/3", "src", "phy_interface", "t1", "14", "WARN", nil, nil),
+			"",
+		},
+		// not a target scope of the rule
+		{
+			tu.MockAlert(15, "Neteng Device Unreachable", "", "sw1", "sw1", "src", "device", "t1", "15", "WARN", nil, nil),
+			"",
+		},
+	}
+	rules := ah.Config.GetRootCauseRules()
+	for _, tt := range tests {
+		tx.add(tt.alert)
+		tx.records = nil
+		event := &models.AlertEvent{Type: models.EventType_ACTIVE, Alert: tt.alert}
+		topo.check(ctx, event, rules, out)
+		if tt.record == "" {
+			assert.Equal(t, <-out, event, tt.alert.Name)
+			assert.Equal(t, tt.alert.Status, models.Status_ACTIVE)
+			continue
+		}
+		assert.Equal(t, len(out), 0, tt.alert.Name)
+		assert.Equal(t, tt.alert.Status, models.Status_SUPPRESSED)
+		assert.Equal(t, tt.alert.InhibitedBy, int64(1))
+		assert.Equal(t, tx.records, []string{tt.record})
+	}
+
+	// a site down inhibits all alerts of the devices in the site
+	tx.roots = models.Alerts{site}
+	tx.records = nil
+	alert := tu.MockAlert(16, "Neteng Device Unreachable", "", "sw3", "sw3", "src", "device", "t1", "16", "WARN", nil, nil)
+	tx.add(alert)
+	topo.check(ctx, &models.AlertEvent{Type: models.EventType_ACTIVE, Alert: alert}, rules, out)
+	assert.Equal(t, alert.Status, models.Status_SUPPRESSED)
+	assert.Equal(t, tx.records, []string{"Alert Inhibited by root cause alert 2:Neteng Site Down (in site dc1), rule: Site down"})
+}
+
+func TestTopologyCheckStale(t *testing.T) {
+	tx := &MockTx{}
+	topo := newTopology(tx)
+	out := make(chan *models.AlertEvent, 1)
+	root := tu.MockAlert(1, "Neteng Device Down", "", "rtr1", "rtr1", "src", "device", "t1", "1", "CRITICAL", nil, nil)
+	tx.roots = models.Alerts{root}
+	held := tu.MockAlert(10, "Neteng DC Link Down", "", "rtr1", "et-0/0/1", "src", "phy_interface", "t1", "10", "WARN", nil, nil)
+	// cleared while held back: the row in the db is what counts
+	cleared := *held
+	cleared.Status = models.Status_CLEARED
+	tx.add(&cleared)
+	topo.check(context.Background(), &models.AlertEvent{Type: models.EventType_ACTIVE, Alert: held}, ah.Config.GetRootCauseRules(), out)
+	assert.Equal(t, len(out), 0)
+	assert.Equal(t, cleared.Status, models.Status_CLEARED)
+	assert.Equal(t, len(tx.records), 0)
+}
+
+func TestTopologyProcess(t *testing.T) {
+	tx := &MockTx{}
+	topo := newTopology(tx)
+	topo.LearnLabels = true
+	root := tu.MockAlert(1, "Neteng Device Down", "", "rtr5", "rtr5", "src", "device", "t1", "1", "CRITICAL", nil, nil)
+	tx.roots = models.Alerts{root}
+	in := make(chan *models.AlertEvent, 5)
+	out := topo.Process(context.Background(), topo.db, in)
+
+	// the link to rtr5 is learned from the alert labels
+	bgp := tu.MockAlert(20, "Neteng BGP Down", "", "rtr6", "10.0.0.5", "src", "bgp_peer", "t1", "20", "WARN", nil,
+		models.Labels{"LabelType": "Bgp", "LocalDeviceName": "rtr6", "RemoteDeviceName": "rtr5"})
+	iface := tu.MockAlert(21, "Neteng DC Link Down", "", "rtr6", "et-0/0/1", "src", "phy_interface", "t1", "21", "WARN", nil, nil)
+	// released while the root cause alert is active
+	released := tu.MockAlert(22, "Neteng DC Link Down", "", "rtr5", "et-0/0/2", "src", "phy_interface", "t1", "22", "WARN", nil, nil)
+	tx.add(root, bgp, iface, released)
+	in <- &models.AlertEvent{Type: models.EventType_ACTIVE, Alert: root}
+	in <- &models.AlertEvent{Type: models.EventType_ACTIVE, Alert: bgp}
+	in <- &models.AlertEvent{Type: models.EventType_ACTIVE, Alert: iface}
+	in <- &models.AlertEvent{Type: models.EventType_CLEARED, Alert: bgp}
+	in <- &models.AlertEvent{Type: models.EventType_UNSUPPRESSED, Alert: released}
+	close(in)
+	var passed []*models.AlertEvent
+	for event := range out {
+		passed = append(passed, event)
+	}
+	assert.Equal(t, len(passed), 2)
+	assert.Equal(t, passed[0].Alert.Id, int64(1))
+	assert.Equal(t, passed[1].Type, models.EventType_CLEARED)
+	assert.Equal(t, bgp.Status, models.Status_SUPPRESSED)
+	assert.Equal(t, iface.Status, models.Status_SUPPRESSED)
+	assert.Equal(t, released.Status, models.Status_SUPPRESSED)
+	assert.Equal(t, released.InhibitedBy, int64(1))
+	assert.True(t, topo.learned.Neighbors("rtr5", "rtr6"))
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	ah.Config = ah.NewConfigHandler("../../../testutil/testdata/test_config.yaml")
+	os.Exit(m.Run())
+}
@@ -23,9 +23,20 @@ CREATE TABLE IF NOT EXISTS alerts (
   status SMALLINT NOT NULL,
   labels JSON,
   last_active BIGINT NOT NULL,
-  scope VARCHAR(16)
+  scope VARCHAR(16),
+  fingerprint VARCHAR(64) NOT NULL DEFAULT '',
+  occurrences INT NOT NULL DEFAULT 1,
+  suppressed_by INT NOT NULL DEFAULT 0,
+  ended_at BIGINT,
+  inhibited_by INT NOT NULL DEFAULT 0
   ) PARTITION BY LIST(team);
 
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS fingerprint VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS occurrences INT NOT NULL DEFAULT 1;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS suppressed_by INT NOT NULL DEFAULT 0;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS ended_at BIGINT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS inhibited_by INT NOT NULL DEFAULT 0;
+
 CREATE TABLE IF NOT EXISTS suppression_rules (
   id SERIAL PRIMARY KEY,
   name VARCHAR(128) NOT NULL,
@@ -34,7 +45,27 @@ CREATE TABLE IF NOT EXISTS suppression_rules (
   created_at BIGINT NOT NULL,
   duration INT NOT NULL,
   reason TEXT,
-  creator varchar(64) NOT NULL);
+  creator varchar(64) NOT NULL,
+  schedule TEXT NOT NULL DEFAULT '',
+  timezone VARCHAR(64) NOT NULL DEFAULT '',
+  external_id TEXT NOT NULL DEFAULT '',
+  expr TEXT NOT NULL DEFAULT '');
+
+ALTER TABLE suppression_rules ADD COLUMN IF NOT EXISTS schedule TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppression_rules ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE suppression_rules ADD COLUMN IF NOT EXISTS external_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE suppression_rules ADD COLUMN IF NOT EXISTS expr TEXT NOT NULL DEFAULT '';
+
+CREATE OR REPLACE FUNCTION notify_suppression_rules() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('suppression_rules', '');
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS suppression_rules_changed ON suppression_rules;
+CREATE TRIGGER suppression_rules_changed AFTER INSERT OR UPDATE OR DELETE ON suppression_rules
+  FOR EACH STATEMENT EXECUTE PROCEDURE notify_suppression_rules();
 
 CREATE TABLE IF NOT EXISTS alert_history (
   id SERIAL PRIMARY KEY,
@@ -53,6 +84,64 @@ CREATE TABLE IF NOT EXISTS users (
   team_id INT REFERENCES teams(id),
   PRIMARY KEY (id, team_id));
 
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email VARCHAR(128) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS slack_id VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS oncall_schedules (
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(128) NOT NULL,
+  team_id INT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+  timezone VARCHAR(64) NOT NULL DEFAULT '',
+  layers JSON NOT NULL);
+
+CREATE TABLE IF NOT EXISTS oncall_overrides (
+  id SERIAL PRIMARY KEY,
+  schedule_id INT NOT NULL REFERENCES oncall_schedules(id) ON DELETE CASCADE,
+  user_id INT NOT NULL,
+  start_time BIGINT NOT NULL,
+  end_time BIGINT NOT NULL);
+
+CREATE TABLE IF NOT EXISTS pending_clears (
+  alert_id INT PRIMARY KEY,
+  deadline BIGINT NOT NULL,
+  created_at BIGINT NOT NULL);
+
+CREATE TABLE IF NOT EXISTS alert_escalations (
+  alert_id INT PRIMARY KEY,
+  policy VARCHAR(128) NOT NULL,
+  step INT NOT NULL,
+  state VARCHAR(16) NOT NULL,
+  started_at BIGINT NOT NULL,
+  updated_at BIGINT NOT NULL);
+
+CREATE TABLE IF NOT EXISTS leader_lease (
+  name VARCHAR(64) PRIMARY KEY,
+  holder VARCHAR(128) NOT NULL,
+  acquired_at BIGINT NOT NULL,
+  expires_at BIGINT NOT NULL);
+
+CREATE TABLE IF NOT EXISTS alert_queue (
+  id BIGSERIAL PRIMARY KEY,
+  kind VARCHAR(16) NOT NULL,
+  data TEXT NOT NULL,
+  queued_at BIGINT NOT NULL,
+  attempts INT NOT NULL DEFAULT 0);
+
+ALTER TABLE alert_queue ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION notify_alert_queue() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('alert_queue', '');
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS alert_queue_added ON alert_queue;
+CREATE TRIGGER alert_queue_added AFTER INSERT ON alert_queue
+  FOR EACH STATEMENT EXECUTE PROCEDURE notify_alert_queue();
+
 CREATE INDEX ON alerts (id);
 CREATE INDEX ON alert_history (alert_id);
+CREATE INDEX IF NOT EXISTS alerts_fingerprint_idx ON alerts (fingerprint);
+CREATE INDEX IF NOT EXISTS oncall_overrides_schedule_idx ON oncall_overrides (schedule_id, end_time);
 `
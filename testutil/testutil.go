@@ -27,6 +27,7 @@ func MockAlert(id int64, name, desc, device, entity, source, scope, team, extId,
 		StartTime:   start,
 		LastActive:  start,
 		Labels:      make(models.Labels),
+		Occurrences: 1,
 	}
 	a.AddDevice(device)
 	a.AddTags(tags...)